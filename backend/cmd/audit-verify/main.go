@@ -0,0 +1,43 @@
+// Command audit-verify checks the hash chain of an audit log written by the
+// voice chat server, including its rotated files.
+//
+// Usage:
+//
+//	audit-verify -path audit.jsonl
+//	audit-verify audit.jsonl.20240101T000000.000000000 audit.jsonl
+//	audit-verify -anchor <hash of the last archived entry> -path audit.jsonl
+//
+// A log whose older files were archived does not start at the first entry,
+// so the hash of the last archived entry must be given with -anchor.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"voice-chat-app/utils"
+)
+
+func main() {
+	path := flag.String("path", "", "audit log path; rotated files next to it are verified too")
+	anchor := flag.String("anchor", "", "hash of the entry before the first one, when older files were archived")
+	flag.Parse()
+
+	files := flag.Args()
+	if *path != "" {
+		files = utils.AuditLogFiles(*path)
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: audit-verify -path <audit.jsonl> | audit-verify <file>...")
+		os.Exit(2)
+	}
+
+	count, err := utils.VerifyAuditLog(*anchor, files...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED after %d valid entries: %v\n", count, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("OK: %d entries across %d file(s)\n", count, len(files))
+}
@@ -89,6 +89,18 @@ func NewUnauthorizedError(message string) *AppError {
 	}
 }
 
+// NewForbiddenError creates a new forbidden error
+func NewForbiddenError(message string) *AppError {
+	if message == "" {
+		message = "Access denied"
+	}
+	return &AppError{
+		Code:       models.ErrorCodeForbidden,
+		Message:    message,
+		StatusCode: http.StatusForbidden,
+	}
+}
+
 // NewRateLimitError creates a new rate limit error
 func NewRateLimitError(message string) *AppError {
 	if message == "" {
@@ -101,6 +113,36 @@ func NewRateLimitError(message string) *AppError {
 	}
 }
 
+// NewServerBusyError creates a new error for when the server is at capacity
+func NewServerBusyError(message string) *AppError {
+	if message == "" {
+		message = "Server is at capacity, please retry later"
+	}
+	return &AppError{
+		Code:       models.ErrorCodeServerBusy,
+		Message:    message,
+		StatusCode: http.StatusServiceUnavailable,
+	}
+}
+
+// NewConflictError creates a new error for a request that clashes with existing state
+func NewConflictError(message string) *AppError {
+	return &AppError{
+		Code:       models.ErrorCodeConflict,
+		Message:    message,
+		StatusCode: http.StatusConflict,
+	}
+}
+
+// NewTooLargeError creates a new error for a request body over its size limit
+func NewTooLargeError(message string) *AppError {
+	return &AppError{
+		Code:       models.ErrorCodeTooLarge,
+		Message:    message,
+		StatusCode: http.StatusRequestEntityTooLarge,
+	}
+}
+
 // NewInternalError creates a new internal server error
 func NewInternalError(message string, cause error) *AppError {
 	if message == "" {
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"voice-chat-app/errors"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// accountRequest is the body of the signup, login and upgrade endpoints
+type accountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Nonce    string `json:"nonce"`
+	Solution string `json:"solution"`
+}
+
+// decodeAccountRequest reads an account request body, writing an error
+// response and returning false if it is malformed
+func decodeAccountRequest(w http.ResponseWriter, r *http.Request) (*accountRequest, bool) {
+	var req accountRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Invalid request body"))
+		return nil, false
+	}
+	return &req, true
+}
+
+// accountsEnabled writes a not found response unless accounts and connect
+// tickets are both configured; a login is useless without a ticket to
+// connect with
+func (s *SignalingServer) accountsEnabled(w http.ResponseWriter) bool {
+	if s.Accounts == nil || s.Tickets == nil {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("account endpoint"))
+		return false
+	}
+	return true
+}
+
+// writeAccountError maps account store errors to HTTP responses
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrInvalidUsername, models.ErrInvalidPassword, models.ErrInvalidEmail:
+		errors.WriteErrorResponse(w, errors.NewValidationError(err.Error()))
+	case models.ErrUsernameTaken, models.ErrEmailTaken, models.ErrEmailChanged, models.ErrExternalAccount:
+		errors.WriteErrorResponse(w, errors.NewConflictError(err.Error()))
+	case models.ErrAccountNotFound:
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("account"))
+	case models.ErrInvalidCredentials:
+		errors.WriteErrorResponse(w, errors.NewUnauthorizedError("Invalid username or password"))
+	case models.ErrAccountLocked:
+		w.Header().Set("Retry-After", strconv.Itoa(int(models.LockoutDuration.Seconds())))
+		errors.WriteErrorResponse(w, errors.NewRateLimitError("Too many failed login attempts; try again later"))
+	default:
+		errors.WriteErrorResponse(w, errors.NewInternalError("Account request failed", err))
+	}
+}
+
+// HandleSignup registers an account and starts a session for it
+func (s *SignalingServer) HandleSignup(w http.ResponseWriter, r *http.Request) {
+	if !s.accountsEnabled(w) {
+		return
+	}
+	req, ok := decodeAccountRequest(w, r)
+	if !ok || !s.verifySessionChallenge(w, r, req.Nonce, req.Solution) {
+		return
+	}
+
+	account, err := s.Accounts.Create(req.Username, req.Password)
+	if err != nil {
+		log.Printf("Signup refused for %q: %v", req.Username, err)
+		writeAccountError(w, err)
+		return
+	}
+
+	log.Printf("[DEBUG] Account %s registered", account.ID)
+	s.writeSession(w, http.StatusCreated, utils.GenerateUUID(), account)
+}
+
+// HandleLogin checks a username and password and starts a session for the
+// account. Repeated failures from one client lock its logins to the username
+// for models.LockoutDuration.
+func (s *SignalingServer) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.accountsEnabled(w) {
+		return
+	}
+	req, ok := decodeAccountRequest(w, r)
+	if !ok || !s.verifySessionChallenge(w, r, req.Nonce, req.Solution) {
+		return
+	}
+
+	account, err := s.Accounts.Authenticate(req.Username, req.Password, utils.ClientIP(r))
+	if err != nil {
+		log.Printf("Login refused for %q: %v", req.Username, err)
+		writeAccountError(w, err)
+		return
+	}
+
+	log.Printf("[DEBUG] Account %s logged in", account.ID)
+	s.writeSession(w, http.StatusOK, utils.GenerateUUID(), account)
+}
+
+// HandleUpgradeAccount registers an account for an anonymous session. The
+// session keeps its user ID; its friends, voice notes, scheduled calls,
+// moderation state, bot score and message penalties move to the account,
+// and its old token is revoked in favour of one carrying the account ID. A
+// session that is not connected presents its device token as X-Device-Token
+// so that state kept for the device moves too.
+func (s *SignalingServer) HandleUpgradeAccount(w http.ResponseWriter, r *http.Request) {
+	if !s.accountsEnabled(w) {
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	claims, err := utils.ValidateJWT(token)
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewUnauthorizedError("A valid session token is required"))
+		return
+	}
+	if claims.AccountID != "" {
+		errors.WriteErrorResponse(w, errors.NewConflictError("Session already belongs to an account"))
+		return
+	}
+
+	req, ok := decodeAccountRequest(w, r)
+	if !ok {
+		return
+	}
+	account, err := s.Accounts.Create(req.Username, req.Password)
+	if err != nil {
+		log.Printf("Upgrade refused for user %s: %v", claims.UserID, err)
+		writeAccountError(w, err)
+		return
+	}
+
+	// The anonymous identities are the session and its device. A connected
+	// session's device is known; otherwise it is taken from the device token.
+	var deviceID string
+	if user := s.UserPool.GetUser(claims.UserID); user != nil {
+		deviceID = user.DeviceID
+	} else if device, err := utils.ValidateDeviceToken(r.Header.Get("X-Device-Token")); err == nil {
+		deviceID = device.DeviceID
+	}
+	anonymous := []string{claims.UserID}
+	previous := claims.UserID // the identity the session was known by
+	if deviceID != "" {
+		anonymous = append(anonymous, deviceID)
+		previous = deviceID
+	}
+
+	// Friends, voice notes and scheduled calls move first, so a failure
+	// leaves the session anonymous
+	if s.Friends != nil {
+		if err := s.Friends.Transfer(anonymous, account.ID); err != nil {
+			log.Printf("Error moving friends of user %s to account %s: %v", claims.UserID, account.ID, err)
+			errors.WriteErrorResponse(w, errors.NewInternalError("Failed to move friends to the account", err))
+			return
+		}
+	}
+	if s.VoiceNotes != nil {
+		if err := s.VoiceNotes.Notes.Transfer(anonymous, account.ID); err != nil {
+			log.Printf("Error moving voice notes of user %s to account %s: %v", claims.UserID, account.ID, err)
+			errors.WriteErrorResponse(w, errors.NewInternalError("Failed to move voice notes to the account", err))
+			return
+		}
+	}
+	if s.Scheduler != nil {
+		if err := s.Scheduler.Calls.Transfer(anonymous, account.ID); err != nil {
+			log.Printf("Error moving scheduled calls of user %s to account %s: %v", claims.UserID, account.ID, err)
+			errors.WriteErrorResponse(w, errors.NewInternalError("Failed to move scheduled calls to the account", err))
+			return
+		}
+	}
+	s.UserPool.LinkAccount(claims.UserID, deviceID, account.ID)
+	if s.BotDetector != nil {
+		s.BotDetector.Transfer(previous, account.ID)
+	}
+	if s.MessageLimit != nil {
+		s.MessageLimit.Transfer(previous, account.ID)
+	}
+	log.Printf("[DEBUG] User %s upgraded to account %s", claims.UserID, account.ID)
+
+	// Revoke the anonymous token only once the connected session, if any,
+	// has switched to the new one
+	if s.writeSession(w, http.StatusCreated, claims.UserID, account) {
+		if err := utils.RevokeToken(token); err != nil {
+			log.Printf("Error revoking anonymous token for user %s: %v", claims.UserID, err)
+		} else {
+			utils.Audit(utils.WithUserID(r.Context(), claims.UserID), utils.AuditActionTokenRevoke, map[string]interface{}{
+				"target_user_id": claims.UserID,
+				"reason":         "account_upgrade",
+			})
+		}
+	}
+}
+
+// issueSession issues a token and connect ticket for an account session. A
+// user already connected under userID switches to the new token.
+func (s *SignalingServer) issueSession(userID string, account *models.Account) (string, *ConnectTicket, error) {
+	token, err := utils.GenerateAccountToken(userID, account.ID)
+	if err != nil {
+		log.Printf("Token generation error: %v", err)
+		return "", nil, err
+	}
+	s.UserPool.SetSessionToken(userID, token)
+
+	ticket, err := s.Tickets.IssueForAccount(userID, account.ID, token)
+	if err != nil {
+		log.Printf("Ticket generation error: %v", err)
+		return "", nil, err
+	}
+	return token, ticket, nil
+}
+
+// writeSession issues an account session and writes it as the response.
+// Returns false if an error response was written instead.
+func (s *SignalingServer) writeSession(w http.ResponseWriter, status int, userID string, account *models.Account) bool {
+	token, ticket, err := s.issueSession(userID, account)
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to create session", err))
+		return false
+	}
+
+	writeJSON(w, status, map[string]interface{}{
+		"user_id":           userID,
+		"account_id":        account.ID,
+		"username":          account.Username,
+		"token":             token,
+		"ticket":            ticket.ID,
+		"ticket_expires_at": ticket.ExpiresAt,
+	})
+	return true
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"voice-chat-app/errors"
+	"voice-chat-app/middleware"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// AdminServer exposes moderation endpoints for on-call staff. Routes are
+// expected to be mounted behind Auth.Authenticate; each route then checks
+// its own scope. Without an Auth every route is refused.
+type AdminServer struct {
+	UserPool *models.UserPool
+	Auth     *middleware.AdminAuthenticator
+}
+
+type adminActionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type shadowBanRequest struct {
+	Identity string `json:"identity"`
+	Reason   string `json:"reason"`
+}
+
+// RegisterRoutes mounts the admin endpoints on the given mux
+func (a *AdminServer) RegisterRoutes(mux *http.ServeMux) {
+	a.handle(mux, "GET /admin/stats", models.ScopeStatsRead, a.handleStats)
+	a.handle(mux, "GET /admin/users", models.ScopeRoomsRead, a.handleListUsers)
+	a.handle(mux, "POST /admin/users/{id}/kick", models.ScopeRoomsWrite, a.handleKickUser)
+	a.handle(mux, "POST /admin/users/{id}/revoke-token", models.ScopeTokensWrite, a.handleRevokeToken)
+	a.handle(mux, "GET /admin/rooms", models.ScopeRoomsRead, a.handleListRooms)
+	a.handle(mux, "POST /admin/rooms/{id}/end", models.ScopeRoomsWrite, a.handleEndRoom)
+	a.handle(mux, "GET /admin/shadow-bans", models.ScopeBansRead, a.handleListShadowBans)
+	a.handle(mux, "POST /admin/shadow-bans", models.ScopeBansWrite, a.handleShadowBan)
+	a.handle(mux, "DELETE /admin/shadow-bans/{identity}", models.ScopeBansWrite, a.handleLiftShadowBan)
+}
+
+// handle registers a route guarded by the given scope
+func (a *AdminServer) handle(mux *http.ServeMux, pattern, scope string, handler http.HandlerFunc) {
+	if a.Auth == nil {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			errors.WriteErrorResponse(w, errors.NewForbiddenError("Admin API authentication is not configured"))
+		})
+		return
+	}
+	mux.Handle(pattern, middleware.Chain(handler, a.Auth.RequireScope(scope)))
+}
+
+func (a *AdminServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.UserPool.GetStats())
+}
+
+func (a *AdminServer) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	users := a.UserPool.ListUsers()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users": users,
+		"count": len(users),
+	})
+}
+
+func (a *AdminServer) handleListRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := a.UserPool.ListRooms()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rooms": rooms,
+		"count": len(rooms),
+	})
+}
+
+func (a *AdminServer) handleKickUser(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	reason := readActionReason(r, "Removed by moderator")
+
+	if !a.UserPool.KickUser(userID, reason) {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("User"))
+		return
+	}
+
+	utils.Audit(r.Context(), utils.AuditActionKick, map[string]interface{}{
+		"target_user_id": userID,
+		"reason":         reason,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"kicked":  true,
+	})
+}
+
+// handleRevokeToken revokes a user's session token. The signaling server
+// closes the session at its next heartbeat.
+func (a *AdminServer) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	reason := readActionReason(r, "Revoked by moderator")
+
+	token := a.UserPool.SessionToken(userID)
+	if token == "" {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("User"))
+		return
+	}
+	if err := utils.RevokeToken(token); err != nil {
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to revoke token", err))
+		return
+	}
+
+	utils.Audit(r.Context(), utils.AuditActionTokenRevoke, map[string]interface{}{
+		"target_user_id": userID,
+		"reason":         reason,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"revoked": true,
+	})
+}
+
+func (a *AdminServer) handleEndRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+	reason := readActionReason(r, "Call ended by moderator")
+
+	if !a.UserPool.EndRoom(roomID, reason) {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("Active room"))
+		return
+	}
+
+	utils.Audit(r.Context(), utils.AuditActionRoomEnd, map[string]interface{}{
+		"room_id": roomID,
+		"reason":  reason,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"room_id": roomID,
+		"ended":   true,
+	})
+}
+
+func (a *AdminServer) handleListShadowBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"shadow_bans": a.UserPool.ListShadowBans(),
+	})
+}
+
+func (a *AdminServer) handleShadowBan(w http.ResponseWriter, r *http.Request) {
+	var req shadowBanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Invalid request body", err.Error()))
+		return
+	}
+
+	req.Identity = models.SanitizeString(req.Identity)
+	if req.Identity == "" || len(req.Identity) > models.MaxUserIDLength {
+		errors.WriteErrorResponse(w, errors.NewValidationError("identity is required"))
+		return
+	}
+
+	created := a.UserPool.ShadowBanIdentity(req.Identity, models.SanitizeString(req.Reason))
+	if created {
+		utils.Audit(r.Context(), utils.AuditActionShadowBan, map[string]interface{}{
+			"identity": req.Identity,
+			"reason":   req.Reason,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"identity": req.Identity,
+		"created":  created,
+	})
+}
+
+func (a *AdminServer) handleLiftShadowBan(w http.ResponseWriter, r *http.Request) {
+	identity := r.PathValue("identity")
+	if !a.UserPool.LiftShadowBan(identity) {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("Shadow ban"))
+		return
+	}
+
+	utils.Audit(r.Context(), utils.AuditActionShadowBanLift, map[string]interface{}{
+		"identity": identity,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"identity": identity,
+		"lifted":   true,
+	})
+}
+
+// readActionReason reads an optional reason from the request body
+func readActionReason(r *http.Request, fallback string) string {
+	var req adminActionRequest
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&req)
+	}
+	if reason := models.SanitizeString(req.Reason); reason != "" {
+		return reason
+	}
+	return fallback
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"voice-chat-app/middleware"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adminTestKey is granted every scope by setupAdminServer
+const adminTestKey = "admin-test-key"
+
+func setupAdminServer(t *testing.T) (*models.UserPool, http.Handler) {
+	return setupAdminServerWithScopes(t, models.AdminScopes)
+}
+
+func setupAdminServerWithScopes(t *testing.T, scopes []string) (*models.UserPool, http.Handler) {
+	userPool := models.NewUserPool()
+	t.Cleanup(userPool.Shutdown)
+
+	auth, err := middleware.NewAdminAuthenticator([]utils.AdminAPIKeyConfig{
+		{ID: "test", Hash: utils.HashAPIKey(adminTestKey), Scopes: scopes},
+	})
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	admin := &AdminServer{UserPool: userPool, Auth: auth}
+	admin.RegisterRoutes(mux)
+	return userPool, auth.Authenticate(mux)
+}
+
+func doAdminRequest(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-API-Key", adminTestKey)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminServer_Scopes(t *testing.T) {
+	_, handler := setupAdminServerWithScopes(t, []string{models.ScopeBansRead})
+
+	rec := doAdminRequest(handler, http.MethodGet, "/admin/shadow-bans", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doAdminRequest(handler, http.MethodPost, "/admin/shadow-bans", `{"identity":"abuser"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Without an authenticator nothing is served
+	userPool := models.NewUserPool()
+	defer userPool.Shutdown()
+	mux := http.NewServeMux()
+	(&AdminServer{UserPool: userPool}).RegisterRoutes(mux)
+	rec = doAdminRequest(mux, http.MethodGet, "/admin/stats", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestAdminServer_ListUsersAndRooms(t *testing.T) {
+	userPool, mux := setupAdminServer(t)
+
+	user1 := &models.User{ID: "user1", Connection: &models.Connection{UserID: "user1", IsActive: true}}
+	user2 := &models.User{ID: "user2", Connection: &models.Connection{UserID: "user2", IsActive: true}}
+	user3 := &models.User{ID: "user3", Connection: &models.Connection{UserID: "user3", IsActive: true}}
+	userPool.AddWaitingUser(user1)
+	userPool.AddWaitingUser(user2)
+	userPool.AddWaitingUser(user3)
+	room := userPool.CreateRoom(user1, user2)
+
+	rec := doAdminRequest(mux, http.MethodGet, "/admin/users", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var usersResp struct {
+		Users []models.UserSnapshot `json:"users"`
+		Count int                   `json:"count"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&usersResp))
+	assert.Equal(t, 3, usersResp.Count)
+
+	rec = doAdminRequest(mux, http.MethodGet, "/admin/rooms", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var roomsResp struct {
+		Rooms []models.RoomSnapshot `json:"rooms"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&roomsResp))
+	require.Len(t, roomsResp.Rooms, 1)
+	assert.Equal(t, room.ID, roomsResp.Rooms[0].ID)
+	assert.ElementsMatch(t, []string{"user1", "user2"}, roomsResp.Rooms[0].Participants)
+}
+
+func TestAdminServer_EndRoomAndKick(t *testing.T) {
+	userPool, mux := setupAdminServer(t)
+
+	user1 := &models.User{ID: "user1", Connection: &models.Connection{UserID: "user1", IsActive: true}}
+	user2 := &models.User{ID: "user2", Connection: &models.Connection{UserID: "user2", IsActive: true}}
+	userPool.AddWaitingUser(user1)
+	userPool.AddWaitingUser(user2)
+	room := userPool.CreateRoom(user1, user2)
+
+	rec := doAdminRequest(mux, http.MethodPost, "/admin/rooms/"+room.ID+"/end", `{"reason":"abuse report"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, room.IsActive)
+	assert.Equal(t, models.StatusWaiting, user1.Status)
+	assert.Empty(t, user2.RoomID)
+
+	// Ending the same room twice is a not found
+	rec = doAdminRequest(mux, http.MethodPost, "/admin/rooms/"+room.ID+"/end", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doAdminRequest(mux, http.MethodPost, "/admin/users/user1/kick", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, user1.Connection.IsActive)
+
+	rec = doAdminRequest(mux, http.MethodPost, "/admin/users/missing/kick", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminServer_RevokeToken(t *testing.T) {
+	userPool, mux := setupAdminServer(t)
+
+	token, err := utils.GenerateToken("user1")
+	require.NoError(t, err)
+	userPool.AddWaitingUser(&models.User{
+		ID:         "user1",
+		SessionID:  token,
+		Connection: &models.Connection{UserID: "user1", IsActive: true},
+	})
+
+	rec := doAdminRequest(mux, http.MethodPost, "/admin/users/user1/revoke-token", `{"reason":"compromised"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	_, err = utils.ValidateJWT(token)
+	assert.Equal(t, utils.ErrTokenBlacklisted, err)
+
+	rec = doAdminRequest(mux, http.MethodPost, "/admin/users/missing/revoke-token", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminServer_ShadowBans(t *testing.T) {
+	userPool, mux := setupAdminServer(t)
+
+	rec := doAdminRequest(mux, http.MethodPost, "/admin/shadow-bans", `{"identity":"abuser","reason":"spam"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, userPool.IsShadowBanned("abuser"))
+
+	rec = doAdminRequest(mux, http.MethodPost, "/admin/shadow-bans", `{}`)
+	assert.Equal(t, models.StatusValidationFailed, rec.Code)
+
+	rec = doAdminRequest(mux, http.MethodDelete, "/admin/shadow-bans/abuser", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, userPool.IsShadowBanned("abuser"))
+
+	rec = doAdminRequest(mux, http.MethodDelete, "/admin/shadow-bans/abuser", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"log"
+	"time"
+	"voice-chat-app/middleware"
+	"voice-chat-app/models"
+)
+
+// awaitAdmission holds a waitlisted client until a slot is handed to it,
+// sending server_busy updates with its queue position. Returns false if the
+// wait times out or the client goes away.
+func (s *SignalingServer) awaitAdmission(conn *models.Connection, ticket *middleware.AdmissionTicket) bool {
+	select {
+	case <-ticket.Ready():
+		return true
+	default:
+	}
+
+	timeout := time.NewTimer(s.Admission.WaitlistTimeout())
+	defer timeout.Stop()
+	updates := time.NewTicker(models.WaitlistUpdateInterval)
+	defer updates.Stop()
+
+	for {
+		busyMsg := Message{
+			Type:      models.MessageTypeServerBusy,
+			Timestamp: time.Now(),
+			Payload: map[string]interface{}{
+				"position":    ticket.Position(),
+				"retry_after": s.Admission.RetryAfterSeconds(),
+			},
+		}
+		if err := conn.WriteJSON(busyMsg); err != nil {
+			log.Printf("Waitlisted client %s went away: %v", conn.UserID, err)
+			return false
+		}
+
+		select {
+		case <-ticket.Ready():
+			conn.WriteJSON(Message{
+				Type:      models.MessageTypeAdmitted,
+				Timestamp: time.Now(),
+			})
+			log.Printf("[DEBUG] Waitlisted client %s admitted", conn.UserID)
+			return true
+		case <-updates.C:
+		case <-timeout.C:
+			writeErrorMessage(conn, "Timed out waiting for a free slot, please retry later")
+			return false
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+	"voice-chat-app/models"
+)
+
+// BotSignal identifies a behavioural heuristic
+type BotSignal string
+
+const (
+	SignalFastFindMatch    BotSignal = "fast_find_match"
+	SignalExcessiveSkips   BotSignal = "excessive_skips"
+	SignalNoNegotiation    BotSignal = "no_negotiation"
+	SignalDuplicatePayload BotSignal = "duplicate_payload"
+	SignalRegularPing      BotSignal = "regular_ping"
+)
+
+// BotAction is the response to a session's bot score
+type BotAction string
+
+const (
+	BotActionNone       BotAction = "none"
+	BotActionChallenge  BotAction = "challenge"
+	BotActionThrottle   BotAction = "throttle"
+	BotActionShadowBan  BotAction = "shadow_ban"
+	BotActionDisconnect BotAction = "disconnect"
+)
+
+// BotDetectorConfig holds score thresholds for each action. A threshold of
+// zero disables that action.
+type BotDetectorConfig struct {
+	ChallengeScore   float64
+	ThrottleScore    float64
+	ShadowBanScore   float64
+	DisconnectScore  float64
+	ThrottleDuration time.Duration
+	FingerprintTypes []string // message types whose payloads are fingerprinted; defaults to share_contact
+}
+
+// Signal weights and parameters
+const (
+	fastFindMatchWindow     = 500 * time.Millisecond
+	fastFindMatchWeight     = 30
+	skipsPerMinuteLimit     = 10
+	excessiveSkipWeight     = 10
+	noNegotiationMatches    = 5
+	noNegotiationWeight     = 25
+	duplicatePayloadSenders = 3
+	duplicatePayloadWeight  = 30
+	duplicatePayloadTTL     = 10 * time.Minute
+	regularPingSamples      = 6
+	regularPingMaxVariation = 0.01 // coefficient of variation of intervals
+	regularPingWeight       = 20
+	idleSessionTTL          = 10 * time.Minute // how long a silent identity keeps its score
+)
+
+// sessionBehaviour accumulates the signals seen for one identity, across
+// its sessions, so reconnecting does not reset the score
+type sessionBehaviour struct {
+	startedAt      time.Time // when the latest session started
+	lastSeen       time.Time
+	findMatches    []time.Time
+	negotiated     bool
+	pingTimes      []time.Time
+	fired          map[BotSignal]bool
+	score          float64
+	action         BotAction
+	throttledUntil time.Time
+}
+
+// payloadFingerprint tracks which identities sent a given free-text payload,
+// such as the same contact handle advertised from many sessions
+type payloadFingerprint struct {
+	senders  map[string]bool
+	lastSeen time.Time
+}
+
+// BotDetector scores per-identity behaviour and recommends actions once the
+// score crosses configured thresholds. Callers key it by User.Identity().
+type BotDetector struct {
+	config       BotDetectorConfig
+	sessions     map[string]*sessionBehaviour
+	payloads     map[[32]byte]*payloadFingerprint
+	fingerprint  map[string]bool
+	signalCounts map[BotSignal]int
+	actionCounts map[BotAction]int
+	mutex        sync.Mutex
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+// NewBotDetector creates a detector with the given thresholds. Call Stop to
+// end its cleanup goroutine.
+func NewBotDetector(config BotDetectorConfig) *BotDetector {
+	if config.ThrottleDuration <= 0 {
+		config.ThrottleDuration = time.Minute
+	}
+	if len(config.FingerprintTypes) == 0 {
+		config.FingerprintTypes = []string{models.MessageTypeShareContact}
+	}
+
+	d := &BotDetector{
+		config:       config,
+		sessions:     make(map[string]*sessionBehaviour),
+		payloads:     make(map[[32]byte]*payloadFingerprint),
+		fingerprint:  make(map[string]bool),
+		signalCounts: make(map[BotSignal]int),
+		actionCounts: make(map[BotAction]int),
+		stop:         make(chan struct{}),
+	}
+	for _, msgType := range config.FingerprintTypes {
+		d.fingerprint[msgType] = true
+	}
+
+	go d.cleanupPayloads()
+	return d
+}
+
+// Stop ends the detector's cleanup goroutine
+func (d *BotDetector) Stop() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// StartSession begins tracking a session from the moment its session message
+// is sent. An identity seen recently keeps its score.
+func (d *BotDetector) StartSession(identity string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	session := d.sessionLocked(identity, now)
+	session.startedAt = now
+}
+
+// EndSession stops tracking a session. An identity with a score is kept
+// until it has been silent for a while, so it cannot reconnect to clear it.
+func (d *BotDetector) EndSession(identity string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	session := d.sessions[identity]
+	if session == nil {
+		return
+	}
+	if session.score == 0 {
+		delete(d.sessions, identity)
+		return
+	}
+	session.lastSeen = time.Now()
+}
+
+// Transfer moves the behaviour tracked for one identity to another, such as
+// an anonymous device upgrading to an account, so upgrading does not reset
+// the score. If both are tracked, the higher score is kept.
+func (d *BotDetector) Transfer(from, to string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if from == to {
+		return
+	}
+	session := d.sessions[from]
+	if session == nil {
+		return
+	}
+	delete(d.sessions, from)
+	if existing := d.sessions[to]; existing == nil || existing.score < session.score {
+		d.sessions[to] = session
+	}
+
+	for _, fingerprint := range d.payloads {
+		if fingerprint.senders[from] {
+			delete(fingerprint.senders, from)
+			fingerprint.senders[to] = true
+		}
+	}
+}
+
+// sessionLocked returns the behaviour tracked for an identity, creating it if
+// needed. Caller must hold the lock.
+func (d *BotDetector) sessionLocked(identity string, now time.Time) *sessionBehaviour {
+	session := d.sessions[identity]
+	if session == nil {
+		session = &sessionBehaviour{
+			fired:  make(map[BotSignal]bool),
+			action: BotActionNone,
+		}
+		d.sessions[identity] = session
+	}
+	session.lastSeen = now
+	return session
+}
+
+// Observe records a message and returns the action to take. An action is only
+// returned the first time the score crosses into its band.
+func (d *BotDetector) Observe(identity string, msg Message) BotAction {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// Another session of the identity may have ended and let it go
+	now := time.Now()
+	session := d.sessionLocked(identity, now)
+	switch {
+	case msg.Type == models.MessageTypeFindMatch:
+		d.observeFindMatch(session, now)
+	case msg.Type == models.MessageTypeOffer || msg.Type == models.MessageTypeAnswer:
+		session.negotiated = true
+	case msg.Type == models.MessageTypePing:
+		d.observePing(session, now)
+	case d.fingerprint[msg.Type]:
+		d.observePayload(identity, session, msg.Payload, now)
+	}
+
+	action := d.actionForScore(session.score)
+	if actionRank(action) <= actionRank(session.action) {
+		return BotActionNone
+	}
+
+	session.action = action
+	d.actionCounts[action]++
+	if action == BotActionThrottle {
+		session.throttledUntil = now.Add(d.config.ThrottleDuration)
+	}
+	return action
+}
+
+// IsThrottled reports whether the identity is currently throttled
+func (d *BotDetector) IsThrottled(identity string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	session := d.sessions[identity]
+	return session != nil && time.Now().Before(session.throttledUntil)
+}
+
+// Score returns the current score of an identity
+func (d *BotDetector) Score(identity string) float64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if session := d.sessions[identity]; session != nil {
+		return session.score
+	}
+	return 0
+}
+
+// GetStats returns per-signal and per-action counters
+func (d *BotDetector) GetStats() map[string]interface{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	signals := make(map[string]int)
+	for _, signal := range []BotSignal{SignalFastFindMatch, SignalExcessiveSkips, SignalNoNegotiation, SignalDuplicatePayload, SignalRegularPing} {
+		signals[string(signal)] = d.signalCounts[signal]
+	}
+	actions := make(map[string]int)
+	for _, action := range []BotAction{BotActionChallenge, BotActionThrottle, BotActionShadowBan, BotActionDisconnect} {
+		actions[string(action)] = d.actionCounts[action]
+	}
+
+	return map[string]interface{}{
+		"tracked_sessions": len(d.sessions),
+		"signals":          signals,
+		"actions":          actions,
+	}
+}
+
+func (d *BotDetector) observeFindMatch(session *sessionBehaviour, now time.Time) {
+	firstInSession := len(session.findMatches) == 0 || session.findMatches[len(session.findMatches)-1].Before(session.startedAt)
+	if firstInSession && now.Sub(session.startedAt) < fastFindMatchWindow {
+		d.fire(session, SignalFastFindMatch, fastFindMatchWeight, true)
+	}
+
+	// Keep only the last minute of find_match requests
+	cutoff := now.Add(-time.Minute)
+	recent := session.findMatches[:0]
+	for _, t := range session.findMatches {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	session.findMatches = append(recent, now)
+
+	if len(session.findMatches) > skipsPerMinuteLimit {
+		d.fire(session, SignalExcessiveSkips, excessiveSkipWeight, false)
+	}
+
+	if !session.negotiated && len(session.findMatches) >= noNegotiationMatches {
+		d.fire(session, SignalNoNegotiation, noNegotiationWeight, true)
+	}
+}
+
+func (d *BotDetector) observePing(session *sessionBehaviour, now time.Time) {
+	session.pingTimes = append(session.pingTimes, now)
+	if len(session.pingTimes) > regularPingSamples+1 {
+		session.pingTimes = session.pingTimes[1:]
+	}
+	if len(session.pingTimes) <= regularPingSamples {
+		return
+	}
+
+	intervals := make([]float64, 0, regularPingSamples)
+	for i := 1; i < len(session.pingTimes); i++ {
+		intervals = append(intervals, session.pingTimes[i].Sub(session.pingTimes[i-1]).Seconds())
+	}
+	if coefficientOfVariation(intervals) < regularPingMaxVariation {
+		d.fire(session, SignalRegularPing, regularPingWeight, true)
+	}
+}
+
+func (d *BotDetector) observePayload(identity string, session *sessionBehaviour, payload interface{}, now time.Time) {
+	data, err := json.Marshal(payload)
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	key := sha256.Sum256(data)
+	fingerprint := d.payloads[key]
+	if fingerprint == nil {
+		fingerprint = &payloadFingerprint{senders: make(map[string]bool)}
+		d.payloads[key] = fingerprint
+	}
+	fingerprint.senders[identity] = true
+	fingerprint.lastSeen = now
+
+	if len(fingerprint.senders) >= duplicatePayloadSenders {
+		d.fire(session, SignalDuplicatePayload, duplicatePayloadWeight, true)
+	}
+}
+
+// fire adds a signal's weight to the session score. Once-only signals count
+// a single time per session. Caller must hold the lock.
+func (d *BotDetector) fire(session *sessionBehaviour, signal BotSignal, weight float64, once bool) {
+	if once && session.fired[signal] {
+		return
+	}
+	session.fired[signal] = true
+	session.score += weight
+	d.signalCounts[signal]++
+}
+
+// actionForScore maps a score to the strongest action whose threshold it meets
+func (d *BotDetector) actionForScore(score float64) BotAction {
+	switch {
+	case d.config.DisconnectScore > 0 && score >= d.config.DisconnectScore:
+		return BotActionDisconnect
+	case d.config.ShadowBanScore > 0 && score >= d.config.ShadowBanScore:
+		return BotActionShadowBan
+	case d.config.ThrottleScore > 0 && score >= d.config.ThrottleScore:
+		return BotActionThrottle
+	case d.config.ChallengeScore > 0 && score >= d.config.ChallengeScore:
+		return BotActionChallenge
+	default:
+		return BotActionNone
+	}
+}
+
+// cleanupPayloads forgets payload fingerprints and identities that have not
+// been seen recently
+func (d *BotDetector) cleanupPayloads() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+
+		d.mutex.Lock()
+		now := time.Now()
+		cutoff := now.Add(-duplicatePayloadTTL)
+		for key, fingerprint := range d.payloads {
+			if fingerprint.lastSeen.Before(cutoff) {
+				delete(d.payloads, key)
+			}
+		}
+		d.forgetIdleLocked(now)
+		d.mutex.Unlock()
+	}
+}
+
+// forgetIdleLocked drops identities that have been silent for idleSessionTTL
+// and are not throttled. Caller must hold the lock.
+func (d *BotDetector) forgetIdleLocked(now time.Time) {
+	cutoff := now.Add(-idleSessionTTL)
+	for identity, session := range d.sessions {
+		if session.lastSeen.Before(cutoff) && !now.Before(session.throttledUntil) {
+			delete(d.sessions, identity)
+		}
+	}
+}
+
+// actionRank orders actions by severity
+func actionRank(action BotAction) int {
+	switch action {
+	case BotActionChallenge:
+		return 1
+	case BotActionThrottle:
+		return 2
+	case BotActionShadowBan:
+		return 3
+	case BotActionDisconnect:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// coefficientOfVariation returns the standard deviation divided by the mean
+func coefficientOfVariation(values []float64) float64 {
+	if len(values) == 0 {
+		return math.Inf(1)
+	}
+
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(values))
+
+	return math.Sqrt(variance) / mean
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBotDetector(t *testing.T) *BotDetector {
+	detector := NewBotDetector(BotDetectorConfig{
+		ChallengeScore:  30,
+		ThrottleScore:   50,
+		ShadowBanScore:  80,
+		DisconnectScore: 200,
+	})
+	t.Cleanup(detector.Stop)
+	return detector
+}
+
+func TestBotDetector_FastFindMatchTriggersChallenge(t *testing.T) {
+	detector := newTestBotDetector(t)
+	detector.StartSession("bot")
+
+	action := detector.Observe("bot", Message{Type: "find_match"})
+	assert.Equal(t, BotActionChallenge, action)
+	assert.Equal(t, float64(fastFindMatchWeight), detector.Score("bot"))
+
+	// The same band does not trigger again
+	assert.Equal(t, BotActionNone, detector.Observe("bot", Message{Type: "find_match"}))
+
+	stats := detector.GetStats()
+	assert.Equal(t, 1, stats["signals"].(map[string]int)[string(SignalFastFindMatch)])
+	assert.Equal(t, 1, stats["actions"].(map[string]int)[string(BotActionChallenge)])
+}
+
+func TestBotDetector_SkipsWithoutNegotiationEscalate(t *testing.T) {
+	detector := newTestBotDetector(t)
+	detector.StartSession("skipper")
+	detector.sessions["skipper"].startedAt = time.Now().Add(-time.Minute)
+
+	var actions []BotAction
+	for i := 0; i < skipsPerMinuteLimit+3; i++ {
+		if action := detector.Observe("skipper", Message{Type: "find_match"}); action != BotActionNone {
+			actions = append(actions, action)
+		}
+	}
+
+	// no_negotiation (25) plus the first excessive skip crosses the challenge
+	// band; two more skips cross throttle but stay short of shadow ban
+	assert.Equal(t, []BotAction{BotActionChallenge, BotActionThrottle}, actions)
+	assert.True(t, detector.IsThrottled("skipper"))
+}
+
+func TestBotDetector_NegotiatingUserIsNotFlagged(t *testing.T) {
+	detector := newTestBotDetector(t)
+	detector.StartSession("human")
+	detector.sessions["human"].startedAt = time.Now().Add(-time.Minute)
+
+	for i := 0; i < noNegotiationMatches; i++ {
+		detector.Observe("human", Message{Type: "offer"})
+		assert.Equal(t, BotActionNone, detector.Observe("human", Message{Type: "find_match"}))
+	}
+	assert.Zero(t, detector.Score("human"))
+}
+
+func TestBotDetector_DuplicatePayloadAcrossSessions(t *testing.T) {
+	detector := newTestBotDetector(t)
+	spam := map[string]interface{}{"handle": "@visit_my_site"}
+
+	for _, id := range []string{"s1", "s2", "s3"} {
+		detector.StartSession(id)
+		detector.Observe(id, Message{Type: "share_contact", Payload: spam})
+	}
+
+	assert.Zero(t, detector.Score("s1"))
+	assert.Equal(t, float64(duplicatePayloadWeight), detector.Score("s3"))
+}
+
+func TestBotDetector_RegularPingCadence(t *testing.T) {
+	detector := newTestBotDetector(t)
+	detector.StartSession("metronome")
+
+	// Pings every 10 seconds, the next one due now
+	session := detector.sessions["metronome"]
+	now := time.Now()
+	for i := regularPingSamples; i > 0; i-- {
+		session.pingTimes = append(session.pingTimes, now.Add(-time.Duration(i)*10*time.Second))
+	}
+
+	detector.Observe("metronome", Message{Type: "ping"})
+	assert.Equal(t, float64(regularPingWeight), detector.Score("metronome"))
+}
+
+func TestBotDetector_ScoreSurvivesReconnect(t *testing.T) {
+	detector := newTestBotDetector(t)
+	detector.StartSession("device-1")
+	assert.Equal(t, BotActionChallenge, detector.Observe("device-1", Message{Type: "find_match"}))
+
+	// A new session of the same identity keeps the score and its action band
+	detector.EndSession("device-1")
+	detector.StartSession("device-1")
+	assert.Equal(t, float64(fastFindMatchWeight), detector.Score("device-1"))
+	assert.Equal(t, BotActionNone, detector.Observe("device-1", Message{Type: "ping"}))
+
+	// Identities without a score are dropped when they end, the rest once idle
+	detector.StartSession("device-2")
+	detector.EndSession("device-2")
+	assert.NotContains(t, detector.sessions, "device-2")
+	detector.EndSession("device-1")
+	detector.sessions["device-1"].lastSeen = time.Now().Add(-idleSessionTTL - time.Second)
+	detector.mutex.Lock()
+	detector.forgetIdleLocked(time.Now())
+	detector.mutex.Unlock()
+	assert.Zero(t, detector.Score("device-1"))
+}
+
+func TestBotDetector_TransferKeepsScore(t *testing.T) {
+	detector := newTestBotDetector(t)
+	detector.StartSession("device-1")
+	assert.Equal(t, BotActionChallenge, detector.Observe("device-1", Message{Type: "find_match"}))
+
+	// Upgrading to an account keeps the score under the account
+	detector.Transfer("device-1", "account-1")
+	assert.Zero(t, detector.Score("device-1"))
+	assert.Equal(t, float64(fastFindMatchWeight), detector.Score("account-1"))
+	assert.Equal(t, BotActionNone, detector.Observe("account-1", Message{Type: "ping"}))
+}
+
+func TestCoefficientOfVariation(t *testing.T) {
+	assert.InDelta(t, 0, coefficientOfVariation([]float64{5, 5, 5}), 1e-9)
+	assert.Greater(t, coefficientOfVariation([]float64{1, 10, 3}), 0.5)
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"voice-chat-app/middleware"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// ChallengeConfig configures the proof-of-work admission challenge
+type ChallengeConfig struct {
+	BaseDifficulty int           // leading zero bits required under normal load
+	MaxDifficulty  int           // upper bound when under heavy load
+	RateThreshold  int           // connection attempts per minute before difficulty rises
+	Timeout        time.Duration // how long a challenge stays valid
+}
+
+// Challenge is a proof-of-work puzzle sent to the client. The client must
+// find a solution such that sha256(nonce + ":" + solution) has at least
+// Difficulty leading zero bits.
+type Challenge struct {
+	Nonce      string    `json:"nonce"`
+	Difficulty int       `json:"difficulty"`
+	Algorithm  string    `json:"algorithm"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Challenger issues and verifies proof-of-work challenges. Nonces are signed
+// so they can be verified without server-side state.
+type Challenger struct {
+	config      ChallengeConfig
+	secret      []byte
+	rateLimiter *middleware.RateLimiter
+
+	// Nonces redeemed over HTTP, kept until they expire so a solution
+	// cannot be replayed for several sessions
+	redeemed map[string]int64
+	mutex    sync.Mutex
+}
+
+// NewChallenger creates a challenger. The rate limiter, if provided, is used
+// to raise the difficulty as the connection rate grows.
+func NewChallenger(config ChallengeConfig, rateLimiter *middleware.RateLimiter) (*Challenger, error) {
+	secret, err := utils.GenerateSecureSecret()
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = models.ChallengeTimeout
+	}
+	return &Challenger{
+		config:      config,
+		secret:      secret,
+		rateLimiter: rateLimiter,
+		redeemed:    make(map[string]int64),
+	}, nil
+}
+
+// Difficulty returns the current difficulty. It grows by one bit each time
+// the connection rate doubles past the threshold.
+func (c *Challenger) Difficulty() int {
+	difficulty := c.config.BaseDifficulty
+	if c.rateLimiter != nil && c.config.RateThreshold > 0 {
+		rate := c.rateLimiter.ConnectionRate()
+		if rate > c.config.RateThreshold {
+			difficulty += int(math.Ceil(math.Log2(float64(rate) / float64(c.config.RateThreshold))))
+		}
+	}
+	if difficulty > c.config.MaxDifficulty {
+		difficulty = c.config.MaxDifficulty
+	}
+	return difficulty
+}
+
+// Issue creates a new challenge at the current difficulty
+func (c *Challenger) Issue() Challenge {
+	random := make([]byte, 16)
+	rand.Read(random)
+
+	difficulty := c.Difficulty()
+	expiresAt := time.Now().Add(c.config.Timeout)
+	payload := fmt.Sprintf("%s.%d.%d", hex.EncodeToString(random), difficulty, expiresAt.Unix())
+
+	return Challenge{
+		Nonce:      payload + "." + c.sign(payload),
+		Difficulty: difficulty,
+		Algorithm:  "sha256",
+		ExpiresAt:  expiresAt,
+	}
+}
+
+// Verify checks that nonce was issued by this challenger, has not expired and
+// that solution meets the difficulty encoded in it
+func (c *Challenger) Verify(nonce, solution string) bool {
+	parts := strings.Split(nonce, ".")
+	if len(parts) != 4 || solution == "" || len(solution) > 128 {
+		return false
+	}
+
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(c.sign(payload))) {
+		return false
+	}
+
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(nonce + ":" + solution))
+	return leadingZeroBits(sum[:]) >= difficulty
+}
+
+// VerifyOnce is Verify for stateless transports such as HTTP: a nonce is
+// accepted only the first time a valid solution is presented for it
+func (c *Challenger) VerifyOnce(nonce, solution string) bool {
+	if !c.Verify(nonce, solution) {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now().Unix()
+	for redeemed, expiresAt := range c.redeemed {
+		if now > expiresAt {
+			delete(c.redeemed, redeemed)
+		}
+	}
+	if _, used := c.redeemed[nonce]; used {
+		return false
+	}
+
+	// Verify already checked the expiry field parses
+	parts := strings.Split(nonce, ".")
+	expiresAt, _ := strconv.ParseInt(parts[2], 10, 64)
+	c.redeemed[nonce] = expiresAt
+	return true
+}
+
+// IssuePass returns a pass that lets the client at clientIP skip the
+// challenge until it expires. Connections admitted by a pass are not given a
+// new one, so one solved challenge admits a client for at most
+// models.ChallengePassTTL.
+func (c *Challenger) IssuePass(clientIP string) (string, time.Time) {
+	expiresAt := time.Now().Add(models.ChallengePassTTL)
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expires + "." + c.passSignature(expires, clientIP), expiresAt
+}
+
+// IsTrusted reports whether the request may skip the challenge: it must
+// carry an unexpired pass from IssuePass, issued to the same client IP, in
+// the X-Challenge-Pass header or the challenge_pass query parameter
+func (c *Challenger) IsTrusted(r *http.Request) bool {
+	pass := r.Header.Get("X-Challenge-Pass")
+	if pass == "" {
+		pass = r.URL.Query().Get("challenge_pass")
+	}
+	expires, signature, found := strings.Cut(pass, ".")
+	if !found {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(c.passSignature(expires, utils.ClientIP(r))))
+}
+
+// passSignature binds a pass to its expiry and client IP. The "pass." prefix
+// keeps passes and challenge nonces from being interchangeable.
+func (c *Challenger) passSignature(expires, clientIP string) string {
+	return c.sign("pass." + expires + "." + clientIP)
+}
+
+func (c *Challenger) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// leadingZeroBits counts the leading zero bits of a hash
+func leadingZeroBits(hash []byte) int {
+	count := 0
+	for _, b := range hash {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// runChallenge sends a challenge and blocks until the client solves it. Any
+// other message, including find_match, is rejected until then. Returns false
+// if the client fails, times out or disconnects.
+func (s *SignalingServer) runChallenge(conn *models.Connection) bool {
+	challenge := s.Challenger.Issue()
+
+	challengeMsg := Message{
+		Type:      models.MessageTypeChallenge,
+		Timestamp: time.Now(),
+		Payload:   challenge,
+	}
+	if err := conn.WriteJSON(challengeMsg); err != nil {
+		log.Printf("Error sending challenge to %s: %v", conn.UserID, err)
+		return false
+	}
+
+	conn.Conn.SetReadDeadline(challenge.ExpiresAt)
+	defer conn.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+
+	attempts := 0
+	for {
+		var msg Message
+		if err := conn.Conn.ReadJSON(&msg); err != nil {
+			log.Printf("Challenge read error for %s: %v", conn.UserID, err)
+			return false
+		}
+
+		if msg.Type != models.MessageTypeChallengeResponse {
+			if msg.Type == models.MessageTypePong {
+				continue
+			}
+			writeErrorMessage(conn, "Challenge must be solved before sending "+msg.Type)
+			continue
+		}
+
+		payload, _ := msg.Payload.(map[string]interface{})
+		nonce, _ := payload["nonce"].(string)
+		solution, _ := payload["solution"].(string)
+
+		if nonce == challenge.Nonce && s.Challenger.Verify(nonce, solution) {
+			pass, passExpiresAt := s.Challenger.IssuePass(conn.ClientIP)
+			conn.WriteJSON(Message{
+				Type:      models.MessageTypeChallengePassed,
+				Timestamp: time.Now(),
+				Payload: map[string]interface{}{
+					"pass":            pass,
+					"pass_expires_at": passExpiresAt,
+				},
+			})
+			log.Printf("[DEBUG] Challenge passed by %s at difficulty %d", conn.UserID, challenge.Difficulty)
+			return true
+		}
+
+		attempts++
+		log.Printf("Invalid challenge solution from %s (attempt %d)", conn.UserID, attempts)
+		if attempts >= models.MaxChallengeAttempts {
+			writeErrorMessage(conn, "Too many invalid challenge solutions")
+			return false
+		}
+		writeErrorMessage(conn, "Invalid challenge solution")
+	}
+}
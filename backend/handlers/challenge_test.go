@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+	"voice-chat-app/middleware"
+	"voice-chat-app/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// solveChallenge brute-forces a proof-of-work solution
+func solveChallenge(challenge Challenge) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(challenge.Nonce + ":" + solution))
+		if leadingZeroBits(sum[:]) >= challenge.Difficulty {
+			return solution
+		}
+	}
+}
+
+func TestChallenger_IssueAndVerify(t *testing.T) {
+	challenger, err := NewChallenger(ChallengeConfig{BaseDifficulty: 8, MaxDifficulty: 12}, nil)
+	require.NoError(t, err)
+
+	challenge := challenger.Issue()
+	assert.Equal(t, 8, challenge.Difficulty)
+	assert.Equal(t, "sha256", challenge.Algorithm)
+
+	solution := solveChallenge(challenge)
+	assert.True(t, challenger.Verify(challenge.Nonce, solution))
+
+	// Wrong solution, tampered nonce and foreign nonce all fail
+	assert.False(t, challenger.Verify(challenge.Nonce, ""))
+	tampered := challenge.Nonce[:len(challenge.Nonce)-1] + "0"
+	if tampered == challenge.Nonce {
+		tampered = challenge.Nonce[:len(challenge.Nonce)-1] + "1"
+	}
+	assert.False(t, challenger.Verify(tampered, solution))
+
+	other, err := NewChallenger(ChallengeConfig{BaseDifficulty: 8, MaxDifficulty: 12}, nil)
+	require.NoError(t, err)
+	assert.False(t, other.Verify(challenge.Nonce, solution))
+}
+
+func TestChallenger_Expiry(t *testing.T) {
+	challenger, err := NewChallenger(ChallengeConfig{BaseDifficulty: 1, MaxDifficulty: 1}, nil)
+	require.NoError(t, err)
+
+	// Issue challenges that are already expired
+	challenger.config.Timeout = -time.Second
+
+	challenge := challenger.Issue()
+	assert.False(t, challenger.Verify(challenge.Nonce, solveChallenge(challenge)))
+}
+
+func TestChallenger_VerifyOnce(t *testing.T) {
+	challenger, err := NewChallenger(ChallengeConfig{BaseDifficulty: 4, MaxDifficulty: 4}, nil)
+	require.NoError(t, err)
+
+	challenge := challenger.Issue()
+	solution := solveChallenge(challenge)
+
+	assert.True(t, challenger.VerifyOnce(challenge.Nonce, solution))
+	assert.False(t, challenger.VerifyOnce(challenge.Nonce, solution), "a solved nonce cannot be replayed")
+}
+
+func TestChallenger_Pass(t *testing.T) {
+	challenger, err := NewChallenger(ChallengeConfig{BaseDifficulty: 4, MaxDifficulty: 4}, nil)
+	require.NoError(t, err)
+
+	request := func(remoteAddr, pass string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		r.RemoteAddr = remoteAddr
+		if pass != "" {
+			r.Header.Set("X-Challenge-Pass", pass)
+		}
+		return r
+	}
+
+	pass, expiresAt := challenger.IssuePass("203.0.113.7")
+	assert.WithinDuration(t, time.Now().Add(models.ChallengePassTTL), expiresAt, time.Second)
+	assert.True(t, challenger.IsTrusted(request("203.0.113.7:5000", pass)))
+
+	// Passes are bound to the client and to this challenger
+	assert.False(t, challenger.IsTrusted(request("198.51.100.1:5000", pass)))
+	assert.False(t, challenger.IsTrusted(request("203.0.113.7:5000", "")))
+	other, err := NewChallenger(ChallengeConfig{BaseDifficulty: 4, MaxDifficulty: 4}, nil)
+	require.NoError(t, err)
+	assert.False(t, other.IsTrusted(request("203.0.113.7:5000", pass)))
+
+	// A challenge nonce is not a pass
+	assert.False(t, challenger.IsTrusted(request("203.0.113.7:5000", challenger.Issue().Nonce)))
+}
+
+func TestChallenger_AdaptiveDifficulty(t *testing.T) {
+	rateLimiter := middleware.NewRateLimiter(60, 100, 10)
+	challenger, err := NewChallenger(ChallengeConfig{BaseDifficulty: 10, MaxDifficulty: 13, RateThreshold: 10}, rateLimiter)
+	require.NoError(t, err)
+
+	assert.Equal(t, 10, challenger.Difficulty())
+
+	for i := 0; i < 40; i++ {
+		rateLimiter.RecordConnectionAttempt()
+	}
+	// 40 attempts is four times the threshold: two extra bits
+	assert.Equal(t, 12, challenger.Difficulty())
+
+	for i := 0; i < 1000; i++ {
+		rateLimiter.RecordConnectionAttempt()
+	}
+	assert.Equal(t, 13, challenger.Difficulty())
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	assert.Equal(t, 0, leadingZeroBits([]byte{0x80}))
+	assert.Equal(t, 7, leadingZeroBits([]byte{0x01}))
+	assert.Equal(t, 12, leadingZeroBits([]byte{0x00, 0x0f}))
+	assert.Equal(t, 16, leadingZeroBits([]byte{0x00, 0x00}))
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"log"
+	"time"
+	"voice-chat-app/models"
+)
+
+// handleShareContact seals the user's handle in their room. The partner is
+// told a handle is waiting but not what it is; only once they share a
+// handle too does each side receive the other's.
+func (s *SignalingServer) handleShareContact(msg Message, user *models.User) {
+	if !s.ContactExchange {
+		s.sendError(user, "Contact exchange is not enabled")
+		return
+	}
+
+	payload, _ := msg.Payload.(map[string]interface{})
+	rawHandle, _ := payload["handle"].(string)
+	handle, err := models.NormalizeContactHandle(rawHandle)
+	if err != nil {
+		s.sendError(user, "Contact handles must be 1 to 64 printable characters")
+		return
+	}
+
+	exchange, err := s.UserPool.ShareContact(user.ID, handle)
+	if err != nil {
+		s.sendError(user, "No conversation to share a contact in")
+		return
+	}
+	partner := s.UserPool.GetUser(exchange.PartnerUserID)
+
+	if exchange.Handles == nil {
+		log.Printf("[DEBUG] User %s sealed a contact handle in room %s", user.ID, exchange.RoomID)
+		if partner != nil {
+			partner.Connection.WriteJSON(Message{
+				Type:      models.MessageTypeContactRequested,
+				Timestamp: time.Now(),
+				Payload: map[string]interface{}{
+					"room_id": exchange.RoomID,
+				},
+			})
+		}
+		return
+	}
+
+	log.Printf("Contact handles revealed in room %s", exchange.RoomID)
+	for _, recipient := range []*models.User{user, partner} {
+		if recipient == nil {
+			continue
+		}
+		other := exchange.PartnerUserID
+		if recipient != user {
+			other = user.ID
+		}
+		if err := recipient.Connection.WriteJSON(Message{
+			Type:      models.MessageTypeContactRevealed,
+			Timestamp: time.Now(),
+			Payload: map[string]interface{}{
+				"room_id": exchange.RoomID,
+				"handle":  exchange.Handles[other],
+			},
+		}); err != nil {
+			log.Printf("Error sending contact_revealed to user %s: %v", recipient.ID, err)
+		}
+	}
+}
+
+// handleWithdrawContact discards the user's sealed handle before their
+// partner has shared one, and tells the partner
+func (s *SignalingServer) handleWithdrawContact(user *models.User) {
+	if !s.ContactExchange {
+		s.sendError(user, "Contact exchange is not enabled")
+		return
+	}
+
+	partnerID, withdrawn := s.UserPool.WithdrawContact(user.ID)
+	if !withdrawn {
+		s.sendError(user, "No contact handle to withdraw")
+		return
+	}
+	log.Printf("[DEBUG] User %s withdrew their contact handle", user.ID)
+
+	if partner := s.UserPool.GetUser(partnerID); partner != nil {
+		partner.Connection.WriteJSON(Message{
+			Type:      models.MessageTypeContactWithdrawn,
+			Timestamp: time.Now(),
+			Payload:   map[string]interface{}{},
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// presentedDeviceToken returns the device token a client presents on
+// connect. Browsers cannot set headers on a WebSocket upgrade, so they
+// connect with device_auth=frame and send the token as their first frame,
+// keeping it out of URLs and access logs. ok is false if that frame could not
+// be read and the connection should be closed.
+func presentedDeviceToken(r *http.Request, conn *models.Connection) (token string, ok bool) {
+	if token := r.Header.Get("X-Device-Token"); token != "" {
+		return token, true
+	}
+	if r.URL.Query().Get("device_auth") != "frame" {
+		return "", true
+	}
+
+	conn.Conn.SetReadDeadline(time.Now().Add(models.DeviceTokenFrameTimeout))
+	defer conn.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+
+	for {
+		var msg Message
+		if err := conn.Conn.ReadJSON(&msg); err != nil {
+			log.Printf("Device token read error for %s: %v", conn.UserID, err)
+			return "", false
+		}
+		if msg.Type == models.MessageTypePong {
+			continue
+		}
+		if msg.Type != models.MessageTypeDeviceToken {
+			writeErrorMessage(conn, "Expected device_token before "+msg.Type)
+			return "", false
+		}
+
+		// An empty token asks for a new device
+		payload, _ := msg.Payload.(map[string]interface{})
+		token, _ := payload["token"].(string)
+		return token, true
+	}
+}
+
+// resolveDevice maps the device token a client presented on connect to its
+// device ID. A client without a valid token is registered as a new device,
+// and a token close to expiry is renewed; newToken is set whenever the client
+// should store a new token.
+func resolveDevice(presented, remoteAddr string) (deviceID, newToken string) {
+	if presented != "" {
+		claims, err := utils.ValidateDeviceToken(presented)
+		if err == nil {
+			if time.Until(claims.ExpiresAt.Time) > models.DeviceTokenRenewWindow {
+				return claims.DeviceID, ""
+			}
+			deviceID = claims.DeviceID
+		} else {
+			log.Printf("Ignoring device token from %s: %v", remoteAddr, err)
+		}
+	}
+
+	deviceID, newToken, err := utils.GenerateDeviceToken(deviceID)
+	if err != nil {
+		log.Printf("Device token generation error: %v", err)
+		return "", ""
+	}
+	return deviceID, newToken
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"log"
+	"time"
+	"voice-chat-app/models"
+)
+
+// defaultNames generates display names when the server has no word lists
+var defaultNames = models.NewNameGenerator(nil, nil)
+
+// nameGenerator returns the generator for new users' display names
+func (s *SignalingServer) nameGenerator() *models.NameGenerator {
+	if s.Names != nil {
+		return s.Names
+	}
+	return defaultNames
+}
+
+// handleSetDisplayName replaces the user's generated display name with one
+// they chose, provided it passes the text filter and no one connected has it
+func (s *SignalingServer) handleSetDisplayName(msg Message, user *models.User) {
+	payload, _ := msg.Payload.(map[string]interface{})
+	rawName, _ := payload["display_name"].(string)
+	name, err := models.NormalizeDisplayName(rawName)
+	if err != nil {
+		s.sendError(user, "Display names must be 2 to 32 letters, digits or spaces")
+		return
+	}
+	if s.TextFilter != nil && !s.TextFilter.Allows(name) {
+		s.sendError(user, "That display name is not allowed")
+		return
+	}
+
+	if err := s.UserPool.ClaimDisplayName(user, name); err != nil {
+		s.sendError(user, "That display name is taken")
+		return
+	}
+	log.Printf("[DEBUG] User %s set their display name", user.ID)
+
+	user.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeDisplayNameSet,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"display_name": name,
+		},
+	})
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"voice-chat-app/errors"
+	"voice-chat-app/middleware"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// mailSendTimeout bounds a single delivery attempt
+const mailSendTimeout = 30 * time.Second
+
+// AccountEmail sends email verification and password reset links
+type AccountEmail struct {
+	Mailer      utils.Mailer
+	Limiter     *middleware.AddressRateLimiter // per-address limit on mail sent
+	LinkBaseURL string                         // frontend URL the links point at
+}
+
+// NewAccountEmail creates the account mail sender
+func NewAccountEmail(mailer utils.Mailer, limiter *middleware.AddressRateLimiter, linkBaseURL string) *AccountEmail {
+	return &AccountEmail{
+		Mailer:      mailer,
+		Limiter:     limiter,
+		LinkBaseURL: strings.TrimSuffix(linkBaseURL, "/"),
+	}
+}
+
+// sendLink mails a link carrying a token. Delivery happens in the
+// background so response timing does not reveal whether mail was sent.
+func (e *AccountEmail) sendLink(to, subject, path, token, text string) {
+	link := e.LinkBaseURL + path + "?token=" + url.QueryEscape(token)
+	mail := utils.Mail{
+		To:      to,
+		Subject: subject,
+		Body:    fmt.Sprintf("%s\n\n%s\n\nIf you did not ask for this, you can ignore this message.\n", text, link),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := e.Mailer.Send(ctx, mail); err != nil {
+			log.Printf("Error sending %q mail: %v", subject, err)
+		}
+	}()
+}
+
+// emailRequest is the body of the email and password reset endpoints
+type emailRequest struct {
+	Email    string `json:"email"`
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// decodeEmailRequest reads an email request body, writing an error response
+// and returning false if it is malformed
+func decodeEmailRequest(w http.ResponseWriter, r *http.Request) (*emailRequest, bool) {
+	var req emailRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Invalid request body"))
+		return nil, false
+	}
+	return &req, true
+}
+
+// emailEnabled writes a not found response unless account mail and the
+// accounts it serves are configured
+func (s *SignalingServer) emailEnabled(w http.ResponseWriter) bool {
+	if s.Email == nil {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("email endpoint"))
+		return false
+	}
+	return s.accountsEnabled(w)
+}
+
+// allowMail applies the per-address mail limit, writing a rate limit
+// response and returning false when the address has had enough mail
+func (s *SignalingServer) allowMail(w http.ResponseWriter, email string) bool {
+	if s.Email.Limiter == nil || s.Email.Limiter.Allow(email) {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(s.Email.Limiter.RetryAfter().Seconds())))
+	errors.WriteErrorResponse(w, errors.NewRateLimitError("Too many emails sent to this address; try again later"))
+	return false
+}
+
+// writeActionTokenError maps a rejected mailed token to a response
+func writeActionTokenError(w http.ResponseWriter, err error) {
+	switch err {
+	case utils.ErrTokenUsed:
+		errors.WriteErrorResponse(w, errors.NewUnauthorizedError("This link has already been used"))
+	case utils.ErrTokenExpired:
+		errors.WriteErrorResponse(w, errors.NewUnauthorizedError("This link has expired"))
+	default:
+		errors.WriteErrorResponse(w, errors.NewUnauthorizedError("This link is invalid"))
+	}
+}
+
+// HandleSetEmail sets the email address of the caller's account and mails
+// a verification link to it
+func (s *SignalingServer) HandleSetEmail(w http.ResponseWriter, r *http.Request) {
+	if !s.emailEnabled(w) {
+		return
+	}
+
+	claims, err := utils.ValidateJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewUnauthorizedError("A valid session token is required"))
+		return
+	}
+	if claims.AccountID == "" {
+		errors.WriteErrorResponse(w, errors.NewForbiddenError("Only registered accounts can add an email address"))
+		return
+	}
+
+	req, ok := decodeEmailRequest(w, r)
+	if !ok {
+		return
+	}
+	email, err := models.NormalizeEmail(req.Email)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	if !s.allowMail(w, email) {
+		return
+	}
+
+	account, err := s.Accounts.SetEmail(claims.AccountID, email)
+	if err == models.ErrEmailTaken {
+		// Answer as if a link was mailed, so addresses of other accounts
+		// cannot be discovered
+		log.Printf("Setting email refused for account %s: %v", claims.AccountID, err)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"email": email, "email_verified": false})
+		return
+	}
+	if err != nil {
+		log.Printf("Setting email refused for account %s: %v", claims.AccountID, err)
+		writeAccountError(w, err)
+		return
+	}
+	if account.EmailVerified {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"email": email, "email_verified": true})
+		return
+	}
+
+	token, err := utils.GenerateActionToken(utils.TokenPurposeVerifyEmail, account.ID, email, models.EmailVerifyTokenTTL)
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to create verification link", err))
+		return
+	}
+	s.Email.sendLink(email, "Verify your email address", "/verify-email", token,
+		"Open this link to verify your email address:")
+
+	log.Printf("[DEBUG] Verification mail queued for account %s", account.ID)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"email": email, "email_verified": false})
+}
+
+// HandleVerifyEmail confirms an email address from a mailed link
+func (s *SignalingServer) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if !s.emailEnabled(w) {
+		return
+	}
+	req, ok := decodeEmailRequest(w, r)
+	if !ok {
+		return
+	}
+
+	claims, err := utils.ConsumeActionToken(req.Token, utils.TokenPurposeVerifyEmail)
+	if err != nil {
+		writeActionTokenError(w, err)
+		return
+	}
+	account, err := s.Accounts.VerifyEmail(claims.AccountID, claims.Email)
+	if err != nil {
+		log.Printf("Email verification refused for account %s: %v", claims.AccountID, err)
+		writeAccountError(w, err)
+		return
+	}
+
+	log.Printf("[DEBUG] Account %s verified its email address", account.ID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"account_id":     account.ID,
+		"email":          account.Email,
+		"email_verified": true,
+	})
+}
+
+// HandleForgotPassword mails a password reset link to a verified address.
+// The response is the same whether or not an account uses the address.
+func (s *SignalingServer) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if !s.emailEnabled(w) {
+		return
+	}
+	req, ok := decodeEmailRequest(w, r)
+	if !ok {
+		return
+	}
+	email, err := models.NormalizeEmail(req.Email)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	if !s.allowMail(w, email) {
+		return
+	}
+
+	if account := s.Accounts.FindByVerifiedEmail(email); account != nil {
+		token, err := utils.GenerateActionToken(utils.TokenPurposeResetPassword, account.ID, email, models.PasswordResetTokenTTL)
+		if err != nil {
+			errors.WriteErrorResponse(w, errors.NewInternalError("Failed to create reset link", err))
+			return
+		}
+		s.Email.sendLink(email, "Reset your password", "/reset-password", token,
+			"Open this link to choose a new password. It works once and expires in an hour:")
+		log.Printf("[DEBUG] Password reset mail queued for account %s", account.ID)
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+}
+
+// HandleResetPassword sets a new password from a mailed reset link and
+// starts a session for the account
+func (s *SignalingServer) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if !s.emailEnabled(w) {
+		return
+	}
+	req, ok := decodeEmailRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Check the password before spending the single-use token on it
+	if err := models.ValidatePassword(req.Password); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	claims, err := utils.ConsumeActionToken(req.Token, utils.TokenPurposeResetPassword)
+	if err != nil {
+		writeActionTokenError(w, err)
+		return
+	}
+
+	account, err := s.Accounts.ResetPassword(claims.AccountID, claims.Email, req.Password)
+	if err != nil {
+		log.Printf("Password reset refused for account %s: %v", claims.AccountID, err)
+		writeAccountError(w, err)
+		return
+	}
+
+	// Sessions opened with the old password end, including connected ones
+	// at their next heartbeat; the session issued below stays valid
+	if err := utils.RevokeAccountSessions(account.ID); err != nil {
+		log.Printf("Failed to revoke sessions of account %s: %v", account.ID, err)
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to revoke existing sessions", err))
+		return
+	}
+	utils.Audit(r.Context(), utils.AuditActionTokenRevoke, map[string]interface{}{
+		"target_account_id": account.ID,
+		"reason":            "password_reset",
+	})
+
+	log.Printf("[DEBUG] Account %s reset its password", account.ID)
+	s.writeSession(w, http.StatusOK, utils.GenerateUUID(), account)
+}
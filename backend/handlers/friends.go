@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"log"
+	"time"
+	"voice-chat-app/models"
+)
+
+// handleAddFriend records that the user wants to befriend their current or
+// most recent partner. Nothing is sent to the partner until they ask too;
+// then both are told the friend ID they can call each other by.
+func (s *SignalingServer) handleAddFriend(user *models.User) {
+	if s.Friends == nil {
+		s.sendError(user, "Friends are not enabled")
+		return
+	}
+
+	request, err := s.UserPool.RequestFriend(user.ID, models.FriendRequestWindow)
+	if err != nil {
+		s.sendError(user, "No recent conversation to add a friend from")
+		return
+	}
+	if !request.Mutual {
+		log.Printf("[DEBUG] User %s asked to befriend their partner in room %s", user.ID, request.RoomID)
+		return
+	}
+
+	friendship, err := s.Friends.Add(request.Requester, request.Partner)
+	if err != nil {
+		log.Printf("Error saving friendship from room %s: %v", request.RoomID, err)
+		s.sendError(user, "Failed to add friend")
+		return
+	}
+	log.Printf("Users in room %s became friends", request.RoomID)
+
+	added := Message{
+		Type:      models.MessageTypeFriendAdded,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"friend_id": friendship.ID,
+			"since":     friendship.CreatedAt,
+		},
+	}
+	user.Connection.WriteJSON(added)
+
+	// The partner may have reconnected since the call, so find them by identity
+	if partner := s.UserPool.FindUserByIdentity(request.Partner); partner != nil {
+		if err := partner.Connection.WriteJSON(added); err != nil {
+			log.Printf("Error sending friend_added to user %s: %v", partner.ID, err)
+		}
+	}
+}
+
+// handleGetFriends sends the user their friend list
+func (s *SignalingServer) handleGetFriends(user *models.User) {
+	if s.Friends == nil {
+		s.sendError(user, "Friends are not enabled")
+		return
+	}
+
+	// Friends who appear offline are listed as offline
+	online := func(identity string) bool {
+		if s.Presence != nil {
+			return s.Presence.Status(identity) != models.PresenceOffline
+		}
+		return s.UserPool.FindUserByIdentity(identity) != nil
+	}
+	user.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeFriends,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"friends": s.Friends.List(user.Identities(), online),
+		},
+	})
+}
+
+// handleCallFriend puts the user and a friend straight into a room, skipping
+// matchmaking, and rings the friend. The friend answers with the usual
+// call_accept or call_reject. A friend whose app has no live connection is
+// rung by push when push notifications are enabled.
+func (s *SignalingServer) handleCallFriend(msg Message, user *models.User) {
+	if s.Friends == nil {
+		s.sendError(user, "Friends are not enabled")
+		return
+	}
+
+	payload, _ := msg.Payload.(map[string]interface{})
+	friendID, _ := payload["friend_id"].(string)
+	identity, err := s.Friends.Friend(user.Identities(), friendID)
+	if err != nil {
+		s.sendError(user, "Unknown friend")
+		return
+	}
+
+	// A friend without a live connection may still be woken by a push
+	friend := s.UserPool.FindUserByIdentity(identity)
+	if (friend == nil || !friend.Connection.Active()) && s.Push != nil && s.ringByPush(user, identity, friendID) {
+		return
+	}
+	if friend == nil || friend.ID == user.ID {
+		s.sendError(user, "Friend is not online")
+		return
+	}
+	if s.ringFriend(user, friend, friendID) == nil {
+		s.sendError(user, "Friend is busy")
+	}
+}
+
+// ringFriend puts a caller and a friend into a direct room and rings the
+// friend. The call is missed if the friend does not answer within
+// models.RingingTimeout. Returns nil if either is busy or the friend cannot
+// be reached.
+func (s *SignalingServer) ringFriend(user, friend *models.User, friendID string) *models.Room {
+	room := s.UserPool.CreateDirectRoom(user, friend)
+	if room == nil {
+		return nil
+	}
+
+	log.Printf("[DEBUG] User %s calling friend %s directly in room %s", user.ID, friend.ID, room.ID)
+
+	if err := friend.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeCallIncoming,
+		From:      user.ID,
+		To:        friend.ID,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"caller_id": user.ID,
+			"room_id":   room.ID,
+			"friend_id": friendID,
+		},
+	}); err != nil {
+		log.Printf("Error sending call_incoming to friend %s: %v", friend.ID, err)
+		s.UserPool.EndRoom(room.ID, "Friend could not be reached")
+		return nil
+	}
+
+	user.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeMatchFound,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"partner_id":          friend.ID,
+			"partner_name":        friend.DisplayName,
+			"partner_avatar_seed": friend.AvatarSeed,
+			"room_id":             room.ID,
+			"role":                "caller",
+			"friend_id":           friendID,
+		},
+	})
+	user.CallState = models.CallStateRinging
+
+	time.AfterFunc(models.RingingTimeout, func() {
+		if !s.UserPool.EndUnansweredRoom(room.ID, "Friend did not answer") {
+			return
+		}
+		log.Printf("[DEBUG] Friend %s did not answer call in room %s", friend.ID, room.ID)
+		user.Connection.WriteJSON(Message{
+			Type:      models.MessageTypeCallMissed,
+			Timestamp: time.Now(),
+			Payload: map[string]interface{}{
+				"friend_id": friendID,
+			},
+		})
+	})
+	return room
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"voice-chat-app/errors"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// oidcStateCookie holds a login's state in the browser that started it, so
+// a callback carrying a state from another browser is refused
+const oidcStateCookie = "oidc_state"
+
+// oidcPending is a login waiting for the identity provider to redirect back
+type oidcPending struct {
+	codeVerifier string
+	nonce        string
+	expiresAt    time.Time
+}
+
+// OIDCLogin runs single sign-on through an OpenID Connect provider. Each
+// login gets its own state, nonce and PKCE verifier, kept server-side until
+// the provider redirects back. The browser is then sent to AppRedirectURL
+// with a connect ticket, or an error, in the URL fragment.
+type OIDCLogin struct {
+	Client         *utils.OIDCClient
+	AppRedirectURL string
+	pending        map[string]*oidcPending // state -> login
+	mutex          sync.Mutex
+}
+
+// NewOIDCLogin creates a login handler for a provider that returns the
+// browser to appRedirectURL
+func NewOIDCLogin(client *utils.OIDCClient, appRedirectURL string) *OIDCLogin {
+	return &OIDCLogin{
+		Client:         client,
+		AppRedirectURL: appRedirectURL,
+		pending:        make(map[string]*oidcPending),
+	}
+}
+
+// begin records a new login and returns its state, nonce and PKCE challenge
+func (l *OIDCLogin) begin() (state, nonce, codeChallenge string, err error) {
+	random := make([]byte, 32)
+	if _, err = rand.Read(random); err != nil {
+		return "", "", "", err
+	}
+	state, nonce = hex.EncodeToString(random[:16]), hex.EncodeToString(random[16:])
+
+	verifier, codeChallenge, err := utils.NewPKCEVerifier()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Abandoned logins are dropped as new ones start
+	now := time.Now()
+	for id, pending := range l.pending {
+		if now.After(pending.expiresAt) {
+			delete(l.pending, id)
+		}
+	}
+	l.pending[state] = &oidcPending{
+		codeVerifier: verifier,
+		nonce:        nonce,
+		expiresAt:    now.Add(models.OIDCLoginTimeout),
+	}
+	return state, nonce, codeChallenge, nil
+}
+
+// complete consumes a login by its state. Each state can be used once.
+func (l *OIDCLogin) complete(state string) (*oidcPending, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	pending, exists := l.pending[state]
+	delete(l.pending, state)
+	if !exists || time.Now().After(pending.expiresAt) {
+		return nil, false
+	}
+	return pending, true
+}
+
+// oidcEnabled writes a not found response unless single sign-on and the
+// accounts it signs in to are configured
+func (s *SignalingServer) oidcEnabled(w http.ResponseWriter) bool {
+	if s.OIDC == nil {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("single sign-on"))
+		return false
+	}
+	return s.accountsEnabled(w)
+}
+
+// HandleOIDCLogin redirects the browser to the identity provider
+func (s *SignalingServer) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.oidcEnabled(w) {
+		return
+	}
+
+	state, nonce, codeChallenge, err := s.OIDC.begin()
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to start login", err))
+		return
+	}
+
+	authURL, err := s.OIDC.Client.AuthCodeURL(r.Context(), state, nonce, codeChallenge)
+	if err != nil {
+		log.Printf("OIDC login unavailable: %v", err)
+		errors.WriteErrorResponse(w, errors.NewServerBusyError("Identity provider is unavailable"))
+		return
+	}
+
+	s.OIDC.setStateCookie(w, state, int(models.OIDCLoginTimeout.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleOIDCCallback completes a login when the identity provider redirects
+// back, trading the authorization code for a verified ID token and then for
+// one of our own sessions. The browser is sent back to the app with a
+// single-use connect ticket, whose session message carries the token.
+func (s *SignalingServer) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !s.oidcEnabled(w) {
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	s.OIDC.setStateCookie(w, "", -1)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		log.Printf("OIDC login refused: state does not match this browser")
+		s.OIDC.redirectToApp(w, r, url.Values{"error": {"invalid_state"}})
+		return
+	}
+	pending, ok := s.OIDC.complete(state)
+	if !ok {
+		s.OIDC.redirectToApp(w, r, url.Values{"error": {"login_expired"}})
+		return
+	}
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("OIDC login refused by provider: %s", providerErr)
+		s.OIDC.redirectToApp(w, r, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	identity, err := s.OIDC.Client.Exchange(r.Context(), query.Get("code"), pending.codeVerifier, pending.nonce)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		s.OIDC.redirectToApp(w, r, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	displayName := identity.Name
+	if identity.Email != "" && identity.EmailVerified {
+		displayName = identity.Email
+	}
+	account, err := s.Accounts.FindOrCreateExternal(identity.Issuer, identity.Subject, displayName)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		s.OIDC.redirectToApp(w, r, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	_, ticket, err := s.issueSession(utils.GenerateUUID(), account)
+	if err != nil {
+		s.OIDC.redirectToApp(w, r, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	log.Printf("[DEBUG] Account %s signed in through %s", account.ID, identity.Issuer)
+	s.OIDC.redirectToApp(w, r, url.Values{"ticket": {ticket.ID}})
+}
+
+// setStateCookie sets or, with a negative maxAge, clears the state cookie.
+// It is only sent to the callback, and only over https when the callback
+// is served over https.
+func (l *OIDCLogin) setStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(l.Client.RedirectURL(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// redirectToApp sends the browser back to the app with the outcome of the
+// login in the URL fragment, which browsers never send to servers
+func (l *OIDCLogin) redirectToApp(w http.ResponseWriter, r *http.Request, outcome url.Values) {
+	http.Redirect(w, r, l.AppRedirectURL+"#"+outcome.Encode(), http.StatusFound)
+}
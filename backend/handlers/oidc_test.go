@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCLogin_StateIsSingleUse(t *testing.T) {
+	login := NewOIDCLogin(nil, "")
+	state, nonce, challenge, err := login.begin()
+	require.NoError(t, err)
+	assert.NotEmpty(t, challenge)
+
+	pending, ok := login.complete(state)
+	require.True(t, ok)
+	assert.Equal(t, nonce, pending.nonce)
+
+	_, ok = login.complete(state)
+	assert.False(t, ok)
+	_, ok = login.complete("")
+	assert.False(t, ok)
+}
+
+func TestOIDCLogin_ExpiredStatesArePruned(t *testing.T) {
+	login := NewOIDCLogin(nil, "")
+	state, _, _, err := login.begin()
+	require.NoError(t, err)
+	login.pending[state].expiresAt = time.Now().Add(-time.Second)
+
+	_, ok := login.complete(state)
+	assert.False(t, ok)
+
+	expired, _, _, err := login.begin()
+	require.NoError(t, err)
+	login.pending[expired].expiresAt = time.Now().Add(-time.Second)
+	_, _, _, err = login.begin()
+	require.NoError(t, err)
+	assert.Len(t, login.pending, 1)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"log"
+	"time"
+	"voice-chat-app/models"
+)
+
+// handleSubscribePresence starts sending the user their friends' presence,
+// beginning with a presence message holding every friend's current state
+func (s *SignalingServer) handleSubscribePresence(user *models.User) {
+	if s.Presence == nil {
+		s.sendError(user, "Presence is not enabled")
+		return
+	}
+
+	friends := s.Presence.Subscribe(user)
+	log.Printf("[DEBUG] User %s subscribed to presence of %d friends", user.ID, len(friends))
+
+	user.Connection.WriteJSON(Message{
+		Type:      models.MessageTypePresence,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"friends": friends,
+		},
+	})
+}
+
+// handleUnsubscribePresence stops sending the user presence updates
+func (s *SignalingServer) handleUnsubscribePresence(user *models.User) {
+	if s.Presence == nil {
+		s.sendError(user, "Presence is not enabled")
+		return
+	}
+	s.Presence.Unsubscribe(user.ID)
+}
+
+// handleSetPresence updates what the user's friends see. Either field may be
+// left out to keep its current value.
+func (s *SignalingServer) handleSetPresence(msg Message, user *models.User) {
+	if s.Presence == nil {
+		s.sendError(user, "Presence is not enabled")
+		return
+	}
+
+	payload, _ := msg.Payload.(map[string]interface{})
+	if away, ok := payload["away"].(bool); ok {
+		s.UserPool.SetAway(user.ID, away)
+	}
+	if hidden, ok := payload["appear_offline"].(bool); ok {
+		s.Presence.SetAppearOffline(user.Identity(), hidden)
+		log.Printf("[DEBUG] User %s set appear offline to %v", user.ID, hidden)
+	}
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// pushSendTimeout bounds a single push delivery attempt
+const pushSendTimeout = 10 * time.Second
+
+// pendingRing is a friend call waiting for a friend woken by a push to
+// connect
+type pendingRing struct {
+	callerID  string
+	friendID  string
+	expiresAt time.Time
+}
+
+// PushNotifier reaches friends whose app has no live connection. It wakes
+// friends who are called, holding the call until they connect, and tells
+// them about voice notes.
+type PushNotifier struct {
+	Providers map[string]utils.PushProvider // platform -> provider
+	Tokens    *models.PushTokenStore
+	rings     map[string]map[string]*pendingRing // callee identity -> caller ID -> ring
+	sent      int
+	failed    int
+	answered  int
+	missed    int
+	mutex     sync.Mutex
+}
+
+// NewPushNotifier creates a notifier sending through the given providers
+func NewPushNotifier(providers map[string]utils.PushProvider, tokens *models.PushTokenStore) *PushNotifier {
+	return &PushNotifier{
+		Providers: providers,
+		Tokens:    tokens,
+		rings:     make(map[string]map[string]*pendingRing),
+	}
+}
+
+// notify sends a push to every registered device of identity, returning
+// false if no device can be reached by push
+func (n *PushNotifier) notify(identity string, push utils.Push) bool {
+	var registrations []models.PushRegistration
+	for _, registration := range n.Tokens.Lookup(identity) {
+		if n.Providers[registration.Platform] != nil {
+			registrations = append(registrations, registration)
+		}
+	}
+	for _, registration := range registrations {
+		go n.send(registration, push)
+	}
+	return len(registrations) > 0
+}
+
+// ring pushes an incoming call to every registered device of identity and
+// records the call until it is answered or expires. Several friends can
+// ring the same identity at once; each keeps its own ring. Returns nil if no
+// device can be reached by push.
+func (n *PushNotifier) ring(identity, callerID, friendID string) *pendingRing {
+	ring := &pendingRing{
+		callerID:  callerID,
+		friendID:  friendID,
+		expiresAt: time.Now().Add(models.RingingTimeout),
+	}
+	push := utils.Push{
+		Title: "Incoming call",
+		Body:  "A friend is calling you",
+		Data: map[string]string{
+			"type":       models.MessageTypeCallIncoming,
+			"friend_id":  friendID,
+			"expires_at": ring.expiresAt.UTC().Format(time.RFC3339),
+		},
+		TTL:        models.RingingTimeout,
+		CollapseID: friendID,
+	}
+	if !n.notify(identity, push) {
+		return nil
+	}
+
+	n.mutex.Lock()
+	if n.rings[identity] == nil {
+		n.rings[identity] = make(map[string]*pendingRing)
+	}
+	n.rings[identity][callerID] = ring
+	n.mutex.Unlock()
+	return ring
+}
+
+// send delivers a push to one device, forgetting its token if the push
+// service no longer accepts it
+func (n *PushNotifier) send(registration models.PushRegistration, push utils.Push) {
+	ctx, cancel := context.WithTimeout(context.Background(), pushSendTimeout)
+	defer cancel()
+
+	push.Token = registration.Token
+	err := n.Providers[registration.Platform].Send(ctx, push)
+
+	n.mutex.Lock()
+	if err != nil {
+		n.failed++
+	} else {
+		n.sent++
+	}
+	n.mutex.Unlock()
+
+	if errors.Is(err, utils.ErrPushTokenInvalid) {
+		log.Printf("Forgetting rejected %s push token of device %s", registration.Platform, registration.DeviceID)
+		if err := n.Tokens.Invalidate(registration.DeviceID, registration.Token); err != nil {
+			log.Printf("Error forgetting push token of device %s: %v", registration.DeviceID, err)
+		}
+	} else if err != nil {
+		log.Printf("Error sending %s push to device %s: %v", registration.Platform, registration.DeviceID, err)
+	}
+}
+
+// answer takes the earliest unexpired call waiting for any of a user's
+// identities. Other callers keep ringing, and expired calls are left for
+// expire so their callers are still told the call was missed.
+func (n *PushNotifier) answer(identities []string) *pendingRing {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	var answered *pendingRing
+	var answeredIdentity string
+	for _, identity := range identities {
+		for _, ring := range n.rings[identity] {
+			if !now.Before(ring.expiresAt) {
+				continue
+			}
+			if answered == nil || ring.expiresAt.Before(answered.expiresAt) {
+				answered, answeredIdentity = ring, identity
+			}
+		}
+	}
+	if answered == nil {
+		return nil
+	}
+	n.removeLocked(answeredIdentity, answered)
+	n.answered++
+	return answered
+}
+
+// expire drops a call that is still waiting, reporting whether it was
+func (n *PushNotifier) expire(identity string, ring *pendingRing) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.rings[identity][ring.callerID] != ring {
+		return false
+	}
+	n.removeLocked(identity, ring)
+	n.missed++
+	return true
+}
+
+// removeLocked forgets a waiting call. Caller must hold the lock.
+func (n *PushNotifier) removeLocked(identity string, ring *pendingRing) {
+	delete(n.rings[identity], ring.callerID)
+	if len(n.rings[identity]) == 0 {
+		delete(n.rings, identity)
+	}
+}
+
+// GetStats returns push counters
+func (n *PushNotifier) GetStats() map[string]interface{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	pending := 0
+	for _, rings := range n.rings {
+		pending += len(rings)
+	}
+	return map[string]interface{}{
+		"pending_rings": pending,
+		"sent":          n.sent,
+		"failed":        n.failed,
+		"answered":      n.answered,
+		"missed":        n.missed,
+		"tokens":        n.Tokens.GetStats(),
+	}
+}
+
+// ringByPush calls a friend with no live connection through a push. The
+// caller is told the friend is ringing, and that the call was missed if the
+// friend does not connect within models.RingingTimeout. Returns false if the
+// friend has no device to push to.
+func (s *SignalingServer) ringByPush(caller *models.User, identity, friendID string) bool {
+	ring := s.Push.ring(identity, caller.ID, friendID)
+	if ring == nil {
+		return false
+	}
+	log.Printf("[DEBUG] User %s calling offline friend %s by push", caller.ID, friendID)
+
+	caller.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeCallRinging,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"friend_id":  friendID,
+			"expires_at": ring.expiresAt,
+		},
+	})
+
+	time.AfterFunc(models.RingingTimeout, func() {
+		if s.Push.expire(identity, ring) {
+			s.notifyCallMissed(ring)
+		}
+	})
+	return true
+}
+
+// answerPushRing connects a user who has just connected to a friend calling
+// them by push
+func (s *SignalingServer) answerPushRing(user *models.User) {
+	if s.Push == nil {
+		return
+	}
+	ring := s.Push.answer(user.Identities())
+	if ring == nil {
+		return
+	}
+
+	caller := s.UserPool.GetUser(ring.callerID)
+	if caller == nil || s.ringFriend(caller, user, ring.friendID) == nil {
+		log.Printf("[DEBUG] Friend call to user %s by push could not be connected", user.ID)
+		s.notifyCallMissed(ring)
+		return
+	}
+	log.Printf("[DEBUG] User %s answered a friend call by push", user.ID)
+}
+
+// notifyCallMissed tells a caller their friend did not pick up
+func (s *SignalingServer) notifyCallMissed(ring *pendingRing) {
+	caller := s.UserPool.GetUser(ring.callerID)
+	if caller == nil {
+		return
+	}
+	caller.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeCallMissed,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"friend_id": ring.friendID,
+		},
+	})
+}
+
+// handleRegisterPush records the push token of the user's device
+func (s *SignalingServer) handleRegisterPush(msg Message, user *models.User) {
+	if s.Push == nil {
+		s.sendError(user, "Push notifications are not enabled")
+		return
+	}
+	if user.DeviceID == "" {
+		s.sendError(user, "Push notifications require a device token")
+		return
+	}
+
+	payload, _ := msg.Payload.(map[string]interface{})
+	platform, _ := payload["platform"].(string)
+	token, _ := payload["token"].(string)
+	if s.Push.Providers[platform] == nil {
+		s.sendError(user, "Unsupported push platform")
+		return
+	}
+	if err := s.Push.Tokens.Register(user.DeviceID, user.Account(), platform, token); err != nil {
+		if err != models.ErrInvalidPushToken {
+			log.Printf("Error saving push token of device %s: %v", user.DeviceID, err)
+			s.sendError(user, "Failed to register push token")
+			return
+		}
+		s.sendError(user, "Invalid push token")
+		return
+	}
+	log.Printf("[DEBUG] Registered %s push token for device %s", platform, user.DeviceID)
+}
+
+// handleUnregisterPush forgets the push token of the user's device
+func (s *SignalingServer) handleUnregisterPush(user *models.User) {
+	if s.Push == nil {
+		s.sendError(user, "Push notifications are not enabled")
+		return
+	}
+	if user.DeviceID == "" {
+		return
+	}
+	if err := s.Push.Tokens.Unregister(user.DeviceID); err != nil {
+		log.Printf("Error forgetting push token of device %s: %v", user.DeviceID, err)
+		s.sendError(user, "Failed to unregister push token")
+	}
+}
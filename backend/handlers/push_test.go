@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushNotifier_RingAnswerAndExpire(t *testing.T) {
+	fake := utils.NewFakePushProvider()
+	tokens := models.NewPushTokenStore()
+	notifier := NewPushNotifier(map[string]utils.PushProvider{models.PushPlatformFCM: fake}, tokens)
+
+	// Devices registered for a platform without a provider cannot be rung
+	require.NoError(t, tokens.Register("device-a", "", models.PushPlatformAPNs, "abcd"))
+	assert.Nil(t, notifier.ring("device-a", "caller", "friend-1"))
+
+	require.NoError(t, tokens.Register("device-b", "", models.PushPlatformFCM, "token-b"))
+	ring := notifier.ring("device-b", "caller", "friend-1")
+	require.NotNil(t, ring)
+	require.Eventually(t, func() bool { return len(fake.Sent()) == 1 }, time.Second, 5*time.Millisecond)
+	push := fake.Sent()[0]
+	assert.Equal(t, "token-b", push.Token)
+	assert.Equal(t, models.RingingTimeout, push.TTL)
+	assert.Equal(t, "friend-1", push.Data["friend_id"])
+
+	// A ring is answered once, by any of the callee's identities
+	assert.Same(t, ring, notifier.answer([]string{"account-b", "device-b"}))
+	assert.Nil(t, notifier.answer([]string{"device-b"}))
+	assert.False(t, notifier.expire("device-b", ring), "an answered ring does not expire")
+
+	// An expired ring is not answered
+	ring = notifier.ring("device-b", "caller", "friend-1")
+	ring.expiresAt = time.Now().Add(-time.Second)
+	assert.Nil(t, notifier.answer([]string{"device-b"}))
+
+	ring = notifier.ring("device-b", "caller", "friend-1")
+	assert.True(t, notifier.expire("device-b", ring))
+	assert.Equal(t, 1, notifier.GetStats()["missed"])
+}
+
+func TestPushNotifier_ForgetsRejectedTokens(t *testing.T) {
+	fake := utils.NewFakePushProvider()
+	fake.Err = utils.ErrPushTokenInvalid
+	tokens := models.NewPushTokenStore()
+	notifier := NewPushNotifier(map[string]utils.PushProvider{models.PushPlatformFCM: fake}, tokens)
+
+	require.NoError(t, tokens.Register("device-b", "", models.PushPlatformFCM, "token-b"))
+	require.NotNil(t, notifier.ring("device-b", "caller", "friend-1"))
+	require.Eventually(t, func() bool { return len(tokens.Lookup("device-b")) == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, notifier.GetStats()["failed"])
+}
+
+func TestPushNotifier_RingsFromSeveralCallers(t *testing.T) {
+	fake := utils.NewFakePushProvider()
+	tokens := models.NewPushTokenStore()
+	notifier := NewPushNotifier(map[string]utils.PushProvider{models.PushPlatformFCM: fake}, tokens)
+	require.NoError(t, tokens.Register("device-b", "", models.PushPlatformFCM, "token-b"))
+
+	// A second caller does not replace the first caller's ring
+	first := notifier.ring("device-b", "caller-1", "friend-1")
+	second := notifier.ring("device-b", "caller-2", "friend-2")
+	require.NotNil(t, first)
+	require.NotNil(t, second)
+	assert.Equal(t, 2, notifier.GetStats()["pending_rings"])
+
+	// Both calls are reported missed when nobody answers
+	assert.True(t, notifier.expire("device-b", first))
+	assert.True(t, notifier.expire("device-b", second))
+	assert.Equal(t, 2, notifier.GetStats()["missed"])
+
+	// The earliest call is answered first and the other keeps ringing
+	first = notifier.ring("device-b", "caller-1", "friend-1")
+	second = notifier.ring("device-b", "caller-2", "friend-2")
+	second.expiresAt = first.expiresAt.Add(time.Second)
+	assert.Same(t, first, notifier.answer([]string{"device-b"}))
+	assert.False(t, notifier.expire("device-b", first))
+	assert.True(t, notifier.expire("device-b", second))
+
+	// An expired ring is left for expire so its caller hears it was missed
+	first = notifier.ring("device-b", "caller-1", "friend-1")
+	first.expiresAt = time.Now().Add(-time.Second)
+	assert.Nil(t, notifier.answer([]string{"device-b"}))
+	assert.True(t, notifier.expire("device-b", first))
+}
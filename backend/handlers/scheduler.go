@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// CallScheduler keeps one timer per scheduled call, armed for the call's
+// next reminder or its start. The calls themselves live in the store, so
+// the timers can be rebuilt from it after a restart.
+type CallScheduler struct {
+	Calls     *models.ScheduledCallStore
+	Clock     utils.Clock
+	Reminders []time.Duration // before the start, longest first
+	due       func(id string)
+	timers    map[string]utils.Timer
+	reminded  int
+	started   int
+	missed    int
+	cancelled int
+	mutex     sync.Mutex
+}
+
+// NewCallScheduler creates a scheduler for the calls in store, sending a
+// reminder at each of the given offsets before a call starts
+func NewCallScheduler(calls *models.ScheduledCallStore, clock utils.Clock, reminders []time.Duration) *CallScheduler {
+	if clock == nil {
+		clock = utils.RealClock
+	}
+	reminders = append([]time.Duration(nil), reminders...)
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i] > reminders[j] })
+
+	return &CallScheduler{
+		Calls:     calls,
+		Clock:     clock,
+		Reminders: reminders,
+		timers:    make(map[string]utils.Timer),
+	}
+}
+
+// start arms a timer for every stored call. due is called with a call's ID
+// whenever one of its reminders or its start falls due.
+func (c *CallScheduler) start(due func(id string)) {
+	c.mutex.Lock()
+	c.due = due
+	c.mutex.Unlock()
+
+	for _, call := range c.Calls.All() {
+		c.arm(call)
+	}
+}
+
+// remindersDue counts the reminders for a call whose time has come by now
+func (c *CallScheduler) remindersDue(call models.ScheduledCall, now time.Time) int {
+	due := 0
+	for _, offset := range c.Reminders {
+		if !call.StartsAt.Add(-offset).After(now) {
+			due++
+		}
+	}
+	return due
+}
+
+// arm sets the timer for a call's next reminder, or its start once every
+// reminder has been sent
+func (c *CallScheduler) arm(call models.ScheduledCall) {
+	at := call.StartsAt
+	if call.RemindersSent < len(c.Reminders) {
+		at = call.StartsAt.Add(-c.Reminders[call.RemindersSent])
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if timer := c.timers[call.ID]; timer != nil {
+		timer.Stop()
+	}
+	due := c.due
+	c.timers[call.ID] = c.Clock.AfterFunc(at.Sub(c.Clock.Now()), func() { due(call.ID) })
+}
+
+// disarm stops a call's timer
+func (c *CallScheduler) disarm(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if timer := c.timers[id]; timer != nil {
+		timer.Stop()
+		delete(c.timers, id)
+	}
+}
+
+// count adds one to a counter under the lock
+func (c *CallScheduler) count(counter *int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	*counter++
+}
+
+// GetStats returns scheduler counters
+func (c *CallScheduler) GetStats() map[string]interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return map[string]interface{}{
+		"scheduled": c.Calls.Len(),
+		"reminded":  c.reminded,
+		"started":   c.started,
+		"missed":    c.missed,
+		"cancelled": c.cancelled,
+	}
+}
+
+// StartScheduler arms the scheduler for the calls already stored, such as
+// those saved before a restart. Calls whose start passed more than
+// models.ScheduledCallGrace ago are dropped as missed.
+func (s *SignalingServer) StartScheduler() {
+	if s.Scheduler != nil {
+		s.Scheduler.start(s.scheduledCallDue)
+	}
+}
+
+// scheduledCallDue sends a call's reminders or, at its start, rings both
+// parties. A call between friends who are no longer friends is dropped
+// without a word to either.
+func (s *SignalingServer) scheduledCallDue(id string) {
+	call, err := s.Scheduler.Calls.Get(id)
+	if err != nil {
+		return
+	}
+	now := s.Scheduler.Clock.Now()
+
+	if s.Friends == nil || !s.Friends.Exists(call.FriendID) {
+		s.Scheduler.disarm(id)
+		if _, err := s.Scheduler.Calls.Remove(id); err != nil {
+			log.Printf("Error removing scheduled call %s: %v", id, err)
+		}
+		log.Printf("[DEBUG] Scheduled call %s dropped; the friendship has ended", id)
+		s.Scheduler.count(&s.Scheduler.cancelled)
+		return
+	}
+
+	if now.Before(call.StartsAt) {
+		// Reminders that fell due together, e.g. while the server was
+		// down, are sent as one
+		if due := s.Scheduler.remindersDue(call, now); due > call.RemindersSent {
+			if call, err = s.Scheduler.Calls.MarkReminded(id, due); err != nil {
+				log.Printf("Error saving reminder for scheduled call %s: %v", id, err)
+			}
+			s.Scheduler.count(&s.Scheduler.reminded)
+			s.notifyScheduledCall(call, models.MessageTypeCallReminder, "Upcoming call", "Your call with a friend starts soon")
+		}
+		s.Scheduler.arm(call)
+		return
+	}
+
+	s.Scheduler.disarm(id)
+	if _, err := s.Scheduler.Calls.Remove(id); err != nil {
+		log.Printf("Error removing started scheduled call %s: %v", id, err)
+	}
+	if now.Sub(call.StartsAt) > models.ScheduledCallGrace {
+		log.Printf("Scheduled call %s was due at %s and is dropped", id, call.StartsAt.Format(time.RFC3339))
+		s.Scheduler.count(&s.Scheduler.missed)
+		s.notifyScheduledCall(call, models.MessageTypeCallMissed, "Missed call", "A call you scheduled with a friend was missed")
+		return
+	}
+	s.startScheduledCall(call)
+}
+
+// startScheduledCall opens a private room for a scheduled call and rings
+// both parties. A party whose app has no live connection is rung by push
+// with the other as the caller; if neither can be reached, both are told
+// the call was missed.
+func (s *SignalingServer) startScheduledCall(call models.ScheduledCall) {
+	online := func(identity string) *models.User {
+		if user := s.UserPool.FindUserByIdentity(identity); user != nil && user.Connection.Active() {
+			return user
+		}
+		return nil
+	}
+	organizer, invitee := online(call.Organizer), online(call.Invitee)
+
+	started := false
+	switch {
+	case organizer != nil && invitee != nil:
+		started = s.ringScheduledCall(call, organizer, invitee)
+	case organizer != nil && s.Push != nil:
+		started = s.ringByPush(organizer, call.Invitee, call.FriendID)
+	case invitee != nil && s.Push != nil:
+		started = s.ringByPush(invitee, call.Organizer, call.FriendID)
+	}
+
+	if !started {
+		log.Printf("[DEBUG] Scheduled call %s could not be started", call.ID)
+		s.Scheduler.count(&s.Scheduler.missed)
+		s.notifyScheduledCall(call, models.MessageTypeCallMissed, "Missed call", "A call you scheduled with a friend was missed")
+		return
+	}
+	log.Printf("[DEBUG] Scheduled call %s started", call.ID)
+	s.Scheduler.count(&s.Scheduler.started)
+}
+
+// ringScheduledCall puts both parties of a scheduled call into a direct room
+// and sends each of them call_incoming. The organizer makes the offer once
+// the call is accepted. Returns false if either is busy.
+func (s *SignalingServer) ringScheduledCall(call models.ScheduledCall, organizer, invitee *models.User) bool {
+	room := s.UserPool.CreateDirectRoom(organizer, invitee)
+	if room == nil {
+		return false
+	}
+
+	for _, ring := range []struct {
+		user, partner *models.User
+		role          string
+	}{
+		{organizer, invitee, "caller"},
+		{invitee, organizer, "callee"},
+	} {
+		if err := ring.user.Connection.WriteJSON(Message{
+			Type:      models.MessageTypeCallIncoming,
+			From:      ring.partner.ID,
+			To:        ring.user.ID,
+			Timestamp: time.Now(),
+			Payload: map[string]interface{}{
+				"caller_id":         ring.partner.ID,
+				"room_id":           room.ID,
+				"friend_id":         call.FriendID,
+				"scheduled_call_id": call.ID,
+				"role":              ring.role,
+			},
+		}); err != nil {
+			log.Printf("Error sending call_incoming to user %s: %v", ring.user.ID, err)
+			s.UserPool.EndRoom(room.ID, "Friend could not be reached")
+			return false
+		}
+		ring.user.CallState = models.CallStateRinging
+	}
+	return true
+}
+
+// scheduledCallPayload describes a call to the party with the given identities
+func scheduledCallPayload(call models.ScheduledCall, identities []string) map[string]interface{} {
+	organizer := false
+	for _, identity := range identities {
+		organizer = organizer || identity == call.Organizer
+	}
+	return map[string]interface{}{
+		"id":         call.ID,
+		"friend_id":  call.FriendID,
+		"starts_at":  call.StartsAt,
+		"created_at": call.CreatedAt,
+		"organizer":  organizer,
+	}
+}
+
+// notifyScheduledCall sends a message about a call to both parties, by push
+// to a party whose app has no live connection
+func (s *SignalingServer) notifyScheduledCall(call models.ScheduledCall, msgType, title, body string) {
+	for _, identity := range []string{call.Organizer, call.Invitee} {
+		if user := s.UserPool.FindUserByIdentity(identity); user != nil && user.Connection.Active() {
+			if err := user.Connection.WriteJSON(Message{
+				Type:      msgType,
+				Timestamp: time.Now(),
+				Payload:   scheduledCallPayload(call, []string{identity}),
+			}); err == nil {
+				continue
+			}
+		}
+
+		if s.Push != nil {
+			s.Push.notify(identity, utils.Push{
+				Title: title,
+				Body:  body,
+				Data: map[string]string{
+					"type":              msgType,
+					"friend_id":         call.FriendID,
+					"scheduled_call_id": call.ID,
+					"starts_at":         call.StartsAt.UTC().Format(time.RFC3339),
+				},
+				CollapseID: call.ID,
+			})
+		}
+	}
+}
+
+// handleScheduleCall schedules a call with a friend. Both parties are told
+// with call_scheduled.
+func (s *SignalingServer) handleScheduleCall(msg Message, user *models.User) {
+	if s.Scheduler == nil || s.Friends == nil {
+		s.sendError(user, "Scheduled calls are not enabled")
+		return
+	}
+
+	payload, _ := msg.Payload.(map[string]interface{})
+	friendID, _ := payload["friend_id"].(string)
+	startsAtText, _ := payload["starts_at"].(string)
+
+	invitee, err := s.Friends.Friend(user.Identities(), friendID)
+	if err != nil {
+		s.sendError(user, "Unknown friend")
+		return
+	}
+	organizer, _ := s.Friends.Friend([]string{invitee}, friendID)
+
+	now := s.Scheduler.Clock.Now()
+	startsAt, err := time.Parse(time.RFC3339, startsAtText)
+	if err != nil || !startsAt.After(now) || startsAt.Sub(now) > models.MaxScheduleAhead {
+		s.sendError(user, "Scheduled calls must start in the future and within 30 days")
+		return
+	}
+
+	call := models.ScheduledCall{
+		ID:        utils.GenerateUUID(),
+		FriendID:  friendID,
+		Organizer: organizer,
+		Invitee:   invitee,
+		StartsAt:  startsAt,
+		CreatedAt: now,
+	}
+	// Reminders whose time has already passed are not sent
+	call.RemindersSent = s.Scheduler.remindersDue(call, now)
+
+	if err := s.Scheduler.Calls.Add(call); err != nil {
+		if err == models.ErrTooManyScheduledCalls {
+			s.sendError(user, "Too many calls scheduled")
+			return
+		}
+		log.Printf("Error saving scheduled call: %v", err)
+		s.sendError(user, "Failed to schedule call")
+		return
+	}
+	s.Scheduler.arm(call)
+
+	log.Printf("[DEBUG] User %s scheduled call %s for %s", user.ID, call.ID, startsAt.Format(time.RFC3339))
+	s.notifyScheduledCall(call, models.MessageTypeCallScheduled, "Call scheduled", "A friend scheduled a call with you")
+}
+
+// handleCancelScheduledCall cancels a call either party scheduled
+func (s *SignalingServer) handleCancelScheduledCall(msg Message, user *models.User) {
+	if s.Scheduler == nil {
+		s.sendError(user, "Scheduled calls are not enabled")
+		return
+	}
+
+	payload, _ := msg.Payload.(map[string]interface{})
+	id, _ := payload["id"].(string)
+	call, err := s.Scheduler.Calls.Get(id)
+	if err != nil || !call.Involves(user.Identities()) {
+		s.sendError(user, "Unknown scheduled call")
+		return
+	}
+
+	s.Scheduler.disarm(id)
+	if _, err := s.Scheduler.Calls.Remove(id); err != nil {
+		if err == models.ErrScheduledCallNotFound {
+			// Started or cancelled in the meantime
+			s.sendError(user, "Unknown scheduled call")
+			return
+		}
+		log.Printf("Error removing cancelled scheduled call %s: %v", id, err)
+	}
+	s.Scheduler.count(&s.Scheduler.cancelled)
+
+	log.Printf("[DEBUG] User %s cancelled scheduled call %s", user.ID, id)
+	s.notifyScheduledCall(call, models.MessageTypeScheduledCallCanceled, "Call cancelled", "A scheduled call with a friend was cancelled")
+}
+
+// handleGetScheduledCalls sends the user their upcoming calls, soonest first
+func (s *SignalingServer) handleGetScheduledCalls(user *models.User) {
+	if s.Scheduler == nil {
+		s.sendError(user, "Scheduled calls are not enabled")
+		return
+	}
+
+	identities := user.Identities()
+	calls := make([]map[string]interface{}, 0)
+	for _, call := range s.Scheduler.Calls.ForIdentities(identities) {
+		calls = append(calls, scheduledCallPayload(call, identities))
+	}
+	user.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeScheduledCalls,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"calls": calls,
+		},
+	})
+}
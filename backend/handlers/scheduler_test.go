@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallScheduler_ArmsRemindersThenStart(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := utils.NewFakeClock(now)
+	calls := models.NewScheduledCallStore(0)
+	scheduler := NewCallScheduler(calls, clock, []time.Duration{time.Minute, 15 * time.Minute})
+	assert.Equal(t, []time.Duration{15 * time.Minute, time.Minute}, scheduler.Reminders)
+
+	call := models.ScheduledCall{ID: "call", Organizer: "a", Invitee: "b", StartsAt: now.Add(time.Hour)}
+	require.NoError(t, calls.Add(call))
+
+	var mutex sync.Mutex
+	var dueAt []time.Time
+	scheduler.start(func(id string) {
+		mutex.Lock()
+		dueAt = append(dueAt, clock.Now())
+		mutex.Unlock()
+
+		call, _ := calls.Get(id)
+		if clock.Now().Before(call.StartsAt) {
+			call, _ = calls.MarkReminded(id, scheduler.remindersDue(call, clock.Now()))
+			scheduler.arm(call)
+		}
+	})
+
+	clock.Advance(2 * time.Hour)
+	assert.Equal(t, []time.Time{
+		now.Add(45 * time.Minute),
+		now.Add(59 * time.Minute),
+		now.Add(time.Hour),
+	}, dueAt)
+
+	// Cancelling stops the timer
+	dueAt = nil
+	call.ID, call.StartsAt = "cancelled", clock.Now().Add(time.Hour)
+	require.NoError(t, calls.Add(call))
+	scheduler.arm(call)
+	scheduler.disarm(call.ID)
+	clock.Advance(2 * time.Hour)
+	assert.Empty(t, dueAt)
+}
+
+func TestCallScheduler_RemindersDue(t *testing.T) {
+	now := time.Now()
+	scheduler := NewCallScheduler(models.NewScheduledCallStore(0), utils.NewFakeClock(now), []time.Duration{15 * time.Minute, time.Minute})
+	call := models.ScheduledCall{StartsAt: now.Add(10 * time.Minute)}
+
+	// A call scheduled ten minutes ahead has missed its first reminder
+	assert.Equal(t, 1, scheduler.remindersDue(call, now))
+	assert.Equal(t, 0, scheduler.remindersDue(call, now.Add(-time.Hour)))
+	assert.Equal(t, 2, scheduler.remindersDue(call, now.Add(9*time.Minute)))
+}
+
+func TestScheduledCallDue_DropsCallsBetweenFormerFriends(t *testing.T) {
+	now := time.Now()
+	calls := models.NewScheduledCallStore(0)
+	server := &SignalingServer{
+		Friends:   models.NewFriendStore(),
+		Scheduler: NewCallScheduler(calls, utils.NewFakeClock(now), nil),
+	}
+	require.NoError(t, calls.Add(models.ScheduledCall{ID: "call", FriendID: "gone", Organizer: "a", Invitee: "b", StartsAt: now}))
+
+	server.scheduledCallDue("call")
+
+	_, err := calls.Get("call")
+	assert.ErrorIs(t, err, models.ErrScheduledCallNotFound)
+	stats := server.Scheduler.GetStats()
+	assert.Equal(t, 1, stats["cancelled"])
+	assert.Equal(t, 0, stats["started"])
+	assert.Equal(t, 0, stats["missed"])
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"voice-chat-app/errors"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// ConnectTicket is a single-use credential for opening a WebSocket. It binds
+// the socket to a session created over HTTP.
+type ConnectTicket struct {
+	ID        string
+	UserID    string
+	AccountID string // empty for anonymous sessions
+	Token     string
+	ExpiresAt time.Time
+}
+
+// TicketStore issues and redeems connect tickets
+type TicketStore struct {
+	tickets  map[string]*ConnectTicket
+	ttl      time.Duration
+	issued   int
+	redeemed int
+	rejected int
+	mutex    sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTicketStore creates a ticket store whose tickets live for ttl. Call
+// Stop to end its cleanup goroutine.
+func NewTicketStore(ttl time.Duration) *TicketStore {
+	if ttl <= 0 {
+		ttl = models.ConnectTicketTTL
+	}
+
+	s := &TicketStore{
+		tickets: make(map[string]*ConnectTicket),
+		ttl:     ttl,
+		stop:    make(chan struct{}),
+	}
+	go s.cleanupExpired()
+	return s
+}
+
+// Stop ends the store's cleanup goroutine
+func (s *TicketStore) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Issue creates a ticket for an anonymous session
+func (s *TicketStore) Issue(userID, token string) (*ConnectTicket, error) {
+	return s.IssueForAccount(userID, "", token)
+}
+
+// IssueForAccount creates a ticket for a session, bound to an account when
+// accountID is set
+func (s *TicketStore) IssueForAccount(userID, accountID, token string) (*ConnectTicket, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	ticket := &ConnectTicket{
+		ID:        hex.EncodeToString(random),
+		UserID:    userID,
+		AccountID: accountID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tickets[ticket.ID] = ticket
+	s.issued++
+	return ticket, nil
+}
+
+// Redeem consumes a ticket. A ticket is removed on its first redemption, so
+// concurrent attempts to use the same ticket succeed at most once.
+func (s *TicketStore) Redeem(id string) (*ConnectTicket, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ticket, exists := s.tickets[id]
+	if exists {
+		delete(s.tickets, id)
+	}
+	if !exists || time.Now().After(ticket.ExpiresAt) {
+		s.rejected++
+		return nil, false
+	}
+
+	s.redeemed++
+	return ticket, true
+}
+
+// GetStats returns ticket counters
+func (s *TicketStore) GetStats() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return map[string]interface{}{
+		"outstanding": len(s.tickets),
+		"issued":      s.issued,
+		"redeemed":    s.redeemed,
+		"rejected":    s.rejected,
+	}
+}
+
+// cleanupExpired drops tickets that were never redeemed
+func (s *TicketStore) cleanupExpired() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mutex.Lock()
+		now := time.Now()
+		for id, ticket := range s.tickets {
+			if now.After(ticket.ExpiresAt) {
+				delete(s.tickets, id)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// createSessionRequest is the optional body of POST /session, carrying a
+// proof-of-work solution when challenges are enabled
+type createSessionRequest struct {
+	Nonce    string `json:"nonce"`
+	Solution string `json:"solution"`
+}
+
+// HandleSessionChallenge issues a proof-of-work challenge for POST /session
+func (s *SignalingServer) HandleSessionChallenge(w http.ResponseWriter, r *http.Request) {
+	if s.Challenger == nil {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("challenge"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Challenger.Issue())
+}
+
+// verifySessionChallenge checks the proof-of-work solution required to create
+// a session, writing an error response and returning false if it is missing
+func (s *SignalingServer) verifySessionChallenge(w http.ResponseWriter, r *http.Request, nonce, solution string) bool {
+	if s.Challenger == nil || s.Challenger.IsTrusted(r) || s.Challenger.VerifyOnce(nonce, solution) {
+		return true
+	}
+	errors.WriteErrorResponse(w, errors.NewForbiddenError("A solved challenge from GET /session/challenge is required"))
+	return false
+}
+
+// HandleCreateSession creates a session over HTTP, returning its JWT and a
+// single-use ticket for opening the WebSocket. Rate limits and challenges are
+// applied here, before any upgrade.
+func (s *SignalingServer) HandleCreateSession(w http.ResponseWriter, r *http.Request) {
+	if s.Tickets == nil {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("session endpoint"))
+		return
+	}
+
+	var req createSessionRequest
+	if s.Challenger != nil && r.Body != nil {
+		json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req)
+	}
+	if !s.verifySessionChallenge(w, r, req.Nonce, req.Solution) {
+		return
+	}
+
+	userID := utils.GenerateUUID()
+	token, err := utils.GenerateToken(userID)
+	if err != nil {
+		log.Printf("Token generation error: %v", err)
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to create session", err))
+		return
+	}
+
+	ticket, err := s.Tickets.Issue(userID, token)
+	if err != nil {
+		log.Printf("Ticket generation error: %v", err)
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to create session", err))
+		return
+	}
+
+	log.Printf("[DEBUG] Created session for user %s over HTTP", userID)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"user_id":           userID,
+		"token":             token,
+		"ticket":            ticket.ID,
+		"ticket_expires_at": ticket.ExpiresAt,
+	})
+}
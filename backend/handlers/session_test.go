@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketStore_SingleUseUnderConcurrency(t *testing.T) {
+	store := NewTicketStore(time.Minute)
+	defer store.Stop()
+	ticket, err := store.Issue("user-1", "token-1")
+	require.NoError(t, err)
+
+	var successes int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if redeemed, ok := store.Redeem(ticket.ID); ok {
+				assert.Equal(t, "user-1", redeemed.UserID)
+				atomic.AddInt32(&successes, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), successes)
+	stats := store.GetStats()
+	assert.Equal(t, 1, stats["redeemed"])
+	assert.Equal(t, 49, stats["rejected"])
+	assert.Equal(t, 0, stats["outstanding"])
+}
+
+func TestTicketStore_Expiry(t *testing.T) {
+	store := NewTicketStore(time.Minute)
+	defer store.Stop()
+	ticket, err := store.Issue("user-1", "token-1")
+	require.NoError(t, err)
+	ticket.ExpiresAt = time.Now().Add(-time.Second)
+
+	_, ok := store.Redeem(ticket.ID)
+	assert.False(t, ok)
+	_, ok = store.Redeem("unknown")
+	assert.False(t, ok)
+}
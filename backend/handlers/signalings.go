@@ -1,21 +1,26 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"regexp"
 	"strings"
 	"time"
+	"voice-chat-app/errors"
+	"voice-chat-app/middleware"
 	"voice-chat-app/models"
 	"voice-chat-app/utils"
 
 	"github.com/gorilla/websocket"
 )
 
+// upgrader is the base WebSocket upgrader. It accepts any origin; servers
+// with an OriginPolicy use a copy that enforces it (see newUpgrader).
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		return true // In production, implement proper CORS
+		return true
 	},
 	HandshakeTimeout: 45 * time.Second,
 	ReadBufferSize:   1024,
@@ -23,10 +28,38 @@ var upgrader = websocket.Upgrader{
 }
 
 type SignalingServer struct {
-	UserPool    *models.UserPool
-	RateLimiter interface{} // Will be updated to proper type later
-	STUNServers []string
-	TURNServers []TURNServer
+	UserPool          *models.UserPool
+	RateLimiter       *middleware.RateLimiter
+	Challenger        *Challenger                     // optional proof-of-work admission challenge
+	BotDetector       *BotDetector                    // optional behavioural bot detection
+	OriginPolicy      *middleware.OriginPolicy        // optional; all origins are accepted when nil
+	Admission         *middleware.AdmissionController // optional global and per-IP connection caps
+	MessageLimit      *middleware.MessageRateLimiter  // optional per-message-type rate limits
+	Tickets           *TicketStore                    // optional; enables POST /session connect tickets
+	Accounts          *models.AccountStore            // optional registered accounts; requires Tickets
+	OIDC              *OIDCLogin                      // optional single sign-on; requires Accounts
+	Email             *AccountEmail                   // optional verification and reset mail; requires Accounts
+	Friends           *models.FriendStore             // optional friend lists and direct calls
+	Presence          *models.PresenceTracker         // optional friend presence; requires Friends
+	Push              *PushNotifier                   // optional push for calls to offline friends; requires Friends
+	VoiceNotes        *VoiceNotes                     // optional voice notes between friends; requires Friends
+	Scheduler         *CallScheduler                  // optional scheduled calls between friends; requires Friends
+	Names             *models.NameGenerator           // defaults to the built-in word lists
+	TextFilter        *utils.TextFilter               // optional; checks display names users choose
+	ContactExchange   bool                            // let partners reveal handles to each other with share_contact
+	RequireTicket     bool                            // reject /ws upgrades without a valid ticket
+	HeartbeatInterval time.Duration                   // defaults to models.HeartbeatInterval
+	STUNServers       []string
+	TURNServers       []TURNServer
+}
+
+// newUpgrader returns an upgrader that enforces the server's origin policy
+func (s *SignalingServer) newUpgrader() *websocket.Upgrader {
+	u := upgrader
+	if s.OriginPolicy != nil {
+		u.CheckOrigin = s.OriginPolicy.CheckRequest
+	}
+	return &u
 }
 
 type TURNServer struct {
@@ -67,52 +100,136 @@ var (
 func (s *SignalingServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[DEBUG] WebSocket upgrade attempt from %s", r.RemoteAddr)
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if s.RateLimiter != nil {
+		s.RateLimiter.RecordConnectionAttempt()
+	}
+
+	// Claim global and per-IP slots before upgrading
+	var ticket *middleware.AdmissionTicket
+	if s.Admission != nil {
+		var decision middleware.AdmissionDecision
+		ticket, decision = s.Admission.AdmitRequest(w, r)
+		if ticket == nil {
+			log.Printf("WebSocket connection from %s refused: %s", r.RemoteAddr, decision)
+			return
+		}
+	}
+
+	// Sessions created over HTTP connect with a single-use ticket, so bans,
+	// rate limits and challenges have already been applied. The ticket is
+	// only consumed once admission has accepted the connection, so a client
+	// turned away as busy can retry with it.
+	var connectTicket *ConnectTicket
+	if ticketID := r.URL.Query().Get("ticket"); s.Tickets != nil && (ticketID != "" || s.RequireTicket) {
+		redeemed, ok := s.Tickets.Redeem(ticketID)
+		if !ok {
+			log.Printf("WebSocket connection from %s refused: invalid connect ticket", r.RemoteAddr)
+			if ticket != nil {
+				ticket.Release()
+			}
+			errors.WriteErrorResponse(w, errors.NewUnauthorizedError("A valid connect ticket from POST /session is required"))
+			return
+		}
+		connectTicket = redeemed
+	}
+
+	conn, err := s.newUpgrader().Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
+		if ticket != nil {
+			ticket.Release()
+		}
 		return
 	}
 
 	log.Printf("[DEBUG] WebSocket connection established from %s", r.RemoteAddr)
 
-	// Generate user session
+	// Use the ticket's session or generate a new one
 	userID := utils.GenerateUUID()
-	token, err := utils.GenerateToken(userID)
-	if err != nil {
-		log.Printf("Token generation error: %v", err)
-		conn.Close()
-		return
+	if connectTicket != nil {
+		userID = connectTicket.UserID
 	}
 
-	log.Printf("[DEBUG] Generated session for user %s with token", userID)
-
 	// Create connection wrapper
 	connection := &models.Connection{
 		Conn:     conn,
 		UserID:   userID,
+		ClientIP: utils.ClientIP(r),
 		LastPing: time.Now(),
 		IsActive: true,
 	}
 
+	// Slots are released when the connection closes, whichever path closes it
+	if ticket != nil {
+		connection.OnClose = ticket.Release
+		if !s.awaitAdmission(connection, ticket) {
+			connection.Close()
+			return
+		}
+	}
+
+	// Require proof of work before issuing a session
+	if s.Challenger != nil && connectTicket == nil && !s.Challenger.IsTrusted(r) {
+		if !s.runChallenge(connection) {
+			connection.Close()
+			return
+		}
+	}
+
+	presentedDevice, ok := presentedDeviceToken(r, connection)
+	if !ok {
+		connection.Close()
+		return
+	}
+
+	var token string
+	if connectTicket != nil {
+		token = connectTicket.Token
+	} else if token, err = utils.GenerateToken(userID); err != nil {
+		log.Printf("Token generation error: %v", err)
+		connection.Close()
+		return
+	}
+
+	log.Printf("[DEBUG] Generated session for user %s with token", userID)
+
 	user := &models.User{
 		ID:         userID,
 		SessionID:  token,
 		Status:     "waiting",
 		Connection: connection,
+		AvatarSeed: models.NewAvatarSeed(),
+	}
+	s.UserPool.AssignDisplayName(user, s.nameGenerator())
+
+	sessionPayload := map[string]string{
+		"user_id":      userID,
+		"token":        token,
+		"display_name": user.DisplayName,
+		"avatar_seed":  user.AvatarSeed,
+	}
+	if deviceID, deviceToken := resolveDevice(presentedDevice, r.RemoteAddr); deviceID != "" {
+		user.DeviceID = deviceID
+		sessionPayload["device_id"] = deviceID
+		if deviceToken != "" {
+			sessionPayload["device_token"] = deviceToken
+		}
+	}
+	if connectTicket != nil && connectTicket.AccountID != "" {
+		user.AccountID = connectTicket.AccountID
+		sessionPayload["account_id"] = connectTicket.AccountID
 	}
 
 	// Send session info to client
 	sessionMsg := Message{
 		Type:      "session",
 		Timestamp: time.Now(),
-		Payload: map[string]string{
-			"user_id": userID,
-			"token":   token,
-		},
+		Payload:   sessionPayload,
 	}
 
 	if err := connection.WriteJSON(sessionMsg); err != nil {
 		log.Printf("Error sending session message: %v", err)
+		s.UserPool.ReleaseDisplayName(user)
 		connection.Close()
 		return
 	}
@@ -122,13 +239,20 @@ func (s *SignalingServer) HandleWebSocket(w http.ResponseWriter, r *http.Request
 	s.UserPool.AddWaitingUser(user)
 	log.Printf("[DEBUG] User %s added to waiting pool", userID)
 
+	if s.BotDetector != nil {
+		s.BotDetector.StartSession(user.Identity())
+	}
+
+	// A friend may have called while this device was only reachable by push
+	s.answerPushRing(user)
+
 	// Get current stats
 	stats := s.UserPool.GetStats()
 	log.Printf("[DEBUG] Current server stats - Waiting: %d, Active: %d, Rooms: %d",
 		stats["waiting_users"], stats["active_users"], stats["active_rooms"])
 
 	// Start heartbeat goroutine
-	go s.handleHeartbeat(connection)
+	go s.handleHeartbeat(connection, user)
 
 	// Handle user messages
 	s.handleUserMessages(connection, user)
@@ -138,17 +262,42 @@ func (s *SignalingServer) HandleWebSocket(w http.ResponseWriter, r *http.Request
 	s.handleDisconnect(user)
 }
 
-func (s *SignalingServer) handleHeartbeat(conn *models.Connection) {
-	ticker := time.NewTicker(30 * time.Second)
+func (s *SignalingServer) handleHeartbeat(conn *models.Connection, user *models.User) {
+	interval := s.HeartbeatInterval
+	if interval <= 0 {
+		interval = models.HeartbeatInterval
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	warnedToken := ""
 	for {
 		select {
 		case <-ticker.C:
-			if !conn.IsActive {
+			if !conn.Active() {
 				return
 			}
 
+			// Close sessions whose token was revoked or has expired, and warn
+			// once per token when it enters the refresh window
+			token := s.UserPool.SessionToken(user.ID)
+			claims, err := utils.ValidateJWT(token)
+			if err != nil && token != "" {
+				s.closeRevokedSession(conn, err)
+				return
+			}
+			if claims != nil && claims.ExpiresAt != nil && token != warnedToken &&
+				time.Until(claims.ExpiresAt.Time) <= models.TokenRefreshWindow {
+				warnedToken = token
+				conn.WriteJSON(Message{
+					Type:      models.MessageTypeTokenExpiring,
+					Timestamp: time.Now(),
+					Payload: map[string]interface{}{
+						"expires_at": claims.ExpiresAt.Time,
+					},
+				})
+			}
+
 			pingMsg := Message{
 				Type:      "ping",
 				Timestamp: time.Now(),
@@ -163,6 +312,67 @@ func (s *SignalingServer) handleHeartbeat(conn *models.Connection) {
 	}
 }
 
+// closeRevokedSession tells the client its session is no longer valid and
+// closes the connection; the read loop then runs the usual disconnect cleanup
+func (s *SignalingServer) closeRevokedSession(conn *models.Connection, reason error) {
+	log.Printf("Closing session for user %s: %v", conn.UserID, reason)
+
+	conn.WriteJSON(Message{
+		Type:      models.MessageTypeSessionRevoked,
+		Timestamp: time.Now(),
+		Payload: map[string]string{
+			"reason": reason.Error(),
+		},
+	})
+	conn.Close()
+}
+
+// handleTokenRefresh issues a new token when the current one is within the
+// refresh window. The old token is revoked.
+func (s *SignalingServer) handleTokenRefresh(user *models.User) {
+	token, err := utils.RefreshToken(s.UserPool.SessionToken(user.ID))
+	if err != nil {
+		log.Printf("Token refresh refused for user %s: %v", user.ID, err)
+		s.sendError(user, "Token refresh failed: "+err.Error())
+		return
+	}
+
+	s.UserPool.SetSessionToken(user.ID, token)
+	claims, _ := utils.ValidateJWT(token)
+
+	payload := map[string]interface{}{
+		"token": token,
+	}
+	if claims != nil && claims.ExpiresAt != nil {
+		payload["expires_at"] = claims.ExpiresAt.Time
+	}
+	user.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeTokenRefreshed,
+		Timestamp: time.Now(),
+		Payload:   payload,
+	})
+	log.Printf("[DEBUG] Token refreshed for user %s", user.ID)
+}
+
+// handleLogout revokes the session token and acknowledges the logout. The
+// caller ends the read loop, which closes the session.
+func (s *SignalingServer) handleLogout(user *models.User) {
+	if err := utils.RevokeToken(s.UserPool.SessionToken(user.ID)); err != nil {
+		log.Printf("Error revoking token for user %s: %v", user.ID, err)
+	} else {
+		utils.Audit(utils.WithUserID(context.Background(), user.ID), utils.AuditActionTokenRevoke, map[string]interface{}{
+			"target_user_id": user.ID,
+			"reason":         "logout",
+		})
+	}
+
+	user.Connection.WriteJSON(Message{
+		Type:      models.MessageTypeLoggedOut,
+		Timestamp: time.Now(),
+	})
+	log.Printf("[DEBUG] User %s logged out", user.ID)
+}
+
 func (s *SignalingServer) handleUserMessages(conn *models.Connection, user *models.User) {
 	// Set read deadline
 	conn.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -186,13 +396,44 @@ func (s *SignalingServer) handleUserMessages(conn *models.Connection, user *mode
 		// Log all incoming messages for debugging
 		log.Printf("[DEBUG] Received message from user %s: type=%s", user.ID, msg.Type)
 
+		// Apply per-type rate limits; repeat offenders are muted, then disconnected
+		if s.MessageLimit != nil {
+			penalty := s.MessageLimit.Allow(user.Identity(), msg.Type)
+			if penalty != middleware.PenaltyNone {
+				s.sendRateLimitError(conn, msg.Type, penalty)
+				if penalty == middleware.PenaltyDisconnect {
+					return
+				}
+				continue
+			}
+		}
+
+		// Score behaviour and act on it before handling the message
+		if s.BotDetector != nil {
+			if !s.applyBotAction(conn, user, s.BotDetector.Observe(user.Identity(), msg)) {
+				return
+			}
+		}
+
 		switch msg.Type {
 		case "pong":
 			// Handle pong response - just update ping time (already done above)
 			log.Printf("[DEBUG] Pong received from user %s", user.ID)
 			continue
+		case models.MessageTypeTokenRefresh:
+			s.handleTokenRefresh(user)
+		case models.MessageTypeLogout:
+			s.handleLogout(user)
+			return
+		case "ping":
+			// Client-initiated keepalive
+			conn.WriteJSON(Message{Type: "pong", Timestamp: time.Now()})
 		case "find_match":
 			log.Printf("[DEBUG] User %s requesting match", user.ID)
+			if s.BotDetector != nil && s.BotDetector.IsThrottled(user.Identity()) {
+				s.sendError(user, "Too many match requests, please slow down")
+				continue
+			}
 			s.handleFindMatch(user)
 		case "offer":
 			log.Printf("[DEBUG] WebRTC offer received from user %s", user.ID)
@@ -218,6 +459,35 @@ func (s *SignalingServer) handleUserMessages(conn *models.Connection, user *mode
 		case "get_ice_servers":
 			log.Printf("[DEBUG] ICE servers request from user %s", user.ID)
 			s.handleGetICEServers(user)
+		case models.MessageTypeAddFriend:
+			s.handleAddFriend(user)
+		case models.MessageTypeGetFriends:
+			s.handleGetFriends(user)
+		case models.MessageTypeCallFriend:
+			log.Printf("[DEBUG] Friend call request from user %s", user.ID)
+			s.handleCallFriend(msg, user)
+		case models.MessageTypeSubscribePresence:
+			s.handleSubscribePresence(user)
+		case models.MessageTypeUnsubscribePresence:
+			s.handleUnsubscribePresence(user)
+		case models.MessageTypeSetPresence:
+			s.handleSetPresence(msg, user)
+		case models.MessageTypeSetDisplayName:
+			s.handleSetDisplayName(msg, user)
+		case models.MessageTypeShareContact:
+			s.handleShareContact(msg, user)
+		case models.MessageTypeWithdrawContact:
+			s.handleWithdrawContact(user)
+		case models.MessageTypeScheduleCall:
+			s.handleScheduleCall(msg, user)
+		case models.MessageTypeCancelScheduledCall:
+			s.handleCancelScheduledCall(msg, user)
+		case models.MessageTypeGetScheduledCalls:
+			s.handleGetScheduledCalls(user)
+		case models.MessageTypeRegisterPush:
+			s.handleRegisterPush(msg, user)
+		case models.MessageTypeUnregisterPush:
+			s.handleUnregisterPush(user)
 		case "disconnect":
 			log.Printf("[DEBUG] User %s disconnecting", user.ID)
 			return // Exit the loop to trigger cleanup
@@ -227,6 +497,55 @@ func (s *SignalingServer) handleUserMessages(conn *models.Connection, user *mode
 	}
 }
 
+// applyBotAction carries out a bot detector action. Returns false if the
+// session should be closed.
+func (s *SignalingServer) applyBotAction(conn *models.Connection, user *models.User, action BotAction) bool {
+	if action == BotActionNone {
+		return true
+	}
+
+	score := s.BotDetector.Score(user.Identity())
+	log.Printf("Bot detection action %s for user %s (score %.0f)", action, user.ID, score)
+
+	switch action {
+	case BotActionChallenge:
+		if s.Challenger != nil {
+			return s.runChallenge(conn)
+		}
+	case BotActionShadowBan:
+		ctx := utils.WithUserID(context.Background(), user.ID)
+		if s.UserPool.ShadowBanIdentity(user.Identity(), "automated bot detection") {
+			utils.Audit(ctx, utils.AuditActionShadowBan, map[string]interface{}{
+				"identity":  user.Identity(),
+				"reason":    "automated bot detection",
+				"bot_score": score,
+			})
+		}
+	case BotActionDisconnect:
+		return false
+	}
+	return true
+}
+
+// sendRateLimitError tells a client its message was dropped by the rate limiter
+func (s *SignalingServer) sendRateLimitError(conn *models.Connection, msgType string, penalty middleware.MessagePenalty) {
+	message := "Too many " + msgType + " messages, please slow down"
+	switch penalty {
+	case middleware.PenaltyMute:
+		message = "Too many messages, you are temporarily muted"
+	case middleware.PenaltyDisconnect:
+		message = "Too many messages, disconnecting"
+	}
+	log.Printf("Rate limit penalty %s for user %s on %s", penalty, conn.UserID, msgType)
+
+	appErr := errors.NewRateLimitError(message).
+		WithContext("message_type", msgType).
+		WithContext("penalty", string(penalty))
+	if err := conn.WriteJSON(appErr.ToWebSocketError()); err != nil {
+		log.Printf("Error sending rate limit error to user %s: %v", conn.UserID, err)
+	}
+}
+
 func (s *SignalingServer) relaySignaling(msg Message) {
 	// Find the target user and relay the signaling message
 	if msg.To == "" {
@@ -285,9 +604,11 @@ func (s *SignalingServer) handleFindMatch(user *models.User) {
 		Type:      "match_found",
 		Timestamp: time.Now(),
 		Payload: map[string]interface{}{
-			"partner_id": partner.ID,
-			"room_id":    room.ID,
-			"role":       "caller", // User who initiated gets caller role
+			"partner_id":          partner.ID,
+			"partner_name":        partner.DisplayName,
+			"partner_avatar_seed": partner.AvatarSeed,
+			"room_id":             room.ID,
+			"role":                "caller", // User who initiated gets caller role
 		},
 	}
 
@@ -295,9 +616,11 @@ func (s *SignalingServer) handleFindMatch(user *models.User) {
 		Type:      "match_found",
 		Timestamp: time.Now(),
 		Payload: map[string]interface{}{
-			"partner_id": user.ID,
-			"room_id":    room.ID,
-			"role":       "callee", // Partner gets callee role
+			"partner_id":          user.ID,
+			"partner_name":        user.DisplayName,
+			"partner_avatar_seed": user.AvatarSeed,
+			"room_id":             room.ID,
+			"role":                "callee", // Partner gets callee role
 		},
 	}
 
@@ -347,6 +670,16 @@ func (s *SignalingServer) handleDisconnect(user *models.User) {
 	s.UserPool.RemoveUser(user.ID)
 	user.Connection.Close()
 
+	if s.BotDetector != nil {
+		s.BotDetector.EndSession(user.Identity())
+	}
+	if s.Presence != nil {
+		s.Presence.Unsubscribe(user.ID)
+	}
+	if s.MessageLimit != nil {
+		s.MessageLimit.EndSession(user.Identity())
+	}
+
 	// Get updated stats
 	stats := s.UserPool.GetStats()
 	log.Printf("[DEBUG] User %s cleanup complete. Updated stats - Waiting: %d, Active: %d, Rooms: %d",
@@ -442,6 +775,7 @@ func (s *SignalingServer) handleWebRTCAnswer(msg Message, user *models.User) {
 	// Update call state
 	user.CallState = models.CallStateAnswered
 	partner.CallState = models.CallStateAnswered
+	s.UserPool.AnswerCall(user.ID)
 
 	// Update room call state
 	if roomID := user.RoomID; roomID != "" {
@@ -585,6 +919,11 @@ func (s *SignalingServer) handleCallReject(msg Message, user *models.User) {
 	user.CallState = models.CallStateEnded
 	partner.CallState = models.CallStateEnded
 
+	// A rejected friend call has nothing else to do; both go back to waiting
+	if room := s.UserPool.GetRoom(user.RoomID); room != nil && room.Direct {
+		s.UserPool.EndRoom(room.ID, "Call rejected")
+	}
+
 	log.Printf("Call rejected by %s from %s", user.ID, partner.ID)
 }
 
@@ -605,20 +944,11 @@ func (s *SignalingServer) handleCallEnd(msg Message, user *models.User) {
 		if err := partner.Connection.WriteJSON(endMsg); err != nil {
 			log.Printf("Error sending call_ended to partner %s: %v", partner.ID, err)
 		}
-
-		partner.CallState = models.CallStateEnded
 	}
 
-	user.CallState = models.CallStateEnded
-
-	// Update room state
-	if roomID := user.RoomID; roomID != "" {
-		if room := s.UserPool.Rooms[roomID]; room != nil {
-			room.CallState = models.CallStateEnded
-			now := time.Now()
-			room.EndedAt = &now
-		}
-	}
+	// A direct call's room ends, freeing both users for other calls; a
+	// random match stays matched with its call marked ended
+	s.UserPool.EndUserCall(user.ID, "Call ended")
 
 	log.Printf("Call ended by %s", user.ID)
 }
@@ -802,6 +1132,11 @@ func (s *SignalingServer) validateICECandidate(payload interface{}) bool {
 // Utility functions
 
 func (s *SignalingServer) sendError(user *models.User, message string) {
+	writeErrorMessage(user.Connection, message)
+}
+
+// writeErrorMessage sends an error message on a connection
+func writeErrorMessage(conn *models.Connection, message string) {
 	errorMsg := Message{
 		Type:      "error",
 		Timestamp: time.Now(),
@@ -810,8 +1145,8 @@ func (s *SignalingServer) sendError(user *models.User, message string) {
 		},
 	}
 
-	if err := user.Connection.WriteJSON(errorMsg); err != nil {
-		log.Printf("Error sending error message to user %s: %v", user.ID, err)
+	if err := conn.WriteJSON(errorMsg); err != nil {
+		log.Printf("Error sending error message to user %s: %v", conn.UserID, err)
 	}
 }
 
@@ -851,10 +1186,50 @@ func (s *SignalingServer) GetICEServers() ICEServersResponse {
 // GetStats returns current server statistics
 func (s *SignalingServer) GetStats() map[string]interface{} {
 	stats := s.UserPool.GetStats()
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"waiting_users": stats["waiting_users"],
 		"active_users":  stats["active_users"],
 		"active_rooms":  stats["active_rooms"],
+		"devices":       stats["connected_devices"],
 		"server_uptime": time.Now().Format(time.RFC3339),
 	}
+
+	if s.BotDetector != nil {
+		result["bot_detection"] = s.BotDetector.GetStats()
+	}
+	if s.OriginPolicy != nil {
+		result["origin_policy"] = s.OriginPolicy.GetStats()
+	}
+	if s.Admission != nil {
+		result["admission"] = s.Admission.GetStats()
+	}
+	if s.MessageLimit != nil {
+		result["message_rate_limit"] = s.MessageLimit.GetStats()
+	}
+	if s.Tickets != nil {
+		result["connect_tickets"] = s.Tickets.GetStats()
+	}
+	if s.Accounts != nil {
+		result["accounts"] = s.Accounts.GetStats()
+	}
+	if s.Friends != nil {
+		result["friends"] = s.Friends.GetStats()
+	}
+	if s.Presence != nil {
+		result["presence"] = s.Presence.GetStats()
+	}
+	if s.Push != nil {
+		result["push"] = s.Push.GetStats()
+	}
+	if s.VoiceNotes != nil {
+		result["voice_notes"] = s.VoiceNotes.GetStats()
+	}
+	if s.Scheduler != nil {
+		result["scheduled_calls"] = s.Scheduler.GetStats()
+	}
+	if s.Email != nil && s.Email.Limiter != nil {
+		result["mail_rate_limit"] = s.Email.Limiter.GetStats()
+	}
+
+	return result
 }
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"voice-chat-app/errors"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// VoiceNotesConfig holds voice note limits; zero values use the defaults
+type VoiceNotesConfig struct {
+	MaxBytes    int64
+	MaxDuration time.Duration
+	Retention   time.Duration
+	URLTTL      time.Duration
+}
+
+// VoiceNotes stores short recorded messages between friends. The audio is
+// kept on a blob store and fetched through signed URLs, so that clients can
+// play it without attaching their session token.
+type VoiceNotes struct {
+	Blobs  utils.BlobStore
+	Notes  *models.VoiceNoteStore
+	Signer *utils.URLSigner
+	config VoiceNotesConfig
+}
+
+// NewVoiceNotes creates voice note storage with audio on blobs and notes
+// indexed in notes, and starts removing notes once they pass the retention
+// period
+func NewVoiceNotes(blobs utils.BlobStore, notes *models.VoiceNoteStore, signer *utils.URLSigner, config VoiceNotesConfig) *VoiceNotes {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = models.DefaultVoiceNoteMaxBytes
+	}
+	if config.MaxDuration <= 0 {
+		config.MaxDuration = models.DefaultVoiceNoteMaxDuration
+	}
+	if config.Retention <= 0 {
+		config.Retention = models.DefaultVoiceNoteRetention
+	}
+	if config.URLTTL <= 0 {
+		config.URLTTL = models.DefaultVoiceNoteURLTTL
+	}
+
+	v := &VoiceNotes{
+		Blobs:  blobs,
+		Notes:  notes,
+		Signer: signer,
+		config: config,
+	}
+	go v.cleanupExpired()
+	return v
+}
+
+// GetStats returns voice note counters
+func (v *VoiceNotes) GetStats() map[string]interface{} {
+	return v.Notes.GetStats()
+}
+
+// cleanupExpired deletes expired notes and their audio every minute
+func (v *VoiceNotes) cleanupExpired() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		v.cleanup(time.Now())
+	}
+}
+
+// cleanup deletes notes expired by now and their audio. Blobs older than the
+// retention period that belong to no note are deleted too, which catches
+// audio left behind by an interrupted upload or delete; audio of notes still
+// waiting to be played is never touched.
+func (v *VoiceNotes) cleanup(now time.Time) {
+	ctx := context.Background()
+	expired, err := v.Notes.RemoveExpired(now)
+	if err != nil {
+		log.Printf("Error removing expired voice notes: %v", err)
+	}
+	for _, id := range expired {
+		if err := v.Blobs.Delete(ctx, id); err != nil {
+			log.Printf("Error deleting expired voice note %s: %v", id, err)
+		}
+	}
+	if deleted, err := v.Blobs.DeleteOlderThan(ctx, now.Add(-v.config.Retention), v.Notes.Contains); err != nil {
+		log.Printf("Error deleting old voice note audio: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Deleted %d orphaned voice note blobs", deleted)
+	}
+}
+
+// payload describes a note to its recipient, with a freshly signed download URL
+func (v *VoiceNotes) payload(note *models.VoiceNote) map[string]interface{} {
+	urlExpiresAt := time.Now().Add(v.config.URLTTL)
+	if urlExpiresAt.After(note.ExpiresAt) {
+		urlExpiresAt = note.ExpiresAt
+	}
+	return map[string]interface{}{
+		"id":             note.ID,
+		"friend_id":      note.FriendID,
+		"content_type":   note.ContentType,
+		"size":           note.Size,
+		"duration_ms":    note.Duration.Milliseconds(),
+		"created_at":     note.CreatedAt,
+		"expires_at":     note.ExpiresAt,
+		"url":            v.Signer.Sign(voiceNotePath(note.ID), urlExpiresAt),
+		"url_expires_at": urlExpiresAt,
+	}
+}
+
+func voiceNotePath(id string) string {
+	return "/voice-notes/" + id
+}
+
+func (s *SignalingServer) voiceNotesEnabled(w http.ResponseWriter) bool {
+	if s.VoiceNotes == nil || s.Friends == nil {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("voice note endpoint"))
+		return false
+	}
+	return true
+}
+
+// sessionIdentities authenticates an HTTP request by its session token and
+// returns the identities of the caller. A connected session is recognised
+// by all of its identities; otherwise by its token and, if presented, its
+// device token.
+func (s *SignalingServer) sessionIdentities(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	claims, err := utils.ValidateJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewUnauthorizedError("A valid session token is required"))
+		return nil, false
+	}
+	if user := s.UserPool.GetUser(claims.UserID); user != nil {
+		return user.Identities(), true
+	}
+
+	caller := &models.User{ID: claims.UserID, AccountID: claims.AccountID}
+	if device, err := utils.ValidateDeviceToken(r.Header.Get("X-Device-Token")); err == nil {
+		caller.DeviceID = device.DeviceID
+	}
+	return caller.Identities(), true
+}
+
+// HandleUploadVoiceNote stores a voice note for a friend, given by the
+// friend_id query parameter, and tells the friend about it over their
+// WebSocket or by push. The body is Opus audio in an Ogg or WebM container;
+// its size and duration are checked against the configured limits.
+func (s *SignalingServer) HandleUploadVoiceNote(w http.ResponseWriter, r *http.Request) {
+	if !s.voiceNotesEnabled(w) {
+		return
+	}
+	identities, ok := s.sessionIdentities(w, r)
+	if !ok {
+		return
+	}
+
+	friendID := r.URL.Query().Get("friend_id")
+	recipient, err := s.Friends.Friend(identities, friendID)
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("friend"))
+		return
+	}
+	// The sender is whichever of the caller's identities the friendship was made with
+	sender, _ := s.Friends.Friend([]string{recipient}, friendID)
+
+	if err := s.VoiceNotes.Notes.Reserve(sender, recipient); err != nil {
+		writeVoiceNoteLimitError(w, err)
+		return
+	}
+
+	limits := s.VoiceNotes.config
+	if r.ContentLength > limits.MaxBytes {
+		errors.WriteErrorResponse(w, errors.NewTooLargeError("Voice note is too large"))
+		return
+	}
+	// Read one byte past the limit to tell a body of exactly the limit from a larger one
+	data, err := io.ReadAll(io.LimitReader(r.Body, limits.MaxBytes+1))
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Failed to read voice note"))
+		return
+	}
+	if int64(len(data)) > limits.MaxBytes {
+		errors.WriteErrorResponse(w, errors.NewTooLargeError("Voice note is too large"))
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	duration, err := utils.OpusDuration(contentType, data)
+	if err != nil {
+		log.Printf("Voice note rejected: %v", err)
+		errors.WriteErrorResponse(w, errors.NewValidationError("Voice notes must be Opus audio in Ogg or WebM"))
+		return
+	}
+	if duration <= 0 {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Voice note is empty"))
+		return
+	}
+	if duration > limits.MaxDuration {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Voice note is too long",
+			fmt.Sprintf("The longest voice note allowed is %s", limits.MaxDuration)))
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	now := time.Now()
+	note := &models.VoiceNote{
+		ID:          utils.GenerateUUID(),
+		FriendID:    friendID,
+		Sender:      sender,
+		Recipient:   recipient,
+		ContentType: mediaType,
+		Size:        int64(len(data)),
+		Duration:    duration,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(limits.Retention),
+	}
+	if err := s.VoiceNotes.Blobs.Put(r.Context(), note.ID, bytes.NewReader(data)); err != nil {
+		log.Printf("Error storing voice note: %v", err)
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to store voice note", err))
+		return
+	}
+	if err := s.VoiceNotes.Notes.Add(note); err != nil {
+		s.VoiceNotes.Blobs.Delete(r.Context(), note.ID)
+		writeVoiceNoteLimitError(w, err)
+		return
+	}
+
+	log.Printf("[DEBUG] Voice note %s (%s, %d bytes) sent through friendship %s", note.ID, duration, note.Size, friendID)
+	s.notifyVoiceNote(note)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":          note.ID,
+		"friend_id":   note.FriendID,
+		"size":        note.Size,
+		"duration_ms": note.Duration.Milliseconds(),
+		"expires_at":  note.ExpiresAt,
+	})
+}
+
+// writeVoiceNoteLimitError reports why a voice note could not be added
+func writeVoiceNoteLimitError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrTooManyVoiceNotes:
+		errors.WriteErrorResponse(w, errors.NewRateLimitError("Your friend has too many voice notes waiting"))
+	case models.ErrTooManyVoiceNotesFromSender:
+		errors.WriteErrorResponse(w, errors.NewRateLimitError("You have too many voice notes waiting for this friend"))
+	default:
+		log.Printf("Error saving voice note: %v", err)
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to store voice note", err))
+	}
+}
+
+// notifyVoiceNote tells the recipient of a note about it, by push if their
+// app has no live connection
+func (s *SignalingServer) notifyVoiceNote(note *models.VoiceNote) {
+	recipient := s.UserPool.FindUserByIdentity(note.Recipient)
+	if recipient != nil && recipient.Connection.Active() {
+		if err := recipient.Connection.WriteJSON(Message{
+			Type:      models.MessageTypeVoiceNote,
+			Timestamp: time.Now(),
+			Payload:   s.VoiceNotes.payload(note),
+		}); err == nil {
+			return
+		}
+	}
+
+	if s.Push != nil {
+		s.Push.notify(note.Recipient, utils.Push{
+			Title: "New voice note",
+			Body:  "A friend sent you a voice note",
+			Data: map[string]string{
+				"type":      models.MessageTypeVoiceNote,
+				"friend_id": note.FriendID,
+				"note_id":   note.ID,
+			},
+			TTL: time.Until(note.ExpiresAt),
+		})
+	}
+}
+
+// HandleListVoiceNotes returns the unexpired voice notes sent to the caller,
+// oldest first, each with a signed download URL
+func (s *SignalingServer) HandleListVoiceNotes(w http.ResponseWriter, r *http.Request) {
+	if !s.voiceNotesEnabled(w) {
+		return
+	}
+	identities, ok := s.sessionIdentities(w, r)
+	if !ok {
+		return
+	}
+
+	notes := s.VoiceNotes.Notes.ForRecipient(identities)
+	payloads := make([]map[string]interface{}, 0, len(notes))
+	for _, note := range notes {
+		payloads = append(payloads, s.VoiceNotes.payload(note))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"voice_notes": payloads})
+}
+
+// HandleDownloadVoiceNote serves the audio of a voice note to anyone holding
+// an unexpired signed URL for it
+func (s *SignalingServer) HandleDownloadVoiceNote(w http.ResponseWriter, r *http.Request) {
+	if !s.voiceNotesEnabled(w) {
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := s.VoiceNotes.Signer.Verify(voiceNotePath(id), r.URL.Query()); err != nil {
+		if err == utils.ErrURLExpired {
+			errors.WriteErrorResponse(w, errors.NewForbiddenError("This link has expired"))
+			return
+		}
+		errors.WriteErrorResponse(w, errors.NewForbiddenError("This link is invalid"))
+		return
+	}
+	note, err := s.VoiceNotes.Notes.Get(id)
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("voice note"))
+		return
+	}
+
+	blob, err := s.VoiceNotes.Blobs.Open(r.Context(), note.ID)
+	if err != nil {
+		log.Printf("Error opening voice note %s: %v", note.ID, err)
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("voice note"))
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", note.ContentType)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("Error serving voice note %s: %v", note.ID, err)
+	}
+}
+
+// HandleDeleteVoiceNote deletes a voice note at the request of its sender
+// or recipient
+func (s *SignalingServer) HandleDeleteVoiceNote(w http.ResponseWriter, r *http.Request) {
+	if !s.voiceNotesEnabled(w) {
+		return
+	}
+	identities, ok := s.sessionIdentities(w, r)
+	if !ok {
+		return
+	}
+
+	note, err := s.VoiceNotes.Notes.Get(r.PathValue("id"))
+	allowed := false
+	if err == nil {
+		for _, identity := range identities {
+			if identity == note.Sender || identity == note.Recipient {
+				allowed = true
+				break
+			}
+		}
+	}
+	// Notes belonging to others are indistinguishable from missing ones
+	if !allowed {
+		errors.WriteErrorResponse(w, errors.NewNotFoundError("voice note"))
+		return
+	}
+
+	if err := s.VoiceNotes.Notes.Remove(note.ID); err != nil {
+		log.Printf("Error removing voice note %s: %v", note.ID, err)
+		errors.WriteErrorResponse(w, errors.NewInternalError("Failed to delete voice note", err))
+		return
+	}
+	if err := s.VoiceNotes.Blobs.Delete(r.Context(), note.ID); err != nil {
+		log.Printf("Error deleting voice note %s: %v", note.ID, err)
+	}
+	log.Printf("[DEBUG] Voice note %s deleted", note.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoiceNotes_CleanupKeepsUnreadAudio(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	blobs, err := utils.NewFileBlobStore(dir)
+	require.NoError(t, err)
+	v := NewVoiceNotes(blobs, models.NewVoiceNoteStore(0, 0), utils.NewURLSigner([]byte("secret"), "voice-notes"), VoiceNotesConfig{
+		Retention: time.Hour,
+	})
+
+	// An unread note whose audio is older than the retention period, say
+	// after the retention was shortened
+	now := time.Now()
+	require.NoError(t, v.Notes.Add(&models.VoiceNote{ID: "unread", Sender: "a", Recipient: "b", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, v.Notes.Add(&models.VoiceNote{ID: "expired", Sender: "a", Recipient: "b", CreatedAt: now, ExpiresAt: now.Add(-time.Minute)}))
+	for _, key := range []string{"unread", "expired", "orphan"} {
+		require.NoError(t, blobs.Put(ctx, key, strings.NewReader("audio")))
+		past := now.Add(-2 * time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, key), past, past))
+	}
+
+	v.cleanup(now)
+
+	blob, err := blobs.Open(ctx, "unread")
+	require.NoError(t, err)
+	blob.Close()
+	for _, key := range []string{"expired", "orphan"} {
+		_, err := blobs.Open(ctx, key)
+		assert.ErrorIs(t, err, utils.ErrBlobNotFound, key)
+	}
+	assert.False(t, v.Notes.Contains("expired"))
+}
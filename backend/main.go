@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -31,11 +33,73 @@ func main() {
 		"stun_servers":       config.STUNServers,
 		"turn_servers_count": len(config.TURNServers),
 		"allowed_origins":    config.AllowedOrigins,
+		"native_app_origins": config.NativeAppOrigins,
 		"max_connections":    config.MaxConnections,
+		"admission_mode":     config.AdmissionMode,
+		"trusted_proxies":    config.TrustedProxies,
+		"proxy_protocol":     config.ProxyProtocolEnabled,
+		"require_ws_ticket":  config.RequireWSTicket,
+		"accounts":           config.AccountsEnabled,
+		"friends":            config.FriendsEnabled,
+		"presence":           config.FriendsEnabled && config.PresenceEnabled,
+		"scheduled_calls":    config.FriendsEnabled && config.ScheduledCallsEnabled,
+		"contact_exchange":   config.ContactExchangeEnabled,
+		"text_filter_terms":  len(config.TextFilterTerms),
+		"push_fcm":           config.PushFCMCredentialsFile != "",
+		"push_apns":          config.PushAPNsKeyFile != "",
+		"voice_notes":        config.VoiceNotesDir != "",
+		"oidc_issuer":        config.OIDCIssuerURL,
+		"mailer":             config.Mailer,
 		"http_rate_limit":    config.HTTPRateLimitPerMinute,
 		"ws_rate_limit":      config.WSRateLimitPerMinute,
+		"admin_api_keys":     len(config.AdminAPIKeys),
+		"jwt_keys":           len(config.JWTKeys),
+		"pow_challenge":      config.ChallengeEnabled,
+		"bot_detection":      config.BotDetectionEnabled,
 	})
 
+	// Initialize the JWT signing keyring
+	keyring, err := utils.NewKeyringFromConfig(config)
+	if err != nil {
+		utils.Fatal(ctx, "Failed to load JWT keys", err)
+	}
+	utils.SetKeyring(keyring)
+
+	// Persist token revocations across restarts
+	if config.RevocationStorePath != "" {
+		revocations, err := utils.OpenFileRevocationStore(config.RevocationStorePath)
+		if err != nil {
+			utils.Fatal(ctx, "Failed to open revocation store", err, map[string]interface{}{
+				"path": config.RevocationStorePath,
+			})
+		}
+		defer revocations.Close()
+		utils.SetRevocationStore(revocations)
+	} else if config.IsProduction() {
+		utils.Warn(ctx, "REVOCATION_STORE_PATH not set; revoked tokens become valid again after a restart")
+	}
+
+	// Initialize tamper-evident audit log
+	if config.AuditLogPath != "" {
+		auditLog, err := utils.OpenAuditLog(config.AuditLogPath, config.AuditLogMaxSize)
+		if err != nil {
+			utils.Fatal(ctx, "Failed to open audit log", err, map[string]interface{}{
+				"path": config.AuditLogPath,
+			})
+		}
+		defer auditLog.Close()
+		utils.SetAuditLog(auditLog)
+	} else if config.IsProduction() {
+		utils.Warn(ctx, "AUDIT_LOG_PATH not set; audit events are only written to the application log")
+	}
+
+	// Initialize client IP resolution behind trusted proxies
+	clientIPResolver, err := utils.NewClientIPResolver(config.TrustedProxies)
+	if err != nil {
+		utils.Fatal(ctx, "Invalid trusted proxy configuration", err)
+	}
+	utils.SetClientIPResolver(clientIPResolver)
+
 	// Initialize rate limiter
 	rateLimiter := middleware.NewRateLimiter(
 		config.HTTPRateLimitPerMinute,
@@ -43,18 +107,239 @@ func main() {
 		config.MaxWSConnPerIP,
 	)
 
+	// Initialize admission control for the global and per-IP connection caps
+	admission := middleware.NewAdmissionController(middleware.AdmissionConfig{
+		MaxConnections:  config.MaxConnections,
+		Mode:            config.AdmissionMode,
+		WaitlistSize:    config.WaitlistSize,
+		WaitlistTimeout: config.WaitlistTimeout,
+		RetryAfter:      config.AdmissionRetryAfter,
+	}, rateLimiter)
+
+	// Initialize per-message-type WebSocket rate limits
+	messageLimiter := middleware.NewMessageRateLimiter(
+		middleware.DefaultMessageRateLimiterConfig(config.WSRateLimitPerMinute),
+	)
+
+	// Initialize the origin policy shared by CORS and the WebSocket upgrader
+	originPolicy := middleware.NewOriginPolicy(
+		config.AllowedOrigins,
+		config.NativeAppOrigins,
+		config.AllowMissingOrigin,
+	)
+
 	// Initialize CORS configuration
-	corsConfig := middleware.NewCORSConfig(config.AllowedOrigins)
+	corsConfig := middleware.NewCORSConfig(originPolicy)
 
 	// Initialize user pool
 	userPool := models.NewUserPool()
 
 	// Initialize signaling server with enhanced configuration
 	signalingServer := &handlers.SignalingServer{
-		UserPool:    userPool,
-		RateLimiter: rateLimiter,
-		STUNServers: config.STUNServers,
-		TURNServers: convertTURNServers(config.TURNServers),
+		UserPool:          userPool,
+		RateLimiter:       rateLimiter,
+		OriginPolicy:      originPolicy,
+		Admission:         admission,
+		MessageLimit:      messageLimiter,
+		Tickets:           handlers.NewTicketStore(models.ConnectTicketTTL),
+		Names:             models.NewNameGenerator(config.DisplayNameAdjectives, config.DisplayNameAnimals),
+		TextFilter:        utils.NewTextFilter(config.DisplayNameFilterTerms()),
+		ContactExchange:   config.ContactExchangeEnabled,
+		RequireTicket:     config.RequireWSTicket,
+		HeartbeatInterval: config.HeartbeatInterval,
+		STUNServers:       config.STUNServers,
+		TURNServers:       convertTURNServers(config.TURNServers),
+	}
+
+	// Optional registered accounts alongside anonymous sessions
+	if config.AccountsEnabled {
+		signalingServer.Accounts = models.NewAccountStore(config.PasswordHashCost)
+		if config.AccountsPath != "" {
+			signalingServer.Accounts, err = models.OpenAccountStore(config.AccountsPath, config.PasswordHashCost)
+			if err != nil {
+				utils.Fatal(ctx, "Failed to open account store", err, map[string]interface{}{
+					"path": config.AccountsPath,
+				})
+			}
+			defer signalingServer.Accounts.Close()
+		} else if config.IsProduction() {
+			utils.Warn(ctx, "ACCOUNTS_PATH not set; registered accounts are lost on restart")
+		}
+	}
+
+	// Optional friend lists and direct calls between friends
+	if config.FriendsEnabled {
+		signalingServer.Friends = models.NewFriendStore()
+		if config.FriendsPath != "" {
+			signalingServer.Friends, err = models.OpenFriendStore(config.FriendsPath)
+			if err != nil {
+				utils.Fatal(ctx, "Failed to open friend store", err, map[string]interface{}{
+					"path": config.FriendsPath,
+				})
+			}
+			defer signalingServer.Friends.Close()
+		} else if config.IsProduction() {
+			utils.Warn(ctx, "FRIENDS_PATH not set; friendships are lost on restart")
+		}
+		if config.PresenceEnabled {
+			signalingServer.Presence = models.NewPresenceTracker(userPool, signalingServer.Friends, config.PresenceCoalesceWindow)
+		}
+		if config.ScheduledCallsEnabled {
+			calls := models.NewScheduledCallStore(config.MaxScheduledCalls)
+			if config.ScheduledCallsPath != "" {
+				calls, err = models.OpenScheduledCallStore(config.ScheduledCallsPath, config.MaxScheduledCalls)
+				if err != nil {
+					utils.Fatal(ctx, "Failed to open scheduled calls", err, map[string]interface{}{
+						"path": config.ScheduledCallsPath,
+					})
+				}
+				defer calls.Close()
+			} else if config.IsProduction() {
+				utils.Warn(ctx, "SCHEDULED_CALLS_PATH not set; scheduled calls are lost on restart")
+			}
+			signalingServer.Scheduler = handlers.NewCallScheduler(calls, utils.RealClock, config.ScheduledCallReminders)
+		}
+	}
+
+	// Optional push notifications for calls to friends whose app is closed
+	providers := make(map[string]utils.PushProvider)
+	if config.PushFCMCredentialsFile != "" {
+		fcm, err := utils.NewFCMProvider(utils.FCMConfig{
+			ProjectID:       config.PushFCMProjectID,
+			CredentialsFile: config.PushFCMCredentialsFile,
+		}, nil)
+		if err != nil {
+			utils.Fatal(ctx, "Failed to load FCM credentials", err)
+		}
+		providers[models.PushPlatformFCM] = fcm
+	}
+	if config.PushAPNsKeyFile != "" {
+		endpoint := utils.APNsProductionEndpoint
+		if config.PushAPNsSandbox {
+			endpoint = utils.APNsSandboxEndpoint
+		}
+		apns, err := utils.NewAPNsProvider(utils.APNsConfig{
+			KeyFile:  config.PushAPNsKeyFile,
+			KeyID:    config.PushAPNsKeyID,
+			TeamID:   config.PushAPNsTeamID,
+			Topic:    config.PushAPNsTopic,
+			Endpoint: endpoint,
+		}, nil)
+		if err != nil {
+			utils.Fatal(ctx, "Failed to load APNs key", err)
+		}
+		providers[models.PushPlatformAPNs] = apns
+	}
+	if len(providers) > 0 {
+		tokens := models.NewPushTokenStore()
+		if config.PushTokensPath != "" {
+			tokens, err = models.OpenPushTokenStore(config.PushTokensPath)
+			if err != nil {
+				utils.Fatal(ctx, "Failed to open push token store", err, map[string]interface{}{
+					"path": config.PushTokensPath,
+				})
+			}
+			defer tokens.Close()
+		} else if config.IsProduction() {
+			utils.Warn(ctx, "PUSH_TOKENS_PATH not set; push tokens are lost on restart")
+		}
+		signalingServer.Push = handlers.NewPushNotifier(providers, tokens)
+	}
+
+	// Optional voice notes between friends, stored on the local filesystem
+	if config.VoiceNotesDir != "" {
+		blobs, err := utils.NewFileBlobStore(config.VoiceNotesDir)
+		if err != nil {
+			utils.Fatal(ctx, "Failed to open voice note storage", err)
+		}
+		// The notes are kept next to their audio; the blob store ignores
+		// file names containing a dot
+		notesPath := filepath.Join(config.VoiceNotesDir, "notes.jsonl")
+		notes, err := models.OpenVoiceNoteStore(notesPath, config.VoiceNoteMaxPending, config.VoiceNoteMaxPerSender)
+		if err != nil {
+			utils.Fatal(ctx, "Failed to open voice notes", err, map[string]interface{}{
+				"path": notesPath,
+			})
+		}
+		defer notes.Close()
+		signalingServer.VoiceNotes = handlers.NewVoiceNotes(blobs, notes, utils.NewURLSigner(config.JWTSecret, "voice-notes"), handlers.VoiceNotesConfig{
+			MaxBytes:    config.VoiceNoteMaxBytes,
+			MaxDuration: config.VoiceNoteMaxDuration,
+			Retention:   config.VoiceNoteRetention,
+			URLTTL:      config.VoiceNoteURLTTL,
+		})
+	}
+
+	// Optional email verification and password reset mail
+	if config.Mailer != "" {
+		var mailer utils.Mailer
+		if config.Mailer == models.MailerSMTP {
+			mailer = utils.NewSMTPMailer(utils.SMTPConfig{
+				Host:     config.SMTPHost,
+				Port:     config.SMTPPort,
+				Username: config.SMTPUsername,
+				Password: config.SMTPPassword,
+				From:     config.MailFrom,
+			})
+		} else {
+			outbox, err := utils.NewOutboxMailer(config.MailOutboxDir, config.MailFrom)
+			if err != nil {
+				utils.Fatal(ctx, "Failed to open mail outbox", err)
+			}
+			if config.Environment == models.EnvironmentProduction {
+				utils.Warn(ctx, "MAILER=outbox does not deliver mail; use smtp in production")
+			}
+			mailer = outbox
+		}
+		limiter := middleware.NewAddressRateLimiter(config.MailRatePerHour, models.DefaultMailBurst)
+		signalingServer.Email = handlers.NewAccountEmail(mailer, limiter, config.MailLinkBaseURL)
+	}
+
+	// Optional single sign-on through an OpenID Connect provider
+	if config.OIDCIssuerURL != "" {
+		signalingServer.OIDC = handlers.NewOIDCLogin(utils.NewOIDCClient(utils.OIDCConfig{
+			IssuerURL:    config.OIDCIssuerURL,
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			RedirectURL:  config.OIDCRedirectURL,
+			Scopes:       config.OIDCScopes,
+		}, nil), config.OIDCAppRedirectURL)
+	}
+
+	// Optional proof-of-work admission challenge
+	if config.ChallengeEnabled {
+		challenger, err := handlers.NewChallenger(handlers.ChallengeConfig{
+			BaseDifficulty: config.ChallengeDifficulty,
+			MaxDifficulty:  config.ChallengeMaxDifficulty,
+			RateThreshold:  config.ChallengeRateThreshold,
+			Timeout:        config.ChallengeTimeout,
+		}, rateLimiter)
+		if err != nil {
+			utils.Fatal(ctx, "Failed to initialize challenger", err)
+		}
+		signalingServer.Challenger = challenger
+	}
+
+	// Initialize admin API authentication
+	adminAuth, err := middleware.NewAdminAuthenticator(config.AdminAPIKeys)
+	if err != nil {
+		utils.Fatal(ctx, "Invalid admin API key configuration", err)
+	}
+
+	// Initialize admin server
+	adminServer := &handlers.AdminServer{
+		UserPool: userPool,
+		Auth:     adminAuth,
+	}
+
+	// Optional behavioural bot detection
+	if config.BotDetectionEnabled {
+		signalingServer.BotDetector = handlers.NewBotDetector(handlers.BotDetectorConfig{
+			ChallengeScore:  config.BotChallengeScore,
+			ThrottleScore:   config.BotThrottleScore,
+			ShadowBanScore:  config.BotShadowBanScore,
+			DisconnectScore: config.BotDisconnectScore,
+		})
 	}
 
 	// Create HTTP mux
@@ -63,12 +348,8 @@ func main() {
 	// Setup routes
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		// Log WebSocket connection attempts
-		clientIP := r.Header.Get("X-Forwarded-For")
-		if clientIP == "" {
-			clientIP = r.RemoteAddr
-		}
 		utils.Info(ctx, "WebSocket connection attempt", map[string]interface{}{
-			"client_ip":  clientIP,
+			"client_ip":  utils.ClientIP(r),
 			"user_agent": r.Header.Get("User-Agent"),
 			"origin":     r.Header.Get("Origin"),
 			"path":       r.URL.Path,
@@ -77,6 +358,33 @@ func main() {
 		signalingServer.HandleWebSocket(w, r)
 	})
 
+	// HTTP session creation; returns a JWT and a single-use /ws ticket
+	mux.HandleFunc("POST /session", signalingServer.HandleCreateSession)
+	mux.HandleFunc("GET /session/challenge", signalingServer.HandleSessionChallenge)
+
+	// Registered accounts; anonymous sessions keep using POST /session
+	mux.HandleFunc("POST /account/signup", signalingServer.HandleSignup)
+	mux.HandleFunc("POST /account/login", signalingServer.HandleLogin)
+	mux.HandleFunc("POST /account/upgrade", signalingServer.HandleUpgradeAccount)
+	mux.HandleFunc("POST /account/email", signalingServer.HandleSetEmail)
+	mux.HandleFunc("POST /account/email/verify", signalingServer.HandleVerifyEmail)
+	mux.HandleFunc("POST /account/password/forgot", signalingServer.HandleForgotPassword)
+	mux.HandleFunc("POST /account/password/reset", signalingServer.HandleResetPassword)
+	mux.HandleFunc("GET /auth/oidc/login", signalingServer.HandleOIDCLogin)
+	mux.HandleFunc("GET /auth/oidc/callback", signalingServer.HandleOIDCCallback)
+	mux.HandleFunc("POST /voice-notes", signalingServer.HandleUploadVoiceNote)
+	mux.HandleFunc("GET /voice-notes", signalingServer.HandleListVoiceNotes)
+	mux.HandleFunc("GET /voice-notes/{id}", signalingServer.HandleDownloadVoiceNote)
+	mux.HandleFunc("DELETE /voice-notes/{id}", signalingServer.HandleDeleteVoiceNote)
+
+	// Public JWT verification keys for other services
+	mux.HandleFunc("GET /.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(utils.JWKS())
+	})
+
 	// Health check endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -110,6 +418,14 @@ func main() {
 		json.NewEncoder(w).Encode(iceServers)
 	})
 
+	// Admin endpoints (every request is rejected unless ADMIN_API_KEYS is set)
+	adminMux := http.NewServeMux()
+	adminServer.RegisterRoutes(adminMux)
+	mux.Handle("/admin/", middleware.Chain(
+		adminMux,
+		adminAuth.Authenticate,
+	))
+
 	// Apply middleware stack (order matters!)
 	handler := middleware.Chain(
 		mux,
@@ -129,13 +445,25 @@ func main() {
 		MaxHeaderBytes: 1 << 20, // 1MB
 	}
 
+	// Arm reminders and starts for calls scheduled before a restart, now
+	// that everything they use is wired
+	signalingServer.StartScheduler()
+
 	// Start server in a goroutine
 	go func() {
 		utils.Info(ctx, "Voice chat server starting", map[string]interface{}{
 			"address": server.Addr,
 		})
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			utils.Fatal(ctx, "Server failed to start", err)
+		}
+		if config.ProxyProtocolEnabled {
+			listener = utils.NewProxyProtocolListener(listener, clientIPResolver, config.ReadTimeout)
+		}
+
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			utils.Fatal(ctx, "Server failed to start", err)
 		}
 	}()
@@ -153,6 +481,10 @@ func main() {
 
 	// Shutdown user pool
 	userPool.Shutdown()
+	if signalingServer.BotDetector != nil {
+		signalingServer.BotDetector.Stop()
+	}
+	signalingServer.Tickets.Stop()
 
 	// Shutdown HTTP server
 	if err := server.Shutdown(shutdownCtx); err != nil {
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// AddressRateLimiter limits actions per email address, such as sending
+// verification or password reset mail, so one address cannot be flooded
+// from many IPs
+type AddressRateLimiter struct {
+	limiters        map[string]*rate.Limiter
+	mutex           sync.Mutex
+	rate            rate.Limit
+	burst           int
+	denied          int
+	cleanupInterval time.Duration
+}
+
+// NewAddressRateLimiter creates a limiter allowing perHour actions per
+// address with bursts of up to burst
+func NewAddressRateLimiter(perHour, burst int) *AddressRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &AddressRateLimiter{
+		limiters:        make(map[string]*rate.Limiter),
+		rate:            rate.Limit(perHour) / 3600, // per second
+		burst:           burst,
+		cleanupInterval: 5 * time.Minute,
+	}
+
+	// Start cleanup goroutine
+	go l.cleanupExpiredLimiters()
+
+	return l
+}
+
+// Allow reports whether another action for an address is allowed.
+// Addresses are compared case-insensitively.
+func (l *AddressRateLimiter) Allow(address string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	key := strings.ToLower(address)
+	limiter, exists := l.limiters[key]
+	if !exists {
+		limiter = rate.NewLimiter(l.rate, l.burst)
+		l.limiters[key] = limiter
+	}
+
+	if !limiter.Allow() {
+		l.denied++
+		return false
+	}
+	return true
+}
+
+// RetryAfter is how long a denied address waits for its next action
+func (l *AddressRateLimiter) RetryAfter() time.Duration {
+	if l.rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration(float64(time.Second) / float64(l.rate))
+}
+
+// GetStats returns limiter counters
+func (l *AddressRateLimiter) GetStats() map[string]interface{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return map[string]interface{}{
+		"tracked_addresses": len(l.limiters),
+		"denied":            l.denied,
+	}
+}
+
+// cleanupExpiredLimiters removes unused limiters periodically
+func (l *AddressRateLimiter) cleanupExpiredLimiters() {
+	ticker := time.NewTicker(l.cleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.mutex.Lock()
+
+		// If limiter is at full capacity, it hasn't been used recently
+		for address, limiter := range l.limiters {
+			if limiter.Tokens() >= float64(l.burst) {
+				delete(l.limiters, address)
+			}
+		}
+
+		l.mutex.Unlock()
+	}
+}
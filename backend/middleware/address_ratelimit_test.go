@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressRateLimiter(t *testing.T) {
+	limiter := NewAddressRateLimiter(6, 2)
+
+	// Addresses share a bucket regardless of case
+	assert.True(t, limiter.Allow("alice@example.com"))
+	assert.True(t, limiter.Allow("ALICE@example.com"))
+	assert.False(t, limiter.Allow("alice@example.com"))
+
+	// Other addresses are unaffected
+	assert.True(t, limiter.Allow("bob@example.com"))
+
+	assert.Equal(t, 10*time.Minute, limiter.RetryAfter())
+	stats := limiter.GetStats()
+	assert.Equal(t, 2, stats["tracked_addresses"])
+	assert.Equal(t, 1, stats["denied"])
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"voice-chat-app/errors"
+	"voice-chat-app/utils"
+)
+
+type adminKeyContextKey struct{}
+
+// AdminKey is an authenticated admin API key and the scopes it grants
+type AdminKey struct {
+	ID     string
+	hash   []byte
+	scopes map[string]bool
+}
+
+// HasScope reports whether the key grants the given scope
+func (k *AdminKey) HasScope(scope string) bool {
+	return k.scopes[scope]
+}
+
+// AdminAuthenticator authenticates admin API requests against hashed keys
+type AdminAuthenticator struct {
+	keys []*AdminKey
+}
+
+// NewAdminAuthenticator creates an authenticator from configured keys.
+// With no keys configured every admin request is rejected.
+func NewAdminAuthenticator(configs []utils.AdminAPIKeyConfig) (*AdminAuthenticator, error) {
+	auth := &AdminAuthenticator{}
+	for _, config := range configs {
+		hash, err := hex.DecodeString(config.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("admin API key %s: invalid hash", config.ID)
+		}
+
+		key := &AdminKey{
+			ID:     config.ID,
+			hash:   hash,
+			scopes: make(map[string]bool),
+		}
+		for _, scope := range config.Scopes {
+			key.scopes[scope] = true
+		}
+		auth.keys = append(auth.keys, key)
+	}
+	return auth, nil
+}
+
+// Authenticate middleware resolves the API key from the Authorization bearer
+// token or the X-API-Key header and attaches it to the request context
+func (a *AdminAuthenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := a.lookup(extractAPIKey(r))
+		if key == nil {
+			utils.Warn(r.Context(), "Admin authentication failed", map[string]interface{}{
+				"path": r.URL.Path,
+			})
+			errors.WriteErrorResponse(w, errors.NewUnauthorizedError("Invalid or missing admin API key"))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), adminKeyContextKey{}, key)
+		ctx = utils.WithAdminKeyID(ctx, key.ID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireScope returns a middleware that rejects requests whose admin key
+// does not grant scope. It must run after Authenticate.
+func (a *AdminAuthenticator) RequireScope(scope string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := GetAdminKey(r.Context())
+			if key == nil {
+				errors.WriteErrorResponse(w, errors.NewUnauthorizedError("Invalid or missing admin API key"))
+				return
+			}
+			if !key.HasScope(scope) {
+				utils.Warn(r.Context(), "Admin key missing required scope", map[string]interface{}{
+					"path":  r.URL.Path,
+					"scope": scope,
+				})
+				errors.WriteErrorResponse(w, errors.NewForbiddenError(fmt.Sprintf("API key lacks scope %s", scope)))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetAdminKey returns the authenticated admin key from the context, if any
+func GetAdminKey(ctx context.Context) *AdminKey {
+	key, _ := ctx.Value(adminKeyContextKey{}).(*AdminKey)
+	return key
+}
+
+// lookup finds the key matching the raw API key, comparing hashes in
+// constant time
+func (a *AdminAuthenticator) lookup(raw string) *AdminKey {
+	if raw == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(raw))
+	var match *AdminKey
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], key.hash) == 1 {
+			match = key
+		}
+	}
+	return match
+}
+
+// extractAPIKey reads an API key from the request headers
+func extractAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
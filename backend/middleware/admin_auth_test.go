@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAuthenticator_Scopes(t *testing.T) {
+	auth, err := NewAdminAuthenticator([]utils.AdminAPIKeyConfig{
+		{ID: "reader", Hash: utils.HashAPIKey("reader-key"), Scopes: []string{models.ScopeStatsRead}},
+		{ID: "moderator", Hash: utils.HashAPIKey("moderator-key"), Scopes: []string{models.ScopeStatsRead, models.ScopeBansWrite}},
+	})
+	require.NoError(t, err)
+
+	var seenKeyID string
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seenKeyID = utils.GetAdminKeyID(r.Context())
+			w.WriteHeader(http.StatusOK)
+		}),
+		auth.Authenticate,
+		auth.RequireScope(models.ScopeBansWrite),
+	)
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		expected int
+		keyID    string
+	}{
+		{"missing key", "", "", http.StatusUnauthorized, ""},
+		{"unknown key", "Authorization", "Bearer nope", http.StatusUnauthorized, ""},
+		{"insufficient scope", "Authorization", "Bearer reader-key", http.StatusForbidden, ""},
+		{"bearer key", "Authorization", "Bearer moderator-key", http.StatusOK, "moderator"},
+		{"header key", "X-API-Key", "moderator-key", http.StatusOK, "moderator"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seenKeyID = ""
+			req := httptest.NewRequest(http.MethodPost, "/admin/shadow-bans", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expected, rec.Code)
+			assert.Equal(t, tt.keyID, seenKeyID)
+			if tt.expected != http.StatusOK {
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestNewAdminAuthenticator_InvalidHash(t *testing.T) {
+	_, err := NewAdminAuthenticator([]utils.AdminAPIKeyConfig{{ID: "bad", Hash: "xyz"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"voice-chat-app/errors"
+	"voice-chat-app/models"
+	"voice-chat-app/utils"
+)
+
+// AdmissionDecision is the outcome of an admission request
+type AdmissionDecision string
+
+const (
+	AdmissionAccepted           AdmissionDecision = "accepted"
+	AdmissionWaitlisted         AdmissionDecision = "waitlisted"
+	AdmissionRejectedPerIP      AdmissionDecision = "rejected_per_ip"
+	AdmissionRejectedOverloaded AdmissionDecision = "rejected_over_capacity"
+)
+
+// AdmissionConfig configures the admission controller
+type AdmissionConfig struct {
+	MaxConnections  int    // global cap on admitted connections; zero disables it
+	Mode            string // models.AdmissionModeReject or models.AdmissionModeWaitlist
+	WaitlistSize    int
+	WaitlistTimeout time.Duration
+	RetryAfter      time.Duration
+}
+
+// AdmissionController enforces the global connection cap and, through the
+// rate limiter, the per-IP cap at WebSocket upgrade time. In waitlist mode
+// clients over the global cap are queued and promoted in FIFO order as
+// slots are released.
+type AdmissionController struct {
+	config      AdmissionConfig
+	rateLimiter *RateLimiter
+	active      int
+	waitlist    []*AdmissionTicket
+	counters    map[AdmissionDecision]int
+	promoted    int
+	abandoned   int
+	mutex       sync.Mutex
+}
+
+// AdmissionTicket represents a client's claim on connection slots. Release
+// must be called when the connection ends; it is safe to call more than once.
+type AdmissionTicket struct {
+	controller *AdmissionController
+	ip         string
+	holdsSlot  bool
+	ready      chan struct{}
+	once       sync.Once
+}
+
+// NewAdmissionController creates an admission controller. The rate limiter,
+// if provided, enforces the per-IP connection cap.
+func NewAdmissionController(config AdmissionConfig, rateLimiter *RateLimiter) *AdmissionController {
+	if config.Mode == "" {
+		config.Mode = models.AdmissionModeReject
+	}
+	if config.WaitlistSize <= 0 {
+		config.WaitlistSize = models.DefaultWaitlistSize
+	}
+	if config.WaitlistTimeout <= 0 {
+		config.WaitlistTimeout = models.DefaultWaitlistTimeout
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = models.DefaultAdmissionRetryAfter
+	}
+
+	return &AdmissionController{
+		config:      config,
+		rateLimiter: rateLimiter,
+		counters:    make(map[AdmissionDecision]int),
+	}
+}
+
+// Admit requests slots for a client. An accepted ticket is ready at once; a
+// waitlisted ticket becomes ready when a slot is handed to it. Rejections
+// return a nil ticket.
+func (a *AdmissionController) Admit(ip string) (*AdmissionTicket, AdmissionDecision) {
+	if a.rateLimiter != nil && !a.rateLimiter.CheckWebSocketConnection(ip) {
+		a.count(AdmissionRejectedPerIP)
+		return nil, AdmissionRejectedPerIP
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	ticket := &AdmissionTicket{
+		controller: a,
+		ip:         ip,
+		ready:      make(chan struct{}),
+	}
+
+	if a.config.MaxConnections <= 0 || a.active < a.config.MaxConnections {
+		a.active++
+		ticket.holdsSlot = true
+		close(ticket.ready)
+		a.counters[AdmissionAccepted]++
+		return ticket, AdmissionAccepted
+	}
+
+	if a.config.Mode == models.AdmissionModeWaitlist && len(a.waitlist) < a.config.WaitlistSize {
+		a.waitlist = append(a.waitlist, ticket)
+		a.counters[AdmissionWaitlisted]++
+		return ticket, AdmissionWaitlisted
+	}
+
+	a.counters[AdmissionRejectedOverloaded]++
+	if a.rateLimiter != nil {
+		a.rateLimiter.ReleaseWebSocketConnection(ip)
+	}
+	return nil, AdmissionRejectedOverloaded
+}
+
+// AdmitRequest admits a WebSocket upgrade request. On rejection it writes a
+// 429 (per-IP cap) or 503 (global cap) response with Retry-After and returns
+// a nil ticket.
+func (a *AdmissionController) AdmitRequest(w http.ResponseWriter, r *http.Request) (*AdmissionTicket, AdmissionDecision) {
+	ticket, decision := a.Admit(utils.ClientIP(r))
+	if ticket != nil {
+		return ticket, decision
+	}
+
+	w.Header().Set("Retry-After", a.RetryAfterSeconds())
+	switch decision {
+	case AdmissionRejectedPerIP:
+		errors.WriteErrorResponse(w, errors.NewRateLimitError("Too many connections from this address"))
+	default:
+		errors.WriteErrorResponse(w, errors.NewServerBusyError(""))
+	}
+	return nil, decision
+}
+
+// RetryAfterSeconds returns the Retry-After value to send to rejected clients
+func (a *AdmissionController) RetryAfterSeconds() string {
+	return formatSeconds(a.config.RetryAfter)
+}
+
+// WaitlistTimeout returns how long a client may wait for a slot
+func (a *AdmissionController) WaitlistTimeout() time.Duration {
+	return a.config.WaitlistTimeout
+}
+
+// GetStats returns admission counters and current occupancy
+func (a *AdmissionController) GetStats() map[string]interface{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return map[string]interface{}{
+		"mode":                   a.config.Mode,
+		"max_connections":        a.config.MaxConnections,
+		"active_connections":     a.active,
+		"waitlist_length":        len(a.waitlist),
+		"accepted":               a.counters[AdmissionAccepted],
+		"waitlisted":             a.counters[AdmissionWaitlisted],
+		"promoted":               a.promoted,
+		"abandoned":              a.abandoned,
+		"rejected_per_ip":        a.counters[AdmissionRejectedPerIP],
+		"rejected_over_capacity": a.counters[AdmissionRejectedOverloaded],
+	}
+}
+
+func (a *AdmissionController) count(decision AdmissionDecision) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.counters[decision]++
+}
+
+// Ready is closed once the ticket holds a global slot
+func (t *AdmissionTicket) Ready() <-chan struct{} {
+	return t.ready
+}
+
+// Position returns the ticket's 1-based waitlist position, or 0 if it is not waiting
+func (t *AdmissionTicket) Position() int {
+	a := t.controller
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for i, waiting := range a.waitlist {
+		if waiting == t {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Release gives back the ticket's slots. A held global slot is handed to the
+// next waitlisted client, if any.
+func (t *AdmissionTicket) Release() {
+	t.once.Do(func() {
+		a := t.controller
+		a.mutex.Lock()
+		if t.holdsSlot {
+			a.handOffLocked()
+		} else {
+			a.removeWaiterLocked(t)
+		}
+		a.mutex.Unlock()
+
+		if a.rateLimiter != nil {
+			a.rateLimiter.ReleaseWebSocketConnection(t.ip)
+		}
+	})
+}
+
+// handOffLocked passes a released slot to the first waiter or frees it.
+// Caller must hold the lock.
+func (a *AdmissionController) handOffLocked() {
+	if len(a.waitlist) == 0 {
+		a.active--
+		return
+	}
+
+	next := a.waitlist[0]
+	a.waitlist = a.waitlist[1:]
+	next.holdsSlot = true
+	close(next.ready)
+	a.promoted++
+}
+
+// removeWaiterLocked drops an abandoned ticket from the waitlist. Caller must hold the lock.
+func (a *AdmissionController) removeWaiterLocked(t *AdmissionTicket) {
+	for i, waiting := range a.waitlist {
+		if waiting == t {
+			a.waitlist = append(a.waitlist[:i], a.waitlist[i+1:]...)
+			a.abandoned++
+			return
+		}
+	}
+}
+
+// formatSeconds renders a duration as whole seconds, rounding up
+func formatSeconds(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"voice-chat-app/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmissionController_RejectMode(t *testing.T) {
+	rateLimiter := NewRateLimiter(60, 60, 1)
+	admission := NewAdmissionController(AdmissionConfig{MaxConnections: 2}, rateLimiter)
+
+	first, decision := admission.Admit("10.0.0.1")
+	require.NotNil(t, first)
+	assert.Equal(t, AdmissionAccepted, decision)
+
+	// Per-IP cap applies before the global cap
+	ticket, decision := admission.Admit("10.0.0.1")
+	assert.Nil(t, ticket)
+	assert.Equal(t, AdmissionRejectedPerIP, decision)
+
+	second, _ := admission.Admit("10.0.0.2")
+	require.NotNil(t, second)
+
+	ticket, decision = admission.Admit("10.0.0.3")
+	assert.Nil(t, ticket)
+	assert.Equal(t, AdmissionRejectedOverloaded, decision)
+
+	// A rejection over capacity must not leak the per-IP slot
+	first.Release()
+	first.Release()
+	third, decision := admission.Admit("10.0.0.3")
+	require.NotNil(t, third)
+	assert.Equal(t, AdmissionAccepted, decision)
+
+	stats := admission.GetStats()
+	assert.Equal(t, 2, stats["active_connections"])
+	assert.Equal(t, 1, stats["rejected_per_ip"])
+	assert.Equal(t, 1, stats["rejected_over_capacity"])
+}
+
+func TestAdmissionController_Waitlist(t *testing.T) {
+	admission := NewAdmissionController(AdmissionConfig{
+		MaxConnections: 1,
+		Mode:           models.AdmissionModeWaitlist,
+		WaitlistSize:   2,
+	}, nil)
+
+	holder, _ := admission.Admit("10.0.0.1")
+	first, decision := admission.Admit("10.0.0.2")
+	require.NotNil(t, first)
+	assert.Equal(t, AdmissionWaitlisted, decision)
+	second, _ := admission.Admit("10.0.0.3")
+	require.NotNil(t, second)
+
+	_, decision = admission.Admit("10.0.0.4")
+	assert.Equal(t, AdmissionRejectedOverloaded, decision)
+
+	assert.Equal(t, 1, first.Position())
+	assert.Equal(t, 2, second.Position())
+
+	// An abandoned waiter leaves the queue without taking a slot
+	first.Release()
+	assert.Equal(t, 1, second.Position())
+
+	holder.Release()
+	select {
+	case <-second.Ready():
+	default:
+		t.Fatal("waitlisted ticket was not promoted")
+	}
+	assert.Equal(t, 0, second.Position())
+
+	second.Release()
+	stats := admission.GetStats()
+	assert.Equal(t, 0, stats["active_connections"])
+	assert.Equal(t, 1, stats["promoted"])
+	assert.Equal(t, 1, stats["abandoned"])
+}
+
+func TestAdmissionController_AdmitRequest(t *testing.T) {
+	admission := NewAdmissionController(AdmissionConfig{MaxConnections: 1}, nil)
+	admission.Admit("10.0.0.1")
+
+	w := httptest.NewRecorder()
+	ticket, _ := admission.AdmitRequest(w, httptest.NewRequest(http.MethodGet, "/ws", nil))
+
+	assert.Nil(t, ticket)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), models.ErrorCodeServerBusy)
+}
@@ -8,7 +8,7 @@ import (
 
 // CORSConfig holds CORS configuration
 type CORSConfig struct {
-	AllowedOrigins   []string
+	Policy           *OriginPolicy
 	AllowedMethods   []string
 	AllowedHeaders   []string
 	ExposedHeaders   []string
@@ -16,16 +16,18 @@ type CORSConfig struct {
 	MaxAge           int
 }
 
-// NewCORSConfig creates a new CORS configuration
-func NewCORSConfig(allowedOrigins []string) *CORSConfig {
+// NewCORSConfig creates a new CORS configuration backed by the shared origin policy
+func NewCORSConfig(policy *OriginPolicy) *CORSConfig {
 	return &CORSConfig{
-		AllowedOrigins: allowedOrigins,
+		Policy:         policy,
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{
 			"Accept",
 			"Authorization",
 			"Content-Type",
 			"X-CSRF-Token",
+			"X-Challenge-Pass",
+			"X-Device-Token",
 			"X-Requested-With",
 		},
 		ExposedHeaders: []string{
@@ -43,10 +45,12 @@ func (c *CORSConfig) CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 
-		// Check if origin is allowed
-		if origin != "" && c.isOriginAllowed(origin) {
+		// Check if origin is allowed. Requests without an Origin are not
+		// cross-origin browser requests, so CORS headers are irrelevant to them.
+		if origin != "" && c.Policy.CheckRequest(r) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else if len(c.AllowedOrigins) == 1 && c.AllowedOrigins[0] == "*" {
+			w.Header().Add("Vary", "Origin")
+		} else if origin == "" && c.allowsAnyOrigin() {
 			// Only allow wildcard in development
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 		}
@@ -77,52 +81,12 @@ func (c *CORSConfig) CORS(next http.Handler) http.Handler {
 	})
 }
 
-// isOriginAllowed checks if the given origin is in the allowed list
-func (c *CORSConfig) isOriginAllowed(origin string) bool {
-	for _, allowedOrigin := range c.AllowedOrigins {
-		if allowedOrigin == "*" {
-			return true
-		}
-		if allowedOrigin == origin {
-			return true
-		}
-		// Support wildcard subdomains like *.example.com
-		if strings.HasPrefix(allowedOrigin, "*.") {
-			domain := allowedOrigin[2:]
-			if strings.HasSuffix(origin, "."+domain) || origin == domain {
-				return true
-			}
-		}
-	}
-	return false
+// allowsAnyOrigin reports whether the policy is the development wildcard
+func (c *CORSConfig) allowsAnyOrigin() bool {
+	return len(c.Policy.AllowedOrigins) == 1 && c.Policy.AllowedOrigins[0] == "*"
 }
 
 // ValidateOrigin performs additional security checks on the origin
 func (c *CORSConfig) ValidateOrigin(origin string) bool {
-	if origin == "" {
-		return false
-	}
-
-	// Check for dangerous patterns
-	dangerousPatterns := []string{
-		"javascript:",
-		"data:",
-		"vbscript:",
-		"file:",
-		"about:",
-	}
-
-	lowerOrigin := strings.ToLower(origin)
-	for _, pattern := range dangerousPatterns {
-		if strings.HasPrefix(lowerOrigin, pattern) {
-			return false
-		}
-	}
-
-	// Must start with http:// or https://
-	if !strings.HasPrefix(lowerOrigin, "http://") && !strings.HasPrefix(lowerOrigin, "https://") {
-		return false
-	}
-
-	return c.isOriginAllowed(origin)
+	return c.Policy.IsAllowed(origin)
 }
@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"voice-chat-app/models"
+
+	"golang.org/x/time/rate"
+)
+
+// MessagePenalty is the outcome of checking a WebSocket message
+type MessagePenalty string
+
+const (
+	PenaltyNone       MessagePenalty = "none"       // message allowed
+	PenaltyThrottle   MessagePenalty = "throttle"   // message dropped, client told to slow down
+	PenaltyMute       MessagePenalty = "mute"       // client muted, messages dropped until it expires
+	PenaltyDisconnect MessagePenalty = "disconnect" // client disconnected
+)
+
+// MessageRateRule is a token bucket for one message type
+type MessageRateRule struct {
+	PerSecond float64
+	Burst     int
+}
+
+// unlistedBucket is the key of the bucket shared by every message type
+// without its own rule, so that inventing types neither escapes the limits
+// nor grows a session's buckets
+const unlistedBucket = "unlisted"
+
+// MessageRateLimiterConfig configures per-type buckets and penalty escalation
+type MessageRateLimiterConfig struct {
+	Rules           map[string]MessageRateRule // per message type
+	Default         MessageRateRule            // one bucket shared by all types without their own rule
+	Exempt          []string                   // types never limited, such as heartbeat pongs
+	MuteExempt      []string                   // types still accepted while muted, so a client can always hang up
+	ViolationWindow time.Duration              // violations older than this are forgotten
+	MuteAfter       int                        // violations within the window before muting
+	MuteDuration    time.Duration
+	DisconnectAfter int // violations within the window before disconnecting
+}
+
+// DefaultMessageRateLimiterConfig returns per-type limits tuned for the
+// signaling protocol: ICE candidates arrive in bursts, matchmaking and call
+// control should be rare.
+func DefaultMessageRateLimiterConfig(messagesPerMinute int) MessageRateLimiterConfig {
+	return MessageRateLimiterConfig{
+		Rules: map[string]MessageRateRule{
+			models.MessageTypeFindMatch:      {PerSecond: 0.5, Burst: 3},
+			models.MessageTypeICECandidate:   {PerSecond: 20, Burst: 50},
+			models.MessageTypeOffer:          {PerSecond: 1, Burst: 5},
+			models.MessageTypeAnswer:         {PerSecond: 1, Burst: 5},
+			models.MessageTypeCallStart:      {PerSecond: 0.5, Burst: 3},
+			models.MessageTypeCallAccept:     {PerSecond: 0.5, Burst: 3},
+			models.MessageTypeCallReject:     {PerSecond: 0.5, Burst: 3},
+			models.MessageTypeCallEnd:        {PerSecond: 0.5, Burst: 3},
+			models.MessageTypeAddFriend:      {PerSecond: 0.5, Burst: 3},
+			models.MessageTypeCallFriend:     {PerSecond: 0.5, Burst: 3},
+			models.MessageTypeSetPresence:    {PerSecond: 1, Burst: 5},
+			models.MessageTypeRegisterPush:   {PerSecond: 0.2, Burst: 3},
+			models.MessageTypeScheduleCall:   {PerSecond: 0.2, Burst: 3},
+			models.MessageTypeShareContact:   {PerSecond: 0.2, Burst: 3},
+			models.MessageTypeSetDisplayName: {PerSecond: 0.2, Burst: 3},
+		},
+		Default:         MessageRateRule{PerSecond: float64(messagesPerMinute) / 60, Burst: messagesPerMinute / 4},
+		Exempt:          []string{models.MessageTypePong},
+		MuteExempt:      []string{models.MessageTypeCallEnd, models.MessageTypeLogout, models.MessageTypeDisconnect},
+		ViolationWindow: time.Minute,
+		MuteAfter:       models.DefaultMessageMuteAfter,
+		MuteDuration:    models.DefaultMessageMuteDuration,
+		DisconnectAfter: models.DefaultMessageDisconnectAfter,
+	}
+}
+
+// messageSession holds one session's buckets and violation history. There is
+// at most one bucket per rule plus the shared unlisted bucket.
+type messageSession struct {
+	limiters   map[string]*rate.Limiter
+	violations []time.Time
+	mutedUntil time.Time
+}
+
+// MessageRateLimiter applies per-session, per-message-type token buckets and
+// escalates repeated violations from throttling to muting to disconnecting.
+// Callers key sessions by User.Identity(), so reconnecting does not reset
+// the penalties.
+type MessageRateLimiter struct {
+	config     MessageRateLimiterConfig
+	exempt     map[string]bool
+	muteExempt map[string]bool
+	sessions   map[string]*messageSession
+	ended      map[string]bool // ended sessions kept until their penalties lapse
+	penalties  map[MessagePenalty]int
+	byType     map[string]int
+	mutex      sync.Mutex
+}
+
+// NewMessageRateLimiter creates a message rate limiter
+func NewMessageRateLimiter(config MessageRateLimiterConfig) *MessageRateLimiter {
+	if config.ViolationWindow <= 0 {
+		config.ViolationWindow = time.Minute
+	}
+	if config.Default.Burst < 1 {
+		config.Default.Burst = 1
+	}
+
+	l := &MessageRateLimiter{
+		config:     config,
+		exempt:     make(map[string]bool),
+		muteExempt: make(map[string]bool),
+		sessions:   make(map[string]*messageSession),
+		ended:      make(map[string]bool),
+		penalties:  make(map[MessagePenalty]int),
+		byType:     make(map[string]int),
+	}
+	for _, msgType := range config.Exempt {
+		l.exempt[msgType] = true
+	}
+	for _, msgType := range config.MuteExempt {
+		l.muteExempt[msgType] = true
+	}
+	return l
+}
+
+// Allow checks a message from a session and returns the penalty to apply.
+// Messages dropped while muted count as violations too, so a client that
+// keeps flooding through its mute is disconnected.
+func (l *MessageRateLimiter) Allow(sessionID, msgType string) MessagePenalty {
+	if l.exempt[msgType] {
+		return PenaltyNone
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	session := l.sessions[sessionID]
+	if session == nil {
+		session = &messageSession{limiters: make(map[string]*rate.Limiter)}
+		l.sessions[sessionID] = session
+	}
+
+	now := time.Now()
+	bucket := l.bucketFor(msgType)
+	muted := now.Before(session.mutedUntil) && !l.muteExempt[msgType]
+	if !muted && l.limiterFor(session, bucket).AllowN(now, 1) {
+		return PenaltyNone
+	}
+
+	// Record the violation and escalate based on recent history
+	cutoff := now.Add(-l.config.ViolationWindow)
+	recent := session.violations[:0]
+	for _, t := range session.violations {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	session.violations = append(recent, now)
+	l.byType[bucket]++
+
+	penalty := PenaltyThrottle
+	switch count := len(session.violations); {
+	case l.config.DisconnectAfter > 0 && count >= l.config.DisconnectAfter:
+		penalty = PenaltyDisconnect
+	case muted:
+		penalty = PenaltyMute
+	case l.config.MuteAfter > 0 && count >= l.config.MuteAfter:
+		penalty = PenaltyMute
+		session.mutedUntil = now.Add(l.config.MuteDuration)
+	}
+	l.penalties[penalty]++
+	return penalty
+}
+
+// EndSession forgets a session's buckets. A session with recent violations
+// or a running mute is kept until they lapse, checked whenever a session ends.
+func (l *MessageRateLimiter) EndSession(sessionID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.ended[sessionID] = true
+	for id := range l.ended {
+		if session := l.sessions[id]; session == nil || !l.penalizedLocked(session, now) {
+			delete(l.sessions, id)
+			delete(l.ended, id)
+		}
+	}
+}
+
+// Transfer moves a session's buckets and penalties to another key, such as
+// an anonymous device upgrading to an account, so upgrading does not clear
+// a mute. If both are tracked, their violations and mutes are combined.
+func (l *MessageRateLimiter) Transfer(from, to string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if from == to {
+		return
+	}
+	session := l.sessions[from]
+	if session == nil {
+		return
+	}
+	ended := l.ended[from]
+	delete(l.sessions, from)
+	delete(l.ended, from)
+
+	existing := l.sessions[to]
+	if existing == nil {
+		l.sessions[to] = session
+		if ended {
+			l.ended[to] = true
+		}
+		return
+	}
+	existing.violations = append(existing.violations, session.violations...)
+	if session.mutedUntil.After(existing.mutedUntil) {
+		existing.mutedUntil = session.mutedUntil
+	}
+}
+
+// penalizedLocked reports whether a session is muted or has violations within
+// the window. Caller must hold the lock.
+func (l *MessageRateLimiter) penalizedLocked(session *messageSession, now time.Time) bool {
+	if now.Before(session.mutedUntil) {
+		return true
+	}
+	cutoff := now.Add(-l.config.ViolationWindow)
+	for _, t := range session.violations {
+		if t.After(cutoff) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetStats returns penalty counters
+func (l *MessageRateLimiter) GetStats() map[string]interface{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	violationsByType := make(map[string]int, len(l.byType))
+	for msgType, count := range l.byType {
+		violationsByType[msgType] = count
+	}
+
+	return map[string]interface{}{
+		"tracked_sessions":   len(l.sessions),
+		"throttled":          l.penalties[PenaltyThrottle],
+		"muted":              l.penalties[PenaltyMute],
+		"disconnected":       l.penalties[PenaltyDisconnect],
+		"violations_by_type": violationsByType,
+	}
+}
+
+// bucketFor returns the bucket a message type is limited by
+func (l *MessageRateLimiter) bucketFor(msgType string) string {
+	if _, ok := l.config.Rules[msgType]; ok {
+		return msgType
+	}
+	return unlistedBucket
+}
+
+// limiterFor gets or creates a session's bucket. Caller must hold the lock.
+func (l *MessageRateLimiter) limiterFor(session *messageSession, bucket string) *rate.Limiter {
+	limiter, exists := session.limiters[bucket]
+	if !exists {
+		rule, ok := l.config.Rules[bucket]
+		if !ok {
+			rule = l.config.Default
+		}
+		limiter = rate.NewLimiter(rate.Limit(rule.PerSecond), rule.Burst)
+		session.limiters[bucket] = limiter
+	}
+	return limiter
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"voice-chat-app/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageRateLimiter_PerTypeBuckets(t *testing.T) {
+	limiter := NewMessageRateLimiter(DefaultMessageRateLimiterConfig(100))
+
+	// ICE candidates are allowed to burst
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, PenaltyNone, limiter.Allow("user-1", models.MessageTypeICECandidate))
+	}
+
+	// find_match is strict and independent of other types
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, PenaltyNone, limiter.Allow("user-1", models.MessageTypeFindMatch))
+	}
+	assert.Equal(t, PenaltyThrottle, limiter.Allow("user-1", models.MessageTypeFindMatch))
+
+	// Other sessions have their own buckets, and pongs are never limited
+	assert.Equal(t, PenaltyNone, limiter.Allow("user-2", models.MessageTypeFindMatch))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, PenaltyNone, limiter.Allow("user-1", models.MessageTypePong))
+	}
+}
+
+func TestMessageRateLimiter_Escalation(t *testing.T) {
+	limiter := NewMessageRateLimiter(MessageRateLimiterConfig{
+		Default:         MessageRateRule{PerSecond: 0.001, Burst: 1},
+		ViolationWindow: 50 * time.Millisecond,
+		MuteAfter:       2,
+		MuteDuration:    50 * time.Millisecond,
+		DisconnectAfter: 3,
+	})
+
+	assert.Equal(t, PenaltyNone, limiter.Allow("user-1", "chat"))
+	assert.Equal(t, PenaltyThrottle, limiter.Allow("user-1", "chat"))
+	assert.Equal(t, PenaltyMute, limiter.Allow("user-1", "chat"))
+
+	// Messages dropped while muted keep escalating
+	assert.Equal(t, PenaltyDisconnect, limiter.Allow("user-1", "chat"))
+
+	stats := limiter.GetStats()
+	assert.Equal(t, 1, stats["throttled"])
+	assert.Equal(t, 1, stats["muted"])
+	assert.Equal(t, 1, stats["disconnected"])
+	assert.Equal(t, map[string]int{"unlisted": 3}, stats["violations_by_type"])
+
+	// Reconnecting does not clear the penalties
+	limiter.EndSession("user-1")
+	assert.Equal(t, PenaltyDisconnect, limiter.Allow("user-1", "chat"))
+
+	// Once the mute and violations lapse, ended sessions are forgotten
+	limiter.Allow("user-2", "chat")
+	limiter.Allow("user-2", "chat")
+	assert.Equal(t, PenaltyMute, limiter.Allow("user-2", "chat"))
+	time.Sleep(60 * time.Millisecond)
+	limiter.EndSession("user-2")
+	assert.NotContains(t, limiter.sessions, "user-1")
+	assert.Equal(t, PenaltyNone, limiter.Allow("user-2", "chat"))
+}
+
+func TestMessageRateLimiter_TransferKeepsMute(t *testing.T) {
+	limiter := NewMessageRateLimiter(MessageRateLimiterConfig{
+		Default:      MessageRateRule{PerSecond: 0.001, Burst: 1},
+		MuteAfter:    2,
+		MuteDuration: time.Minute,
+	})
+
+	limiter.Allow("device-1", "chat")
+	limiter.Allow("device-1", "chat")
+	assert.Equal(t, PenaltyMute, limiter.Allow("device-1", "chat"))
+
+	// Upgrading to an account does not clear the mute
+	limiter.Transfer("device-1", "account-1")
+	assert.NotContains(t, limiter.sessions, "device-1")
+	assert.Equal(t, PenaltyMute, limiter.Allow("account-1", "chat"))
+}
+
+func TestMessageRateLimiter_UnlistedTypesShareABucket(t *testing.T) {
+	limiter := NewMessageRateLimiter(MessageRateLimiterConfig{
+		Default: MessageRateRule{PerSecond: 0.001, Burst: 2},
+	})
+
+	// Made-up types cannot each get a fresh bucket
+	assert.Equal(t, PenaltyNone, limiter.Allow("user-1", "made_up_1"))
+	assert.Equal(t, PenaltyNone, limiter.Allow("user-1", "made_up_2"))
+	assert.Equal(t, PenaltyThrottle, limiter.Allow("user-1", "made_up_3"))
+	assert.Len(t, limiter.sessions["user-1"].limiters, 1)
+}
+
+func TestMessageRateLimiter_MuteAllowsHangingUp(t *testing.T) {
+	config := DefaultMessageRateLimiterConfig(100)
+	config.MuteAfter, config.MuteDuration, config.DisconnectAfter = 1, time.Minute, 0
+	limiter := NewMessageRateLimiter(config)
+
+	for limiter.Allow("user-1", models.MessageTypeFindMatch) == PenaltyNone {
+	}
+	assert.Equal(t, PenaltyMute, limiter.Allow("user-1", models.MessageTypeOffer))
+	assert.Equal(t, PenaltyNone, limiter.Allow("user-1", models.MessageTypeCallEnd))
+	assert.Equal(t, PenaltyNone, limiter.Allow("user-1", models.MessageTypeLogout))
+}
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"voice-chat-app/utils"
+)
+
+// maxTrackedRejectedOrigins caps the per-origin rejection counters so a
+// flood of random origins cannot grow them without bound
+const maxTrackedRejectedOrigins = 100
+
+// OriginPolicy decides which origins may call the HTTP API and open
+// WebSocket connections. It is shared by the CORS middleware and the
+// WebSocket upgrader so both enforce the same rules.
+type OriginPolicy struct {
+	// AllowedOrigins lists exact origins, "*" or wildcard subdomains such as
+	// "https://*.example.com" or "*.example.com"
+	AllowedOrigins []string
+
+	// AllowMissingOrigin admits requests without an Origin header. Native
+	// apps (React Native) do not send one; browsers always do.
+	AllowMissingOrigin bool
+
+	// NativeAppOrigins lists custom origins sent by native app shells, such
+	// as "capacitor://localhost" or "app://voice-chat". Entries ending in
+	// "://" allow any origin with that scheme.
+	NativeAppOrigins []string
+
+	rejectedTotal    int
+	rejectedMissing  int
+	rejectedByOrigin map[string]int
+	mutex            sync.Mutex
+}
+
+// NewOriginPolicy creates an origin policy
+func NewOriginPolicy(allowedOrigins, nativeAppOrigins []string, allowMissingOrigin bool) *OriginPolicy {
+	return &OriginPolicy{
+		AllowedOrigins:     allowedOrigins,
+		AllowMissingOrigin: allowMissingOrigin,
+		NativeAppOrigins:   nativeAppOrigins,
+		rejectedByOrigin:   make(map[string]int),
+	}
+}
+
+// IsAllowed reports whether a non-empty origin is permitted
+func (p *OriginPolicy) IsAllowed(origin string) bool {
+	if origin == "" || hasDangerousScheme(origin) {
+		return false
+	}
+
+	for _, native := range p.NativeAppOrigins {
+		if origin == native || (strings.HasSuffix(native, "://") && strings.HasPrefix(origin, native)) {
+			return true
+		}
+	}
+
+	lowerOrigin := strings.ToLower(origin)
+	if !strings.HasPrefix(lowerOrigin, "http://") && !strings.HasPrefix(lowerOrigin, "https://") {
+		return false
+	}
+
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if matchesWildcardOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRequest applies the policy to a request, recording and logging any
+// rejection. It is suitable for websocket.Upgrader.CheckOrigin.
+func (p *OriginPolicy) CheckRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" {
+		if p.AllowMissingOrigin {
+			return true
+		}
+		p.recordRejection(origin)
+		utils.Warn(r.Context(), "Rejected request without Origin header", map[string]interface{}{
+			"path":       r.URL.Path,
+			"user_agent": r.Header.Get("User-Agent"),
+		})
+		return false
+	}
+
+	if p.IsAllowed(origin) {
+		return true
+	}
+
+	p.recordRejection(origin)
+	utils.Warn(r.Context(), "Rejected request from disallowed origin", map[string]interface{}{
+		"origin": origin,
+		"path":   r.URL.Path,
+	})
+	return false
+}
+
+// GetStats returns rejection counters
+func (p *OriginPolicy) GetStats() map[string]interface{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	byOrigin := make(map[string]int, len(p.rejectedByOrigin))
+	for origin, count := range p.rejectedByOrigin {
+		byOrigin[origin] = count
+	}
+
+	return map[string]interface{}{
+		"rejected_total":          p.rejectedTotal,
+		"rejected_missing_origin": p.rejectedMissing,
+		"rejected_by_origin":      byOrigin,
+		"allow_missing_origin":    p.AllowMissingOrigin,
+	}
+}
+
+func (p *OriginPolicy) recordRejection(origin string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.rejectedTotal++
+	if origin == "" {
+		p.rejectedMissing++
+		return
+	}
+	if _, tracked := p.rejectedByOrigin[origin]; tracked || len(p.rejectedByOrigin) < maxTrackedRejectedOrigins {
+		p.rejectedByOrigin[origin]++
+	} else {
+		p.rejectedByOrigin["other"]++
+	}
+}
+
+// matchesWildcardOrigin matches "*.example.com" or "https://*.example.com"
+// against an origin's host (and scheme, when the pattern has one)
+func matchesWildcardOrigin(pattern, origin string) bool {
+	scheme := ""
+	hostPattern := pattern
+	if idx := strings.Index(pattern, "://"); idx != -1 {
+		scheme = strings.ToLower(pattern[:idx])
+		hostPattern = pattern[idx+3:]
+	}
+	if !strings.HasPrefix(hostPattern, "*.") {
+		return false
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	if scheme != "" && !strings.EqualFold(parsed.Scheme, scheme) {
+		return false
+	}
+
+	domain := strings.ToLower(hostPattern[2:])
+	host := strings.ToLower(parsed.Hostname())
+	return strings.HasSuffix(host, "."+domain) || host == domain
+}
+
+// hasDangerousScheme rejects script and local schemes outright
+func hasDangerousScheme(origin string) bool {
+	dangerousPatterns := []string{
+		"javascript:",
+		"data:",
+		"vbscript:",
+		"file:",
+		"about:",
+	}
+
+	lowerOrigin := strings.ToLower(origin)
+	for _, pattern := range dangerousPatterns {
+		if strings.HasPrefix(lowerOrigin, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOriginPolicy_IsAllowed(t *testing.T) {
+	policy := NewOriginPolicy(
+		[]string{"https://app.example.com", "https://*.voice.example.com"},
+		[]string{"capacitor://localhost", "voicechat://"},
+		false,
+	)
+
+	tests := []struct {
+		origin   string
+		expected bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://eu.voice.example.com", true},
+		{"http://eu.voice.example.com", false},
+		{"https://evilvoice.example.com", false},
+		{"https://app.example.com.evil.org", false},
+		{"capacitor://localhost", true},
+		{"voicechat://anything", true},
+		{"ionic://localhost", false},
+		{"javascript:alert(1)", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			assert.Equal(t, tt.expected, policy.IsAllowed(tt.origin))
+		})
+	}
+}
+
+func TestOriginPolicy_CheckRequestRecordsRejections(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://app.example.com"}, nil, false)
+
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	assert.True(t, policy.CheckRequest(request("https://app.example.com")))
+	assert.False(t, policy.CheckRequest(request("")))
+	assert.False(t, policy.CheckRequest(request("https://evil.example.org")))
+	assert.False(t, policy.CheckRequest(request("https://evil.example.org")))
+
+	stats := policy.GetStats()
+	assert.Equal(t, 3, stats["rejected_total"])
+	assert.Equal(t, 1, stats["rejected_missing_origin"])
+	assert.Equal(t, map[string]int{"https://evil.example.org": 2}, stats["rejected_by_origin"])
+
+	policy.AllowMissingOrigin = true
+	assert.True(t, policy.CheckRequest(request("")))
+}
+
+func TestCORS_UsesOriginPolicy(t *testing.T) {
+	cors := NewCORSConfig(NewOriginPolicy([]string{"https://app.example.com"}, nil, true))
+	handler := cors.CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for origin, expected := range map[string]string{
+		"https://app.example.com":  "https://app.example.com",
+		"https://evil.example.org": "",
+		"":                         "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, expected, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+}
@@ -2,12 +2,12 @@ package middleware
 
 import (
 	"fmt"
-	"net"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
+	"voice-chat-app/utils"
+
 	"golang.org/x/time/rate"
 )
 
@@ -23,6 +23,10 @@ type RateLimiter struct {
 	wsBurst         int
 	maxWSConnPerIP  int
 	cleanupInterval time.Duration
+
+	// Connection attempts per second over the last minute, used to gauge load
+	attemptBuckets [60]int
+	attemptSeconds [60]int64
 }
 
 // NewRateLimiter creates a new rate limiter with specified rates
@@ -48,7 +52,7 @@ func NewRateLimiter(httpRequestsPerMinute, wsRequestsPerMinute, maxWSConnPerIP i
 // HTTPRateLimit middleware for HTTP requests
 func (rl *RateLimiter) HTTPRateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getClientIP(r)
+		ip := utils.ClientIP(r)
 
 		if !rl.allowHTTPRequest(ip) {
 			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%.0f", float64(rl.httpRate*60)))
@@ -100,6 +104,41 @@ func (rl *RateLimiter) ReleaseWebSocketConnection(ip string) {
 	}
 }
 
+// RecordConnectionAttempt counts a WebSocket connection attempt towards the
+// current connection rate
+func (rl *RateLimiter) RecordConnectionAttempt() {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := time.Now().Unix()
+	idx := now % int64(len(rl.attemptBuckets))
+	if rl.attemptSeconds[idx] != now {
+		rl.attemptSeconds[idx] = now
+		rl.attemptBuckets[idx] = 0
+	}
+	rl.attemptBuckets[idx]++
+}
+
+// ConnectionRate returns the number of connection attempts seen in the last minute
+func (rl *RateLimiter) ConnectionRate() int {
+	rl.mutex.RLock()
+	defer rl.mutex.RUnlock()
+
+	return rl.connectionRateLocked()
+}
+
+// connectionRateLocked sums recent connection attempts. Caller must hold the lock.
+func (rl *RateLimiter) connectionRateLocked() int {
+	now := time.Now().Unix()
+	total := 0
+	for i, second := range rl.attemptSeconds {
+		if now-second < int64(len(rl.attemptBuckets)) {
+			total += rl.attemptBuckets[i]
+		}
+	}
+	return total
+}
+
 // allowHTTPRequest checks if an HTTP request should be allowed
 func (rl *RateLimiter) allowHTTPRequest(ip string) bool {
 	limiter := rl.getHTTPLimiter(ip)
@@ -167,46 +206,6 @@ func (rl *RateLimiter) cleanupExpiredLimiters() {
 	}
 }
 
-// getClientIP extracts the real client IP from the request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header (for reverse proxies)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP from the list
-		if ip := parseFirstIP(xff); ip != "" {
-			return ip
-		}
-	}
-
-	// Check X-Real-IP header (for nginx)
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		if ip := net.ParseIP(xri); ip != nil {
-			return xri
-		}
-	}
-
-	// Fall back to remote address
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return host
-}
-
-// parseFirstIP parses the first valid IP from a comma-separated list
-func parseFirstIP(ips string) string {
-	for _, ip := range []string{ips} {
-		// Split by comma and take first
-		if idx := strings.Index(ip, ","); idx != -1 {
-			ip = ip[:idx]
-		}
-		ip = strings.TrimSpace(ip)
-		if net.ParseIP(ip) != nil {
-			return ip
-		}
-	}
-	return ""
-}
-
 // GetStats returns current rate limiting statistics
 func (rl *RateLimiter) GetStats() map[string]interface{} {
 	rl.mutex.RLock()
@@ -226,6 +225,7 @@ func (rl *RateLimiter) GetStats() map[string]interface{} {
 		"http_rate_per_minute":           float64(rl.httpRate * 60),
 		"ws_rate_per_minute":             float64(rl.wsRate * 60),
 		"max_ws_connections_per_ip":      rl.maxWSConnPerIP,
+		"connection_attempts_per_minute": rl.connectionRateLocked(),
 	}
 
 	return stats
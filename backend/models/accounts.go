@@ -0,0 +1,459 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account errors
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAccountLocked      = errors.New("account is temporarily locked")
+	ErrUsernameTaken      = errors.New("username is already taken")
+	ErrInvalidUsername    = errors.New("username must be 3-32 letters, digits, '.', '_' or '-'")
+	ErrInvalidPassword    = errors.New("password must be 8-72 bytes long")
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrEmailTaken         = errors.New("email address belongs to another account")
+	ErrEmailChanged       = errors.New("account email address has changed")
+	ErrAccountNotFound    = errors.New("account not found")
+	ErrExternalAccount    = errors.New("account is managed by an identity provider")
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{3,32}$`)
+
+// Account is a registered user. Anonymous sessions have no account.
+type Account struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	PasswordHash  []byte    `json:"-"` // empty for accounts signed in through an identity provider
+	ExternalID    string    `json:"-"` // issuer and subject of an identity provider login
+	Email         string    `json:"email,omitempty"`
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// accountRecord is one line of an account file. It carries the fields that
+// are never sent to clients.
+type accountRecord struct {
+	Account
+	PasswordHash []byte `json:"password_hash,omitempty"`
+	ExternalID   string `json:"external_id,omitempty"`
+}
+
+// loginFailures tracks failed logins for one username from one client IP
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// AccountStore holds registered accounts and enforces login lockout. A
+// store opened on a file appends every change to it, so accounts survive
+// restarts; lockouts are kept in memory only.
+type AccountStore struct {
+	accounts   map[string]*Account // username (lower case) -> account
+	byID       map[string]*Account
+	external   map[string]*Account       // external ID -> account
+	emails     map[string]*Account       // verified email -> account
+	failures   map[string]*loginFailures // username (lower case) and client IP -> failures
+	journal    *journal
+	cost       int
+	dummyHash  []byte // compared against for unknown usernames so timing does not reveal them
+	maxRetries int
+	lockout    time.Duration
+	lockouts   int
+	mutex      sync.Mutex
+}
+
+// NewAccountStore creates an empty account store. cost is the bcrypt work
+// factor; zero selects bcrypt.DefaultCost.
+func NewAccountStore(cost int) *AccountStore {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("dummy-password"), cost)
+
+	return &AccountStore{
+		accounts:   make(map[string]*Account),
+		byID:       make(map[string]*Account),
+		external:   make(map[string]*Account),
+		emails:     make(map[string]*Account),
+		failures:   make(map[string]*loginFailures),
+		cost:       cost,
+		dummyHash:  dummyHash,
+		maxRetries: MaxRetryAttempts,
+		lockout:    LockoutDuration,
+	}
+}
+
+// OpenAccountStore opens (or creates) an account store persisted to path
+func OpenAccountStore(path string, cost int) (*AccountStore, error) {
+	s := NewAccountStore(cost)
+
+	journal, err := openJournal(path, func(line []byte) error {
+		var record accountRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.ID == "" {
+			return ErrJournalMalformed
+		}
+		account := record.Account
+		account.PasswordHash = record.PasswordHash
+		account.ExternalID = record.ExternalID
+		s.byID[account.ID] = &account
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+
+	// Later records replace earlier ones, so index only the final state
+	for _, account := range s.byID {
+		if account.ExternalID != "" {
+			s.external[account.ExternalID] = account
+		} else {
+			s.accounts[strings.ToLower(account.Username)] = account
+		}
+		if account.EmailVerified {
+			s.emails[account.Email] = account
+		}
+	}
+	return s, nil
+}
+
+// Close closes the store's file, if any
+func (s *AccountStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.journal.close()
+}
+
+// Create registers a new account
+func (s *AccountStore) Create(username, password string) (*Account, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if err := ValidatePassword(password); err != nil {
+		return nil, err
+	}
+
+	// Hash outside the lock; bcrypt is deliberately slow
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := strings.ToLower(username)
+	if _, exists := s.accounts[key]; exists {
+		return nil, ErrUsernameTaken
+	}
+
+	account := &Account{
+		ID:           uuid.New().String(),
+		Username:     username,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.saveLocked(account); err != nil {
+		return nil, err
+	}
+	s.accounts[key] = account
+	s.byID[account.ID] = account
+	return account, nil
+}
+
+// Authenticate checks a username and password. After MaxRetryAttempts
+// consecutive failures from one client IP, logins to that username from
+// that IP are locked for LockoutDuration, during which even the correct
+// password is refused. Other clients can still log in, so a guesser cannot
+// lock the owner out. Unknown usernames are locked the same way so that
+// lockouts do not reveal which usernames exist.
+func (s *AccountStore) Authenticate(username, password, clientIP string) (*Account, error) {
+	key := strings.ToLower(username) + "|" + clientIP
+
+	s.mutex.Lock()
+	if s.lockedLocked(key, time.Now()) {
+		s.mutex.Unlock()
+		return nil, ErrAccountLocked
+	}
+	account := s.accounts[strings.ToLower(username)]
+	hash := s.dummyHash
+	if account != nil {
+		hash = account.PasswordHash
+	}
+	s.mutex.Unlock()
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Another attempt may have locked the login while we were hashing
+	now := time.Now()
+	if s.lockedLocked(key, now) {
+		return nil, ErrAccountLocked
+	}
+	if account == nil || err != nil {
+		s.recordFailureLocked(key, now)
+		return nil, ErrInvalidCredentials
+	}
+
+	delete(s.failures, key)
+	return account, nil
+}
+
+// lockedLocked reports whether logins are locked for a username and client
+// IP. Caller must hold the lock.
+func (s *AccountStore) lockedLocked(key string, now time.Time) bool {
+	failures := s.failures[key]
+	return failures != nil && now.Before(failures.lockedUntil)
+}
+
+// recordFailureLocked counts a failed login, locking the username for the
+// client once it reaches maxRetries. Entries idle for longer than a lockout
+// are dropped when the map is full. Caller must hold the lock.
+func (s *AccountStore) recordFailureLocked(key string, now time.Time) {
+	if len(s.failures) >= MaxTrackedLoginFailures {
+		for other, failures := range s.failures {
+			if now.Sub(failures.lastFailure) > s.lockout && !now.Before(failures.lockedUntil) {
+				delete(s.failures, other)
+			}
+		}
+	}
+
+	failures := s.failures[key]
+	if failures == nil {
+		failures = &loginFailures{}
+		s.failures[key] = failures
+	}
+	failures.count++
+	failures.lastFailure = now
+	if failures.count >= s.maxRetries {
+		failures.count = 0
+		failures.lockedUntil = now.Add(s.lockout)
+		s.lockouts++
+	}
+}
+
+// FindOrCreateExternal returns the account for an identity provider login,
+// creating it on first sign-in. External accounts have no password and are
+// not reachable through Authenticate.
+func (s *AccountStore) FindOrCreateExternal(issuer, subject, displayName string) (*Account, error) {
+	externalID := issuer + "|" + subject
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if account := s.external[externalID]; account != nil {
+		return account, nil
+	}
+
+	account := &Account{
+		ID:         uuid.New().String(),
+		Username:   displayName,
+		ExternalID: externalID,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.saveLocked(account); err != nil {
+		return nil, err
+	}
+	s.external[externalID] = account
+	s.byID[account.ID] = account
+	return account, nil
+}
+
+// SetEmail sets an account's email address. A new address is unverified
+// until VerifyEmail confirms it; setting the current address is a no-op.
+func (s *AccountStore) SetEmail(accountID, email string) (*Account, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	account := s.byID[accountID]
+	switch {
+	case account == nil:
+		return nil, ErrAccountNotFound
+	case account.ExternalID != "":
+		return nil, ErrExternalAccount
+	case account.Email == email:
+		return account, nil
+	case s.emails[email] != nil:
+		return nil, ErrEmailTaken
+	}
+
+	updated := *account
+	updated.Email = email
+	updated.EmailVerified = false
+	if err := s.saveLocked(&updated); err != nil {
+		return nil, err
+	}
+
+	if account.EmailVerified {
+		delete(s.emails, account.Email)
+	}
+	*account = updated
+	return account, nil
+}
+
+// VerifyEmail marks an account's email address as verified. The address
+// must still be the one the verification was sent to.
+func (s *AccountStore) VerifyEmail(accountID, email string) (*Account, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	account := s.byID[accountID]
+	switch {
+	case account == nil:
+		return nil, ErrAccountNotFound
+	case account.Email != email:
+		return nil, ErrEmailChanged
+	case s.emails[email] != nil && s.emails[email] != account:
+		return nil, ErrEmailTaken
+	}
+
+	updated := *account
+	updated.EmailVerified = true
+	if err := s.saveLocked(&updated); err != nil {
+		return nil, err
+	}
+
+	*account = updated
+	s.emails[email] = account
+	return account, nil
+}
+
+// FindByVerifiedEmail returns the account with a verified email address
+func (s *AccountStore) FindByVerifiedEmail(email string) *Account {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.emails[email]
+}
+
+// ResetPassword replaces an account's password and lifts any lockout. The
+// reset must have been sent to the account's current verified address.
+func (s *AccountStore) ResetPassword(accountID, email, password string) (*Account, error) {
+	if err := ValidatePassword(password); err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	account := s.byID[accountID]
+	switch {
+	case account == nil:
+		return nil, ErrAccountNotFound
+	case account.ExternalID != "":
+		return nil, ErrExternalAccount
+	case account.Email != email || !account.EmailVerified:
+		return nil, ErrEmailChanged
+	}
+
+	updated := *account
+	updated.PasswordHash = hash
+	if err := s.saveLocked(&updated); err != nil {
+		return nil, err
+	}
+
+	*account = updated
+	prefix := strings.ToLower(account.Username) + "|"
+	for key := range s.failures {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.failures, key)
+		}
+	}
+	return account, nil
+}
+
+// Get returns an account by ID
+func (s *AccountStore) Get(accountID string) *Account {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.byID[accountID]
+}
+
+// GetStats returns account counters
+func (s *AccountStore) GetStats() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	locked := 0
+	now := time.Now()
+	for _, failures := range s.failures {
+		if now.Before(failures.lockedUntil) {
+			locked++
+		}
+	}
+
+	return map[string]interface{}{
+		"accounts":        len(s.byID),
+		"external":        len(s.external),
+		"verified_emails": len(s.emails),
+		"locked_logins":   locked,
+		"lockouts":        s.lockouts,
+	}
+}
+
+// saveLocked appends an account's new state to the store's file, compacting
+// the file once superseded records dominate it. Caller must hold the lock.
+func (s *AccountStore) saveLocked(account *Account) error {
+	if err := s.journal.append(accountRecord{Account: *account, PasswordHash: account.PasswordHash, ExternalID: account.ExternalID}); err != nil {
+		return err
+	}
+	if !s.journal.needsCompaction(len(s.byID)) {
+		return nil
+	}
+
+	records := make([]interface{}, 0, len(s.byID)+1)
+	for _, existing := range s.byID {
+		if existing.ID != account.ID {
+			records = append(records, accountRecord{Account: *existing, PasswordHash: existing.PasswordHash, ExternalID: existing.ExternalID})
+		}
+	}
+	records = append(records, accountRecord{Account: *account, PasswordHash: account.PasswordHash, ExternalID: account.ExternalID})
+	return s.journal.compact(records)
+}
+
+// ValidatePassword checks a password against the length limits
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// NormalizeEmail validates a bare email address and lower-cases it
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if len(email) > MaxEmailLength {
+		return "", ErrInvalidEmail
+	}
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Address != email || parsed.Name != "" {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(email), nil
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccountStore_Create(t *testing.T) {
+	store := NewAccountStore(bcrypt.MinCost)
+
+	account, err := store.Create("Alice", "correct horse")
+	require.NoError(t, err)
+	assert.NotEmpty(t, account.ID)
+	assert.NotContains(t, string(account.PasswordHash), "correct horse")
+	assert.Same(t, account, store.Get(account.ID))
+
+	// Usernames are unique regardless of case
+	_, err = store.Create("alice", "another password")
+	assert.Equal(t, ErrUsernameTaken, err)
+
+	_, err = store.Create("a", "long enough")
+	assert.Equal(t, ErrInvalidUsername, err)
+	_, err = store.Create("bob smith", "long enough")
+	assert.Equal(t, ErrInvalidUsername, err)
+	_, err = store.Create("bob", "short")
+	assert.Equal(t, ErrInvalidPassword, err)
+	_, err = store.Create("bob", strings.Repeat("x", MaxPasswordLength+1))
+	assert.Equal(t, ErrInvalidPassword, err)
+}
+
+func TestAccountStore_Authenticate(t *testing.T) {
+	store := NewAccountStore(bcrypt.MinCost)
+	created, err := store.Create("alice", "correct horse")
+	require.NoError(t, err)
+
+	account, err := store.Authenticate("ALICE", "correct horse", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, account.ID)
+
+	_, err = store.Authenticate("alice", "wrong password", "10.0.0.1")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	// Unknown usernames are indistinguishable from wrong passwords
+	_, err = store.Authenticate("nobody", "whatever1", "10.0.0.1")
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func TestAccountStore_Lockout(t *testing.T) {
+	store := NewAccountStore(bcrypt.MinCost)
+	store.lockout = 50 * time.Millisecond
+	_, err := store.Create("alice", "correct horse")
+	require.NoError(t, err)
+
+	// A success resets the failure count
+	_, err = store.Authenticate("alice", "wrong password", "10.0.0.1")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = store.Authenticate("alice", "correct horse", "10.0.0.1")
+	require.NoError(t, err)
+
+	for i := 0; i < MaxRetryAttempts; i++ {
+		_, err = store.Authenticate("alice", "wrong password", "10.0.0.1")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+
+	// A locked login refuses even the right password, but only from the
+	// client that kept failing
+	_, err = store.Authenticate("alice", "correct horse", "10.0.0.1")
+	assert.Equal(t, ErrAccountLocked, err)
+	_, err = store.Authenticate("alice", "correct horse", "10.0.0.2")
+	assert.NoError(t, err)
+	stats := store.GetStats()
+	assert.Equal(t, 1, stats["locked_logins"])
+	assert.Equal(t, 1, stats["lockouts"])
+
+	// Unknown usernames lock the same way
+	for i := 0; i < MaxRetryAttempts; i++ {
+		store.Authenticate("nobody", "whatever1", "10.0.0.1")
+	}
+	_, err = store.Authenticate("nobody", "whatever1", "10.0.0.1")
+	assert.Equal(t, ErrAccountLocked, err)
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = store.Authenticate("alice", "correct horse", "10.0.0.1")
+	assert.NoError(t, err)
+}
+
+func TestAccountStore_EmailVerification(t *testing.T) {
+	store := NewAccountStore(bcrypt.MinCost)
+	alice, err := store.Create("alice", "correct horse")
+	require.NoError(t, err)
+	bob, err := store.Create("bob", "battery staple")
+	require.NoError(t, err)
+
+	_, err = store.SetEmail(alice.ID, "not an address")
+	assert.Equal(t, ErrInvalidEmail, err)
+	_, err = store.SetEmail(alice.ID, "Alice <alice@example.com>")
+	assert.Equal(t, ErrInvalidEmail, err)
+
+	account, err := store.SetEmail(alice.ID, "Alice@Example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", account.Email)
+	assert.False(t, account.EmailVerified)
+	assert.Nil(t, store.FindByVerifiedEmail("alice@example.com"))
+
+	// Verification is bound to the address it was sent to
+	_, err = store.VerifyEmail(alice.ID, "old@example.com")
+	assert.Equal(t, ErrEmailChanged, err)
+	_, err = store.VerifyEmail(alice.ID, "alice@example.com")
+	require.NoError(t, err)
+	assert.Same(t, alice, store.FindByVerifiedEmail("ALICE@example.com"))
+
+	// A verified address belongs to one account
+	_, err = store.SetEmail(bob.ID, "alice@example.com")
+	assert.Equal(t, ErrEmailTaken, err)
+
+	// Changing the address drops the verification
+	account, err = store.SetEmail(alice.ID, "alice@example.org")
+	require.NoError(t, err)
+	assert.False(t, account.EmailVerified)
+	assert.Nil(t, store.FindByVerifiedEmail("alice@example.com"))
+}
+
+func TestAccountStore_ResetPassword(t *testing.T) {
+	store := NewAccountStore(bcrypt.MinCost)
+	account, err := store.Create("alice", "correct horse")
+	require.NoError(t, err)
+	_, err = store.SetEmail(account.ID, "alice@example.com")
+	require.NoError(t, err)
+
+	// Resets need a verified address
+	_, err = store.ResetPassword(account.ID, "alice@example.com", "new password")
+	assert.Equal(t, ErrEmailChanged, err)
+	_, err = store.VerifyEmail(account.ID, "alice@example.com")
+	require.NoError(t, err)
+
+	for i := 0; i < MaxRetryAttempts; i++ {
+		store.Authenticate("alice", "wrong password", "10.0.0.1")
+	}
+	_, err = store.Authenticate("alice", "correct horse", "10.0.0.1")
+	require.Equal(t, ErrAccountLocked, err)
+
+	_, err = store.ResetPassword(account.ID, "alice@example.com", "short")
+	assert.Equal(t, ErrInvalidPassword, err)
+	_, err = store.ResetPassword(account.ID, "alice@example.com", "new password")
+	require.NoError(t, err)
+
+	// The reset lifts the lockout and replaces the password
+	_, err = store.Authenticate("alice", "correct horse", "10.0.0.1")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = store.Authenticate("alice", "new password", "10.0.0.1")
+	assert.NoError(t, err)
+
+	external, err := store.FindOrCreateExternal("https://idp.example.com", "user-1", "user@example.com")
+	require.NoError(t, err)
+	_, err = store.ResetPassword(external.ID, "", "new password")
+	assert.Equal(t, ErrExternalAccount, err)
+}
+
+func TestAccountStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.jsonl")
+	store, err := OpenAccountStore(path, bcrypt.MinCost)
+	require.NoError(t, err)
+
+	alice, err := store.Create("alice", "correct horse")
+	require.NoError(t, err)
+	_, err = store.SetEmail(alice.ID, "alice@example.com")
+	require.NoError(t, err)
+	_, err = store.VerifyEmail(alice.ID, "alice@example.com")
+	require.NoError(t, err)
+	_, err = store.ResetPassword(alice.ID, "alice@example.com", "new password")
+	require.NoError(t, err)
+	external, err := store.FindOrCreateExternal("https://idp.example.com", "user-1", "User")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "new password")
+
+	reopened, err := OpenAccountStore(path, bcrypt.MinCost)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	account, err := reopened.Authenticate("alice", "new password", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, account.ID)
+	assert.Equal(t, alice.ID, reopened.FindByVerifiedEmail("alice@example.com").ID)
+	_, err = reopened.Create("Alice", "another password")
+	assert.Equal(t, ErrUsernameTaken, err)
+
+	again, err := reopened.FindOrCreateExternal("https://idp.example.com", "user-1", "User")
+	require.NoError(t, err)
+	assert.Equal(t, external.ID, again.ID)
+	assert.Equal(t, 2, reopened.GetStats()["accounts"])
+}
+
+func TestAccountStore_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"username\":\"no id\"}\n"), 0o600))
+
+	_, err := OpenAccountStore(path, bcrypt.MinCost)
+	assert.ErrorIs(t, err, ErrJournalMalformed)
+}
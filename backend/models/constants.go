@@ -10,6 +10,14 @@ const (
 	StatusMatched      = "matched"
 )
 
+// Presence states shown to friends
+const (
+	PresenceOffline = "offline"
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceInCall  = "in_call"
+)
+
 // WebSocket message types
 const (
 	MessageTypeFindMatch     = "find_match"
@@ -28,6 +36,61 @@ const (
 	MessageTypeUserMatched   = "user_matched"
 	MessageTypeUserLeft      = "user_left"
 	MessageTypeError         = "error"
+	MessageTypeKicked        = "kicked"
+	MessageTypeRoomEnded     = "room_ended"
+
+	MessageTypeChallenge         = "challenge"
+	MessageTypeChallengeResponse = "challenge_response"
+	MessageTypeChallengePassed   = "challenge_passed"
+
+	MessageTypeDeviceToken = "device_token"
+
+	MessageTypeTokenRefresh   = "token_refresh"
+	MessageTypeTokenRefreshed = "token_refreshed"
+	MessageTypeTokenExpiring  = "token_expiring"
+	MessageTypeLogout         = "logout"
+	MessageTypeLoggedOut      = "logged_out"
+	MessageTypeSessionRevoked = "session_revoked"
+
+	MessageTypeServerBusy = "server_busy"
+	MessageTypeAdmitted   = "admitted"
+
+	MessageTypeAddFriend    = "add_friend"
+	MessageTypeFriendAdded  = "friend_added"
+	MessageTypeGetFriends   = "get_friends"
+	MessageTypeFriends      = "friends"
+	MessageTypeCallFriend   = "call_friend"
+	MessageTypeCallIncoming = "call_incoming"
+	MessageTypeMatchFound   = "match_found"
+
+	MessageTypeSubscribePresence   = "subscribe_presence"
+	MessageTypeUnsubscribePresence = "unsubscribe_presence"
+	MessageTypeSetPresence         = "set_presence"
+	MessageTypePresence            = "presence"
+
+	MessageTypeRegisterPush   = "register_push"
+	MessageTypeUnregisterPush = "unregister_push"
+	MessageTypeCallRinging    = "call_ringing"
+	MessageTypeCallMissed     = "call_missed"
+
+	MessageTypeVoiceNote = "voice_note"
+
+	MessageTypeScheduleCall          = "schedule_call"
+	MessageTypeCancelScheduledCall   = "cancel_scheduled_call"
+	MessageTypeGetScheduledCalls     = "get_scheduled_calls"
+	MessageTypeCallScheduled         = "call_scheduled"
+	MessageTypeScheduledCallCanceled = "scheduled_call_cancelled"
+	MessageTypeScheduledCalls        = "scheduled_calls"
+	MessageTypeCallReminder          = "call_reminder"
+
+	MessageTypeShareContact     = "share_contact"
+	MessageTypeWithdrawContact  = "withdraw_contact"
+	MessageTypeContactRequested = "contact_requested"
+	MessageTypeContactRevealed  = "contact_revealed"
+	MessageTypeContactWithdrawn = "contact_withdrawn"
+
+	MessageTypeSetDisplayName = "set_display_name"
+	MessageTypeDisplayNameSet = "display_name_set"
 )
 
 // Call states
@@ -50,6 +113,10 @@ const (
 	ErrorCodeNoPartner      = "NO_PARTNER"
 	ErrorCodeConnectionLost = "CONNECTION_LOST"
 	ErrorCodeInvalidState   = "INVALID_STATE"
+	ErrorCodeForbidden      = "FORBIDDEN"
+	ErrorCodeServerBusy     = "SERVER_BUSY"
+	ErrorCodeConflict       = "CONFLICT"
+	ErrorCodeTooLarge       = "PAYLOAD_TOO_LARGE"
 )
 
 // Timeout constants
@@ -63,6 +130,7 @@ const (
 	IdleTimeout         = 60 * time.Second
 	TokenExpiryDuration = 24 * time.Hour
 	TokenRefreshWindow  = 1 * time.Hour
+	ChallengeTimeout    = 30 * time.Second
 )
 
 // Size limits
@@ -83,6 +151,127 @@ const (
 	DefaultMaxConnections    = 1000
 )
 
+// Per-message-type rate limit escalation defaults
+const (
+	DefaultMessageMuteAfter       = 5 // violations per minute before muting
+	DefaultMessageMuteDuration    = 30 * time.Second
+	DefaultMessageDisconnectAfter = 10 // violations per minute before disconnecting
+)
+
+// ChallengePassTTL is how long a client that solved a proof-of-work
+// challenge may reconnect from the same IP without solving another
+const ChallengePassTTL = 10 * time.Minute
+
+// ConnectTicketTTL is how long a WebSocket connect ticket from POST /session stays valid
+const ConnectTicketTTL = 30 * time.Second
+
+// OIDCLoginTimeout is how long a user has to complete a login at the identity provider
+const OIDCLoginTimeout = 10 * time.Minute
+
+// Device tokens identify an install across sessions. They are renewed on
+// connect once they are within DeviceTokenRenewWindow of expiring. Browsers
+// that send the token as their first frame have DeviceTokenFrameTimeout to do so.
+const (
+	DeviceTokenTTL          = 365 * 24 * time.Hour
+	DeviceTokenRenewWindow  = 30 * 24 * time.Hour
+	DeviceTokenFrameTimeout = 10 * time.Second
+)
+
+// FriendRequestWindow is how long after a call ends add_friend still counts
+const FriendRequestWindow = 2 * time.Minute
+
+// RingingTimeout is how long a friend call rings before it is missed, and
+// how long it waits for an offline friend woken by a push to connect
+const RingingTimeout = 30 * time.Second
+
+// Push platforms a device can register a token for
+const (
+	PushPlatformFCM  = "fcm"
+	PushPlatformAPNs = "apns"
+)
+
+// MaxPushTokenLength bounds registered push tokens
+const MaxPushTokenLength = 4096
+
+// Voice note defaults. Notes are small Opus recordings; download URLs are
+// short-lived and notes are deleted once the retention period ends.
+const (
+	DefaultVoiceNoteMaxBytes     = 1 << 20
+	DefaultVoiceNoteMaxDuration  = time.Minute
+	DefaultVoiceNoteRetention    = 7 * 24 * time.Hour
+	DefaultVoiceNoteURLTTL       = 15 * time.Minute
+	DefaultMaxPendingVoiceNotes  = 50 // per recipient
+	DefaultVoiceNoteMaxPerSender = 10 // of a recipient's pending notes
+)
+
+// MaxContactHandleLength bounds a handle shared with share_contact, in characters
+const MaxContactHandleLength = 64
+
+// Display name limits, in characters. Words in generated names are bounded
+// so that an adjective, an animal and a number still fit.
+const (
+	MinDisplayNameLength = 2
+	MaxDisplayNameLength = 32
+	MaxNameWordLength    = 12
+)
+
+// ReservedNameTerms may not appear in a chosen display name, on top of any
+// configured filter terms, so that users cannot pose as the service
+var ReservedNameTerms = []string{"admin", "moderator", "official", "staff", "support", "system"}
+
+// Scheduled call limits. A call whose start was missed by more than
+// ScheduledCallGrace, e.g. while the server was down, is dropped rather than
+// rung late.
+const (
+	MaxScheduleAhead              = 30 * 24 * time.Hour
+	ScheduledCallGrace            = 5 * time.Minute
+	DefaultMaxScheduledCalls      = 20       // per identity
+	DefaultScheduledCallReminders = "15m,1m" // before the start
+)
+
+// DefaultPresenceCoalesceWindow is how long presence changes are collected
+// before friends are told, so a quick match and hang-up sends nothing
+const DefaultPresenceCoalesceWindow = 2 * time.Second
+
+// Email verification and password reset defaults
+const (
+	EmailVerifyTokenTTL    = 24 * time.Hour
+	PasswordResetTokenTTL  = 1 * time.Hour
+	DefaultMailRatePerHour = 5 // messages to one address
+	DefaultMailBurst       = 3
+	MaxEmailLength         = 254
+
+	MailerSMTP   = "smtp"   // send through an SMTP relay
+	MailerOutbox = "outbox" // record mail locally for development
+)
+
+// Admission control modes and defaults
+const (
+	AdmissionModeReject   = "reject"   // answer 503 with Retry-After when full
+	AdmissionModeWaitlist = "waitlist" // accept the socket and queue the client
+
+	DefaultWaitlistSize        = 100
+	DefaultWaitlistTimeout     = 2 * time.Minute
+	DefaultAdmissionRetryAfter = 30 * time.Second
+	WaitlistUpdateInterval     = 5 * time.Second
+)
+
+// Proof-of-work challenge defaults (difficulty is in leading zero bits)
+const (
+	DefaultChallengeDifficulty    = 16
+	DefaultMaxChallengeDifficulty = 22
+	DefaultChallengeRateThreshold = 120 // connection attempts per minute
+	MaxChallengeAttempts          = 3
+)
+
+// Bot detection score thresholds (zero disables an action)
+const (
+	DefaultBotChallengeScore  = 40
+	DefaultBotThrottleScore   = 60
+	DefaultBotShadowBanScore  = 80
+	DefaultBotDisconnectScore = 100
+)
+
 // WebRTC constants
 const (
 	DefaultSTUNServer1 = "stun:stun.l.google.com:19302"
@@ -101,8 +290,15 @@ var DefaultAllowedOrigins = []string{
 const (
 	MinJWTSecretLength = 8
 	MinPasswordLength  = 8
-	MaxRetryAttempts   = 3
+	MaxPasswordLength  = 72 // bcrypt refuses to hash anything longer
+	MaxRetryAttempts   = 3  // failed logins per username and client IP before a lockout
 	LockoutDuration    = 15 * time.Minute
+
+	// MaxTrackedLoginFailures bounds the failed-login entries kept for
+	// lockouts; idle entries are dropped once it is reached
+	MaxTrackedLoginFailures = 100000
+
+	DefaultPasswordHashCost = 12
 )
 
 // Media codec constants
@@ -140,8 +336,31 @@ const (
 	EnvRateLimitPerMinute = "RATE_LIMIT_PER_MINUTE"
 	EnvLogLevel           = "LOG_LEVEL"
 	EnvEnvironment        = "ENVIRONMENT"
+	EnvAdminAPIKeys       = "ADMIN_API_KEYS"
 )
 
+// Admin API scopes
+const (
+	ScopeStatsRead   = "stats:read"
+	ScopeRoomsRead   = "rooms:read"
+	ScopeRoomsWrite  = "rooms:write"
+	ScopeBansRead    = "bans:read"
+	ScopeBansWrite   = "bans:write"
+	ScopeTokensWrite = "tokens:write"
+	ScopeCDRRead     = "cdr:read"
+)
+
+// AdminScopes lists every scope an admin API key may be granted
+var AdminScopes = []string{
+	ScopeStatsRead,
+	ScopeRoomsRead,
+	ScopeRoomsWrite,
+	ScopeBansRead,
+	ScopeBansWrite,
+	ScopeTokensWrite,
+	ScopeCDRRead,
+}
+
 // Log levels
 const (
 	LogLevelDebug = "debug"
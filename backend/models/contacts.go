@@ -0,0 +1,98 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Contact exchange errors
+var (
+	ErrNoActiveRoom         = errors.New("not in an active room")
+	ErrInvalidContactHandle = errors.New("invalid contact handle")
+)
+
+// ContactExchange is the outcome of a share_contact
+type ContactExchange struct {
+	RoomID        string
+	PartnerUserID string
+	// Handles holds both users' handles, by user ID, once both have shared.
+	// Until then it is nil and the handle stays sealed in the room.
+	Handles map[string]string
+}
+
+// NormalizeContactHandle trims a handle and checks that it is short,
+// printable text
+func NormalizeContactHandle(handle string) (string, error) {
+	handle = strings.TrimSpace(handle)
+	if handle == "" || utf8.RuneCountInString(handle) > MaxContactHandleLength {
+		return "", ErrInvalidContactHandle
+	}
+	for _, r := range handle {
+		if !unicode.IsPrint(r) {
+			return "", ErrInvalidContactHandle
+		}
+	}
+	return handle, nil
+}
+
+// ShareContact seals a user's handle in their active room. Once their
+// partner has shared too, both handles are returned and removed from the
+// room; a handle still sealed when the room ends is discarded with it.
+// Sharing again before then replaces the sealed handle.
+func (p *UserPool) ShareContact(userID, handle string) (*ContactExchange, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	room, partnerID := p.activeRoomLocked(userID)
+	if room == nil {
+		return nil, ErrNoActiveRoom
+	}
+
+	if room.contacts == nil {
+		room.contacts = make(map[string]string)
+	}
+	room.contacts[userID] = handle
+
+	exchange := &ContactExchange{RoomID: room.ID, PartnerUserID: partnerID}
+	if _, shared := room.contacts[partnerID]; shared {
+		exchange.Handles = room.contacts
+		room.contacts = nil
+	}
+	return exchange, nil
+}
+
+// WithdrawContact discards a user's sealed handle, returning their partner's
+// user ID. Returns false if the user had no handle sealed.
+func (p *UserPool) WithdrawContact(userID string) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	room, partnerID := p.activeRoomLocked(userID)
+	if room == nil {
+		return "", false
+	}
+	if _, shared := room.contacts[userID]; !shared {
+		return "", false
+	}
+	delete(room.contacts, userID)
+	return partnerID, true
+}
+
+// activeRoomLocked returns a user's active room and their partner in it, or
+// nil if the user is not a participant of an active room or its call has
+// been hung up. Caller must hold the lock.
+func (p *UserPool) activeRoomLocked(userID string) (*Room, string) {
+	room := p.Rooms[p.UserRooms[userID]]
+	if room == nil || !room.IsActive || room.CallState == CallState(CallStateEnded) {
+		return nil, ""
+	}
+	switch userID {
+	case room.User1ID:
+		return room, room.User2ID
+	case room.User2ID:
+		return room, room.User1ID
+	}
+	return nil, ""
+}
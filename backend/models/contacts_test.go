@@ -0,0 +1,142 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeContactHandle(t *testing.T) {
+	handle, err := NormalizeContactHandle("  @alice_99 ")
+	require.NoError(t, err)
+	assert.Equal(t, "@alice_99", handle)
+
+	handle, err = NormalizeContactHandle(strings.Repeat("é", MaxContactHandleLength))
+	require.NoError(t, err)
+	assert.Equal(t, MaxContactHandleLength, len([]rune(handle)))
+
+	for _, invalid := range []string{"", "   ", strings.Repeat("a", MaxContactHandleLength+1), "line\nbreak", "bell\a"} {
+		_, err := NormalizeContactHandle(invalid)
+		assert.ErrorIs(t, err, ErrInvalidContactHandle, invalid)
+	}
+}
+
+func TestUserPool_ShareContact(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	alice := &User{ID: "session-a", Connection: &Connection{UserID: "session-a", IsActive: true}}
+	bob := &User{ID: "session-b", Connection: &Connection{UserID: "session-b", IsActive: true}}
+	pool.AddWaitingUser(alice)
+	pool.AddWaitingUser(bob)
+
+	_, err := pool.ShareContact(alice.ID, "@alice")
+	assert.ErrorIs(t, err, ErrNoActiveRoom, "no conversation yet")
+
+	room := pool.CreateRoom(alice, bob)
+
+	// A handle stays sealed until both have shared; resharing replaces it
+	exchange, err := pool.ShareContact(alice.ID, "@old")
+	require.NoError(t, err)
+	assert.Nil(t, exchange.Handles)
+	assert.Equal(t, bob.ID, exchange.PartnerUserID)
+	_, err = pool.ShareContact(alice.ID, "@alice")
+	require.NoError(t, err)
+
+	exchange, err = pool.ShareContact(bob.ID, "@bob")
+	require.NoError(t, err)
+	assert.Equal(t, room.ID, exchange.RoomID)
+	assert.Equal(t, map[string]string{alice.ID: "@alice", bob.ID: "@bob"}, exchange.Handles)
+
+	// Revealed handles are not kept, so a new exchange starts from scratch
+	exchange, err = pool.ShareContact(bob.ID, "@bob")
+	require.NoError(t, err)
+	assert.Nil(t, exchange.Handles)
+
+	// Withdrawing discards the sealed handle
+	partnerID, withdrawn := pool.WithdrawContact(bob.ID)
+	assert.True(t, withdrawn)
+	assert.Equal(t, alice.ID, partnerID)
+	_, withdrawn = pool.WithdrawContact(bob.ID)
+	assert.False(t, withdrawn)
+	exchange, err = pool.ShareContact(alice.ID, "@alice")
+	require.NoError(t, err)
+	assert.Nil(t, exchange.Handles)
+}
+
+func TestUserPool_ContactsDiscardedWhenRoomEnds(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	alice := &User{ID: "session-a", Connection: &Connection{UserID: "session-a", IsActive: true}}
+	bob := &User{ID: "session-b", Connection: &Connection{UserID: "session-b", IsActive: true}}
+	carol := &User{ID: "session-c", Connection: &Connection{UserID: "session-c", IsActive: true}}
+	pool.AddWaitingUser(alice)
+	pool.AddWaitingUser(bob)
+	pool.AddWaitingUser(carol)
+
+	// Ended by a moderator
+	room := pool.CreateRoom(alice, bob)
+	_, err := pool.ShareContact(alice.ID, "@alice")
+	require.NoError(t, err)
+	require.True(t, pool.EndRoom(room.ID, "test"))
+	pool.mutex.RLock()
+	assert.Nil(t, room.contacts)
+	pool.mutex.RUnlock()
+	_, err = pool.ShareContact(bob.ID, "@bob")
+	assert.ErrorIs(t, err, ErrNoActiveRoom)
+
+	// Hung up by a participant's call_end, which keeps a random match
+	room = pool.CreateRoom(alice, bob)
+	_, err = pool.ShareContact(bob.ID, "@bob")
+	require.NoError(t, err)
+	require.True(t, pool.EndUserCall(alice.ID, "Call ended"))
+	pool.mutex.RLock()
+	assert.Nil(t, room.contacts)
+	assert.True(t, room.IsActive)
+	assert.NotNil(t, room.EndedAt)
+	pool.mutex.RUnlock()
+	assert.Same(t, bob, pool.FindPartner(alice.ID))
+	_, err = pool.ShareContact(alice.ID, "@alice")
+	assert.ErrorIs(t, err, ErrNoActiveRoom)
+	require.True(t, pool.EndRoom(room.ID, "test"))
+	assert.Nil(t, pool.FindPartner(bob.ID))
+
+	// Ended by a participant leaving
+	room = pool.CreateRoom(alice, carol)
+	_, err = pool.ShareContact(carol.ID, "@carol")
+	require.NoError(t, err)
+	pool.RemoveUser(carol.ID)
+	pool.mutex.RLock()
+	assert.Nil(t, room.contacts)
+	pool.mutex.RUnlock()
+	_, err = pool.ShareContact(alice.ID, "@alice")
+	assert.ErrorIs(t, err, ErrNoActiveRoom)
+}
+
+func TestUserPool_ContactsStayInTheirRoom(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	users := make(map[string]*User)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		users[id] = &User{ID: id, Connection: &Connection{UserID: id, IsActive: true}}
+		pool.AddWaitingUser(users[id])
+	}
+
+	// Two rooms created within the same second keep their own IDs
+	first := pool.CreateRoom(users["a"], users["b"])
+	second := pool.CreateRoom(users["c"], users["d"])
+	require.NotEqual(t, first.ID, second.ID)
+
+	// A handle sealed in one room is never revealed to another room
+	_, err := pool.ShareContact("c", "c-secret")
+	require.NoError(t, err)
+	exchange, err := pool.ShareContact("a", "a-handle")
+	require.NoError(t, err)
+	assert.Nil(t, exchange.Handles)
+	assert.Equal(t, "b", exchange.PartnerUserID)
+	assert.Equal(t, first.ID, exchange.RoomID)
+}
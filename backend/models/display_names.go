@@ -0,0 +1,226 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Display name errors
+var (
+	ErrInvalidDisplayName = errors.New("invalid display name")
+	ErrDisplayNameTaken   = errors.New("display name is taken")
+)
+
+// Word lists for generated display names, used when none are configured
+var (
+	DefaultNameAdjectives = []string{
+		"Amber", "Bold", "Brave", "Breezy", "Bright", "Calm", "Clever", "Cosy",
+		"Curious", "Daring", "Dreamy", "Eager", "Gentle", "Glad", "Golden", "Happy",
+		"Jolly", "Kind", "Lively", "Lucky", "Mellow", "Merry", "Misty", "Nimble",
+		"Quiet", "Rapid", "Silver", "Sleepy", "Sunny", "Swift", "Witty", "Zesty",
+	}
+	DefaultNameAnimals = []string{
+		"Badger", "Beaver", "Bison", "Crane", "Dolphin", "Falcon", "Ferret", "Finch",
+		"Fox", "Gecko", "Heron", "Ibis", "Koala", "Lemur", "Lynx", "Marten",
+		"Moose", "Newt", "Ocelot", "Otter", "Owl", "Panda", "Puffin", "Quokka",
+		"Raven", "Robin", "Seal", "Sparrow", "Stoat", "Tapir", "Walrus", "Wombat",
+	}
+)
+
+// confusables maps Cyrillic and Greek letters that look like Latin ones to
+// the lower-case Latin letter they pass for, so that "Аdmin" with a Cyrillic
+// "А" is treated as "admin"
+var confusables = map[rune]rune{
+	// Cyrillic
+	'А': 'a', 'а': 'a', 'В': 'b', 'Е': 'e', 'е': 'e', 'Һ': 'h', 'һ': 'h', 'Н': 'h',
+	'І': 'i', 'і': 'i', 'Ј': 'j', 'ј': 'j', 'К': 'k', 'к': 'k', 'Ӏ': 'l', 'ӏ': 'l',
+	'М': 'm', 'О': 'o', 'о': 'o', 'Р': 'p', 'р': 'p', 'ԛ': 'q', 'С': 'c', 'с': 'c',
+	'Ѕ': 's', 'ѕ': 's', 'Т': 't', 'У': 'y', 'у': 'y', 'Х': 'x', 'х': 'x', 'ԁ': 'd',
+	'Ԝ': 'w', 'ԝ': 'w',
+	// Greek
+	'Α': 'a', 'α': 'a', 'Β': 'b', 'Ε': 'e', 'Ζ': 'z', 'Η': 'h', 'Ι': 'i', 'ι': 'i',
+	'Κ': 'k', 'κ': 'k', 'Μ': 'm', 'Ν': 'n', 'ν': 'v', 'Ο': 'o', 'ο': 'o', 'Ρ': 'p',
+	'ρ': 'p', 'Τ': 't', 'Υ': 'y', 'υ': 'u', 'Χ': 'x', 'χ': 'x',
+}
+
+// FoldConfusables replaces letters that pass for Latin ones with those Latin
+// letters, leaving the rest of text as it is
+func FoldConfusables(text string) string {
+	return strings.Map(func(r rune) rune {
+		if latin, ok := confusables[r]; ok {
+			return latin
+		}
+		return r
+	}, text)
+}
+
+// scriptSets are the scripts that may be mixed in one display name; any
+// other mix is refused, as it is how lookalike names are made. Japanese
+// and Korean names are written in several scripts.
+var scriptSets = [][]string{
+	{"Han", "Hiragana", "Katakana"},
+	{"Han", "Hangul"},
+}
+
+// singleScript reports whether the letters of name come from one script, or
+// one of the scriptSets
+func singleScript(name string) bool {
+	scripts := make(map[string]bool)
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for script, table := range unicode.Scripts {
+			if unicode.Is(table, r) {
+				scripts[script] = true
+				break
+			}
+		}
+	}
+	if len(scripts) <= 1 {
+		return true
+	}
+	for _, set := range scriptSets {
+		covered := 0
+		for _, script := range set {
+			if scripts[script] {
+				covered++
+			}
+		}
+		if covered == len(scripts) {
+			return true
+		}
+	}
+	return false
+}
+
+// generatedNameAttempts is how many random names are tried before a number
+// is added to make one unique
+const generatedNameAttempts = 10
+
+// NameGenerator makes adjective-animal display names such as "Brave Otter"
+type NameGenerator struct {
+	adjectives []string
+	animals    []string
+}
+
+// NewNameGenerator creates a generator from word lists, falling back to the
+// default list for any that are empty
+func NewNameGenerator(adjectives, animals []string) *NameGenerator {
+	if len(adjectives) == 0 {
+		adjectives = DefaultNameAdjectives
+	}
+	if len(animals) == 0 {
+		animals = DefaultNameAnimals
+	}
+	return &NameGenerator{adjectives: adjectives, animals: animals}
+}
+
+// Generate returns a random name; it is not necessarily unique
+func (g *NameGenerator) Generate() string {
+	return g.adjectives[mathrand.IntN(len(g.adjectives))] + " " + g.animals[mathrand.IntN(len(g.animals))]
+}
+
+// NewAvatarSeed returns a random seed clients use to draw a user's avatar
+func NewAvatarSeed() string {
+	seed := make([]byte, 8)
+	rand.Read(seed)
+	return hex.EncodeToString(seed)
+}
+
+// NormalizeDisplayName collapses whitespace in a display name and checks it
+// is 2 to MaxDisplayNameLength letters, digits, spaces and simple punctuation,
+// with all the letters written in one script
+func NormalizeDisplayName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if length := utf8.RuneCountInString(name); length < MinDisplayNameLength || length > MaxDisplayNameLength {
+		return "", ErrInvalidDisplayName
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" -_.'", r) {
+			return "", ErrInvalidDisplayName
+		}
+	}
+	if !singleScript(name) {
+		return "", ErrInvalidDisplayName
+	}
+	return name, nil
+}
+
+// displayNameKey is how display names are compared for uniqueness, so that
+// names differing only in case or in lookalike letters cannot be used to
+// impersonate each other
+func displayNameKey(name string) string {
+	return strings.ToLower(FoldConfusables(name))
+}
+
+// AssignDisplayName gives a user a generated name that no connected user
+// has, adding a number if random attempts keep colliding
+func (p *UserPool) AssignDisplayName(user *User, generator *NameGenerator) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	name := generator.Generate()
+	for attempt := 1; attempt < generatedNameAttempts && p.displayNameTakenLocked(user, name); attempt++ {
+		name = generator.Generate()
+	}
+	for base, n := name, 2; p.displayNameTakenLocked(user, name); n++ {
+		name = fmt.Sprintf("%s %d", base, n)
+	}
+
+	p.setDisplayNameLocked(user, name)
+	return name
+}
+
+// ClaimDisplayName sets a user's display name, releasing their previous one.
+// Returns ErrDisplayNameTaken if another connected user has the name.
+func (p *UserPool) ClaimDisplayName(user *User, name string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.displayNameTakenLocked(user, name) {
+		return ErrDisplayNameTaken
+	}
+	p.setDisplayNameLocked(user, name)
+	return nil
+}
+
+// ReleaseDisplayName frees a user's display name for others, e.g. when
+// their session fails before they join the pool
+func (p *UserPool) ReleaseDisplayName(user *User) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.releaseDisplayNameLocked(user)
+}
+
+// displayNameTakenLocked reports whether a user other than user holds name.
+// Caller must hold the lock.
+func (p *UserPool) displayNameTakenLocked(user *User, name string) bool {
+	holder, taken := p.displayNames[displayNameKey(name)]
+	return taken && holder != user.ID
+}
+
+// setDisplayNameLocked records name as the user's. Caller must hold the lock.
+func (p *UserPool) setDisplayNameLocked(user *User, name string) {
+	p.releaseDisplayNameLocked(user)
+	user.DisplayName = name
+	p.displayNames[displayNameKey(name)] = user.ID
+}
+
+// releaseDisplayNameLocked forgets the user's display name. Caller must hold
+// the lock.
+func (p *UserPool) releaseDisplayNameLocked(user *User) {
+	if user == nil || user.DisplayName == "" {
+		return
+	}
+	key := displayNameKey(user.DisplayName)
+	if p.displayNames[key] == user.ID {
+		delete(p.displayNames, key)
+	}
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeDisplayName(t *testing.T) {
+	name, err := NormalizeDisplayName("  Night   Owl ")
+	require.NoError(t, err)
+	assert.Equal(t, "Night Owl", name)
+
+	name, err = NormalizeDisplayName("Zoë O'Brien-Smith")
+	require.NoError(t, err)
+	assert.Equal(t, "Zoë O'Brien-Smith", name)
+
+	// Names in one script, or the scripts Japanese and Korean are written in
+	for _, valid := range []string{"Ночная Сова", "Νυχτοπούλι", "山田 はなこ", "김 민준 金"} {
+		_, err := NormalizeDisplayName(valid)
+		assert.NoError(t, err, valid)
+	}
+
+	// "Аdmin" with a Cyrillic "А" mixes scripts
+	for _, invalid := range []string{"", "a", strings.Repeat("a", MaxDisplayNameLength+1), "<script>", "null\x00byte", "😀 face", "Аdmin", "Night Оwl"} {
+		_, err := NormalizeDisplayName(invalid)
+		assert.ErrorIs(t, err, ErrInvalidDisplayName, invalid)
+	}
+}
+
+func TestNameGenerator(t *testing.T) {
+	generator := NewNameGenerator([]string{"Brave"}, nil)
+	name := generator.Generate()
+	assert.True(t, strings.HasPrefix(name, "Brave "), name)
+	assert.Contains(t, DefaultNameAnimals, strings.TrimPrefix(name, "Brave "))
+
+	assert.Len(t, NewAvatarSeed(), 16)
+	assert.NotEqual(t, NewAvatarSeed(), NewAvatarSeed())
+}
+
+func TestUserPool_DisplayNamesAreUnique(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	// With a single possible name, later users get a number added
+	generator := NewNameGenerator([]string{"Brave"}, []string{"Otter"})
+	alice := &User{ID: "session-a", Connection: &Connection{UserID: "session-a", IsActive: true}}
+	bob := &User{ID: "session-b", Connection: &Connection{UserID: "session-b", IsActive: true}}
+	carol := &User{ID: "session-c", Connection: &Connection{UserID: "session-c", IsActive: true}}
+	assert.Equal(t, "Brave Otter", pool.AssignDisplayName(alice, generator))
+	assert.Equal(t, "Brave Otter 2", pool.AssignDisplayName(bob, generator))
+	pool.AddWaitingUser(alice)
+	pool.AddWaitingUser(bob)
+
+	// Names differing only in case are taken too; a user may reclaim their own
+	assert.ErrorIs(t, pool.ClaimDisplayName(carol, "brave otter"), ErrDisplayNameTaken)
+	require.NoError(t, pool.ClaimDisplayName(alice, "Brave Otter"))
+	require.NoError(t, pool.ClaimDisplayName(alice, "Night Owl"))
+	assert.Equal(t, "Night Owl", alice.DisplayName)
+
+	// As are names spelt with lookalike letters of another script
+	require.NoError(t, pool.ClaimDisplayName(alice, "Coco"))
+	assert.ErrorIs(t, pool.ClaimDisplayName(carol, "Сосо"), ErrDisplayNameTaken)
+	require.NoError(t, pool.ClaimDisplayName(alice, "Night Owl"))
+
+	// Changing or leaving releases a name
+	require.NoError(t, pool.ClaimDisplayName(carol, "Brave Otter"))
+	pool.RemoveUser(bob.ID)
+	require.NoError(t, pool.ClaimDisplayName(carol, "Brave Otter 2"))
+	pool.ReleaseDisplayName(carol)
+	assert.Equal(t, "Brave Otter", pool.AssignDisplayName(bob, generator))
+}
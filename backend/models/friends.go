@@ -0,0 +1,336 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Friend errors
+var (
+	ErrNoRecentPartner = errors.New("no current or recent conversation partner")
+	ErrNotFriends      = errors.New("not a friend")
+)
+
+// Friendship links two identities. Its ID is the friend ID both sides use to
+// refer to each other, so neither learns the other's account or device ID.
+type Friendship struct {
+	ID         string
+	Identities [2]string
+	CreatedAt  time.Time
+}
+
+// other returns the identity on the other side from identity
+func (f *Friendship) other(identity string) string {
+	if f.Identities[0] == identity {
+		return f.Identities[1]
+	}
+	return f.Identities[0]
+}
+
+// Friend is one entry in a user's friend list
+type Friend struct {
+	FriendID string    `json:"friend_id"`
+	Since    time.Time `json:"since"`
+	Online   bool      `json:"online"`
+}
+
+// FriendRequest is the outcome of an add_friend
+type FriendRequest struct {
+	RoomID        string
+	Requester     string // identity of the user who sent add_friend
+	Partner       string // identity of their conversation partner
+	PartnerUserID string
+	Mutual        bool // both sides have now asked, for the first time in this room
+}
+
+// friendshipRecord is one line of a friend file: a friendship's current
+// sides, or its removal
+type friendshipRecord struct {
+	ID         string    `json:"id"`
+	Identities [2]string `json:"identities"`
+	CreatedAt  time.Time `json:"created_at"`
+	Removed    bool      `json:"removed,omitempty"`
+}
+
+func newFriendshipRecord(friendship *Friendship) friendshipRecord {
+	return friendshipRecord{ID: friendship.ID, Identities: friendship.Identities, CreatedAt: friendship.CreatedAt}
+}
+
+// FriendStore holds friendships. A store opened on a file appends every
+// change to it, so friendships survive restarts.
+type FriendStore struct {
+	friendships map[string]*Friendship            // friend ID -> friendship
+	byIdentity  map[string]map[string]*Friendship // identity -> other identity -> friendship
+	journal     *journal
+	mutex       sync.RWMutex
+}
+
+// NewFriendStore creates an empty friend store
+func NewFriendStore() *FriendStore {
+	return &FriendStore{
+		friendships: make(map[string]*Friendship),
+		byIdentity:  make(map[string]map[string]*Friendship),
+	}
+}
+
+// OpenFriendStore opens (or creates) a friend store persisted to path
+func OpenFriendStore(path string) (*FriendStore, error) {
+	s := NewFriendStore()
+
+	journal, err := openJournal(path, func(line []byte) error {
+		var record friendshipRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.ID == "" {
+			return ErrJournalMalformed
+		}
+		if record.Removed {
+			delete(s.friendships, record.ID)
+			return nil
+		}
+		if record.Identities[0] == "" || record.Identities[1] == "" {
+			return ErrJournalMalformed
+		}
+		s.friendships[record.ID] = &Friendship{ID: record.ID, Identities: record.Identities, CreatedAt: record.CreatedAt}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+
+	// Later records replace earlier ones, so index only the final state
+	for _, friendship := range s.friendships {
+		s.indexLocked(friendship)
+	}
+	return s, nil
+}
+
+// Close closes the store's file, if any
+func (s *FriendStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.journal.close()
+}
+
+// Add makes two identities friends, returning the existing friendship if
+// they already are
+func (s *FriendStore) Add(a, b string) (*Friendship, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existing := s.byIdentity[a][b]; existing != nil {
+		return existing, nil
+	}
+
+	friendship := &Friendship{
+		ID:         uuid.New().String(),
+		Identities: [2]string{a, b},
+		CreatedAt:  time.Now(),
+	}
+	if err := s.journal.append(newFriendshipRecord(friendship)); err != nil {
+		return nil, err
+	}
+	s.friendships[friendship.ID] = friendship
+	s.indexLocked(friendship)
+	return friendship, s.compactLocked()
+}
+
+// indexLocked adds a friendship to both sides' lists. Caller must hold the lock.
+func (s *FriendStore) indexLocked(friendship *Friendship) {
+	a, b := friendship.Identities[0], friendship.Identities[1]
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		if s.byIdentity[pair[0]] == nil {
+			s.byIdentity[pair[0]] = make(map[string]*Friendship)
+		}
+		s.byIdentity[pair[0]][pair[1]] = friendship
+	}
+}
+
+// compactLocked rewrites the store's file from the current friendships once
+// superseded records dominate it. Caller must hold the lock.
+func (s *FriendStore) compactLocked() error {
+	if !s.journal.needsCompaction(len(s.friendships)) {
+		return nil
+	}
+	records := make([]interface{}, 0, len(s.friendships))
+	for _, friendship := range s.friendships {
+		records = append(records, newFriendshipRecord(friendship))
+	}
+	return s.journal.compact(records)
+}
+
+// Friend returns the identity behind a friend ID, provided one of the
+// caller's identities is on the other side of that friendship
+func (s *FriendStore) Friend(identities []string, friendID string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if friendship := s.friendships[friendID]; friendship != nil {
+		for _, identity := range identities {
+			if friendship.Identities[0] == identity || friendship.Identities[1] == identity {
+				return friendship.other(identity), nil
+			}
+		}
+	}
+	return "", ErrNotFriends
+}
+
+// Exists reports whether a friendship is still in place
+func (s *FriendStore) Exists(friendID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.friendships[friendID] != nil
+}
+
+// List returns the friends of any of a user's identities, oldest first.
+// online reports whether a friend's identity is connected; it is called
+// without the store's lock held.
+func (s *FriendStore) List(identities []string, online func(identity string) bool) []Friend {
+	friendships := make(map[*Friendship]string)
+	s.mutex.RLock()
+	for _, identity := range identities {
+		for other, friendship := range s.byIdentity[identity] {
+			friendships[friendship] = other
+		}
+	}
+	s.mutex.RUnlock()
+
+	friends := make([]Friend, 0, len(friendships))
+	for friendship, other := range friendships {
+		friends = append(friends, Friend{FriendID: friendship.ID, Since: friendship.CreatedAt, Online: online(other)})
+	}
+	sort.Slice(friends, func(i, j int) bool { return friends[i].Since.Before(friends[j].Since) })
+	return friends
+}
+
+// Transfer moves the friendships of anonymous identities to the account they
+// were upgraded to, so they follow the account to other devices. A friend the
+// account already has keeps the account's friendship. Each friendship is
+// written to the store's file before it moves, so a failed write leaves the
+// rest where they were.
+func (s *FriendStore) Transfer(from []string, to string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, identity := range from {
+		if identity == to {
+			continue
+		}
+		for other, friendship := range s.byIdentity[identity] {
+			if other == to || s.byIdentity[to][other] != nil {
+				record := newFriendshipRecord(friendship)
+				record.Removed = true
+				if err := s.journal.append(record); err != nil {
+					return err
+				}
+				delete(s.byIdentity[other], identity)
+				delete(s.byIdentity[identity], other)
+				delete(s.friendships, friendship.ID)
+				continue
+			}
+
+			moved := *friendship
+			if moved.Identities[0] == identity {
+				moved.Identities[0] = to
+			} else {
+				moved.Identities[1] = to
+			}
+			if err := s.journal.append(newFriendshipRecord(&moved)); err != nil {
+				return err
+			}
+			delete(s.byIdentity[other], identity)
+			delete(s.byIdentity[identity], other)
+			friendship.Identities = moved.Identities
+			s.indexLocked(friendship)
+		}
+		delete(s.byIdentity, identity)
+	}
+	return s.compactLocked()
+}
+
+// friendsOf returns the friendships of an identity
+func (s *FriendStore) friendsOf(identity string) []*Friendship {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	friendships := make([]*Friendship, 0, len(s.byIdentity[identity]))
+	for _, friendship := range s.byIdentity[identity] {
+		friendships = append(friendships, friendship)
+	}
+	return friendships
+}
+
+// GetStats returns friend counters
+func (s *FriendStore) GetStats() map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return map[string]interface{}{
+		"friendships": len(s.friendships),
+	}
+}
+
+// RequestFriend records that a user wants to befriend their partner in the
+// current room, or in their last room if it ended less than window ago. The
+// partner is not told; the request only takes effect once both have asked.
+func (p *UserPool) RequestFriend(userID string, window time.Duration) (*FriendRequest, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	user := p.getUserLocked(userID)
+	if user == nil {
+		return nil, ErrNoRecentPartner
+	}
+	roomID := user.RoomID
+	if roomID == "" {
+		roomID = user.LastRoomID
+	}
+
+	room := p.Rooms[roomID]
+	if room == nil || room.Direct {
+		return nil, ErrNoRecentPartner
+	}
+	if !room.IsActive && (room.EndedAt == nil || time.Since(*room.EndedAt) > window) {
+		return nil, ErrNoRecentPartner
+	}
+
+	request := &FriendRequest{RoomID: roomID}
+	if room.User1ID == userID {
+		request.Requester, request.Partner, request.PartnerUserID = room.identities[0], room.identities[1], room.User2ID
+	} else {
+		request.Requester, request.Partner, request.PartnerUserID = room.identities[1], room.identities[0], room.User1ID
+	}
+
+	if room.friendRequests == nil {
+		room.friendRequests = make(map[string]bool)
+	}
+	room.friendRequests[userID] = true
+	if !room.friendsMade && room.friendRequests[room.User1ID] && room.friendRequests[room.User2ID] {
+		room.friendsMade = true
+		request.Mutual = true
+	}
+	return request, nil
+}
+
+// FindUserByIdentity returns a connected user recognised by identity
+func (p *UserPool) FindUserByIdentity(identity string) *User {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, user := range p.sessions[identity] {
+		return user
+	}
+	return nil
+}
+
+// markRoomEnded records when a room stopped being active and discards any
+// contact handles that were never revealed
+func markRoomEnded(room *Room) {
+	now := time.Now()
+	room.EndedAt = &now
+	room.contacts = nil
+}
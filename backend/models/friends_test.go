@@ -0,0 +1,160 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addFriends makes two identities friends, failing the test on error
+func addFriends(t *testing.T, store *FriendStore, a, b string) *Friendship {
+	friendship, err := store.Add(a, b)
+	require.NoError(t, err)
+	return friendship
+}
+
+func TestFriendStore(t *testing.T) {
+	store := NewFriendStore()
+
+	first := addFriends(t, store, "device-a", "account-b")
+	assert.Same(t, first, addFriends(t, store, "account-b", "device-a"), "adding twice keeps the friendship")
+	second := addFriends(t, store, "device-c", "device-a")
+
+	identity, err := store.Friend([]string{"session-a", "device-a"}, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "account-b", identity)
+
+	_, err = store.Friend([]string{"device-c"}, first.ID)
+	assert.ErrorIs(t, err, ErrNotFriends, "a friend ID is only usable by its two sides")
+	_, err = store.Friend([]string{"device-a"}, "unknown")
+	assert.ErrorIs(t, err, ErrNotFriends)
+
+	online := func(identity string) bool { return identity == "device-c" }
+	friends := store.List([]string{"device-a"}, online)
+	require.Len(t, friends, 2)
+	assert.Equal(t, first.ID, friends[0].FriendID, "oldest first")
+	assert.False(t, friends[0].Online)
+	assert.Equal(t, second.ID, friends[1].FriendID)
+	assert.True(t, friends[1].Online)
+
+	assert.Equal(t, 2, store.GetStats()["friendships"])
+}
+
+func TestFriendStore_Transfer(t *testing.T) {
+	store := NewFriendStore()
+	kept := addFriends(t, store, "device-a", "device-b")
+	duplicate := addFriends(t, store, "device-a", "device-c")
+	existing := addFriends(t, store, "account-1", "device-c")
+	addFriends(t, store, "device-a", "account-1")
+
+	require.NoError(t, store.Transfer([]string{"session-a", "device-a"}, "account-1"))
+
+	// Friendships follow the account, keeping their friend IDs
+	identity, err := store.Friend([]string{"account-1"}, kept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "device-b", identity)
+	_, err = store.Friend([]string{"device-a"}, kept.ID)
+	assert.ErrorIs(t, err, ErrNotFriends)
+
+	// A friend the account already had keeps the account's friendship, and
+	// the account is not its own friend
+	_, err = store.Friend([]string{"device-c"}, duplicate.ID)
+	assert.ErrorIs(t, err, ErrNotFriends)
+	_, err = store.Friend([]string{"device-c"}, existing.ID)
+	assert.NoError(t, err)
+	assert.Len(t, store.List([]string{"account-1"}, func(string) bool { return false }), 2)
+	assert.Equal(t, 2, store.GetStats()["friendships"])
+}
+
+func TestFriendStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "friends.jsonl")
+	store, err := OpenFriendStore(path)
+	require.NoError(t, err)
+
+	kept := addFriends(t, store, "device-a", "device-b")
+	duplicate := addFriends(t, store, "device-a", "device-c")
+	addFriends(t, store, "account-1", "device-c")
+	require.NoError(t, store.Transfer([]string{"device-a"}, "account-1"))
+	require.NoError(t, store.Close())
+
+	reopened, err := OpenFriendStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	// Transferred friendships stay with the account, dropped ones stay gone
+	identity, err := reopened.Friend([]string{"account-1"}, kept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "device-b", identity)
+	_, err = reopened.Friend([]string{"device-c"}, duplicate.ID)
+	assert.ErrorIs(t, err, ErrNotFriends)
+	assert.Empty(t, reopened.List([]string{"device-a"}, func(string) bool { return false }))
+	assert.Equal(t, 2, reopened.GetStats()["friendships"])
+}
+
+func TestFriendStore_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "friends.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"identities\":[\"a\",\"b\"]}\n"), 0o600))
+
+	_, err := OpenFriendStore(path)
+	assert.ErrorIs(t, err, ErrJournalMalformed)
+}
+
+func TestUserPool_RequestFriend(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	alice := &User{ID: "session-a", DeviceID: "device-a", Connection: &Connection{UserID: "session-a", IsActive: true}}
+	bob := &User{ID: "session-b", DeviceID: "device-b", Connection: &Connection{UserID: "session-b", IsActive: true}}
+	pool.AddWaitingUser(alice)
+	pool.AddWaitingUser(bob)
+
+	_, err := pool.RequestFriend(alice.ID, FriendRequestWindow)
+	assert.ErrorIs(t, err, ErrNoRecentPartner, "no conversation yet")
+
+	room := pool.CreateRoom(alice, bob)
+
+	// Only the second request is mutual, and only once per room
+	request, err := pool.RequestFriend(alice.ID, FriendRequestWindow)
+	require.NoError(t, err)
+	assert.False(t, request.Mutual)
+	assert.Equal(t, "device-a", request.Requester)
+	assert.Equal(t, "device-b", request.Partner)
+
+	request, err = pool.RequestFriend(bob.ID, FriendRequestWindow)
+	require.NoError(t, err)
+	assert.True(t, request.Mutual)
+	assert.Equal(t, room.ID, request.RoomID)
+	assert.Equal(t, alice.ID, request.PartnerUserID)
+
+	request, err = pool.RequestFriend(alice.ID, FriendRequestWindow)
+	require.NoError(t, err)
+	assert.False(t, request.Mutual)
+
+	// A request is still accepted shortly after the room ends, but not
+	// once the window has passed
+	pool.EndRoom(room.ID, "test")
+	_, err = pool.RequestFriend(bob.ID, FriendRequestWindow)
+	assert.NoError(t, err)
+
+	pool.mutex.Lock()
+	ended := time.Now().Add(-FriendRequestWindow - time.Second)
+	room.EndedAt = &ended
+	pool.mutex.Unlock()
+	_, err = pool.RequestFriend(bob.ID, FriendRequestWindow)
+	assert.ErrorIs(t, err, ErrNoRecentPartner)
+
+	// Direct calls between friends cannot be used to add friends
+	direct := pool.CreateDirectRoom(alice, bob)
+	require.NotNil(t, direct)
+	assert.True(t, direct.Direct)
+	assert.Nil(t, pool.CreateDirectRoom(alice, bob), "both are already in a room")
+	_, err = pool.RequestFriend(alice.ID, FriendRequestWindow)
+	assert.ErrorIs(t, err, ErrNoRecentPartner)
+
+	assert.Same(t, bob, pool.FindUserByIdentity("device-b"))
+	assert.Nil(t, pool.FindUserByIdentity("device-z"))
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// journalCompactMinRecords is the file size, in records, below which a
+// journal is never compacted
+const journalCompactMinRecords = 1024
+
+// ErrJournalMalformed is returned when a store's file cannot be parsed
+var ErrJournalMalformed = errors.New("store file entry is malformed")
+
+// journal persists a store's changes to an append-only JSON Lines file, in
+// the same way as the token revocation store. The file is replayed on open
+// and rewritten from a snapshot once superseded records make up most of it.
+// A nil journal keeps nothing, which is how in-memory stores use it.
+type journal struct {
+	path    string
+	file    *os.File
+	records int // records in the file, including superseded ones
+}
+
+// openJournal opens (or creates) the journal at path, passing each record to
+// replay in the order it was written. A final line without a newline is the
+// remains of an interrupted write; it is ignored and cut from the file, so
+// the next record does not land on it.
+func openJournal(path string, replay func(line []byte) error) (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+
+	j := &journal{path: path}
+	end, err := j.load(replay)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > end {
+		if err := os.Truncate(path, end); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+	return j, nil
+}
+
+// load replays the journal file, returning the length of its complete lines
+func (j *journal) load(replay func(line []byte) error) (int64, error) {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var end int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		end += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := replay(line); err != nil {
+			return 0, err
+		}
+		j.records++
+	}
+}
+
+// append writes a record and syncs it to disk. Callers append before
+// applying a change, so a failed write leaves the store unchanged.
+func (j *journal) append(record interface{}) error {
+	if j == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.records++
+	return nil
+}
+
+// needsCompaction reports whether superseded records outnumber the live ones
+func (j *journal) needsCompaction(live int) bool {
+	return j != nil && j.records >= journalCompactMinRecords && j.records > 2*live
+}
+
+// compact writes the live records to a temporary file and renames it over
+// the journal, so a crash leaves either the old or the new file
+func (j *journal) compact(records []interface{}) error {
+	if j == nil {
+		return nil
+	}
+
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	j.file.Close()
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	j.records = len(records)
+	return nil
+}
+
+// close closes the journal file
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
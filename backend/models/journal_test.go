@@ -0,0 +1,34 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal_TornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"id\":\"1\"}\n{\"id\":\"2\",\"na"), 0o640))
+
+	var replayed []string
+	replay := func(line []byte) error {
+		replayed = append(replayed, string(line))
+		return nil
+	}
+	j, err := openJournal(path, replay)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"{\"id\":\"1\"}\n"}, replayed)
+
+	// The next record starts on a line of its own, so reopening still works
+	require.NoError(t, j.append(map[string]string{"id": "3"}))
+	require.NoError(t, j.close())
+
+	replayed = nil
+	j, err = openJournal(path, replay)
+	require.NoError(t, err)
+	defer j.close()
+	assert.Equal(t, []string{"{\"id\":\"1\"}\n", "{\"id\":\"3\"}\n"}, replayed)
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -25,27 +26,52 @@ type CallState string
 type Connection struct {
 	Conn     *websocket.Conn
 	UserID   string
+	ClientIP string // resolved through trusted proxies at upgrade
 	LastPing time.Time
 	IsActive bool
 	mutex    sync.RWMutex
+
+	// OnClose runs exactly once, the first time the connection is closed.
+	// It is used to release admission slots on every disconnect path.
+	OnClose   func()
+	closeOnce sync.Once
 }
 
 func (c *Connection) Close() error {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
 	c.IsActive = false
-	return c.Conn.Close()
+	var err error
+	if c.Conn != nil {
+		err = c.Conn.Close()
+	}
+	c.mutex.Unlock()
+
+	c.closeOnce.Do(func() {
+		if c.OnClose != nil {
+			c.OnClose()
+		}
+	})
+	return err
 }
 
 func (c *Connection) WriteJSON(v interface{}) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	if !c.IsActive {
+	if !c.IsActive || c.Conn == nil {
 		return websocket.ErrCloseSent
 	}
+	// A peer that stops reading must not block the writer indefinitely
+	c.Conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
 	return c.Conn.WriteJSON(v)
 }
 
+// Active reports whether the connection is still open
+func (c *Connection) Active() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.IsActive
+}
+
 func (c *Connection) UpdatePing() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -54,14 +80,81 @@ func (c *Connection) UpdatePing() {
 
 type User struct {
 	ID          string      `json:"id"`
+	AccountID   string      `json:"account_id,omitempty"` // empty for anonymous sessions
+	DeviceID    string      `json:"device_id,omitempty"`  // stable across sessions from one install
 	SessionID   string      `json:"session_id"`
 	Status      string      `json:"status"` // waiting, matched, connected, disconnected
 	ConnectedAt time.Time   `json:"connected_at"`
 	Connection  *Connection `json:"-"` // Don't serialize connection
 	PartnerID   string      `json:"partner_id,omitempty"`
 	RoomID      string      `json:"room_id,omitempty"`
+	LastRoomID  string      `json:"-"` // kept after the room ends, for add_friend
 	CallState   CallState   `json:"call_state"`
 	MediaInfo   *MediaInfo  `json:"media_info,omitempty"`
+	Away        bool        `json:"away,omitempty"` // set by the client, e.g. while the app is in the background
+	DisplayName string      `json:"display_name"`   // generated on connect, unique among connected users
+	AvatarSeed  string      `json:"avatar_seed"`
+
+	// accountMutex guards AccountID once the user is in the pool, as an
+	// account upgrade sets it while the session's goroutine reads it
+	accountMutex sync.RWMutex
+}
+
+// Account returns the user's account ID, or "" for an anonymous session
+func (u *User) Account() string {
+	u.accountMutex.RLock()
+	defer u.accountMutex.RUnlock()
+	return u.AccountID
+}
+
+// setAccount attaches an account to the user
+func (u *User) setAccount(accountID string) {
+	u.accountMutex.Lock()
+	defer u.accountMutex.Unlock()
+	u.AccountID = accountID
+}
+
+// Identity returns the key used to recognise this user across moderation
+// actions such as shadow bans. Registered users are recognised by account so
+// that moderation follows them across devices; anonymous users by device so
+// that it survives reconnecting.
+func (u *User) Identity() string {
+	if accountID := u.Account(); accountID != "" {
+		return accountID
+	}
+	if u.DeviceID != "" {
+		return u.DeviceID
+	}
+	return u.ID
+}
+
+// Identities returns every key this user can be recognised by. Moderation
+// recorded against any of them applies, so a banned device stays banned
+// after logging in to an account and vice versa.
+func (u *User) Identities() []string {
+	identities := make([]string, 0, 3)
+	for _, identity := range []string{u.Account(), u.DeviceID, u.ID} {
+		if identity != "" {
+			identities = append(identities, identity)
+		}
+	}
+	return identities
+}
+
+// hasIdentity reports whether the user is recognised by identity
+func (u *User) hasIdentity(identity string) bool {
+	for _, own := range u.Identities() {
+		if own == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOwner reports whether two sessions belong to the same account or device
+func (u *User) sameOwner(other *User) bool {
+	return (u.Account() != "" && u.Account() == other.Account()) ||
+		(u.DeviceID != "" && u.DeviceID == other.DeviceID)
 }
 
 type MediaInfo struct {
@@ -79,27 +172,76 @@ type Room struct {
 	CallState CallState  `json:"call_state"`
 	StartedAt *time.Time `json:"started_at,omitempty"`
 	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Direct    bool       `json:"direct,omitempty"` // a call between friends rather than a random match
+
+	identities     [2]string       // participants' identities when the room was created
+	friendRequests map[string]bool // user ID -> sent add_friend
+	friendsMade    bool
+	contacts       map[string]string // user ID -> handle sealed until both have shared
+}
+
+// ShadowBan records why and when an identity was moved into the shadow pool
+type ShadowBan struct {
+	Identity  string    `json:"identity"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserSnapshot is a point-in-time view of a user for the admin API
+type UserSnapshot struct {
+	ID            string    `json:"id"`
+	DisplayName   string    `json:"display_name,omitempty"`
+	AccountID     string    `json:"account_id,omitempty"`
+	DeviceID      string    `json:"device_id,omitempty"`
+	Status        string    `json:"status"`
+	CallState     CallState `json:"call_state"`
+	RoomID        string    `json:"room_id,omitempty"`
+	PartnerID     string    `json:"partner_id,omitempty"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	ConnectionAge float64   `json:"connection_age_seconds"`
+	LastPing      time.Time `json:"last_ping,omitempty"`
+	ShadowBanned  bool      `json:"shadow_banned,omitempty"`
+}
+
+// RoomSnapshot is a point-in-time view of a room for the admin API
+type RoomSnapshot struct {
+	ID           string     `json:"id"`
+	Participants []string   `json:"participants"`
+	IsActive     bool       `json:"is_active"`
+	CallState    CallState  `json:"call_state"`
+	CreatedAt    time.Time  `json:"created_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	EndedAt      *time.Time `json:"ended_at,omitempty"`
 }
 
 type UserPool struct {
-	WaitingUsers map[string]*User
-	ActiveUsers  map[string]*User
-	Rooms        map[string]*Room
-	UserRooms    map[string]string // userID -> roomID mapping
-	mutex        sync.RWMutex
-	ctx          context.Context
-	cancel       context.CancelFunc
+	WaitingUsers       map[string]*User
+	ShadowWaitingUsers map[string]*User      // shadow-banned users, only matched with each other
+	ShadowBans         map[string]*ShadowBan // identity -> shadow ban
+	ActiveUsers        map[string]*User
+	Rooms              map[string]*Room
+	UserRooms          map[string]string           // userID -> roomID mapping
+	sessions           map[string]map[string]*User // identity -> user ID -> connected session
+	displayNames       map[string]string           // lowercased display name -> user ID
+	presence           *PresenceTracker            // told about status changes when presence is enabled
+	mutex              sync.RWMutex
+	ctx                context.Context
+	cancel             context.CancelFunc
 }
 
 func NewUserPool() *UserPool {
 	ctx, cancel := context.WithCancel(context.Background())
 	pool := &UserPool{
-		WaitingUsers: make(map[string]*User),
-		ActiveUsers:  make(map[string]*User),
-		Rooms:        make(map[string]*Room),
-		UserRooms:    make(map[string]string),
-		ctx:          ctx,
-		cancel:       cancel,
+		WaitingUsers:       make(map[string]*User),
+		ShadowWaitingUsers: make(map[string]*User),
+		ShadowBans:         make(map[string]*ShadowBan),
+		ActiveUsers:        make(map[string]*User),
+		Rooms:              make(map[string]*Room),
+		UserRooms:          make(map[string]string),
+		sessions:           make(map[string]map[string]*User),
+		displayNames:       make(map[string]string),
+		ctx:                ctx,
+		cancel:             cancel,
 	}
 
 	// Start cleanup goroutine
@@ -112,54 +254,139 @@ func (p *UserPool) AddWaitingUser(user *User) {
 	defer p.mutex.Unlock()
 	user.Status = StatusWaiting
 	user.ConnectedAt = time.Now()
-	p.WaitingUsers[user.ID] = user
+	p.waitingPoolFor(user)[user.ID] = user
+	p.indexSessionLocked(user)
+	p.presenceChanged(user)
+}
+
+// indexSessionLocked records a connected user under each of its identities.
+// Caller must hold the lock.
+func (p *UserPool) indexSessionLocked(user *User) {
+	for _, identity := range user.Identities() {
+		if p.sessions[identity] == nil {
+			p.sessions[identity] = make(map[string]*User)
+		}
+		p.sessions[identity][user.ID] = user
+	}
+}
+
+// unindexSessionLocked forgets a disconnected user, along with its presence
+// subscription. Caller must hold the lock.
+func (p *UserPool) unindexSessionLocked(user *User) {
+	for _, identity := range user.Identities() {
+		delete(p.sessions[identity], user.ID)
+		if len(p.sessions[identity]) == 0 {
+			delete(p.sessions, identity)
+		}
+	}
+	if p.presence != nil {
+		p.presence.Unsubscribe(user.ID)
+	}
 }
 
+// GetRandomWaitingUser returns a waiting user other than excludeID. Users in
+// the shadow pool are only ever offered other shadow-banned users, and
+// sessions from the same account or device are never offered each other.
 func (p *UserPool) GetRandomWaitingUser(excludeID string) *User {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
-	for id, user := range p.WaitingUsers {
-		if id != excludeID {
+	seeker := p.getUserLocked(excludeID)
+	candidates := p.WaitingUsers
+	if _, shadowed := p.ShadowWaitingUsers[excludeID]; shadowed {
+		candidates = p.ShadowWaitingUsers
+	} else if user := p.ActiveUsers[excludeID]; user != nil && p.isShadowBannedLocked(user) {
+		candidates = p.ShadowWaitingUsers
+	}
+
+	for id, user := range candidates {
+		if id != excludeID && (seeker == nil || !seeker.sameOwner(user)) {
 			return user
 		}
 	}
 	return nil
 }
 
+// waitingPoolFor returns the waiting map the user belongs in. Caller must hold the lock.
+func (p *UserPool) waitingPoolFor(user *User) map[string]*User {
+	if p.isShadowBannedLocked(user) {
+		return p.ShadowWaitingUsers
+	}
+	return p.WaitingUsers
+}
+
+// isShadowBannedLocked reports whether any of the user's identities is
+// shadow-banned. Caller must hold the lock.
+func (p *UserPool) isShadowBannedLocked(user *User) bool {
+	for _, identity := range user.Identities() {
+		if p.ShadowBans[identity] != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *UserPool) CreateRoom(user1 *User, user2 *User) *Room {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
+	return p.createRoomLocked(user1, user2)
+}
+
+// CreateDirectRoom puts a caller and a friend they are calling into a room
+// without going through matchmaking. Returns nil if either is already in a
+// room or has disconnected.
+func (p *UserPool) CreateDirectRoom(caller, callee *User) *Room {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, user := range []*User{caller, callee} {
+		if p.WaitingUsers[user.ID] == nil && p.ShadowWaitingUsers[user.ID] == nil {
+			return nil
+		}
+	}
+
+	room := p.createRoomLocked(caller, callee)
+	room.Direct = true
+	return room
+}
 
+// createRoomLocked creates a room for two users. Caller must hold the lock.
+func (p *UserPool) createRoomLocked(user1 *User, user2 *User) *Room {
 	roomID := generateRoomID()
 	room := &Room{
-		ID:        roomID,
-		User1ID:   user1.ID,
-		User2ID:   user2.ID,
-		CreatedAt: time.Now(),
-		IsActive:  true,
-		CallState: CallState(CallStateIdle),
+		ID:         roomID,
+		User1ID:    user1.ID,
+		User2ID:    user2.ID,
+		CreatedAt:  time.Now(),
+		IsActive:   true,
+		CallState:  CallState(CallStateIdle),
+		identities: [2]string{user1.Identity(), user2.Identity()},
 	}
 
 	// Update users
 	user1.Status = StatusConnected
 	user1.PartnerID = user2.ID
 	user1.RoomID = roomID
+	user1.LastRoomID = roomID
 	user1.CallState = CallState(CallStateIdle)
 
 	user2.Status = StatusConnected
 	user2.PartnerID = user1.ID
 	user2.RoomID = roomID
+	user2.LastRoomID = roomID
 	user2.CallState = CallState(CallStateIdle)
 
 	// Move users to active and create room mappings
 	delete(p.WaitingUsers, user1.ID)
 	delete(p.WaitingUsers, user2.ID)
+	delete(p.ShadowWaitingUsers, user1.ID)
+	delete(p.ShadowWaitingUsers, user2.ID)
 	p.ActiveUsers[user1.ID] = user1
 	p.ActiveUsers[user2.ID] = user2
 	p.Rooms[roomID] = room
 	p.UserRooms[user1.ID] = roomID
 	p.UserRooms[user2.ID] = roomID
+	p.presenceChanged(user1, user2)
 
 	return room
 }
@@ -172,6 +399,10 @@ func (p *UserPool) MoveToActive(userID string) {
 		delete(p.WaitingUsers, userID)
 		p.ActiveUsers[userID] = user
 		user.Status = StatusConnected
+	} else if user, exists := p.ShadowWaitingUsers[userID]; exists {
+		delete(p.ShadowWaitingUsers, userID)
+		p.ActiveUsers[userID] = user
+		user.Status = StatusConnected
 	}
 }
 
@@ -182,13 +413,53 @@ func (p *UserPool) GetActiveUser(userID string) *User {
 	return p.ActiveUsers[userID]
 }
 
+// GetRoom returns a room by ID
+func (p *UserPool) GetRoom(roomID string) *Room {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.Rooms[roomID]
+}
+
 func (p *UserPool) GetUser(userID string) *User {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
+	return p.getUserLocked(userID)
+}
+
+// SessionToken returns the current session token of a user, or "" if the
+// user is not in the pool
+func (p *UserPool) SessionToken(userID string) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if user := p.getUserLocked(userID); user != nil {
+		return user.SessionID
+	}
+	return ""
+}
+
+// SetSessionToken replaces a user's session token after a refresh
+func (p *UserPool) SetSessionToken(userID, token string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	user := p.getUserLocked(userID)
+	if user == nil {
+		return false
+	}
+	user.SessionID = token
+	return true
+}
+
+// getUserLocked looks a user up in every pool. Caller must hold the lock.
+func (p *UserPool) getUserLocked(userID string) *User {
 	if user := p.WaitingUsers[userID]; user != nil {
 		return user
 	}
+	if user := p.ShadowWaitingUsers[userID]; user != nil {
+		return user
+	}
 	return p.ActiveUsers[userID]
 }
 
@@ -200,6 +471,7 @@ func (p *UserPool) RemoveUser(userID string) {
 	if roomID, exists := p.UserRooms[userID]; exists {
 		if room := p.Rooms[roomID]; room != nil {
 			room.IsActive = false
+			markRoomEnded(room)
 			// Remove partner's room mapping too
 			partnerID := ""
 			if room.User1ID == userID {
@@ -212,7 +484,14 @@ func (p *UserPool) RemoveUser(userID string) {
 		delete(p.UserRooms, userID)
 	}
 
+	user := p.getUserLocked(userID)
+	if user != nil {
+		p.unindexSessionLocked(user)
+	}
+	p.presenceChanged(user)
+	p.releaseDisplayNameLocked(user)
 	delete(p.WaitingUsers, userID)
+	delete(p.ShadowWaitingUsers, userID)
 	delete(p.ActiveUsers, userID)
 }
 
@@ -240,10 +519,11 @@ func (p *UserPool) MoveToWaiting(userID string) {
 
 	if user, exists := p.ActiveUsers[userID]; exists {
 		delete(p.ActiveUsers, userID)
-		p.WaitingUsers[userID] = user
+		p.waitingPoolFor(user)[userID] = user
 		user.Status = "waiting"
 		user.PartnerID = ""
 		user.RoomID = ""
+		p.presenceChanged(user)
 	}
 }
 
@@ -251,11 +531,332 @@ func (p *UserPool) GetStats() map[string]int {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
+	devices := make(map[string]bool)
+	for _, pool := range []map[string]*User{p.WaitingUsers, p.ShadowWaitingUsers, p.ActiveUsers} {
+		for _, user := range pool {
+			if user.DeviceID != "" {
+				devices[user.DeviceID] = true
+			}
+		}
+	}
+
 	return map[string]int{
-		"waiting_users": len(p.WaitingUsers),
-		"active_users":  len(p.ActiveUsers),
-		"active_rooms":  len(p.Rooms),
+		"waiting_users":        len(p.WaitingUsers),
+		"shadow_waiting_users": len(p.ShadowWaitingUsers),
+		"shadow_bans":          len(p.ShadowBans),
+		"active_users":         len(p.ActiveUsers),
+		"active_rooms":         len(p.Rooms),
+		"connected_devices":    len(devices),
+	}
+}
+
+// ShadowBanIdentity moves an identity into the shadow pool. Any of its users
+// currently waiting are moved immediately; users in a room are moved the next
+// time they return to waiting. Returns false if the identity was already banned.
+func (p *UserPool) ShadowBanIdentity(identity, reason string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, exists := p.ShadowBans[identity]; exists {
+		return false
+	}
+
+	p.ShadowBans[identity] = &ShadowBan{
+		Identity:  identity,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+
+	for id, user := range p.WaitingUsers {
+		if user.hasIdentity(identity) {
+			delete(p.WaitingUsers, id)
+			p.ShadowWaitingUsers[id] = user
+		}
+	}
+	return true
+}
+
+// LiftShadowBan moves an identity back into the regular pool, unless its
+// users are also banned under another identity. Returns false if the
+// identity was not shadow-banned.
+func (p *UserPool) LiftShadowBan(identity string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, exists := p.ShadowBans[identity]; !exists {
+		return false
 	}
+	delete(p.ShadowBans, identity)
+
+	for id, user := range p.ShadowWaitingUsers {
+		if user.hasIdentity(identity) && !p.isShadowBannedLocked(user) {
+			delete(p.ShadowWaitingUsers, id)
+			p.WaitingUsers[id] = user
+		}
+	}
+	return true
+}
+
+// LinkAccount attaches an account to a user upgrading from an anonymous
+// session. Moderation state recorded against the anonymous identity carries
+// over to the account, so upgrading cannot be used to shed a shadow ban. A
+// ban on the session itself moves; a ban on the device is copied, since the
+// device stays banned for its other sessions. deviceID is the device of a
+// session that is not connected; a connected session's own device is used
+// otherwise.
+func (p *UserPool) LinkAccount(userID, deviceID, accountID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	user := p.getUserLocked(userID)
+	if user != nil {
+		deviceID = user.DeviceID
+	}
+	anonymous := []string{userID}
+	if deviceID != "" {
+		anonymous = append(anonymous, deviceID)
+	}
+
+	for _, identity := range anonymous {
+		ban := p.ShadowBans[identity]
+		if ban == nil {
+			continue
+		}
+		if identity == userID {
+			delete(p.ShadowBans, userID)
+		}
+		if _, exists := p.ShadowBans[accountID]; !exists {
+			carried := *ban
+			carried.Identity = accountID
+			p.ShadowBans[accountID] = &carried
+		}
+	}
+
+	if user == nil {
+		return
+	}
+	user.setAccount(accountID)
+	p.indexSessionLocked(user)
+	p.presenceChanged(user)
+
+	// A waiting user moves pools if the account carries a ban of its own
+	if _, waiting := p.WaitingUsers[userID]; waiting && p.isShadowBannedLocked(user) {
+		delete(p.WaitingUsers, userID)
+		p.ShadowWaitingUsers[userID] = user
+	}
+}
+
+// IsShadowBanned reports whether an identity is in the shadow pool
+func (p *UserPool) IsShadowBanned(identity string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	_, exists := p.ShadowBans[identity]
+	return exists
+}
+
+// ListShadowBans returns a snapshot of all shadow bans
+func (p *UserPool) ListShadowBans() []ShadowBan {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	bans := make([]ShadowBan, 0, len(p.ShadowBans))
+	for _, ban := range p.ShadowBans {
+		bans = append(bans, *ban)
+	}
+	return bans
+}
+
+// ListUsers returns snapshots of all waiting and active users
+func (p *UserPool) ListUsers() []UserSnapshot {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	now := time.Now()
+	users := make([]UserSnapshot, 0, len(p.WaitingUsers)+len(p.ShadowWaitingUsers)+len(p.ActiveUsers))
+	for _, pool := range []map[string]*User{p.WaitingUsers, p.ShadowWaitingUsers, p.ActiveUsers} {
+		for _, user := range pool {
+			snapshot := UserSnapshot{
+				ID:            user.ID,
+				DisplayName:   user.DisplayName,
+				AccountID:     user.Account(),
+				DeviceID:      user.DeviceID,
+				Status:        user.Status,
+				CallState:     user.CallState,
+				RoomID:        user.RoomID,
+				PartnerID:     user.PartnerID,
+				ConnectedAt:   user.ConnectedAt,
+				ConnectionAge: now.Sub(user.ConnectedAt).Seconds(),
+				ShadowBanned:  p.isShadowBannedLocked(user),
+			}
+			if user.Connection != nil {
+				snapshot.LastPing = user.Connection.LastPing
+			}
+			users = append(users, snapshot)
+		}
+	}
+	return users
+}
+
+// ListRooms returns snapshots of all rooms with their participants
+func (p *UserPool) ListRooms() []RoomSnapshot {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	rooms := make([]RoomSnapshot, 0, len(p.Rooms))
+	for _, room := range p.Rooms {
+		rooms = append(rooms, RoomSnapshot{
+			ID:           room.ID,
+			Participants: []string{room.User1ID, room.User2ID},
+			IsActive:     room.IsActive,
+			CallState:    room.CallState,
+			CreatedAt:    room.CreatedAt,
+			StartedAt:    room.StartedAt,
+			EndedAt:      room.EndedAt,
+		})
+	}
+	return rooms
+}
+
+// KickUser sends a kicked message to the user and closes their connection.
+// The connection's read loop then performs the usual disconnect cleanup.
+// Returns false if the user is not connected.
+func (p *UserPool) KickUser(userID, reason string) bool {
+	p.mutex.RLock()
+	user := p.getUserLocked(userID)
+	p.mutex.RUnlock()
+	if user == nil {
+		return false
+	}
+
+	// Written without the pool lock so a stuck peer cannot stall the pool
+	if user.Connection != nil {
+		user.Connection.WriteJSON(map[string]interface{}{
+			"type":      MessageTypeKicked,
+			"timestamp": time.Now(),
+			"payload": map[string]string{
+				"reason": reason,
+			},
+		})
+		user.Connection.Close()
+	}
+	return true
+}
+
+// EndRoom force-ends an active room, notifies both participants and moves
+// them back to waiting. Returns false if the room does not exist or has
+// already ended.
+func (p *UserPool) EndRoom(roomID, reason string) bool {
+	return p.endRoomIf(roomID, reason, nil)
+}
+
+// EndUserCall hangs up the call in a user's room. A direct call ends the
+// room, as EndRoom does, freeing both users for other calls. A random match
+// keeps the pair matched and only marks the call ended, discarding
+// unrevealed contact handles.
+func (p *UserPool) EndUserCall(userID, reason string) bool {
+	p.mutex.Lock()
+	room := p.Rooms[p.UserRooms[userID]]
+	if room == nil || !room.IsActive {
+		p.mutex.Unlock()
+		return false
+	}
+	if room.Direct {
+		p.mutex.Unlock()
+		return p.EndRoom(room.ID, reason)
+	}
+	defer p.mutex.Unlock()
+
+	room.CallState = CallState(CallStateEnded)
+	markRoomEnded(room)
+	for _, id := range []string{room.User1ID, room.User2ID} {
+		if user := p.ActiveUsers[id]; user != nil {
+			user.CallState = CallState(CallStateEnded)
+		}
+	}
+	return true
+}
+
+// EndUnansweredRoom ends a room, as EndRoom does, unless its call has been
+// answered
+func (p *UserPool) EndUnansweredRoom(roomID, reason string) bool {
+	return p.endRoomIf(roomID, reason, func(room *Room) bool {
+		return room.CallState != CallState(CallStateAnswered)
+	})
+}
+
+// AnswerCall marks the call in a user's room as answered
+func (p *UserPool) AnswerCall(userID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if room := p.Rooms[p.UserRooms[userID]]; room != nil && room.IsActive {
+		room.CallState = CallState(CallStateAnswered)
+	}
+}
+
+// endRoomIf ends a room and tells its participants, provided the room passes
+// check (when set)
+func (p *UserPool) endRoomIf(roomID, reason string, check func(room *Room) bool) bool {
+	p.mutex.Lock()
+	if room := p.Rooms[roomID]; check != nil && (room == nil || !check(room)) {
+		p.mutex.Unlock()
+		return false
+	}
+	notify, ok := p.endRoomLocked(roomID)
+	p.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	// Written without the pool lock so a stuck peer cannot stall the pool
+	for _, conn := range notify {
+		conn.WriteJSON(map[string]interface{}{
+			"type":      MessageTypeRoomEnded,
+			"timestamp": time.Now(),
+			"payload": map[string]string{
+				"room_id": roomID,
+				"reason":  reason,
+			},
+		})
+	}
+	return true
+}
+
+// endRoomLocked ends a room and moves its participants back to waiting,
+// returning the connections to notify. Caller must hold the lock.
+func (p *UserPool) endRoomLocked(roomID string) ([]*Connection, bool) {
+	room := p.Rooms[roomID]
+	if room == nil || !room.IsActive {
+		return nil, false
+	}
+
+	room.IsActive = false
+	room.CallState = CallState(CallStateEnded)
+	markRoomEnded(room)
+
+	var notify []*Connection
+	for _, userID := range []string{room.User1ID, room.User2ID} {
+		delete(p.UserRooms, userID)
+
+		user := p.ActiveUsers[userID]
+		if user == nil {
+			continue
+		}
+
+		delete(p.ActiveUsers, userID)
+		p.waitingPoolFor(user)[userID] = user
+		user.Status = StatusWaiting
+		user.PartnerID = ""
+		user.RoomID = ""
+		user.CallState = CallState(CallStateEnded)
+		p.presenceChanged(user)
+
+		if user.Connection != nil {
+			notify = append(notify, user.Connection)
+		}
+	}
+	return notify, true
 }
 
 // Cleanup inactive connections periodically
@@ -280,10 +881,15 @@ func (p *UserPool) performCleanup() {
 	cutoff := time.Now().Add(-5 * time.Minute)
 
 	// Clean up waiting users with old connections
-	for id, user := range p.WaitingUsers {
-		if user.Connection != nil && user.Connection.LastPing.Before(cutoff) {
-			delete(p.WaitingUsers, id)
-			user.Connection.Close()
+	for _, waiting := range []map[string]*User{p.WaitingUsers, p.ShadowWaitingUsers} {
+		for id, user := range waiting {
+			if user.Connection != nil && user.Connection.LastPing.Before(cutoff) {
+				delete(waiting, id)
+				user.Connection.Close()
+				p.unindexSessionLocked(user)
+				p.presenceChanged(user)
+				p.releaseDisplayNameLocked(user)
+			}
 		}
 	}
 
@@ -292,10 +898,14 @@ func (p *UserPool) performCleanup() {
 		if user.Connection != nil && user.Connection.LastPing.Before(cutoff) {
 			delete(p.ActiveUsers, id)
 			user.Connection.Close()
+			p.unindexSessionLocked(user)
+			p.presenceChanged(user)
+			p.releaseDisplayNameLocked(user)
 			// Also clean up room
 			if roomID := p.UserRooms[id]; roomID != "" {
 				if room := p.Rooms[roomID]; room != nil {
 					room.IsActive = false
+					markRoomEnded(room)
 				}
 				delete(p.UserRooms, id)
 			}
@@ -303,11 +913,41 @@ func (p *UserPool) performCleanup() {
 	}
 }
 
+// SetAway records whether the client says the user is away
+func (p *UserPool) SetAway(userID string, away bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	user := p.getUserLocked(userID)
+	if user == nil {
+		return false
+	}
+	if user.Away != away {
+		user.Away = away
+		p.presenceChanged(user)
+	}
+	return true
+}
+
+// presenceChanged tells the presence tracker that users' status may have
+// changed. Caller must hold the lock.
+func (p *UserPool) presenceChanged(users ...*User) {
+	if p.presence == nil {
+		return
+	}
+	for _, user := range users {
+		if user != nil {
+			p.presence.markChanged(user.Identities()...)
+		}
+	}
+}
+
 func (p *UserPool) Shutdown() {
 	p.cancel()
 }
 
+// generateRoomID returns a random room ID. Rooms are looked up, ended and
+// hold sealed contact handles by ID, so IDs must never collide.
 func generateRoomID() string {
-	// Simple room ID generation - in production, use UUID
-	return time.Now().Format("20060102150405") + "-room"
+	return uuid.New().String()
 }
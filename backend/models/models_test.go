@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConnection_UpdatePing(t *testing.T) {
@@ -36,6 +37,29 @@ func TestConnection_Close(t *testing.T) {
 	assert.False(t, conn.IsActive)
 }
 
+func TestConnection_OnCloseRunsOnceOnCleanup(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	released := 0
+	user := &User{
+		ID: "stale-user",
+		Connection: &Connection{
+			UserID:   "stale-user",
+			LastPing: time.Now().Add(-time.Hour),
+			IsActive: true,
+			OnClose:  func() { released++ },
+		},
+	}
+	pool.AddWaitingUser(user)
+
+	pool.performCleanup()
+	user.Connection.Close()
+
+	assert.Equal(t, 1, released)
+	assert.False(t, user.Connection.IsActive)
+}
+
 func TestNewUserPool(t *testing.T) {
 	pool := NewUserPool()
 
@@ -155,6 +179,60 @@ func TestUserPool_RemoveUser(t *testing.T) {
 	assert.Equal(t, "", pool.UserRooms[user2.ID])
 }
 
+func TestUserPool_EndUnansweredRoom(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	caller := &User{ID: "caller", Connection: &Connection{UserID: "caller", IsActive: true}}
+	callee := &User{ID: "callee", Connection: &Connection{UserID: "callee", IsActive: true}}
+	pool.AddWaitingUser(caller)
+	pool.AddWaitingUser(callee)
+
+	// An answered call keeps ringing timeouts from ending it
+	room := pool.CreateDirectRoom(caller, callee)
+	require.NotNil(t, room)
+	pool.AnswerCall(callee.ID)
+	assert.False(t, pool.EndUnansweredRoom(room.ID, "timeout"))
+	assert.True(t, pool.GetRoom(room.ID).IsActive)
+
+	// Hanging up ends the room and returns both users to waiting
+	assert.True(t, pool.EndUserCall(caller.ID, "hung up"))
+	assert.False(t, pool.GetRoom(room.ID).IsActive)
+	assert.NotNil(t, pool.GetRoom(room.ID).EndedAt)
+	assert.Equal(t, 2, pool.GetStats()["waiting_users"])
+	assert.False(t, pool.EndUserCall(caller.ID, "hung up"))
+
+	unanswered := pool.CreateDirectRoom(caller, callee)
+	require.NotNil(t, unanswered)
+	assert.True(t, pool.EndUnansweredRoom(unanswered.ID, "timeout"))
+}
+
+func TestUserPool_EndRoomEndsOnlyThatRoom(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	users := make(map[string]*User)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		users[id] = &User{ID: id, Connection: &Connection{UserID: id, IsActive: true}}
+		pool.AddWaitingUser(users[id])
+	}
+
+	// Rooms created back to back, within the same second
+	first := pool.CreateRoom(users["a"], users["b"])
+	second := pool.CreateDirectRoom(users["c"], users["d"])
+	require.NotNil(t, second)
+	require.NotEqual(t, first.ID, second.ID)
+
+	assert.True(t, pool.EndRoom(first.ID, "ended by a moderator"))
+	assert.False(t, pool.GetRoom(first.ID).IsActive)
+	assert.True(t, pool.GetRoom(second.ID).IsActive)
+	assert.Equal(t, "d", pool.FindPartner("c").ID)
+
+	// A ringing timeout for the first room cannot end the second either
+	assert.False(t, pool.EndUnansweredRoom(first.ID, "timeout"))
+	assert.True(t, pool.GetRoom(second.ID).IsActive)
+}
+
 func TestUserPool_ConcurrentAccess(t *testing.T) {
 	pool := NewUserPool()
 	defer pool.Shutdown()
@@ -231,6 +309,118 @@ func TestUserPool_Stats(t *testing.T) {
 	assert.Equal(t, 1, stats["active_rooms"])
 }
 
+func TestUserPool_ShadowBan(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	regular := &User{ID: "regular", Connection: &Connection{UserID: "regular", IsActive: true}}
+	abuser1 := &User{ID: "abuser1", Connection: &Connection{UserID: "abuser1", IsActive: true}}
+	abuser2 := &User{ID: "abuser2", Connection: &Connection{UserID: "abuser2", IsActive: true}}
+
+	pool.AddWaitingUser(regular)
+	pool.AddWaitingUser(abuser1)
+
+	// Banning moves a waiting user into the shadow pool
+	assert.True(t, pool.ShadowBanIdentity("abuser1", "spam"))
+	assert.False(t, pool.ShadowBanIdentity("abuser1", "spam"))
+	assert.True(t, pool.IsShadowBanned("abuser1"))
+	assert.Nil(t, pool.WaitingUsers["abuser1"])
+	assert.Equal(t, abuser1, pool.ShadowWaitingUsers["abuser1"])
+	assert.Equal(t, StatusWaiting, abuser1.Status)
+
+	// Pools are isolated from each other
+	assert.Nil(t, pool.GetRandomWaitingUser("abuser1"))
+	assert.Nil(t, pool.GetRandomWaitingUser("regular"))
+
+	// New users with a banned identity join the shadow pool
+	pool.ShadowBanIdentity("abuser2", "")
+	pool.AddWaitingUser(abuser2)
+	assert.Equal(t, abuser2, pool.GetRandomWaitingUser("abuser1"))
+
+	room := pool.CreateRoom(abuser1, abuser2)
+	assert.Empty(t, pool.ShadowWaitingUsers)
+
+	// Returning to waiting keeps them in the shadow pool
+	pool.MoveToWaiting(abuser1.ID)
+	assert.Equal(t, abuser1, pool.ShadowWaitingUsers["abuser1"])
+	assert.NotEmpty(t, room.ID)
+
+	// Lifting the ban moves them back
+	assert.True(t, pool.LiftShadowBan("abuser1"))
+	assert.False(t, pool.LiftShadowBan("abuser1"))
+	assert.Equal(t, abuser1, pool.WaitingUsers["abuser1"])
+	assert.Equal(t, abuser1, pool.GetRandomWaitingUser("regular"))
+
+	stats := pool.GetStats()
+	assert.Equal(t, 1, stats["shadow_bans"])
+	assert.Len(t, pool.ListShadowBans(), 1)
+}
+
+func TestUserPool_LinkAccountKeepsShadowBan(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	user := &User{ID: "anon-user", Connection: &Connection{UserID: "anon-user", IsActive: true}}
+	pool.AddWaitingUser(user)
+	assert.True(t, pool.ShadowBanIdentity("anon-user", "spam"))
+
+	pool.LinkAccount("anon-user", "", "account-1")
+
+	assert.Equal(t, "account-1", user.Identity())
+	assert.True(t, pool.IsShadowBanned("account-1"), "the ban follows the upgraded identity")
+	assert.False(t, pool.IsShadowBanned("anon-user"))
+	assert.Len(t, pool.ListShadowBans(), 1)
+	assert.Equal(t, "account-1", pool.ListShadowBans()[0].Identity)
+
+	// Linking to an account that is banned itself moves a waiting user
+	clean := &User{ID: "clean-user", Connection: &Connection{UserID: "clean-user", IsActive: true}}
+	pool.AddWaitingUser(clean)
+	pool.ShadowBanIdentity("account-2", "ban evasion")
+	pool.LinkAccount("clean-user", "", "account-2")
+
+	stats := pool.GetStats()
+	assert.Equal(t, 2, stats["shadow_waiting_users"])
+	assert.Equal(t, 0, stats["waiting_users"])
+}
+
+func TestUserPool_DeviceIdentity(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+
+	first := &User{ID: "session-1", DeviceID: "device-1", Connection: &Connection{UserID: "session-1", IsActive: true}}
+	second := &User{ID: "session-2", DeviceID: "device-1", Connection: &Connection{UserID: "session-2", IsActive: true}}
+	other := &User{ID: "session-3", DeviceID: "device-2", Connection: &Connection{UserID: "session-3", IsActive: true}}
+	pool.AddWaitingUser(first)
+	pool.AddWaitingUser(second)
+
+	// Two sessions from one device are never matched with each other
+	assert.Equal(t, "device-1", first.Identity())
+	assert.Nil(t, pool.GetRandomWaitingUser("session-1"))
+	pool.AddWaitingUser(other)
+	assert.Same(t, other, pool.GetRandomWaitingUser("session-1"))
+	assert.Equal(t, 2, pool.GetStats()["connected_devices"])
+
+	// A device ban applies to every session from the device, and to a
+	// session that later logs in to an account
+	assert.True(t, pool.ShadowBanIdentity("device-1", "spam"))
+	assert.Equal(t, 2, pool.GetStats()["shadow_waiting_users"])
+	pool.LinkAccount("session-1", "", "account-1")
+	assert.True(t, pool.IsShadowBanned("device-1"), "the device stays banned")
+	assert.True(t, pool.IsShadowBanned("account-1"), "the account inherits the ban")
+
+	// A session that is not connected passes its device along
+	assert.True(t, pool.ShadowBanIdentity("device-3", "spam"))
+	pool.LinkAccount("session-offline", "device-3", "account-3")
+	assert.True(t, pool.IsShadowBanned("account-3"), "the account inherits the device ban")
+
+	// Lifting one ban leaves users banned under another identity in the
+	// shadow pool
+	assert.True(t, pool.LiftShadowBan("device-1"))
+	stats := pool.GetStats()
+	assert.Equal(t, 1, stats["shadow_waiting_users"])
+	assert.Equal(t, 2, stats["waiting_users"])
+}
+
 // Race condition test for matchmaking
 func TestUserPool_MatchmakingRaceCondition(t *testing.T) {
 	pool := NewUserPool()
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// presenceRank orders states so that a friend connected from several
+// sessions shows the most engaged one
+var presenceRank = map[string]int{
+	PresenceOffline: 0,
+	PresenceAway:    1,
+	PresenceOnline:  2,
+	PresenceInCall:  3,
+}
+
+// PresenceUpdate is one friend's state in a presence message
+type PresenceUpdate struct {
+	FriendID string `json:"friend_id"`
+	Status   string `json:"status"`
+}
+
+// PresenceTracker tells subscribed users when their friends come online,
+// start or finish a call, go away or disconnect. The pool reports changes as
+// they happen; they are collected for a short window and only states that
+// differ from what friends were last told are sent.
+type PresenceTracker struct {
+	pool        *UserPool
+	friends     *FriendStore
+	window      time.Duration
+	subscribers map[string]*User  // user ID -> subscribed session
+	hidden      map[string]bool   // identities that appear offline
+	published   map[string]string // identity -> state friends were last told
+	pending     map[string]bool   // identities changed since the last flush
+	flushing    bool
+	sent        int
+	coalesced   int
+	mutex       sync.Mutex
+}
+
+// NewPresenceTracker creates a presence tracker and attaches it to the pool.
+// Changes are sent to friends at most once per window.
+func NewPresenceTracker(pool *UserPool, friends *FriendStore, window time.Duration) *PresenceTracker {
+	if window <= 0 {
+		window = DefaultPresenceCoalesceWindow
+	}
+
+	t := &PresenceTracker{
+		pool:        pool,
+		friends:     friends,
+		window:      window,
+		subscribers: make(map[string]*User),
+		hidden:      make(map[string]bool),
+		published:   make(map[string]string),
+		pending:     make(map[string]bool),
+	}
+
+	pool.mutex.Lock()
+	pool.presence = t
+	pool.mutex.Unlock()
+	return t
+}
+
+// Subscribe starts sending a session its friends' presence changes and
+// returns the states friends were last told. Changes still being collected
+// follow in the next update, so the subscriber never misses a change back.
+func (t *PresenceTracker) Subscribe(user *User) []PresenceUpdate {
+	t.pool.mutex.RLock()
+	defer t.pool.mutex.RUnlock()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.subscribers[user.ID] = user
+
+	updates := make([]PresenceUpdate, 0)
+	for _, identity := range user.Identities() {
+		for _, friendship := range t.friends.friendsOf(identity) {
+			updates = append(updates, PresenceUpdate{
+				FriendID: friendship.ID,
+				Status:   t.publishedLocked(friendship.other(identity)),
+			})
+		}
+	}
+	return updates
+}
+
+// Unsubscribe stops sending a session presence changes
+func (t *PresenceTracker) Unsubscribe(userID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.subscribers, userID)
+}
+
+// SetAppearOffline hides or reveals an identity's presence. A hidden
+// identity shows as offline to friends whatever it is doing.
+func (t *PresenceTracker) SetAppearOffline(identity string, hidden bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if hidden {
+		t.hidden[identity] = true
+	} else {
+		delete(t.hidden, identity)
+	}
+	t.markChangedLocked(identity)
+}
+
+// Status returns the state an identity's friends currently see
+func (t *PresenceTracker) Status(identity string) string {
+	t.pool.mutex.RLock()
+	defer t.pool.mutex.RUnlock()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.presenceLocked(identity)
+}
+
+// GetStats returns presence counters
+func (t *PresenceTracker) GetStats() map[string]interface{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return map[string]interface{}{
+		"subscribers":    len(t.subscribers),
+		"appear_offline": len(t.hidden),
+		"updates_sent":   t.sent,
+		"coalesced":      t.coalesced,
+	}
+}
+
+// markChanged records that identities' states may have changed. The pool
+// calls it with its lock held, so it must not take the pool's lock.
+func (t *PresenceTracker) markChanged(identities ...string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.markChangedLocked(identities...)
+}
+
+func (t *PresenceTracker) markChangedLocked(identities ...string) {
+	for _, identity := range identities {
+		if t.pending[identity] {
+			t.coalesced++
+		}
+		t.pending[identity] = true
+	}
+	if !t.flushing && len(t.pending) > 0 {
+		t.flushing = true
+		time.AfterFunc(t.window, t.flush)
+	}
+}
+
+// flush sends friends the states that changed since they were last told.
+// The pool's lock is always taken before the tracker's.
+func (t *PresenceTracker) flush() {
+	t.pool.mutex.RLock()
+	t.mutex.Lock()
+
+	updates := make(map[*User][]PresenceUpdate)
+	for identity := range t.pending {
+		status := t.presenceLocked(identity)
+		if status == t.publishedLocked(identity) {
+			continue
+		}
+		if status == PresenceOffline {
+			delete(t.published, identity)
+		} else {
+			t.published[identity] = status
+		}
+
+		// Only the friends' own connected sessions are looked at
+		for _, friendship := range t.friends.friendsOf(identity) {
+			for id := range t.pool.sessions[friendship.other(identity)] {
+				if subscriber := t.subscribers[id]; subscriber != nil {
+					updates[subscriber] = append(updates[subscriber], PresenceUpdate{FriendID: friendship.ID, Status: status})
+				}
+			}
+		}
+	}
+	t.pending = make(map[string]bool)
+	t.flushing = false
+	t.sent += len(updates)
+
+	t.mutex.Unlock()
+	t.pool.mutex.RUnlock()
+
+	now := time.Now()
+	for subscriber, friends := range updates {
+		if subscriber.Connection == nil {
+			continue
+		}
+		subscriber.Connection.WriteJSON(map[string]interface{}{
+			"type":      MessageTypePresence,
+			"timestamp": now,
+			"payload": map[string]interface{}{
+				"friends": friends,
+			},
+		})
+	}
+}
+
+func (t *PresenceTracker) publishedLocked(identity string) string {
+	if status, exists := t.published[identity]; exists {
+		return status
+	}
+	return PresenceOffline
+}
+
+// presenceLocked derives an identity's state from its connected sessions.
+// Caller must hold both the pool's and the tracker's locks.
+func (t *PresenceTracker) presenceLocked(identity string) string {
+	best := PresenceOffline
+	for _, user := range t.pool.sessions[identity] {
+		if t.hiddenLocked(user) {
+			continue
+		}
+		if status := userPresence(user); presenceRank[status] > presenceRank[best] {
+			best = status
+		}
+	}
+	return best
+}
+
+func (t *PresenceTracker) hiddenLocked(user *User) bool {
+	for _, identity := range user.Identities() {
+		if t.hidden[identity] {
+			return true
+		}
+	}
+	return false
+}
+
+// userPresence maps a session's status to a presence state. A session in a
+// room is in a call from the moment it is matched or rings a friend; the call
+// state is not consulted since it is left as it was when a partner leaves.
+func userPresence(user *User) string {
+	switch {
+	case user.Status == StatusConnected || user.Status == StatusMatched:
+		return PresenceInCall
+	case user.Away:
+		return PresenceAway
+	default:
+		return PresenceOnline
+	}
+}
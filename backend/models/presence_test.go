@@ -0,0 +1,94 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceTracker(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+	friends := NewFriendStore()
+	friendship := addFriends(t, friends, "device-a", "device-b")
+	presence := NewPresenceTracker(pool, friends, 20*time.Millisecond)
+
+	sent := func() int { return presence.GetStats()["updates_sent"].(int) }
+
+	bob := &User{ID: "session-b", DeviceID: "device-b", Connection: &Connection{UserID: "session-b", IsActive: true}}
+	pool.AddWaitingUser(bob)
+	assert.Equal(t, []PresenceUpdate{{FriendID: friendship.ID, Status: PresenceOffline}}, presence.Subscribe(bob))
+
+	// Coming online is sent to subscribed friends
+	alice := &User{ID: "session-a", DeviceID: "device-a", Connection: &Connection{UserID: "session-a", IsActive: true}}
+	pool.AddWaitingUser(alice)
+	assert.Equal(t, PresenceOnline, presence.Status("device-a"))
+	require.Eventually(t, func() bool { return sent() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, PresenceOnline, presence.Subscribe(bob)[0].Status)
+
+	// A call that starts and ends within the window sends nothing
+	carol := &User{ID: "session-c", DeviceID: "device-c", Connection: &Connection{UserID: "session-c", IsActive: true}}
+	pool.AddWaitingUser(carol)
+	room := pool.CreateRoom(alice, carol)
+	assert.Equal(t, PresenceInCall, presence.Status("device-a"))
+	pool.EndRoom(room.ID, "test")
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, 1, sent())
+	assert.Positive(t, presence.GetStats()["coalesced"])
+
+	// Away and appearing offline are set by the user
+	assert.True(t, pool.SetAway(alice.ID, true))
+	assert.Equal(t, PresenceAway, presence.Status("device-a"))
+	require.Eventually(t, func() bool { return sent() == 2 }, time.Second, 5*time.Millisecond)
+
+	presence.SetAppearOffline("device-a", true)
+	assert.Equal(t, PresenceOffline, presence.Status("device-a"))
+	require.Eventually(t, func() bool { return sent() == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, presence.GetStats()["appear_offline"])
+
+	// An identity with several sessions shows the most engaged one
+	presence.SetAppearOffline("device-a", false)
+	second := &User{ID: "session-a2", DeviceID: "device-a", Connection: &Connection{UserID: "session-a2", IsActive: true}}
+	pool.AddWaitingUser(second)
+	assert.Equal(t, PresenceOnline, presence.Status("device-a"))
+	require.Eventually(t, func() bool { return sent() == 4 }, time.Second, 5*time.Millisecond)
+
+	// Unsubscribed sessions are no longer sent anything
+	presence.Unsubscribe(bob.ID)
+	pool.RemoveUser(alice.ID)
+	pool.RemoveUser(second.ID)
+	assert.Equal(t, PresenceOffline, presence.Status("device-a"))
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, 4, sent())
+	assert.Equal(t, 0, presence.GetStats()["subscribers"])
+}
+
+func TestPresenceTracker_FollowsIdentities(t *testing.T) {
+	pool := NewUserPool()
+	defer pool.Shutdown()
+	friends := NewFriendStore()
+	friendship := addFriends(t, friends, "account-a", "device-b")
+	presence := NewPresenceTracker(pool, friends, 20*time.Millisecond)
+
+	bob := &User{ID: "session-b", DeviceID: "device-b", Connection: &Connection{UserID: "session-b", IsActive: true}}
+	pool.AddWaitingUser(bob)
+	presence.Subscribe(bob)
+
+	// A session that logs in to an account is seen under the account
+	alice := &User{ID: "session-a", DeviceID: "device-a", Connection: &Connection{UserID: "session-a", IsActive: true}}
+	pool.AddWaitingUser(alice)
+	assert.Equal(t, PresenceOffline, presence.Status("account-a"))
+	pool.LinkAccount(alice.ID, "", "account-a")
+	assert.Equal(t, PresenceOnline, presence.Status("account-a"))
+	assert.Same(t, alice, pool.FindUserByIdentity("account-a"))
+	require.Eventually(t, func() bool { return presence.GetStats()["updates_sent"] == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, PresenceOnline, presence.Subscribe(bob)[0].Status)
+	assert.Equal(t, friendship.ID, presence.Subscribe(bob)[0].FriendID)
+
+	// Removing a session drops its subscription
+	pool.RemoveUser(bob.ID)
+	assert.Equal(t, 0, presence.GetStats()["subscribers"])
+	assert.Nil(t, pool.FindUserByIdentity("device-b"))
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidPushToken is returned when registering a malformed push token
+var ErrInvalidPushToken = errors.New("invalid push token")
+
+// PushRegistration is the push token of one device
+type PushRegistration struct {
+	DeviceID  string
+	AccountID string // account logged in on the device when it registered
+	Platform  string
+	Token     string
+	UpdatedAt time.Time
+}
+
+// pushRecord is one line of a push token file: a device's registration, or
+// its removal
+type pushRecord struct {
+	DeviceID  string    `json:"device_id"`
+	AccountID string    `json:"account_id,omitempty"`
+	Platform  string    `json:"platform,omitempty"`
+	Token     string    `json:"token,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Removed   bool      `json:"removed,omitempty"`
+}
+
+// PushTokenStore holds one push token per device. A store opened on a file
+// appends every change to it, so devices stay reachable across restarts.
+type PushTokenStore struct {
+	registrations map[string]*PushRegistration // device ID -> registration
+	journal       *journal
+	mutex         sync.RWMutex
+}
+
+// NewPushTokenStore creates an empty push token store
+func NewPushTokenStore() *PushTokenStore {
+	return &PushTokenStore{
+		registrations: make(map[string]*PushRegistration),
+	}
+}
+
+// OpenPushTokenStore opens (or creates) a push token store persisted to path
+func OpenPushTokenStore(path string) (*PushTokenStore, error) {
+	s := NewPushTokenStore()
+
+	journal, err := openJournal(path, func(line []byte) error {
+		var record pushRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.DeviceID == "" {
+			return ErrJournalMalformed
+		}
+		if record.Removed {
+			delete(s.registrations, record.DeviceID)
+			return nil
+		}
+		if !validPushToken(record.Platform, record.Token) {
+			return ErrJournalMalformed
+		}
+		s.registrations[record.DeviceID] = &PushRegistration{
+			DeviceID:  record.DeviceID,
+			AccountID: record.AccountID,
+			Platform:  record.Platform,
+			Token:     record.Token,
+			UpdatedAt: record.UpdatedAt,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+	return s, nil
+}
+
+// Close closes the store's file, if any
+func (s *PushTokenStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.journal.close()
+}
+
+// Register records a device's push token, replacing any earlier one
+func (s *PushTokenStore) Register(deviceID, accountID, platform, token string) error {
+	if deviceID == "" || !validPushToken(platform, token) {
+		return ErrInvalidPushToken
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	registration := &PushRegistration{
+		DeviceID:  deviceID,
+		AccountID: accountID,
+		Platform:  platform,
+		Token:     token,
+		UpdatedAt: time.Now(),
+	}
+	if err := s.journal.append(newPushRecord(registration)); err != nil {
+		return err
+	}
+	s.registrations[deviceID] = registration
+	return s.compactLocked()
+}
+
+// Unregister forgets a device's push token
+func (s *PushTokenStore) Unregister(deviceID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.removeLocked(deviceID)
+}
+
+// Invalidate forgets a device's push token after the push service rejected
+// it, unless the device has registered a new one since
+func (s *PushTokenStore) Invalidate(deviceID, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if registration := s.registrations[deviceID]; registration != nil && registration.Token == token {
+		return s.removeLocked(deviceID)
+	}
+	return nil
+}
+
+// removeLocked forgets a device's registration. Caller must hold the lock.
+func (s *PushTokenStore) removeLocked(deviceID string) error {
+	if s.registrations[deviceID] == nil {
+		return nil
+	}
+	if err := s.journal.append(pushRecord{DeviceID: deviceID, UpdatedAt: time.Now(), Removed: true}); err != nil {
+		return err
+	}
+	delete(s.registrations, deviceID)
+	return s.compactLocked()
+}
+
+// compactLocked rewrites the store's file from the current registrations
+// once superseded records dominate it. Caller must hold the lock.
+func (s *PushTokenStore) compactLocked() error {
+	if !s.journal.needsCompaction(len(s.registrations)) {
+		return nil
+	}
+	records := make([]interface{}, 0, len(s.registrations))
+	for _, registration := range s.registrations {
+		records = append(records, newPushRecord(registration))
+	}
+	return s.journal.compact(records)
+}
+
+func newPushRecord(registration *PushRegistration) pushRecord {
+	return pushRecord{
+		DeviceID:  registration.DeviceID,
+		AccountID: registration.AccountID,
+		Platform:  registration.Platform,
+		Token:     registration.Token,
+		UpdatedAt: registration.UpdatedAt,
+	}
+}
+
+// Lookup returns the registrations of an identity: the device itself, or
+// every device that registered while logged in to the account
+func (s *PushTokenStore) Lookup(identity string) []PushRegistration {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if registration := s.registrations[identity]; registration != nil {
+		return []PushRegistration{*registration}
+	}
+
+	var registrations []PushRegistration
+	for _, registration := range s.registrations {
+		if registration.AccountID != "" && registration.AccountID == identity {
+			registrations = append(registrations, *registration)
+		}
+	}
+	return registrations
+}
+
+// GetStats returns push token counters
+func (s *PushTokenStore) GetStats() map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	platforms := make(map[string]int)
+	for _, registration := range s.registrations {
+		platforms[registration.Platform]++
+	}
+	return map[string]interface{}{
+		"registered_devices": len(s.registrations),
+		"platforms":          platforms,
+	}
+}
+
+// validPushToken checks a token's shape. APNs tokens are hex; FCM tokens
+// use URL-safe characters and a colon.
+func validPushToken(platform, token string) bool {
+	if token == "" || len(token) > MaxPushTokenLength {
+		return false
+	}
+
+	switch platform {
+	case PushPlatformAPNs:
+		_, err := hex.DecodeString(token)
+		return err == nil
+	case PushPlatformFCM:
+		return strings.Trim(token, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_:") == ""
+	default:
+		return false
+	}
+}
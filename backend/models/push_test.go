@@ -0,0 +1,85 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushTokenStore(t *testing.T) {
+	store := NewPushTokenStore()
+
+	for _, tc := range []struct {
+		name     string
+		deviceID string
+		platform string
+		token    string
+	}{
+		{"no device", "", PushPlatformFCM, "token"},
+		{"unknown platform", "device-a", "sms", "token"},
+		{"empty token", "device-a", PushPlatformFCM, ""},
+		{"APNs token not hex", "device-a", PushPlatformAPNs, "not-hex"},
+		{"FCM token with a path", "device-a", PushPlatformFCM, "token/../x"},
+		{"too long", "device-a", PushPlatformFCM, strings.Repeat("a", MaxPushTokenLength+1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorIs(t, store.Register(tc.deviceID, "", tc.platform, tc.token), ErrInvalidPushToken)
+		})
+	}
+
+	require.NoError(t, store.Register("device-a", "account-1", PushPlatformFCM, "fcm:token-a"))
+	require.NoError(t, store.Register("device-b", "account-1", PushPlatformAPNs, "ABCDEF0123"))
+	require.NoError(t, store.Register("device-c", "", PushPlatformFCM, "token-c"))
+
+	assert.Len(t, store.Lookup("device-a"), 1)
+	assert.Len(t, store.Lookup("account-1"), 2, "an account is reached on every device it registered from")
+	assert.Empty(t, store.Lookup("unknown"))
+
+	// A rejected token is only forgotten if the device has not replaced it
+	require.NoError(t, store.Register("device-c", "", PushPlatformFCM, "token-c2"))
+	require.NoError(t, store.Invalidate("device-c", "token-c"))
+	assert.Len(t, store.Lookup("device-c"), 1)
+	require.NoError(t, store.Invalidate("device-c", "token-c2"))
+	assert.Empty(t, store.Lookup("device-c"))
+
+	require.NoError(t, store.Unregister("device-a"))
+	assert.Len(t, store.Lookup("account-1"), 1)
+	assert.Equal(t, 1, store.GetStats()["registered_devices"])
+}
+
+func TestPushTokenStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "push.jsonl")
+	store, err := OpenPushTokenStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Register("device-a", "account-1", PushPlatformFCM, "token-a"))
+	require.NoError(t, store.Register("device-b", "", PushPlatformFCM, "token-b"))
+	require.NoError(t, store.Register("device-b", "", PushPlatformFCM, "token-b2"))
+	require.NoError(t, store.Register("device-c", "", PushPlatformFCM, "token-c"))
+	require.NoError(t, store.Unregister("device-c"))
+	require.NoError(t, store.Close())
+
+	reopened, err := OpenPushTokenStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	registrations := reopened.Lookup("account-1")
+	require.Len(t, registrations, 1)
+	assert.Equal(t, "token-a", registrations[0].Token)
+	registrations = reopened.Lookup("device-b")
+	require.Len(t, registrations, 1)
+	assert.Equal(t, "token-b2", registrations[0].Token)
+	assert.Empty(t, reopened.Lookup("device-c"))
+}
+
+func TestPushTokenStore_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "push.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"device_id\":\"device-a\",\"platform\":\"sms\",\"token\":\"x\"}\n"), 0o600))
+
+	_, err := OpenPushTokenStore(path)
+	assert.ErrorIs(t, err, ErrJournalMalformed)
+}
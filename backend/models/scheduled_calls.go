@@ -0,0 +1,271 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scheduled call errors
+var (
+	ErrScheduledCallNotFound  = errors.New("scheduled call not found")
+	ErrTooManyScheduledCalls  = errors.New("too many scheduled calls")
+	ErrScheduledCallMalformed = errors.New("scheduled call file is malformed")
+)
+
+// ScheduledCall is a call between two friends arranged for a future time
+type ScheduledCall struct {
+	ID            string    `json:"id"`
+	FriendID      string    `json:"friend_id"`
+	Organizer     string    `json:"organizer"` // identity of the friend who scheduled it
+	Invitee       string    `json:"invitee"`
+	StartsAt      time.Time `json:"starts_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	RemindersSent int       `json:"reminders_sent"`
+}
+
+// Involves reports whether any of a user's identities is a party to the call
+func (c ScheduledCall) Involves(identities []string) bool {
+	for _, identity := range identities {
+		if identity == c.Organizer || identity == c.Invitee {
+			return true
+		}
+	}
+	return false
+}
+
+// ScheduledCallStore holds scheduled calls until they start or are
+// cancelled. A store opened on a file rewrites it after every change, so
+// the schedule survives restarts.
+type ScheduledCallStore struct {
+	calls          map[string]ScheduledCall
+	path           string // empty for an in-memory store
+	closed         bool   // changes are refused once the file is closed
+	maxPerIdentity int
+	mutex          sync.RWMutex
+}
+
+// NewScheduledCallStore creates an in-memory store allowing each identity
+// at most maxPerIdentity upcoming calls
+func NewScheduledCallStore(maxPerIdentity int) *ScheduledCallStore {
+	if maxPerIdentity <= 0 {
+		maxPerIdentity = DefaultMaxScheduledCalls
+	}
+	return &ScheduledCallStore{
+		calls:          make(map[string]ScheduledCall),
+		maxPerIdentity: maxPerIdentity,
+	}
+}
+
+// OpenScheduledCallStore opens (or creates) a store persisted to path
+func OpenScheduledCallStore(path string, maxPerIdentity int) (*ScheduledCallStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+
+	s := NewScheduledCallStore(maxPerIdentity)
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var calls []ScheduledCall
+	if err := json.Unmarshal(data, &calls); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrScheduledCallMalformed)
+	}
+	for _, call := range calls {
+		if call.ID == "" {
+			return nil, fmt.Errorf("%s: %w", path, ErrScheduledCallMalformed)
+		}
+		s.calls[call.ID] = call
+	}
+	return s, nil
+}
+
+// Close stops writing the store's file, if any. Later changes to a persisted
+// store fail, so a reminder firing during shutdown cannot rewrite the
+// schedule.
+func (s *ScheduledCallStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Add records a call, provided neither party has too many calls scheduled
+func (s *ScheduledCallStore) Add(call ScheduledCall) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, identity := range []string{call.Organizer, call.Invitee} {
+		if s.countLocked(identity) >= s.maxPerIdentity {
+			return ErrTooManyScheduledCalls
+		}
+	}
+	s.calls[call.ID] = call
+	if err := s.saveLocked(); err != nil {
+		delete(s.calls, call.ID)
+		return err
+	}
+	return nil
+}
+
+// Get returns a call
+func (s *ScheduledCallStore) Get(id string) (ScheduledCall, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	call, exists := s.calls[id]
+	if !exists {
+		return ScheduledCall{}, ErrScheduledCallNotFound
+	}
+	return call, nil
+}
+
+// All returns every call, soonest first
+func (s *ScheduledCallStore) All() []ScheduledCall {
+	return s.ForIdentities(nil)
+}
+
+// ForIdentities returns the calls any of a user's identities is a party to,
+// soonest first. A nil identities returns every call.
+func (s *ScheduledCallStore) ForIdentities(identities []string) []ScheduledCall {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	calls := make([]ScheduledCall, 0)
+	for _, call := range s.calls {
+		if identities == nil || call.Involves(identities) {
+			calls = append(calls, call)
+		}
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].StartsAt.Before(calls[j].StartsAt) })
+	return calls
+}
+
+// MarkReminded records how many reminders have been sent for a call
+func (s *ScheduledCallStore) MarkReminded(id string, sent int) (ScheduledCall, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	call, exists := s.calls[id]
+	if !exists {
+		return ScheduledCall{}, ErrScheduledCallNotFound
+	}
+	call.RemindersSent = sent
+	s.calls[id] = call
+	return call, s.saveLocked()
+}
+
+// Remove deletes a call, returning it
+func (s *ScheduledCallStore) Remove(id string) (ScheduledCall, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	call, exists := s.calls[id]
+	if !exists {
+		return ScheduledCall{}, ErrScheduledCallNotFound
+	}
+	delete(s.calls, id)
+	return call, s.saveLocked()
+}
+
+// Transfer moves the calls organized by or inviting any of from to to, such
+// as when an anonymous session upgrades to an account
+func (s *ScheduledCallStore) Transfer(from []string, to string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := make(map[string]ScheduledCall)
+	for id, call := range s.calls {
+		moved := call
+		for _, identity := range from {
+			if moved.Organizer == identity {
+				moved.Organizer = to
+			}
+			if moved.Invitee == identity {
+				moved.Invitee = to
+			}
+		}
+		if moved.Organizer != call.Organizer || moved.Invitee != call.Invitee {
+			previous[id] = call
+			s.calls[id] = moved
+		}
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+	if err := s.saveLocked(); err != nil {
+		for id, call := range previous {
+			s.calls[id] = call
+		}
+		return err
+	}
+	return nil
+}
+
+// Len returns the number of scheduled calls
+func (s *ScheduledCallStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.calls)
+}
+
+// countLocked counts the calls an identity is a party to. Caller must hold the lock.
+func (s *ScheduledCallStore) countLocked(identity string) int {
+	count := 0
+	for _, call := range s.calls {
+		if call.Organizer == identity || call.Invitee == identity {
+			count++
+		}
+	}
+	return count
+}
+
+// saveLocked rewrites the store's file through a temporary file, so a crash
+// leaves either the old schedule or the new one. Caller must hold the lock.
+func (s *ScheduledCallStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	if s.closed {
+		return os.ErrClosed
+	}
+
+	calls := make([]ScheduledCall, 0, len(s.calls))
+	for _, call := range s.calls {
+		calls = append(calls, call)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].ID < calls[j].ID })
+	data, err := json.MarshalIndent(calls, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".schedule-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledCallStore(t *testing.T) {
+	store := NewScheduledCallStore(2)
+	now := time.Now()
+	call := func(id, organizer, invitee string, in time.Duration) ScheduledCall {
+		return ScheduledCall{ID: id, FriendID: "f-" + id, Organizer: organizer, Invitee: invitee, StartsAt: now.Add(in), CreatedAt: now}
+	}
+
+	require.NoError(t, store.Add(call("later", "a", "b", 2*time.Hour)))
+	require.NoError(t, store.Add(call("sooner", "c", "a", time.Hour)))
+	// a is party to two calls already, on either side
+	assert.ErrorIs(t, store.Add(call("third", "a", "d", time.Hour)), ErrTooManyScheduledCalls)
+	assert.ErrorIs(t, store.Add(call("third", "d", "a", time.Hour)), ErrTooManyScheduledCalls)
+	require.NoError(t, store.Add(call("other", "c", "d", time.Hour)))
+
+	calls := store.ForIdentities([]string{"account-a", "a"})
+	require.Len(t, calls, 2)
+	assert.Equal(t, "sooner", calls[0].ID)
+	assert.Equal(t, "later", calls[1].ID)
+	assert.Len(t, store.All(), 3)
+
+	updated, err := store.MarkReminded("later", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated.RemindersSent)
+	got, err := store.Get("later")
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.RemindersSent)
+
+	removed, err := store.Remove("later")
+	require.NoError(t, err)
+	assert.Equal(t, "later", removed.ID)
+	_, err = store.Remove("later")
+	assert.ErrorIs(t, err, ErrScheduledCallNotFound)
+	_, err = store.Get("later")
+	assert.ErrorIs(t, err, ErrScheduledCallNotFound)
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestScheduledCallStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "scheduled_calls.json")
+	startsAt := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := OpenScheduledCallStore(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Add(ScheduledCall{ID: "kept", Organizer: "a", Invitee: "b", StartsAt: startsAt}))
+	require.NoError(t, store.Add(ScheduledCall{ID: "removed", Organizer: "a", Invitee: "b", StartsAt: startsAt}))
+	_, err = store.MarkReminded("kept", 1)
+	require.NoError(t, err)
+	_, err = store.Remove("removed")
+	require.NoError(t, err)
+
+	reopened, err := OpenScheduledCallStore(path, 0)
+	require.NoError(t, err)
+	calls := reopened.All()
+	require.Len(t, calls, 1)
+	assert.Equal(t, "kept", calls[0].ID)
+	assert.Equal(t, 1, calls[0].RemindersSent)
+	assert.True(t, startsAt.Equal(calls[0].StartsAt))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+	_, err = OpenScheduledCallStore(path, 0)
+	assert.ErrorIs(t, err, ErrScheduledCallMalformed)
+}
+
+func TestScheduledCallStore_Transfer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scheduled_calls.json")
+	startsAt := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := OpenScheduledCallStore(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Add(ScheduledCall{ID: "organized", Organizer: "device-a", Invitee: "b", StartsAt: startsAt}))
+	require.NoError(t, store.Add(ScheduledCall{ID: "invited", Organizer: "b", Invitee: "session-a", StartsAt: startsAt}))
+	require.NoError(t, store.Add(ScheduledCall{ID: "other", Organizer: "b", Invitee: "c", StartsAt: startsAt}))
+
+	// Upgrading moves calls from every anonymous identity, and survives a restart
+	require.NoError(t, store.Transfer([]string{"session-a", "device-a"}, "account-a"))
+	reopened, err := OpenScheduledCallStore(path, 0)
+	require.NoError(t, err)
+	assert.Len(t, reopened.ForIdentities([]string{"account-a"}), 2)
+	assert.Empty(t, reopened.ForIdentities([]string{"session-a", "device-a"}))
+	organized, err := reopened.Get("organized")
+	require.NoError(t, err)
+	assert.Equal(t, "account-a", organized.Organizer)
+
+	// A closed store refuses changes rather than rewriting its file
+	require.NoError(t, store.Close())
+	assert.ErrorIs(t, store.Add(ScheduledCall{ID: "late", Organizer: "b", Invitee: "c", StartsAt: startsAt}), os.ErrClosed)
+}
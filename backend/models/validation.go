@@ -31,7 +31,7 @@ func init() {
 
 // ValidatedMessage represents a validated WebSocket message
 type ValidatedMessage struct {
-	Type    string      `json:"type" validate:"required,oneof=find_match offer answer ice_candidate call_start call_accept call_reject call_end ping pong disconnect get_ice_servers"`
+	Type    string      `json:"type" validate:"required,oneof=find_match offer answer ice_candidate call_start call_accept call_reject call_end ping pong disconnect get_ice_servers challenge_response device_token token_refresh logout add_friend get_friends call_friend subscribe_presence unsubscribe_presence set_presence set_display_name share_contact withdraw_contact schedule_call cancel_scheduled_call get_scheduled_calls register_push unregister_push"`
 	Payload interface{} `json:"payload" validate:"required"`
 	From    string      `json:"from,omitempty" validate:"omitempty,uuid4"`
 	To      string      `json:"to,omitempty" validate:"omitempty,uuid4"`
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAndParseMessage_ClientTypes(t *testing.T) {
+	clientTypes := []string{
+		MessageTypeFindMatch, MessageTypePing, MessageTypePong, MessageTypeDisconnect,
+		MessageTypeGetICEServers, MessageTypeChallengeResponse, MessageTypeTokenRefresh, MessageTypeLogout,
+		MessageTypeAddFriend, MessageTypeGetFriends, MessageTypeCallFriend,
+		MessageTypeSubscribePresence, MessageTypeUnsubscribePresence, MessageTypeSetPresence,
+		MessageTypeSetDisplayName, MessageTypeShareContact, MessageTypeWithdrawContact,
+		MessageTypeScheduleCall, MessageTypeCancelScheduledCall, MessageTypeGetScheduledCalls,
+		MessageTypeRegisterPush, MessageTypeUnregisterPush,
+	}
+	for _, msgType := range clientTypes {
+		_, err := ValidateAndParseMessage(map[string]interface{}{
+			"type":    msgType,
+			"payload": map[string]interface{}{},
+		})
+		assert.NoError(t, err, msgType)
+	}
+
+	_, err := ValidateAndParseMessage(map[string]interface{}{
+		"type":    "made_up",
+		"payload": map[string]interface{}{},
+	})
+	assert.Error(t, err)
+}
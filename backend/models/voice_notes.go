@@ -0,0 +1,311 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Voice note errors
+var (
+	ErrVoiceNoteNotFound = errors.New("voice note not found")
+	ErrTooManyVoiceNotes = errors.New("too many voice notes waiting for this friend")
+	// ErrTooManyVoiceNotesFromSender is returned when one sender has filled
+	// their share of a recipient's notes
+	ErrTooManyVoiceNotesFromSender = errors.New("too many voice notes sent to this friend")
+)
+
+// VoiceNote describes a recorded message from one friend to another. The
+// audio itself lives in a blob store under the note's ID.
+type VoiceNote struct {
+	ID          string
+	FriendID    string // the friendship the note was sent through
+	Sender      string // sender's identity
+	Recipient   string // recipient's identity
+	ContentType string
+	Size        int64
+	Duration    time.Duration
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// voiceNoteRecord is one line of a voice note file: a note, or its removal
+type voiceNoteRecord struct {
+	ID          string    `json:"id"`
+	FriendID    string    `json:"friend_id,omitempty"`
+	Sender      string    `json:"sender,omitempty"`
+	Recipient   string    `json:"recipient,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	DurationMS  int64     `json:"duration_ms,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+	Removed     bool      `json:"removed,omitempty"`
+}
+
+// VoiceNoteStore indexes voice notes until they expire or are deleted. A
+// store opened on a file appends every change to it, so notes stay
+// listed, and their audio kept, across restarts.
+type VoiceNoteStore struct {
+	notes        map[string]*VoiceNote
+	maxPending   int // per recipient
+	maxPerSender int // per sender and recipient
+	journal      *journal
+	mutex        sync.RWMutex
+}
+
+// NewVoiceNoteStore creates an empty store holding at most maxPending notes
+// for each recipient, at most maxPerSender of them from any one sender
+func NewVoiceNoteStore(maxPending, maxPerSender int) *VoiceNoteStore {
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPendingVoiceNotes
+	}
+	if maxPerSender <= 0 {
+		maxPerSender = DefaultVoiceNoteMaxPerSender
+	}
+	return &VoiceNoteStore{
+		notes:        make(map[string]*VoiceNote),
+		maxPending:   maxPending,
+		maxPerSender: maxPerSender,
+	}
+}
+
+// OpenVoiceNoteStore opens (or creates) a voice note store persisted to
+// path. Notes that expired while the server was down are loaded too, so
+// that their audio is deleted with them.
+func OpenVoiceNoteStore(path string, maxPending, maxPerSender int) (*VoiceNoteStore, error) {
+	s := NewVoiceNoteStore(maxPending, maxPerSender)
+
+	journal, err := openJournal(path, func(line []byte) error {
+		var record voiceNoteRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.ID == "" {
+			return ErrJournalMalformed
+		}
+		if record.Removed {
+			delete(s.notes, record.ID)
+			return nil
+		}
+		if record.Sender == "" || record.Recipient == "" || record.ExpiresAt.IsZero() {
+			return ErrJournalMalformed
+		}
+		s.notes[record.ID] = &VoiceNote{
+			ID:          record.ID,
+			FriendID:    record.FriendID,
+			Sender:      record.Sender,
+			Recipient:   record.Recipient,
+			ContentType: record.ContentType,
+			Size:        record.Size,
+			Duration:    time.Duration(record.DurationMS) * time.Millisecond,
+			CreatedAt:   record.CreatedAt,
+			ExpiresAt:   record.ExpiresAt,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+	return s, nil
+}
+
+// Close closes the store's file, if any
+func (s *VoiceNoteStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.journal.close()
+}
+
+// Reserve checks that the recipient has room for another note from sender
+func (s *VoiceNoteStore) Reserve(sender, recipient string) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.reserveLocked(sender, recipient)
+}
+
+// Add records a note
+func (s *VoiceNoteStore) Add(note *VoiceNote) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.reserveLocked(note.Sender, note.Recipient); err != nil {
+		return err
+	}
+	if err := s.journal.append(newVoiceNoteRecord(note)); err != nil {
+		return err
+	}
+	s.notes[note.ID] = note
+	return s.compactLocked()
+}
+
+// Contains reports whether a note, expired or not, is still held
+func (s *VoiceNoteStore) Contains(id string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.notes[id] != nil
+}
+
+// Get returns an unexpired note
+func (s *VoiceNoteStore) Get(id string) (*VoiceNote, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	note := s.notes[id]
+	if note == nil || time.Now().After(note.ExpiresAt) {
+		return nil, ErrVoiceNoteNotFound
+	}
+	return note, nil
+}
+
+// ForRecipient returns the unexpired notes sent to any of a user's
+// identities, oldest first
+func (s *VoiceNoteStore) ForRecipient(identities []string) []*VoiceNote {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	notes := make([]*VoiceNote, 0)
+	for _, note := range s.notes {
+		if now.After(note.ExpiresAt) {
+			continue
+		}
+		for _, identity := range identities {
+			if note.Recipient == identity {
+				notes = append(notes, note)
+				break
+			}
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].CreatedAt.Before(notes[j].CreatedAt) })
+	return notes
+}
+
+// Remove deletes a note
+func (s *VoiceNoteStore) Remove(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.removeLocked(id)
+}
+
+// Transfer moves the notes sent or received under any of from to to, such
+// as when an anonymous session upgrades to an account
+func (s *VoiceNoteStore) Transfer(from []string, to string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, note := range s.notes {
+		moved := *note
+		for _, identity := range from {
+			if moved.Sender == identity {
+				moved.Sender = to
+			}
+			if moved.Recipient == identity {
+				moved.Recipient = to
+			}
+		}
+		if moved.Sender == note.Sender && moved.Recipient == note.Recipient {
+			continue
+		}
+		if err := s.journal.append(newVoiceNoteRecord(&moved)); err != nil {
+			return err
+		}
+		s.notes[id] = &moved
+	}
+	return nil
+}
+
+// RemoveExpired deletes notes past their expiry, returning their IDs so the
+// audio can be deleted too. Notes that could not be removed are kept, and
+// tried again next time.
+func (s *VoiceNoteStore) RemoveExpired(now time.Time) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var expired []string
+	for id, note := range s.notes {
+		if now.After(note.ExpiresAt) {
+			if err := s.removeLocked(id); err != nil {
+				return expired, err
+			}
+			expired = append(expired, id)
+		}
+	}
+	return expired, nil
+}
+
+// GetStats returns voice note counters
+func (s *VoiceNoteStore) GetStats() map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var bytes int64
+	for _, note := range s.notes {
+		bytes += note.Size
+	}
+	return map[string]interface{}{
+		"notes": len(s.notes),
+		"bytes": bytes,
+	}
+}
+
+// reserveLocked checks the recipient's limit and the sender's share of it.
+// Caller must hold the lock.
+func (s *VoiceNoteStore) reserveLocked(sender, recipient string) error {
+	pending, fromSender := 0, 0
+	for _, note := range s.notes {
+		if note.Recipient != recipient {
+			continue
+		}
+		pending++
+		if note.Sender == sender {
+			fromSender++
+		}
+	}
+	if pending >= s.maxPending {
+		return ErrTooManyVoiceNotes
+	}
+	if fromSender >= s.maxPerSender {
+		return ErrTooManyVoiceNotesFromSender
+	}
+	return nil
+}
+
+// removeLocked forgets a note. Caller must hold the lock.
+func (s *VoiceNoteStore) removeLocked(id string) error {
+	if s.notes[id] == nil {
+		return nil
+	}
+	if err := s.journal.append(voiceNoteRecord{ID: id, Removed: true}); err != nil {
+		return err
+	}
+	delete(s.notes, id)
+	return s.compactLocked()
+}
+
+// compactLocked rewrites the store's file from the current notes once
+// removed ones dominate it. Caller must hold the lock.
+func (s *VoiceNoteStore) compactLocked() error {
+	if !s.journal.needsCompaction(len(s.notes)) {
+		return nil
+	}
+	records := make([]interface{}, 0, len(s.notes))
+	for _, note := range s.notes {
+		records = append(records, newVoiceNoteRecord(note))
+	}
+	return s.journal.compact(records)
+}
+
+func newVoiceNoteRecord(note *VoiceNote) voiceNoteRecord {
+	return voiceNoteRecord{
+		ID:          note.ID,
+		FriendID:    note.FriendID,
+		Sender:      note.Sender,
+		Recipient:   note.Recipient,
+		ContentType: note.ContentType,
+		Size:        note.Size,
+		DurationMS:  note.Duration.Milliseconds(),
+		CreatedAt:   note.CreatedAt,
+		ExpiresAt:   note.ExpiresAt,
+	}
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoiceNoteStore(t *testing.T) {
+	store := NewVoiceNoteStore(2, 0)
+	now := time.Now()
+	note := func(id, recipient string, age time.Duration) *VoiceNote {
+		return &VoiceNote{
+			ID:        id,
+			Sender:    "sender",
+			Recipient: recipient,
+			Size:      100,
+			CreatedAt: now.Add(-age),
+			ExpiresAt: now.Add(time.Hour - age),
+		}
+	}
+
+	require.NoError(t, store.Reserve("sender", "device-b"))
+	require.NoError(t, store.Add(note("second", "device-b", time.Minute)))
+	require.NoError(t, store.Add(note("first", "device-b", 2*time.Minute)))
+	assert.ErrorIs(t, store.Reserve("sender", "device-b"), ErrTooManyVoiceNotes)
+	assert.ErrorIs(t, store.Add(note("third", "device-b", 0)), ErrTooManyVoiceNotes)
+	require.NoError(t, store.Add(note("other", "account-c", 0)))
+
+	got, err := store.Get("first")
+	require.NoError(t, err)
+	assert.Equal(t, "device-b", got.Recipient)
+	_, err = store.Get("missing")
+	assert.ErrorIs(t, err, ErrVoiceNoteNotFound)
+
+	// Notes to any identity, oldest first
+	notes := store.ForRecipient([]string{"account-c", "device-b"})
+	require.Len(t, notes, 3)
+	assert.Equal(t, []string{"first", "second", "other"}, []string{notes[0].ID, notes[1].ID, notes[2].ID})
+
+	stats := store.GetStats()
+	assert.Equal(t, 3, stats["notes"])
+	assert.Equal(t, int64(300), stats["bytes"])
+
+	require.NoError(t, store.Remove("first"))
+	assert.NoError(t, store.Reserve("sender", "device-b"))
+	assert.Len(t, store.ForRecipient([]string{"device-b"}), 1)
+}
+
+func TestVoiceNoteStore_Expiry(t *testing.T) {
+	store := NewVoiceNoteStore(0, 0)
+	now := time.Now()
+	require.NoError(t, store.Add(&VoiceNote{ID: "old", Sender: "a", Recipient: "b", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}))
+	require.NoError(t, store.Add(&VoiceNote{ID: "new", Sender: "a", Recipient: "b", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+	// Expired notes are hidden before they are removed
+	_, err := store.Get("old")
+	assert.ErrorIs(t, err, ErrVoiceNoteNotFound)
+	assert.Len(t, store.ForRecipient([]string{"b"}), 1)
+
+	assert.True(t, store.Contains("old"), "expired notes are held until removed")
+	expired, err := store.RemoveExpired(now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"old"}, expired)
+	assert.False(t, store.Contains("old"))
+	expired, err = store.RemoveExpired(now)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	assert.Equal(t, 1, store.GetStats()["notes"])
+}
+
+func TestVoiceNoteStore_PerSenderLimit(t *testing.T) {
+	store := NewVoiceNoteStore(3, 2)
+	now := time.Now()
+	add := func(id, sender string) error {
+		return store.Add(&VoiceNote{ID: id, Sender: sender, Recipient: "b", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+	}
+
+	require.NoError(t, add("1", "a"))
+	require.NoError(t, add("2", "a"))
+
+	// One sender cannot fill the recipient's whole quota
+	assert.ErrorIs(t, store.Reserve("a", "b"), ErrTooManyVoiceNotesFromSender)
+	assert.ErrorIs(t, add("3", "a"), ErrTooManyVoiceNotesFromSender)
+	require.NoError(t, store.Reserve("c", "b"))
+	require.NoError(t, add("3", "c"))
+	assert.ErrorIs(t, store.Reserve("d", "b"), ErrTooManyVoiceNotes)
+}
+
+func TestVoiceNoteStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.jsonl")
+	store, err := OpenVoiceNoteStore(path, 0, 0)
+	require.NoError(t, err)
+
+	now := time.Now()
+	kept := &VoiceNote{
+		ID:          "kept",
+		FriendID:    "friendship-1",
+		Sender:      "device-a",
+		Recipient:   "device-b",
+		ContentType: "audio/ogg",
+		Size:        100,
+		Duration:    1500 * time.Millisecond,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+	require.NoError(t, store.Add(kept))
+	require.NoError(t, store.Add(&VoiceNote{ID: "deleted", Sender: "device-a", Recipient: "device-b", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, store.Remove("deleted"))
+	require.NoError(t, store.Close())
+
+	reopened, err := OpenVoiceNoteStore(path, 0, 0)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	got, err := reopened.Get("kept")
+	require.NoError(t, err)
+	assert.Equal(t, kept.FriendID, got.FriendID)
+	assert.Equal(t, kept.Duration, got.Duration)
+	assert.Equal(t, kept.ContentType, got.ContentType)
+	assert.True(t, kept.ExpiresAt.Equal(got.ExpiresAt))
+	assert.False(t, reopened.Contains("deleted"))
+}
+
+func TestVoiceNoteStore_Transfer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.jsonl")
+	store, err := OpenVoiceNoteStore(path, 0, 0)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, store.Add(&VoiceNote{ID: "sent", Sender: "device-a", Recipient: "device-b", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, store.Add(&VoiceNote{ID: "received", Sender: "device-b", Recipient: "session-a", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+	// Upgrading moves notes from every anonymous identity, and survives a restart
+	require.NoError(t, store.Transfer([]string{"session-a", "device-a"}, "account-a"))
+	require.NoError(t, store.Close())
+	reopened, err := OpenVoiceNoteStore(path, 0, 0)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	sent, err := reopened.Get("sent")
+	require.NoError(t, err)
+	assert.Equal(t, "account-a", sent.Sender)
+	assert.Equal(t, "device-b", sent.Recipient)
+	assert.Len(t, reopened.ForRecipient([]string{"account-a"}), 1)
+	assert.Empty(t, reopened.ForRecipient([]string{"session-a", "device-a"}))
+}
+
+func TestVoiceNoteStore_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"id\":\"note-1\"}\n"), 0o600))
+
+	_, err := OpenVoiceNoteStore(path, 0, 0)
+	assert.ErrorIs(t, err, ErrJournalMalformed)
+}
@@ -1,28 +1,64 @@
 package tests
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
+	"io"
+	"math/bits"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 	"voice-chat-app/handlers"
+	"voice-chat-app/middleware"
 	"voice-chat-app/models"
+	"voice-chat-app/oidctest"
+	"voice-chat-app/utils"
 
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Integration test setup
 func setupTestServer() (*httptest.Server, *handlers.SignalingServer) {
 	userPool := models.NewUserPool()
 	signalingServer := &handlers.SignalingServer{
-		UserPool: userPool,
+		UserPool:        userPool,
+		Friends:         models.NewFriendStore(),
+		TextFilter:      utils.NewTextFilter(models.ReservedNameTerms),
+		ContactExchange: true,
 	}
+	signalingServer.Presence = models.NewPresenceTracker(userPool, signalingServer.Friends, 20*time.Millisecond)
+	signalingServer.Push = handlers.NewPushNotifier(map[string]utils.PushProvider{
+		models.PushPlatformFCM: utils.NewFakePushProvider(),
+	}, models.NewPushTokenStore())
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", signalingServer.HandleWebSocket)
+	mux.HandleFunc("POST /session", signalingServer.HandleCreateSession)
+	mux.HandleFunc("POST /account/signup", signalingServer.HandleSignup)
+	mux.HandleFunc("POST /account/login", signalingServer.HandleLogin)
+	mux.HandleFunc("POST /account/upgrade", signalingServer.HandleUpgradeAccount)
+	mux.HandleFunc("POST /account/email", signalingServer.HandleSetEmail)
+	mux.HandleFunc("POST /account/email/verify", signalingServer.HandleVerifyEmail)
+	mux.HandleFunc("POST /account/password/forgot", signalingServer.HandleForgotPassword)
+	mux.HandleFunc("POST /account/password/reset", signalingServer.HandleResetPassword)
+	mux.HandleFunc("GET /auth/oidc/login", signalingServer.HandleOIDCLogin)
+	mux.HandleFunc("GET /auth/oidc/callback", signalingServer.HandleOIDCCallback)
+	mux.HandleFunc("POST /voice-notes", signalingServer.HandleUploadVoiceNote)
+	mux.HandleFunc("GET /voice-notes", signalingServer.HandleListVoiceNotes)
+	mux.HandleFunc("GET /voice-notes/{id}", signalingServer.HandleDownloadVoiceNote)
+	mux.HandleFunc("DELETE /voice-notes/{id}", signalingServer.HandleDeleteVoiceNote)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -185,3 +221,1160 @@ func TestIntegration_HealthAndStatsEndpoints(t *testing.T) {
 	assert.Contains(t, statsResponse, "active_rooms")
 	assert.Contains(t, statsResponse, "server_uptime")
 }
+
+func TestIntegration_ProofOfWorkChallenge(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	challenger, err := handlers.NewChallenger(handlers.ChallengeConfig{BaseDifficulty: 8, MaxDifficulty: 8}, nil)
+	require.NoError(t, err)
+	signalingServer.Challenger = challenger
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// First message is the challenge
+	var challengeMsg handlers.Message
+	require.NoError(t, conn.ReadJSON(&challengeMsg))
+	require.Equal(t, "challenge", challengeMsg.Type)
+
+	payload := challengeMsg.Payload.(map[string]interface{})
+	nonce := payload["nonce"].(string)
+	difficulty := int(payload["difficulty"].(float64))
+
+	// find_match is refused until the challenge is solved
+	require.NoError(t, conn.WriteJSON(handlers.Message{Type: "find_match"}))
+	var errorMsg handlers.Message
+	require.NoError(t, conn.ReadJSON(&errorMsg))
+	assert.Equal(t, "error", errorMsg.Type)
+	assert.Equal(t, 0, signalingServer.GetStats()["waiting_users"])
+
+	require.NoError(t, conn.WriteJSON(handlers.Message{
+		Type: "challenge_response",
+		Payload: map[string]string{
+			"nonce":    nonce,
+			"solution": solveProofOfWork(nonce, difficulty),
+		},
+	}))
+
+	var passedMsg, sessionMsg handlers.Message
+	require.NoError(t, conn.ReadJSON(&passedMsg))
+	assert.Equal(t, "challenge_passed", passedMsg.Type)
+	require.NoError(t, conn.ReadJSON(&sessionMsg))
+	require.Equal(t, "session", sessionMsg.Type)
+
+	// A session token alone does not skip the challenge
+	token := sessionMsg.Payload.(map[string]interface{})["token"].(string)
+	tokenConn, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws?token="+token, nil)
+	require.NoError(t, err)
+	defer tokenConn.Close()
+
+	var tokenMsg handlers.Message
+	require.NoError(t, tokenConn.ReadJSON(&tokenMsg))
+	assert.Equal(t, "challenge", tokenMsg.Type)
+
+	// The pass from challenge_passed does, from the same client
+	pass := passedMsg.Payload.(map[string]interface{})["pass"].(string)
+	trustedConn, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws?challenge_pass="+url.QueryEscape(pass), nil)
+	require.NoError(t, err)
+	defer trustedConn.Close()
+
+	var trustedMsg handlers.Message
+	require.NoError(t, trustedConn.ReadJSON(&trustedMsg))
+	assert.Equal(t, "session", trustedMsg.Type)
+}
+
+func TestIntegration_OriginPolicy(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	signalingServer.OriginPolicy = middleware.NewOriginPolicy(
+		[]string{"https://app.example.com"},
+		[]string{"capacitor://localhost"},
+		true,
+	)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	tests := []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{"allowed web origin", "https://app.example.com", true},
+		{"native app without origin", "", true},
+		{"native app custom origin", "capacitor://localhost", true},
+		{"disallowed origin", "https://evil.example.org", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+			if !tt.allowed {
+				require.Error(t, err)
+				assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+				return
+			}
+			require.NoError(t, err)
+			conn.Close()
+		})
+	}
+
+	originStats := signalingServer.GetStats()["origin_policy"].(map[string]interface{})
+	assert.Equal(t, 1, originStats["rejected_total"])
+}
+
+func TestIntegration_AdmissionWaitlist(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	signalingServer.Admission = middleware.NewAdmissionController(middleware.AdmissionConfig{
+		MaxConnections: 1,
+		Mode:           models.AdmissionModeWaitlist,
+		WaitlistSize:   1,
+	}, nil)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	holder, _ := connectWebSocket(t, server.URL)
+
+	// Second client is accepted but held in the waitlist
+	waiting, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer waiting.Close()
+
+	var busyMsg handlers.Message
+	require.NoError(t, waiting.ReadJSON(&busyMsg))
+	assert.Equal(t, "server_busy", busyMsg.Type)
+	assert.Equal(t, float64(1), busyMsg.Payload.(map[string]interface{})["position"])
+
+	// Third client is refused outright once the waitlist is full
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	// Freeing the slot promotes the waiting client
+	holder.Close()
+
+	var admittedMsg, sessionMsg handlers.Message
+	require.NoError(t, waiting.ReadJSON(&admittedMsg))
+	assert.Equal(t, "admitted", admittedMsg.Type)
+	require.NoError(t, waiting.ReadJSON(&sessionMsg))
+	assert.Equal(t, "session", sessionMsg.Type)
+}
+
+func TestIntegration_MessageRateLimit(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	signalingServer.MessageLimit = middleware.NewMessageRateLimiter(middleware.DefaultMessageRateLimiterConfig(100))
+
+	conn, _ := connectWebSocket(t, server.URL)
+	defer conn.Close()
+
+	// The find_match burst is allowed, the next request is throttled
+	for i := 0; i < 4; i++ {
+		require.NoError(t, conn.WriteJSON(handlers.Message{Type: "find_match"}))
+	}
+
+	for {
+		var msg map[string]interface{}
+		require.NoError(t, conn.ReadJSON(&msg))
+		if msg["type"] != "error" {
+			continue
+		}
+		assert.Equal(t, models.ErrorCodeRateLimit, msg["code"])
+		assert.Equal(t, "throttle", msg["context"].(map[string]interface{})["penalty"])
+		break
+	}
+}
+
+func TestIntegration_ConnectTicket(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	signalingServer.Tickets = handlers.NewTicketStore(models.ConnectTicketTTL)
+	defer signalingServer.Tickets.Stop()
+	signalingServer.RequireTicket = true
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	// Upgrades without a ticket are refused before the upgrade
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/session", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var session map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+	ticket := session["ticket"].(string)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?ticket="+ticket, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The socket is bound to the session created over HTTP
+	var sessionMsg handlers.Message
+	require.NoError(t, conn.ReadJSON(&sessionMsg))
+	payload := sessionMsg.Payload.(map[string]interface{})
+	assert.Equal(t, session["user_id"], payload["user_id"])
+	assert.Equal(t, session["token"], payload["token"])
+
+	// Tickets are single-use
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL+"?ticket="+ticket, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestIntegration_ConnectTicketSurvivesBusyServer(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	signalingServer.Tickets = handlers.NewTicketStore(models.ConnectTicketTTL)
+	defer signalingServer.Tickets.Stop()
+	signalingServer.Admission = middleware.NewAdmissionController(middleware.AdmissionConfig{
+		MaxConnections: 1,
+		Mode:           models.AdmissionModeReject,
+	}, nil)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	holder, _ := connectWebSocket(t, server.URL)
+
+	resp, err := http.Post(server.URL+"/session", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var session map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+	ticket := session["ticket"].(string)
+
+	// Refused as busy, the ticket is not spent
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL+"?ticket="+ticket, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	holder.Close()
+	require.Eventually(t, func() bool {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?ticket="+ticket, nil)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+
+		var sessionMsg handlers.Message
+		require.NoError(t, conn.ReadJSON(&sessionMsg))
+		assert.Equal(t, session["user_id"], sessionMsg.Payload.(map[string]interface{})["user_id"])
+		return true
+	}, time.Second, 20*time.Millisecond)
+}
+
+func TestIntegration_TokenLifecycle(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	signalingServer.HeartbeatInterval = 50 * time.Millisecond
+
+	// A fresh token is outside the refresh window
+	conn, sessionMsg := connectWebSocket(t, server.URL)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteJSON(handlers.Message{Type: "token_refresh"}))
+	errorMsg := readUntil(t, conn, "error")
+	assert.Contains(t, errorMsg.Payload.(map[string]interface{})["message"], "does not need refresh")
+
+	// Logout revokes the token, audits it and closes the session
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := utils.OpenAuditLog(auditPath, 0)
+	require.NoError(t, err)
+	utils.SetAuditLog(auditLog)
+	defer utils.SetAuditLog(nil)
+	defer auditLog.Close()
+
+	token := sessionMsg.Payload.(map[string]interface{})["token"].(string)
+	require.NoError(t, conn.WriteJSON(handlers.Message{Type: "logout"}))
+	readUntil(t, conn, "logged_out")
+	_, err = utils.ValidateJWT(token)
+	assert.Equal(t, utils.ErrTokenBlacklisted, err)
+	audited, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(audited), `"action":"token_revoke"`)
+	assert.Contains(t, string(audited), `"reason":"logout"`)
+
+	// A token revoked elsewhere closes the session at the next heartbeat
+	conn2, sessionMsg2 := connectWebSocket(t, server.URL)
+	defer conn2.Close()
+	require.NoError(t, utils.RevokeToken(sessionMsg2.Payload.(map[string]interface{})["token"].(string)))
+
+	revokedMsg := readUntil(t, conn2, "session_revoked")
+	assert.NotEmpty(t, revokedMsg.Payload.(map[string]interface{})["reason"])
+}
+
+// postJSON sends a JSON body and decodes the JSON response
+func postJSON(t *testing.T, url, bearer string, body interface{}) (int, map[string]interface{}) {
+	encoded, err := json.Marshal(body)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(string(encoded)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var decoded map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp.StatusCode, decoded
+}
+
+func TestIntegration_Accounts(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	signalingServer.Tickets = handlers.NewTicketStore(models.ConnectTicketTTL)
+	defer signalingServer.Tickets.Stop()
+	signalingServer.Accounts = models.NewAccountStore(bcrypt.MinCost)
+	credentials := map[string]string{"username": "alice", "password": "correct horse"}
+
+	// Signup starts a session whose token carries the account ID
+	status, signup := postJSON(t, server.URL+"/account/signup", "", credentials)
+	require.Equal(t, http.StatusCreated, status)
+	claims, err := utils.ValidateJWT(signup["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, signup["account_id"], claims.AccountID)
+
+	status, _ = postJSON(t, server.URL+"/account/signup", "", credentials)
+	assert.Equal(t, http.StatusConflict, status)
+
+	// Login connects over the WebSocket as the account
+	status, login := postJSON(t, server.URL+"/account/login", "", credentials)
+	require.Equal(t, http.StatusOK, status)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?ticket="+login["ticket"].(string), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	sessionMsg := readUntil(t, conn, "session")
+	assert.Equal(t, signup["account_id"], sessionMsg.Payload.(map[string]interface{})["account_id"])
+
+	// Repeated failures lock the account
+	wrong := map[string]string{"username": "alice", "password": "wrong password"}
+	for i := 0; i < models.MaxRetryAttempts; i++ {
+		status, _ = postJSON(t, server.URL+"/account/login", "", wrong)
+		assert.Equal(t, http.StatusUnauthorized, status)
+	}
+	status, _ = postJSON(t, server.URL+"/account/login", "", credentials)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	// An anonymous session upgrades in place and keeps its shadow ban
+	anonConn, anonSession := connectWebSocket(t, server.URL)
+	defer anonConn.Close()
+	anonPayload := anonSession.Payload.(map[string]interface{})
+	anonUserID := anonPayload["user_id"].(string)
+	anonToken := anonPayload["token"].(string)
+	signalingServer.UserPool.ShadowBanIdentity(anonUserID, "spam")
+
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := utils.OpenAuditLog(auditPath, 0)
+	require.NoError(t, err)
+	utils.SetAuditLog(auditLog)
+	defer utils.SetAuditLog(nil)
+	defer auditLog.Close()
+
+	upgrade := map[string]string{"username": "bob", "password": "battery staple"}
+	status, _ = postJSON(t, server.URL+"/account/upgrade", "", upgrade)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, upgraded := postJSON(t, server.URL+"/account/upgrade", anonToken, upgrade)
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, anonUserID, upgraded["user_id"])
+	assert.True(t, signalingServer.UserPool.IsShadowBanned(upgraded["account_id"].(string)))
+	assert.Equal(t, upgraded["token"], signalingServer.UserPool.SessionToken(anonUserID))
+
+	_, err = utils.ValidateJWT(anonToken)
+	assert.Equal(t, utils.ErrTokenBlacklisted, err)
+	audited, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(audited), `"reason":"account_upgrade"`)
+	status, _ = postJSON(t, server.URL+"/account/upgrade", upgraded["token"].(string), map[string]string{"username": "carol", "password": "battery staple"})
+	assert.Equal(t, http.StatusConflict, status)
+}
+
+// readUntil reads messages until one of the given type arrives
+func readUntil(t *testing.T, conn *websocket.Conn, msgType string) handlers.Message {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for {
+		var msg handlers.Message
+		require.NoError(t, conn.ReadJSON(&msg))
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// solveProofOfWork finds a solution with the required leading zero bits
+func solveProofOfWork(nonce string, difficulty int) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(nonce + ":" + solution))
+
+		zeros := 0
+		for _, b := range sum {
+			zeros += bits.LeadingZeros8(b)
+			if b != 0 {
+				break
+			}
+		}
+		if zeros >= difficulty {
+			return solution
+		}
+	}
+}
+
+func TestIntegration_OIDCLogin(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	provider := oidctest.NewProvider("talk-app", "client-secret")
+	defer provider.Close()
+
+	signalingServer.Tickets = handlers.NewTicketStore(models.ConnectTicketTTL)
+	defer signalingServer.Tickets.Stop()
+	signalingServer.Accounts = models.NewAccountStore(bcrypt.MinCost)
+	signalingServer.OIDC = handlers.NewOIDCLogin(utils.NewOIDCClient(utils.OIDCConfig{
+		IssuerURL:    provider.Issuer(),
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  server.URL + "/auth/oidc/callback",
+	}, nil), "https://app.example.com/signed-in")
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	// The browser follows the redirects to the provider and back to the
+	// callback, which returns it to the app with the outcome in the fragment
+	newBrowser := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		return &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Host == "app.example.com" {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			},
+		}
+	}
+	returnedToApp := func(resp *http.Response) url.Values {
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		location, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "/signed-in", location.Path)
+		outcome, err := url.ParseQuery(location.Fragment)
+		require.NoError(t, err)
+		return outcome
+	}
+	login := func() map[string]interface{} {
+		resp, err := newBrowser().Get(server.URL + "/auth/oidc/login")
+		require.NoError(t, err)
+		resp.Body.Close()
+		outcome := returnedToApp(resp)
+		require.NotEmpty(t, outcome.Get("ticket"), "login failed: %s", outcome.Get("error"))
+
+		// The ticket connects over the WebSocket as the account
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?ticket="+outcome.Get("ticket"), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		return readUntil(t, conn, "session").Payload.(map[string]interface{})
+	}
+
+	session := login()
+	claims, err := utils.ValidateJWT(session["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, session["account_id"], claims.AccountID)
+	assert.Equal(t, "user@example.com", signalingServer.Accounts.Get(claims.AccountID).Username)
+
+	// Signing in again reaches the same account; another subject gets its own
+	again := login()
+	assert.Equal(t, session["account_id"], again["account_id"])
+	provider.SetUser(oidctest.User{Subject: "user-2", Name: "Second User"})
+	other := login()
+	assert.NotEqual(t, session["account_id"], other["account_id"])
+	assert.Equal(t, "Second User", signalingServer.Accounts.Get(other["account_id"].(string)).Username)
+
+	// A provider that leaves the kid out of its tokens still verifies
+	provider.OmitKeyID(true)
+	assert.Equal(t, other["account_id"], login()["account_id"])
+
+	// A callback with a state this browser did not start is refused, even
+	// when the state is pending for another browser
+	victim := newBrowser()
+	victim.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := victim.Get(server.URL + "/auth/oidc/login")
+	require.NoError(t, err)
+	resp.Body.Close()
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	state := authURL.Query().Get("state")
+
+	resp, err = newBrowser().Get(server.URL + "/auth/oidc/callback?state=" + state + "&code=bogus")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "invalid_state", returnedToApp(resp).Get("error"))
+}
+
+// mailedToken waits for a mail to an address and returns the token in its link
+func mailedToken(t *testing.T, outbox *utils.OutboxMailer, to, subject string) string {
+	var mail utils.Mail
+	require.Eventually(t, func() bool {
+		var ok bool
+		mail, ok = outbox.LastTo(to)
+		return ok && mail.Subject == subject
+	}, time.Second, 10*time.Millisecond)
+
+	for _, field := range strings.Fields(mail.Body) {
+		if link, err := url.Parse(field); err == nil && link.Query().Get("token") != "" {
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("no link in mail %q", mail.Body)
+	return ""
+}
+
+func TestIntegration_EmailVerificationAndPasswordReset(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	outbox, err := utils.NewOutboxMailer("", "noreply@example.com")
+	require.NoError(t, err)
+	signalingServer.Tickets = handlers.NewTicketStore(models.ConnectTicketTTL)
+	defer signalingServer.Tickets.Stop()
+	signalingServer.Accounts = models.NewAccountStore(bcrypt.MinCost)
+	signalingServer.Email = handlers.NewAccountEmail(outbox, middleware.NewAddressRateLimiter(60, 4), "https://app.example.com")
+
+	status, signup := postJSON(t, server.URL+"/account/signup", "", map[string]string{"username": "alice", "password": "correct horse"})
+	require.Equal(t, http.StatusCreated, status)
+	token := signup["token"].(string)
+
+	// Anonymous sessions cannot add an address
+	anonConn, anon := connectWebSocket(t, server.URL)
+	defer anonConn.Close()
+	status, _ = postJSON(t, server.URL+"/account/email", anon.Payload.(map[string]interface{})["token"].(string), map[string]string{"email": "anon@example.com"})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// Until verified, the address cannot be used to reset the password
+	status, _ = postJSON(t, server.URL+"/account/email", token, map[string]string{"email": "alice@example.com"})
+	require.Equal(t, http.StatusAccepted, status)
+	verifyToken := mailedToken(t, outbox, "alice@example.com", "Verify your email address")
+
+	status, _ = postJSON(t, server.URL+"/account/password/forgot", "", map[string]string{"email": "alice@example.com"})
+	assert.Equal(t, http.StatusAccepted, status)
+
+	status, verified := postJSON(t, server.URL+"/account/email/verify", "", map[string]string{"token": verifyToken})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, verified["email_verified"])
+	status, _ = postJSON(t, server.URL+"/account/email/verify", "", map[string]string{"token": verifyToken})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Claiming a verified address looks the same as claiming a free one,
+	// but nothing is mailed
+	status, bobSignup := postJSON(t, server.URL+"/account/signup", "", map[string]string{"username": "bob", "password": "battery staple"})
+	require.Equal(t, http.StatusCreated, status)
+	sentBefore := len(outbox.Sent())
+	status, claimed := postJSON(t, server.URL+"/account/email", bobSignup["token"].(string), map[string]string{"email": "alice@example.com"})
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, false, claimed["email_verified"])
+	assert.Len(t, outbox.Sent(), sentBefore)
+
+	// Unknown addresses get the same answer as known ones
+	status, _ = postJSON(t, server.URL+"/account/password/forgot", "", map[string]string{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, status)
+	status, _ = postJSON(t, server.URL+"/account/password/forgot", "", map[string]string{"email": "alice@example.com"})
+	require.Equal(t, http.StatusAccepted, status)
+	resetToken := mailedToken(t, outbox, "alice@example.com", "Reset your password")
+	for _, mail := range outbox.Sent() {
+		assert.NotEqual(t, "nobody@example.com", mail.To)
+	}
+
+	// The reset token is single-use and is not spent on a rejected password
+	status, _ = postJSON(t, server.URL+"/account/password/reset", "", map[string]string{"token": resetToken, "password": "short"})
+	assert.Equal(t, models.StatusValidationFailed, status)
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := utils.OpenAuditLog(auditPath, 0)
+	require.NoError(t, err)
+	utils.SetAuditLog(auditLog)
+	defer utils.SetAuditLog(nil)
+	defer auditLog.Close()
+
+	status, reset := postJSON(t, server.URL+"/account/password/reset", "", map[string]string{"token": resetToken, "password": "new password"})
+	require.Equal(t, http.StatusOK, status)
+	audited, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(audited), `"reason":"password_reset"`)
+	assert.Equal(t, signup["account_id"], reset["account_id"])
+	status, _ = postJSON(t, server.URL+"/account/password/reset", "", map[string]string{"token": resetToken, "password": "another password"})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Sessions from before the reset are revoked, the new one is not
+	status, _ = postJSON(t, server.URL+"/account/email", token, map[string]string{"email": "alice@example.org"})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = postJSON(t, server.URL+"/account/email", reset["token"].(string), map[string]string{"email": "alice@example.org"})
+	assert.Equal(t, http.StatusAccepted, status)
+
+	status, _ = postJSON(t, server.URL+"/account/login", "", map[string]string{"username": "alice", "password": "new password"})
+	assert.Equal(t, http.StatusOK, status)
+
+	// Mail to one address is rate limited
+	status, _ = postJSON(t, server.URL+"/account/password/forgot", "", map[string]string{"email": "Alice@example.com"})
+	assert.Equal(t, http.StatusTooManyRequests, status)
+}
+
+func TestIntegration_DeviceIdentity(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	// The first connect registers the device
+	conn, sessionMsg := connectWebSocket(t, server.URL)
+	payload := sessionMsg.Payload.(map[string]interface{})
+	deviceID := payload["device_id"].(string)
+	deviceToken := payload["device_token"].(string)
+	require.NotEmpty(t, deviceID)
+	assert.NotEqual(t, payload["user_id"], deviceID)
+	conn.Close()
+
+	// A ban on the device applies to the next session from it
+	signalingServer.UserPool.ShadowBanIdentity(deviceID, "spam")
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	again, _, err := websocket.DefaultDialer.Dial(wsURL+"?device_auth=frame", nil)
+	require.NoError(t, err)
+	defer again.Close()
+	require.NoError(t, again.WriteJSON(handlers.Message{
+		Type:    models.MessageTypeDeviceToken,
+		Payload: map[string]string{"token": deviceToken},
+	}))
+	againMsg := readUntil(t, again, "session")
+	againPayload := againMsg.Payload.(map[string]interface{})
+	assert.Equal(t, deviceID, againPayload["device_id"])
+	assert.NotContains(t, againPayload, "device_token", "a valid token is not reissued")
+	assert.NotEqual(t, payload["user_id"], againPayload["user_id"])
+
+	require.Eventually(t, func() bool {
+		return signalingServer.UserPool.GetStats()["shadow_waiting_users"] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Tokens in the URL are ignored
+	queried, _, err := websocket.DefaultDialer.Dial(wsURL+"?device_token="+url.QueryEscape(deviceToken), nil)
+	require.NoError(t, err)
+	defer queried.Close()
+	queriedMsg := readUntil(t, queried, "session")
+	assert.NotEqual(t, deviceID, queriedMsg.Payload.(map[string]interface{})["device_id"])
+
+	// An invalid token registers a new device
+	fresh, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"X-Device-Token": {"not-a-token"}})
+	require.NoError(t, err)
+	defer fresh.Close()
+	freshMsg := readUntil(t, fresh, "session")
+	assert.NotEqual(t, deviceID, freshMsg.Payload.(map[string]interface{})["device_id"])
+}
+
+func TestIntegration_Friends(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	alice, aliceSession := connectWebSocket(t, server.URL)
+	bob, _ := connectWebSocket(t, server.URL)
+	defer bob.Close()
+	aliceToken := aliceSession.Payload.(map[string]interface{})["device_token"].(string)
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "find_match"}))
+	readUntil(t, alice, "match_found")
+	readUntil(t, bob, "match_found")
+
+	// Both sides have to ask before anyone is told
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "add_friend"}))
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "add_friend"}))
+	aliceAdded := readUntil(t, alice, "friend_added").Payload.(map[string]interface{})
+	bobAdded := readUntil(t, bob, "friend_added").Payload.(map[string]interface{})
+	friendID := aliceAdded["friend_id"].(string)
+	assert.Equal(t, friendID, bobAdded["friend_id"])
+
+	// The friendship belongs to the device, so it survives a new session
+	alice.Close()
+	readUntil(t, bob, "partner_disconnected")
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	alice, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"X-Device-Token": {aliceToken}})
+	require.NoError(t, err)
+	defer alice.Close()
+	readUntil(t, alice, "session")
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "get_friends"}))
+	friends := readUntil(t, alice, "friends").Payload.(map[string]interface{})["friends"].([]interface{})
+	require.Len(t, friends, 1)
+	assert.Equal(t, friendID, friends[0].(map[string]interface{})["friend_id"])
+	assert.Equal(t, true, friends[0].(map[string]interface{})["online"])
+
+	// Calling the friend skips matchmaking and rings them
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "call_friend", Payload: map[string]string{"friend_id": friendID}}))
+	match := readUntil(t, alice, "match_found").Payload.(map[string]interface{})
+	incoming := readUntil(t, bob, "call_incoming").Payload.(map[string]interface{})
+	assert.Equal(t, match["room_id"], incoming["room_id"])
+	assert.Equal(t, friendID, incoming["friend_id"])
+
+	// Rejecting a friend call ends the room
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "call_reject"}))
+	readUntil(t, alice, "call_rejected")
+	readUntil(t, alice, "room_ended")
+	readUntil(t, bob, "room_ended")
+
+	// Hanging up an answered friend call ends the room, so they can call again
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "call_friend", Payload: map[string]string{"friend_id": friendID}}))
+	readUntil(t, bob, "call_incoming")
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "call_accept"}))
+	readUntil(t, alice, "call_accepted")
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "call_end"}))
+	readUntil(t, bob, "call_ended")
+	readUntil(t, bob, "room_ended")
+	readUntil(t, alice, "room_ended")
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "call_friend", Payload: map[string]string{"friend_id": friendID}}))
+	readUntil(t, alice, "call_incoming")
+
+	// Unknown friend IDs are refused
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "call_friend", Payload: map[string]string{"friend_id": "unknown"}}))
+	readUntil(t, bob, "error")
+}
+
+// readPresence reads until a presence message reports a friend in status
+func readPresence(t *testing.T, conn *websocket.Conn, friendID, status string) {
+	for {
+		msg := readUntil(t, conn, "presence")
+		for _, friend := range msg.Payload.(map[string]interface{})["friends"].([]interface{}) {
+			entry := friend.(map[string]interface{})
+			if entry["friend_id"] == friendID && entry["status"] == status {
+				return
+			}
+		}
+	}
+}
+
+func TestIntegration_Presence(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	alice, aliceSession := connectWebSocket(t, server.URL)
+	bob, _ := connectWebSocket(t, server.URL)
+	defer bob.Close()
+	aliceToken := aliceSession.Payload.(map[string]interface{})["device_token"].(string)
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "find_match"}))
+	readUntil(t, alice, "match_found")
+	readUntil(t, bob, "match_found")
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "add_friend"}))
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "add_friend"}))
+	friendID := readUntil(t, bob, "friend_added").Payload.(map[string]interface{})["friend_id"].(string)
+
+	// Subscribing answers with the current state of every friend
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "subscribe_presence"}))
+	readPresence(t, bob, friendID, "in_call")
+
+	alice.Close()
+	readPresence(t, bob, friendID, "offline")
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	alice, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"X-Device-Token": {aliceToken}})
+	require.NoError(t, err)
+	defer alice.Close()
+	readUntil(t, alice, "session")
+	readPresence(t, bob, friendID, "online")
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "set_presence", Payload: map[string]bool{"away": true}}))
+	readPresence(t, bob, friendID, "away")
+
+	// Appearing offline hides the friend from presence and the friend list
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "set_presence", Payload: map[string]bool{"appear_offline": true}}))
+	readPresence(t, bob, friendID, "offline")
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "get_friends"}))
+	friends := readUntil(t, bob, "friends").Payload.(map[string]interface{})["friends"].([]interface{})
+	require.Len(t, friends, 1)
+	assert.Equal(t, false, friends[0].(map[string]interface{})["online"])
+
+	stats := signalingServer.GetStats()["presence"].(map[string]interface{})
+	assert.Equal(t, 1, stats["subscribers"])
+}
+
+func TestIntegration_PushRingsOfflineFriend(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+	fake := signalingServer.Push.Providers[models.PushPlatformFCM].(*utils.FakePushProvider)
+
+	alice, _ := connectWebSocket(t, server.URL)
+	defer alice.Close()
+	bob, bobSession := connectWebSocket(t, server.URL)
+	bobPayload := bobSession.Payload.(map[string]interface{})
+	bobDevice := bobPayload["device_id"].(string)
+	bobToken := bobPayload["device_token"].(string)
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "find_match"}))
+	readUntil(t, alice, "match_found")
+	readUntil(t, bob, "match_found")
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "add_friend"}))
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "add_friend"}))
+	friendID := readUntil(t, alice, "friend_added").Payload.(map[string]interface{})["friend_id"].(string)
+
+	// Bob registers a push token and closes the app
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "register_push", Payload: map[string]string{"platform": "fcm", "token": "bob-push-token"}}))
+	require.Eventually(t, func() bool {
+		return len(signalingServer.Push.Tokens.Lookup(bobDevice)) == 1
+	}, time.Second, 10*time.Millisecond)
+	bob.Close()
+	readUntil(t, alice, "partner_disconnected")
+
+	// Calling him rings his device instead of failing
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "call_friend", Payload: map[string]string{"friend_id": friendID}}))
+	ringing := readUntil(t, alice, "call_ringing").Payload.(map[string]interface{})
+	assert.Equal(t, friendID, ringing["friend_id"])
+	require.Eventually(t, func() bool { return len(fake.Sent()) == 1 }, time.Second, 10*time.Millisecond)
+	push := fake.Sent()[0]
+	assert.Equal(t, "bob-push-token", push.Token)
+	assert.Equal(t, "call_incoming", push.Data["type"])
+	assert.Equal(t, models.RingingTimeout, push.TTL)
+
+	// Opening the app from the push connects the call
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	bob, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"X-Device-Token": {bobToken}})
+	require.NoError(t, err)
+	defer bob.Close()
+	incoming := readUntil(t, bob, "call_incoming").Payload.(map[string]interface{})
+	match := readUntil(t, alice, "match_found").Payload.(map[string]interface{})
+	assert.Equal(t, match["room_id"], incoming["room_id"])
+	assert.Equal(t, friendID, incoming["friend_id"])
+	assert.Equal(t, 1, signalingServer.GetStats()["push"].(map[string]interface{})["answered"])
+}
+
+// oggOpus builds a minimal Ogg Opus stream lasting duration
+func oggOpus(duration time.Duration) []byte {
+	page := func(granule int64, body []byte) []byte {
+		header := make([]byte, 28)
+		copy(header, "OggS")
+		binary.LittleEndian.PutUint64(header[6:], uint64(granule))
+		header[26] = 1
+		header[27] = byte(len(body))
+		return append(header, body...)
+	}
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8], head[9] = 1, 1
+	binary.LittleEndian.PutUint32(head[12:], 48000)
+
+	data := append(page(0, head), page(-1, []byte("OpusTags"))...)
+	return append(data, page(int64(duration/time.Millisecond)*48, make([]byte, 200))...)
+}
+
+// voiceNoteRequest sends a voice note request and returns the response
+func voiceNoteRequest(t *testing.T, method, url, bearer, deviceToken string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	if deviceToken != "" {
+		req.Header.Set("X-Device-Token", deviceToken)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "audio/ogg; codecs=opus")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestIntegration_VoiceNotes(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+	fake := signalingServer.Push.Providers[models.PushPlatformFCM].(*utils.FakePushProvider)
+
+	blobs, err := utils.NewFileBlobStore(t.TempDir())
+	require.NoError(t, err)
+	signalingServer.VoiceNotes = handlers.NewVoiceNotes(blobs, models.NewVoiceNoteStore(0, 0), utils.NewURLSigner([]byte("voice-note-test-secret"), "voice-notes"), handlers.VoiceNotesConfig{
+		MaxBytes:    4096,
+		MaxDuration: 5 * time.Second,
+	})
+
+	alice, aliceSession := connectWebSocket(t, server.URL)
+	defer alice.Close()
+	bob, bobSession := connectWebSocket(t, server.URL)
+	aliceJWT := aliceSession.Payload.(map[string]interface{})["token"].(string)
+	bobPayload := bobSession.Payload.(map[string]interface{})
+	bobJWT := bobPayload["token"].(string)
+	bobDeviceToken := bobPayload["device_token"].(string)
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "find_match"}))
+	readUntil(t, alice, "match_found")
+	readUntil(t, bob, "match_found")
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "add_friend"}))
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "add_friend"}))
+	friendID := readUntil(t, alice, "friend_added").Payload.(map[string]interface{})["friend_id"].(string)
+	uploadURL := server.URL + "/voice-notes?friend_id=" + friendID
+
+	// A connected friend is told over the WebSocket
+	resp := voiceNoteRequest(t, http.MethodPost, uploadURL, aliceJWT, "", oggOpus(2*time.Second))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, float64(2000), created["duration_ms"])
+	firstID := created["id"].(string)
+
+	note := readUntil(t, bob, "voice_note").Payload.(map[string]interface{})
+	assert.Equal(t, firstID, note["id"])
+	assert.Equal(t, friendID, note["friend_id"])
+
+	// The signed URL works without a session; a tampered one does not
+	resp = voiceNoteRequest(t, http.MethodGet, server.URL+note["url"].(string), "", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "audio/ogg", resp.Header.Get("Content-Type"))
+	audio, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, oggOpus(2*time.Second), audio)
+	resp = voiceNoteRequest(t, http.MethodGet, server.URL+"/voice-notes/"+firstID+"?expires=9999999999&signature=forged", "", "", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Limits, authentication and friendship are enforced
+	resp = voiceNoteRequest(t, http.MethodPost, uploadURL, aliceJWT, "", oggOpus(10*time.Second))
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	resp = voiceNoteRequest(t, http.MethodPost, uploadURL, aliceJWT, "", append(oggOpus(time.Second), make([]byte, 4096)...))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp = voiceNoteRequest(t, http.MethodPost, uploadURL, aliceJWT, "", []byte("not audio"))
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	resp = voiceNoteRequest(t, http.MethodPost, uploadURL, "", "", oggOpus(time.Second))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = voiceNoteRequest(t, http.MethodPost, server.URL+"/voice-notes?friend_id=unknown", aliceJWT, "", oggOpus(time.Second))
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// A friend whose app is closed is told by push
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "register_push", Payload: map[string]string{"platform": "fcm", "token": "bob-push-token"}}))
+	require.Eventually(t, func() bool {
+		return len(signalingServer.Push.Tokens.Lookup(bobPayload["device_id"].(string))) == 1
+	}, time.Second, 10*time.Millisecond)
+	bob.Close()
+	readUntil(t, alice, "partner_disconnected")
+
+	resp = voiceNoteRequest(t, http.MethodPost, uploadURL, aliceJWT, "", oggOpus(time.Second))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Eventually(t, func() bool { return len(fake.Sent()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "voice_note", fake.Sent()[0].Data["type"])
+
+	// Bob lists his notes with his old session and device token
+	list := func() []interface{} {
+		resp := voiceNoteRequest(t, http.MethodGet, server.URL+"/voice-notes", bobJWT, bobDeviceToken, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body["voice_notes"].([]interface{})
+	}
+	notes := list()
+	require.Len(t, notes, 2)
+	assert.Equal(t, firstID, notes[0].(map[string]interface{})["id"])
+
+	// The sender can delete a note; strangers cannot tell it exists
+	carol, carolSession := connectWebSocket(t, server.URL)
+	defer carol.Close()
+	carolJWT := carolSession.Payload.(map[string]interface{})["token"].(string)
+	resp = voiceNoteRequest(t, http.MethodDelete, server.URL+"/voice-notes/"+firstID, carolJWT, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = voiceNoteRequest(t, http.MethodDelete, server.URL+"/voice-notes/"+firstID, aliceJWT, "", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Len(t, list(), 1)
+	assert.Equal(t, 1, signalingServer.GetStats()["voice_notes"].(map[string]interface{})["notes"])
+}
+
+func TestIntegration_ScheduledCalls(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	clock := utils.NewFakeClock(time.Now().Truncate(time.Second))
+	signalingServer.Scheduler = handlers.NewCallScheduler(models.NewScheduledCallStore(0), clock, []time.Duration{15 * time.Minute, time.Minute})
+	signalingServer.StartScheduler()
+
+	alice, _ := connectWebSocket(t, server.URL)
+	defer alice.Close()
+	bob, bobSession := connectWebSocket(t, server.URL)
+	bobToken := bobSession.Payload.(map[string]interface{})["device_token"].(string)
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "find_match"}))
+	readUntil(t, alice, "match_found")
+	readUntil(t, bob, "match_found")
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "add_friend"}))
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "add_friend"}))
+	friendID := readUntil(t, alice, "friend_added").Payload.(map[string]interface{})["friend_id"].(string)
+
+	// Leave the match so both are free when the call starts
+	bob.Close()
+	readUntil(t, alice, "partner_disconnected")
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	bob, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"X-Device-Token": {bobToken}})
+	require.NoError(t, err)
+	defer bob.Close()
+	readUntil(t, bob, "session")
+
+	schedule := func(startsAt time.Time) string {
+		require.NoError(t, alice.WriteJSON(handlers.Message{Type: "schedule_call", Payload: map[string]string{
+			"friend_id": friendID,
+			"starts_at": startsAt.Format(time.RFC3339),
+		}}))
+		scheduled := readUntil(t, alice, "call_scheduled").Payload.(map[string]interface{})
+		assert.Equal(t, true, scheduled["organizer"])
+		invited := readUntil(t, bob, "call_scheduled").Payload.(map[string]interface{})
+		assert.Equal(t, false, invited["organizer"])
+		assert.Equal(t, scheduled["id"], invited["id"])
+		return scheduled["id"].(string)
+	}
+
+	// Times in the past are refused
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "schedule_call", Payload: map[string]string{
+		"friend_id": friendID,
+		"starts_at": clock.Now().Add(-time.Minute).Format(time.RFC3339),
+	}}))
+	readUntil(t, alice, "error")
+
+	// A cancelled call is not rung
+	cancelled := schedule(clock.Now().Add(30 * time.Minute))
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "cancel_scheduled_call", Payload: map[string]string{"id": cancelled}}))
+	readUntil(t, alice, "scheduled_call_cancelled")
+	readUntil(t, bob, "scheduled_call_cancelled")
+
+	callID := schedule(clock.Now().Add(time.Hour))
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "get_scheduled_calls"}))
+	calls := readUntil(t, bob, "scheduled_calls").Payload.(map[string]interface{})["calls"].([]interface{})
+	require.Len(t, calls, 1)
+	assert.Equal(t, callID, calls[0].(map[string]interface{})["id"])
+
+	// Reminders go to both parties before the start
+	clock.Advance(45 * time.Minute)
+	assert.Equal(t, callID, readUntil(t, alice, "call_reminder").Payload.(map[string]interface{})["id"])
+	assert.Equal(t, callID, readUntil(t, bob, "call_reminder").Payload.(map[string]interface{})["id"])
+
+	// At the start both are rung into one private room
+	clock.Advance(15 * time.Minute)
+	readUntil(t, alice, "call_reminder")
+	aliceRing := readUntil(t, alice, "call_incoming").Payload.(map[string]interface{})
+	bobRing := readUntil(t, bob, "call_incoming").Payload.(map[string]interface{})
+	assert.Equal(t, aliceRing["room_id"], bobRing["room_id"])
+	assert.Equal(t, callID, aliceRing["scheduled_call_id"])
+	assert.Equal(t, "caller", aliceRing["role"])
+	assert.Equal(t, "callee", bobRing["role"])
+
+	stats := signalingServer.GetStats()["scheduled_calls"].(map[string]interface{})
+	assert.Equal(t, 0, stats["scheduled"])
+	assert.Equal(t, 1, stats["started"])
+	assert.Equal(t, 1, stats["cancelled"])
+	assert.Equal(t, 2, stats["reminded"])
+}
+
+func TestIntegration_ContactExchange(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	alice, _ := connectWebSocket(t, server.URL)
+	defer alice.Close()
+	bob, _ := connectWebSocket(t, server.URL)
+	defer bob.Close()
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "find_match"}))
+	room := readUntil(t, alice, "match_found").Payload.(map[string]interface{})["room_id"]
+	readUntil(t, bob, "match_found")
+
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "share_contact", Payload: map[string]string{"handle": "line\nbreak"}}))
+	readUntil(t, alice, "error")
+
+	// The partner learns a handle is waiting, but not what it is
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "share_contact", Payload: map[string]string{"handle": "@alice"}}))
+	requested := readUntil(t, bob, "contact_requested").Payload.(map[string]interface{})
+	assert.Equal(t, room, requested["room_id"])
+	assert.NotContains(t, requested, "handle")
+
+	// Once both have shared, each receives the other's handle
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "share_contact", Payload: map[string]string{"handle": "@bob"}}))
+	assert.Equal(t, "@bob", readUntil(t, alice, "contact_revealed").Payload.(map[string]interface{})["handle"])
+	assert.Equal(t, "@alice", readUntil(t, bob, "contact_revealed").Payload.(map[string]interface{})["handle"])
+
+	// A withdrawn handle is never revealed
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "share_contact", Payload: map[string]string{"handle": "@bob2"}}))
+	readUntil(t, alice, "contact_requested")
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "withdraw_contact"}))
+	readUntil(t, alice, "contact_withdrawn")
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "share_contact", Payload: map[string]string{"handle": "@alice"}}))
+	readUntil(t, bob, "contact_requested")
+
+	// Nor is a handle sealed when the call ends, though the pair stays matched
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "call_end"}))
+	readUntil(t, bob, "call_ended")
+	assert.Equal(t, 2, signalingServer.UserPool.GetStats()["active_users"])
+	require.NoError(t, bob.WriteJSON(handlers.Message{Type: "share_contact", Payload: map[string]string{"handle": "@bob"}}))
+	readUntil(t, bob, "error")
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "share_contact", Payload: map[string]string{"handle": "@alice"}}))
+	readUntil(t, alice, "error")
+}
+
+func TestIntegration_DisplayNames(t *testing.T) {
+	server, signalingServer := setupTestServer()
+	defer server.Close()
+	defer signalingServer.UserPool.Shutdown()
+
+	alice, aliceSession := connectWebSocket(t, server.URL)
+	defer alice.Close()
+	bob, bobSession := connectWebSocket(t, server.URL)
+	defer bob.Close()
+
+	aliceInfo := aliceSession.Payload.(map[string]interface{})
+	bobInfo := bobSession.Payload.(map[string]interface{})
+	assert.NotEmpty(t, aliceInfo["display_name"])
+	assert.NotEmpty(t, aliceInfo["avatar_seed"])
+	assert.NotEqual(t, aliceInfo["display_name"], bobInfo["display_name"])
+
+	// A chosen name must be valid, pass the filter and be free
+	for _, name := range []string{"x", "The Adm1n", bobInfo["display_name"].(string)} {
+		require.NoError(t, alice.WriteJSON(handlers.Message{Type: "set_display_name", Payload: map[string]string{"display_name": name}}))
+		readUntil(t, alice, "error")
+	}
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "set_display_name", Payload: map[string]string{"display_name": "  Night  Owl "}}))
+	set := readUntil(t, alice, "display_name_set").Payload.(map[string]interface{})
+	assert.Equal(t, "Night Owl", set["display_name"])
+
+	// Partners see each other's names in match_found
+	require.NoError(t, alice.WriteJSON(handlers.Message{Type: "find_match"}))
+	match := readUntil(t, alice, "match_found").Payload.(map[string]interface{})
+	assert.Equal(t, bobInfo["display_name"], match["partner_name"])
+	assert.Equal(t, bobInfo["avatar_seed"], match["partner_avatar_seed"])
+	match = readUntil(t, bob, "match_found").Payload.(map[string]interface{})
+	assert.Equal(t, "Night Owl", match["partner_name"])
+	assert.Equal(t, aliceInfo["avatar_seed"], match["partner_avatar_seed"])
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Action token purposes
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+// ErrTokenUsed is returned for an action token that was already consumed
+var ErrTokenUsed = errors.New("token has already been used")
+
+// ActionClaims are the claims of a single-use token mailed to a user, such
+// as an email verification or password reset link. They are signed by the
+// session keyring but are never accepted as a session: they carry no user
+// or session ID.
+type ActionClaims struct {
+	Purpose   string `json:"purpose"`
+	AccountID string `json:"account_id"`
+	Email     string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// consumeMutex makes checking and recording a used token atomic
+var consumeMutex sync.Mutex
+
+// GenerateActionToken issues a single-use token for an account and address
+func GenerateActionToken(purpose, accountID, email string, ttl time.Duration) (string, error) {
+	ensureJWTInit()
+
+	now := time.Now()
+	claims := &ActionClaims{
+		Purpose:   purpose,
+		AccountID: accountID,
+		Email:     email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "voice-chat-app",
+			Audience:  jwt.ClaimStrings{purpose},
+			Subject:   accountID,
+			ID:        GenerateUUID(),
+		},
+	}
+	return getKeyring().Sign(claims)
+}
+
+// ConsumeActionToken validates an action token for a purpose and marks it
+// used. Used tokens are recorded in the revocation store until they expire,
+// so a persistent store keeps them single-use across restarts.
+func ConsumeActionToken(tokenString, purpose string) (*ActionClaims, error) {
+	ensureJWTInit()
+
+	claims := &ActionClaims{}
+	token, err := getKeyring().Parse(tokenString, claims)
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrInvalidToken
+	}
+	if !token.Valid || claims.Purpose != purpose || !claims.VerifyAudience(purpose, true) ||
+		claims.AccountID == "" || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+
+	consumeMutex.Lock()
+	defer consumeMutex.Unlock()
+
+	store := getRevocationStore()
+	if store.IsRevoked(claims.ID) {
+		return nil, ErrTokenUsed
+	}
+	if err := store.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionToken_SingleUse(t *testing.T) {
+	previous := getRevocationStore()
+	SetRevocationStore(NewMemoryRevocationStore())
+	defer SetRevocationStore(previous)
+
+	token, err := GenerateActionToken(TokenPurposeResetPassword, "account-1", "alice@example.com", time.Hour)
+	require.NoError(t, err)
+
+	// A token is only good for its own purpose
+	_, err = ConsumeActionToken(token, TokenPurposeVerifyEmail)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	claims, err := ConsumeActionToken(token, TokenPurposeResetPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "account-1", claims.AccountID)
+	assert.Equal(t, "alice@example.com", claims.Email)
+
+	_, err = ConsumeActionToken(token, TokenPurposeResetPassword)
+	assert.Equal(t, ErrTokenUsed, err)
+}
+
+func TestActionToken_Expired(t *testing.T) {
+	token, err := GenerateActionToken(TokenPurposeVerifyEmail, "account-1", "alice@example.com", -time.Minute)
+	require.NoError(t, err)
+
+	_, err = ConsumeActionToken(token, TokenPurposeVerifyEmail)
+	assert.Equal(t, ErrTokenExpired, err)
+}
+
+func TestActionToken_NotInterchangeableWithSessions(t *testing.T) {
+	session, err := GenerateAccountToken("user-1", "account-1")
+	require.NoError(t, err)
+	_, err = ConsumeActionToken(session, TokenPurposeResetPassword)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	action, err := GenerateActionToken(TokenPurposeVerifyEmail, "account-1", "alice@example.com", time.Hour)
+	require.NoError(t, err)
+	_, err = ValidateJWT(action)
+	assert.Equal(t, ErrInvalidToken, err)
+}
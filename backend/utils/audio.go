@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"time"
+)
+
+// Audio errors
+var (
+	ErrUnsupportedAudio = errors.New("unsupported audio format")
+	ErrInvalidAudio     = errors.New("invalid audio data")
+)
+
+// Audio content types accepted for voice notes
+const (
+	AudioTypeOgg  = "audio/ogg"
+	AudioTypeWebM = "audio/webm"
+)
+
+// OpusDuration checks that data is Opus audio in an Ogg or WebM container
+// and returns its duration, measured from the container rather than trusted
+// from the client. contentType may carry parameters such as codecs=opus.
+func OpusDuration(contentType string, data []byte) (time.Duration, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0, ErrUnsupportedAudio
+	}
+
+	switch mediaType {
+	case AudioTypeOgg:
+		return oggOpusDuration(data)
+	case AudioTypeWebM:
+		return webmOpusDuration(data)
+	default:
+		return 0, ErrUnsupportedAudio
+	}
+}
+
+// oggOpusDuration walks the pages of an Ogg Opus stream. The duration is the
+// last page's granule position, in 48 kHz samples, less the encoder's
+// pre-skip.
+func oggOpusDuration(data []byte) (time.Duration, error) {
+	const headerSize = 27
+
+	var serial uint32
+	var preSkip uint16
+	granule := int64(-1)
+	for offset, page := 0, 0; offset < len(data); page++ {
+		if len(data)-offset < headerSize || !bytes.Equal(data[offset:offset+4], []byte("OggS")) {
+			return 0, fmt.Errorf("%w: bad Ogg page at %d", ErrInvalidAudio, offset)
+		}
+		header := data[offset : offset+headerSize]
+		segments := int(header[26])
+		if len(data)-offset < headerSize+segments {
+			return 0, fmt.Errorf("%w: truncated Ogg page", ErrInvalidAudio)
+		}
+		bodySize := 0
+		for _, lacing := range data[offset+headerSize : offset+headerSize+segments] {
+			bodySize += int(lacing)
+		}
+		bodyStart := offset + headerSize + segments
+		if len(data)-bodyStart < bodySize {
+			return 0, fmt.Errorf("%w: truncated Ogg page", ErrInvalidAudio)
+		}
+		body := data[bodyStart : bodyStart+bodySize]
+
+		pageSerial := binary.LittleEndian.Uint32(header[14:18])
+		if page == 0 {
+			// The first page holds the Opus identification header
+			if len(body) < 19 || !bytes.Equal(body[:8], []byte("OpusHead")) {
+				return 0, ErrUnsupportedAudio
+			}
+			serial = pageSerial
+			preSkip = binary.LittleEndian.Uint16(body[10:12])
+		} else if pageSerial != serial {
+			return 0, fmt.Errorf("%w: more than one logical stream", ErrUnsupportedAudio)
+		}
+
+		// Pages where no packet ends carry a granule position of -1
+		if position := int64(binary.LittleEndian.Uint64(header[6:14])); position >= 0 {
+			granule = position
+		}
+		offset = bodyStart + bodySize
+	}
+
+	if granule < int64(preSkip) {
+		return 0, fmt.Errorf("%w: no audio", ErrInvalidAudio)
+	}
+	return time.Duration(granule-int64(preSkip)) * time.Second / 48000, nil
+}
+
+// WebM element IDs, including their length marker bits
+const (
+	ebmlIDHeader        = 0x1A45DFA3
+	ebmlIDDocType       = 0x4282
+	ebmlIDSegment       = 0x18538067
+	ebmlIDInfo          = 0x1549A966
+	ebmlIDTimecodeScale = 0x2AD7B1
+	ebmlIDDuration      = 0x4489
+	ebmlIDTracks        = 0x1654AE6B
+	ebmlIDTrackEntry    = 0xAE
+	ebmlIDCodecID       = 0x86
+	ebmlIDCluster       = 0x1F43B675
+	ebmlIDTimecode      = 0xE7
+	ebmlIDBlockGroup    = 0xA0
+	ebmlIDBlock         = 0xA1
+	ebmlIDSimpleBlock   = 0xA3
+)
+
+// webmOpusDuration reads a WebM file whose only track is Opus audio. The
+// duration comes from the segment info when the muxer wrote it; browsers
+// recording live leave it out, so otherwise it is the latest block timestamp.
+//
+// Elements are read as one flat sequence: the segment, info, tracks, track
+// entries, clusters and block groups are entered rather than skipped, so the
+// unknown-size segment and clusters that recorders write need no special
+// handling.
+func webmOpusDuration(data []byte) (time.Duration, error) {
+	id, size, offset, err := ebmlElement(data, 0)
+	if err != nil || id != ebmlIDHeader || size < 0 || offset+int(size) > len(data) {
+		return 0, ErrUnsupportedAudio
+	}
+	docType := ""
+	for header := data[offset : offset+int(size)]; len(header) > 0; {
+		childID, childSize, childOffset, err := ebmlElement(header, 0)
+		if err != nil || childSize < 0 || childOffset+int(childSize) > len(header) {
+			return 0, fmt.Errorf("%w: bad EBML header", ErrInvalidAudio)
+		}
+		if childID == ebmlIDDocType {
+			docType = string(bytes.TrimRight(header[childOffset:childOffset+int(childSize)], "\x00"))
+		}
+		header = header[childOffset+int(childSize):]
+	}
+	if docType != "webm" {
+		return 0, ErrUnsupportedAudio
+	}
+	offset += int(size)
+
+	entered := map[uint32]bool{
+		ebmlIDSegment: true, ebmlIDInfo: true, ebmlIDTracks: true, ebmlIDTrackEntry: true,
+		ebmlIDCluster: true, ebmlIDBlockGroup: true,
+	}
+	scale := int64(1000000) // nanoseconds per timecode tick
+	var duration float64
+	var cluster, latest int64
+	tracks := 0
+	for offset < len(data) {
+		id, size, payload, err := ebmlElement(data, offset)
+		if err != nil {
+			return 0, err
+		}
+		if entered[id] {
+			if id == ebmlIDTrackEntry {
+				tracks++
+			}
+			offset = payload
+			continue
+		}
+		if size < 0 || payload+int(size) > len(data) {
+			return 0, fmt.Errorf("%w: truncated element %X", ErrInvalidAudio, id)
+		}
+		value := data[payload : payload+int(size)]
+		offset = payload + int(size)
+
+		switch id {
+		case ebmlIDTimecodeScale:
+			scale = int64(ebmlUint(value))
+		case ebmlIDDuration:
+			switch len(value) {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(value)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(value))
+			}
+		case ebmlIDCodecID:
+			if string(bytes.TrimRight(value, "\x00")) != "A_OPUS" {
+				return 0, ErrUnsupportedAudio
+			}
+		case ebmlIDTimecode:
+			cluster = int64(ebmlUint(value))
+		case ebmlIDSimpleBlock, ebmlIDBlock:
+			// Track number, then a timecode relative to the cluster
+			_, width, err := ebmlVint(value, 0, false)
+			if err != nil || len(value) < width+2 {
+				return 0, fmt.Errorf("%w: bad block", ErrInvalidAudio)
+			}
+			if timecode := cluster + int64(int16(binary.BigEndian.Uint16(value[width:]))); timecode > latest {
+				latest = timecode
+			}
+		}
+	}
+
+	if tracks != 1 {
+		return 0, fmt.Errorf("%w: expected a single audio track", ErrUnsupportedAudio)
+	}
+	if scale <= 0 {
+		return 0, fmt.Errorf("%w: bad timecode scale", ErrInvalidAudio)
+	}
+	if duration > 0 {
+		return time.Duration(duration * float64(scale)), nil
+	}
+	return time.Duration(latest * scale), nil
+}
+
+// ebmlElement reads the ID and size of the element at offset, returning
+// where its payload starts. An unknown size is returned as -1.
+func ebmlElement(data []byte, offset int) (id uint32, size int64, payload int, err error) {
+	rawID, idWidth, err := ebmlVint(data, offset, true)
+	if err != nil || idWidth > 4 {
+		return 0, 0, 0, fmt.Errorf("%w: bad element ID at %d", ErrInvalidAudio, offset)
+	}
+	rawSize, sizeWidth, err := ebmlVint(data, offset+idWidth, false)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: bad element size at %d", ErrInvalidAudio, offset)
+	}
+
+	size = int64(rawSize)
+	if rawSize == 1<<(7*sizeWidth)-1 {
+		size = -1
+	}
+	return uint32(rawID), size, offset + idWidth + sizeWidth, nil
+}
+
+// ebmlVint reads a variable-length integer, keeping the length marker for
+// element IDs and stripping it for sizes
+func ebmlVint(data []byte, offset int, keepMarker bool) (uint64, int, error) {
+	if offset >= len(data) || data[offset] == 0 {
+		return 0, 0, ErrInvalidAudio
+	}
+	width := 1
+	for mask := byte(0x80); data[offset]&mask == 0; mask >>= 1 {
+		width++
+	}
+	if offset+width > len(data) {
+		return 0, 0, ErrInvalidAudio
+	}
+
+	value := uint64(data[offset])
+	if !keepMarker {
+		value &= uint64(0xFF >> width)
+	}
+	for _, b := range data[offset+1 : offset+width] {
+		value = value<<8 | uint64(b)
+	}
+	return value, width, nil
+}
+
+// ebmlUint decodes a big-endian unsigned integer element
+func ebmlUint(value []byte) uint64 {
+	var n uint64
+	for _, b := range value {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
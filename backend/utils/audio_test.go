@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oggPage builds an Ogg page holding body as a single packet. The CRC is
+// left zero as it is not checked.
+func oggPage(serial uint32, granule int64, body []byte) []byte {
+	page := make([]byte, 27, 27+len(body)/255+1+len(body))
+	copy(page, "OggS")
+	binary.LittleEndian.PutUint64(page[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:], serial)
+	lacing := make([]byte, 0, len(body)/255+1)
+	for n := len(body); ; n -= 255 {
+		if n < 255 {
+			lacing = append(lacing, byte(n))
+			break
+		}
+		lacing = append(lacing, 255)
+	}
+	page[26] = byte(len(lacing))
+	page = append(page, lacing...)
+	return append(page, body...)
+}
+
+// oggOpus builds an Ogg Opus stream whose last page ends at granule
+func oggOpus(preSkip uint16, granule int64) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // version
+	head[9] = 1 // channels
+	binary.LittleEndian.PutUint16(head[10:], preSkip)
+	binary.LittleEndian.PutUint32(head[12:], 48000)
+
+	data := oggPage(7, 0, head)
+	data = append(data, oggPage(7, -1, []byte("OpusTags"))...)
+	return append(data, oggPage(7, granule, make([]byte, 300))...)
+}
+
+// ebml encodes an element with a one to four byte ID and an eight byte size
+func ebml(id uint32, payload ...[]byte) []byte {
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+	body := []byte{}
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(body)))
+	size[0] = 0x01
+	return append(append(out, size...), body...)
+}
+
+// webmOpus builds a WebM file with one Opus track. A zero duration leaves
+// the segment duration out, as recorders do.
+func webmOpus(codec string, duration float64, blockTimecodes ...int16) []byte {
+	info := [][]byte{ebml(ebmlIDTimecodeScale, []byte{0x0F, 0x42, 0x40})}
+	if duration > 0 {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, math.Float64bits(duration))
+		info = append(info, ebml(ebmlIDDuration, value))
+	}
+
+	cluster := [][]byte{ebml(ebmlIDTimecode, []byte{0x03, 0xE8})} // 1000
+	for _, timecode := range blockTimecodes {
+		block := []byte{0x81, byte(uint16(timecode) >> 8), byte(timecode), 0x80, 0xFC}
+		cluster = append(cluster, ebml(ebmlIDSimpleBlock, block))
+	}
+
+	return append(
+		ebml(ebmlIDHeader, ebml(ebmlIDDocType, []byte("webm"))),
+		ebml(ebmlIDSegment,
+			ebml(ebmlIDInfo, info...),
+			ebml(ebmlIDTracks, ebml(ebmlIDTrackEntry, ebml(ebmlIDCodecID, []byte(codec)))),
+			ebml(ebmlIDCluster, cluster...),
+		)...,
+	)
+}
+
+func TestOpusDuration_Ogg(t *testing.T) {
+	duration, err := OpusDuration("audio/ogg; codecs=opus", oggOpus(312, 312+48000*5/2))
+	require.NoError(t, err)
+	assert.Equal(t, 2500*time.Millisecond, duration)
+
+	// Not Opus
+	vorbis := oggPage(1, 0, append([]byte("\x01vorbis"), make([]byte, 30)...))
+	_, err = OpusDuration(AudioTypeOgg, vorbis)
+	assert.ErrorIs(t, err, ErrUnsupportedAudio)
+
+	// A second logical stream
+	mixed := append(oggOpus(0, 48000), oggPage(8, 48000, []byte("x"))...)
+	_, err = OpusDuration(AudioTypeOgg, mixed)
+	assert.ErrorIs(t, err, ErrUnsupportedAudio)
+
+	// Truncated
+	data := oggOpus(0, 48000)
+	_, err = OpusDuration(AudioTypeOgg, data[:len(data)-10])
+	assert.ErrorIs(t, err, ErrInvalidAudio)
+
+	// Garbage
+	_, err = OpusDuration(AudioTypeOgg, []byte("not audio at all, just some text"))
+	assert.ErrorIs(t, err, ErrInvalidAudio)
+}
+
+func TestOpusDuration_WebM(t *testing.T) {
+	// Duration from the segment info, in timecode ticks of 1ms
+	duration, err := OpusDuration("audio/webm;codecs=opus", webmOpus("A_OPUS", 4200, 0, 20))
+	require.NoError(t, err)
+	assert.Equal(t, 4200*time.Millisecond, duration)
+
+	// Without it, the latest block: cluster 1000 + 1500
+	duration, err = OpusDuration(AudioTypeWebM, webmOpus("A_OPUS", 0, 0, 1500, 20))
+	require.NoError(t, err)
+	assert.Equal(t, 2500*time.Millisecond, duration)
+
+	_, err = OpusDuration(AudioTypeWebM, webmOpus("V_VP8", 1000, 0))
+	assert.ErrorIs(t, err, ErrUnsupportedAudio)
+
+	data := webmOpus("A_OPUS", 1000, 0)
+	_, err = OpusDuration(AudioTypeWebM, data[:len(data)-3])
+	assert.ErrorIs(t, err, ErrInvalidAudio)
+
+	matroska := append(ebml(ebmlIDHeader, ebml(ebmlIDDocType, []byte("matroska"))), ebml(ebmlIDSegment)...)
+	_, err = OpusDuration(AudioTypeWebM, matroska)
+	assert.ErrorIs(t, err, ErrUnsupportedAudio)
+}
+
+func TestOpusDuration_UnsupportedType(t *testing.T) {
+	for _, contentType := range []string{"", "audio/mpeg", "text/html", "audio/ogg; bad=\"param"} {
+		_, err := OpusDuration(contentType, oggOpus(0, 48000))
+		assert.ErrorIs(t, err, ErrUnsupportedAudio, contentType)
+	}
+}
@@ -0,0 +1,380 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Audit actions
+const (
+	AuditActionShadowBan     = "shadow_ban"
+	AuditActionShadowBanLift = "shadow_ban_lift"
+	AuditActionKick          = "kick"
+	AuditActionRoomEnd       = "room_end"
+	AuditActionTokenRevoke   = "token_revoke"
+)
+
+// hashFieldPrefix separates an entry's body from its trailing hash field
+var hashFieldPrefix = []byte(`,"hash":"`)
+
+// Audit log errors
+var (
+	ErrAuditChainBroken = errors.New("audit log hash chain is broken")
+	ErrAuditMalformed   = errors.New("audit log entry is malformed")
+)
+
+// AuditActor identifies who performed an audited action
+type AuditActor struct {
+	AdminKeyID    string `json:"admin_key_id,omitempty"`
+	UserID        string `json:"user_id,omitempty"`
+	SessionID     string `json:"session_id,omitempty"`
+	IPAddress     string `json:"ip_address,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// AuditEntry is one line of the audit log. Hash covers the previous entry's
+// hash and the serialized entry body, so editing, removing or reordering
+// entries breaks the chain.
+type AuditEntry struct {
+	Seq       uint64                 `json:"seq"`
+	Timestamp time.Time              `json:"timestamp"`
+	Action    string                 `json:"action"`
+	Actor     AuditActor             `json:"actor"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	PrevHash  string                 `json:"prev_hash"`
+	Hash      string                 `json:"-"`
+}
+
+// AuditLog is an append-only, hash-chained JSON Lines log with size-based
+// rotation. The chain continues across rotated files.
+type AuditLog struct {
+	path     string
+	maxSize  int64
+	file     *os.File
+	size     int64
+	seq      uint64
+	lastHash string
+	mutex    sync.Mutex
+}
+
+var (
+	auditLog      *AuditLog
+	auditLogMutex sync.RWMutex
+)
+
+// OpenAuditLog opens (or creates) the audit log at path, resuming the chain
+// from its last entry. Files are rotated once they exceed maxSize bytes; a
+// maxSize of zero disables rotation.
+func OpenAuditLog(path string, maxSize int64) (*AuditLog, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &AuditLog{path: path, maxSize: maxSize}
+
+	last, err := lastAuditEntry(AuditLogFiles(path))
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		l.seq = last.Seq
+		l.lastHash = last.Hash
+	}
+
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append writes a new entry for action. Actor fields are taken from the
+// context (admin key ID, user ID, session ID, IP address, correlation ID).
+func (l *AuditLog) Append(ctx context.Context, action string, details map[string]interface{}) (*AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry := &AuditEntry{
+		Seq:       l.seq + 1,
+		Timestamp: time.Now().UTC(),
+		Action:    action,
+		Actor:     auditActorFromContext(ctx),
+		Details:   details,
+		PrevHash:  l.lastHash,
+	}
+
+	line, hash, err := encodeAuditEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	entry.Hash = hash
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.file.Sync(); err != nil {
+		return nil, err
+	}
+
+	l.seq = entry.Seq
+	l.lastHash = entry.Hash
+	return entry, nil
+}
+
+// Close closes the underlying file
+func (l *AuditLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// openFile opens the current log file for appending. Caller must hold the lock.
+func (l *AuditLog) openFile() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one. Caller must hold the lock.
+func (l *AuditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%s", l.path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(l.path, rotated); err != nil {
+		return err
+	}
+	return l.openFile()
+}
+
+// encodeAuditEntry serializes an entry and computes its chained hash. The
+// hash is appended as the final field so verification can recover the exact
+// bytes that were hashed.
+func encodeAuditEntry(entry *AuditEntry) ([]byte, string, error) {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := chainHash(entry.PrevHash, body)
+
+	line := make([]byte, 0, len(body)+len(hashFieldPrefix)+len(hash)+3)
+	line = append(line, body[:len(body)-1]...)
+	line = append(line, hashFieldPrefix...)
+	line = append(line, hash...)
+	line = append(line, '"', '}', '\n')
+	return line, hash, nil
+}
+
+// decodeAuditEntry parses a log line and checks that its hash matches its body
+func decodeAuditEntry(line []byte) (*AuditEntry, error) {
+	idx := bytes.LastIndex(line, hashFieldPrefix)
+	if idx < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, ErrAuditMalformed
+	}
+
+	body := append(append([]byte{}, line[:idx]...), '}')
+	storedHash := string(line[idx+len(hashFieldPrefix) : len(line)-2])
+
+	entry := &AuditEntry{}
+	if err := json.Unmarshal(body, entry); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuditMalformed, err)
+	}
+	entry.Hash = storedHash
+
+	if chainHash(entry.PrevHash, body) != storedHash {
+		return nil, fmt.Errorf("%w: entry %d hash mismatch", ErrAuditChainBroken, entry.Seq)
+	}
+	return entry, nil
+}
+
+// chainHash hashes an entry body together with the previous entry's hash
+func chainHash(prevHash string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prevHash))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// auditActorFromContext builds an actor from the logger context fields
+func auditActorFromContext(ctx context.Context) AuditActor {
+	return AuditActor{
+		AdminKeyID:    GetAdminKeyID(ctx),
+		UserID:        GetUserID(ctx),
+		SessionID:     GetSessionID(ctx),
+		IPAddress:     GetIPAddress(ctx),
+		CorrelationID: GetCorrelationID(ctx),
+	}
+}
+
+// AuditLogFiles returns the rotated files for path in chronological order,
+// followed by path itself if it exists
+func AuditLogFiles(path string) []string {
+	rotated, _ := filepath.Glob(path + ".*")
+	sort.Strings(rotated)
+
+	if _, err := os.Stat(path); err == nil {
+		rotated = append(rotated, path)
+	}
+	return rotated
+}
+
+// VerifyAuditLog checks the hash chain across files, which must be given in
+// chronological order. If the first entry is not the start of the chain (older
+// files were archived), its prev_hash must equal anchor, the hash of the last
+// archived entry; without an anchor such a log fails, as its start could have
+// been cut off. Returns the number of verified entries.
+func VerifyAuditLog(anchor string, files ...string) (int, error) {
+	count := 0
+	var prev *AuditEntry
+
+	for _, path := range files {
+		err := scanAuditFile(path, func(entry *AuditEntry) error {
+			if prev == nil {
+				switch {
+				case entry.Seq == 1 && entry.PrevHash != "":
+					return fmt.Errorf("%w: first entry has a previous hash", ErrAuditChainBroken)
+				case entry.Seq > 1 && anchor == "":
+					return fmt.Errorf("%w: log starts at entry %d and no anchor was given", ErrAuditChainBroken, entry.Seq)
+				case entry.Seq > 1 && entry.PrevHash != anchor:
+					return fmt.Errorf("%w: entry %d does not follow the anchor", ErrAuditChainBroken, entry.Seq)
+				}
+			} else {
+				if entry.PrevHash != prev.Hash {
+					return fmt.Errorf("%w: entry %d does not follow entry %d", ErrAuditChainBroken, entry.Seq, prev.Seq)
+				}
+				if entry.Seq != prev.Seq+1 {
+					return fmt.Errorf("%w: expected entry %d, found %d", ErrAuditChainBroken, prev.Seq+1, entry.Seq)
+				}
+			}
+			prev = entry
+			count++
+			return nil
+		})
+		if err != nil {
+			return count, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return count, nil
+}
+
+// lastAuditEntry returns the last verified entry across files
+func lastAuditEntry(files []string) (*AuditEntry, error) {
+	for i := len(files) - 1; i >= 0; i-- {
+		var last *AuditEntry
+		err := scanAuditFile(files[i], func(entry *AuditEntry) error {
+			last = entry
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", files[i], err)
+		}
+		if last != nil {
+			return last, nil
+		}
+	}
+	return nil, nil
+}
+
+// scanAuditFile decodes each line of an audit file in order
+func scanAuditFile(path string, fn func(*AuditEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			entry, decodeErr := decodeAuditEntry(bytes.TrimRight(line, "\r\n"))
+			if decodeErr != nil {
+				return decodeErr
+			}
+			if fnErr := fn(entry); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// SetAuditLog installs the log that Audit appends to
+func SetAuditLog(l *AuditLog) {
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+	auditLog = l
+}
+
+// Audit records a moderation or admin action. The event is always written to
+// the application log; when an audit log is installed it is also appended to
+// the hash chain.
+func Audit(ctx context.Context, action string, fields ...logrus.Fields) {
+	var details map[string]interface{}
+	if len(fields) > 0 {
+		details = fields[0]
+	}
+
+	entry := NewLoggerEntry(ctx).WithFields(logrus.Fields{
+		"audit":  true,
+		"action": action,
+	})
+	if details != nil {
+		entry = entry.WithFields(details)
+	}
+
+	auditLogMutex.RLock()
+	l := auditLog
+	auditLogMutex.RUnlock()
+
+	if l != nil {
+		appended, err := l.Append(ctx, action, details)
+		if err != nil {
+			entry.WithError(err).Error("Failed to append audit log entry")
+			return
+		}
+		entry = entry.WithField("audit_seq", appended.Seq)
+	}
+
+	entry.Info("Audit event")
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "blobs")
+	store, err := NewFileBlobStore(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "note-1", strings.NewReader("audio")))
+	blob, err := store.Open(ctx, "note-1")
+	require.NoError(t, err)
+	data, err := io.ReadAll(blob)
+	blob.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "audio", string(data))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, store.Delete(ctx, "note-1"))
+	require.NoError(t, store.Delete(ctx, "note-1"))
+	_, err = store.Open(ctx, "note-1")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestFileBlobStore_RejectsPathKeys(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../escape", "a/b", ".hidden", strings.Repeat("a", 129)} {
+		assert.ErrorIs(t, store.Put(ctx, key, strings.NewReader("x")), ErrInvalidBlobKey, key)
+		_, err := store.Open(ctx, key)
+		assert.ErrorIs(t, err, ErrInvalidBlobKey, key)
+	}
+}
+
+func TestFileBlobStore_DeleteOlderThan(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "old", strings.NewReader("x")))
+	require.NoError(t, store.Put(ctx, "new", strings.NewReader("y")))
+	require.NoError(t, store.Put(ctx, "kept", strings.NewReader("z")))
+	past := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(store.dir, "old"), past, past))
+	require.NoError(t, os.Chtimes(filepath.Join(store.dir, "kept"), past, past))
+
+	deleted, err := store.DeleteOlderThan(ctx, time.Now().Add(-24*time.Hour), func(key string) bool { return key == "kept" })
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	// Blobs still in use survive however old they are
+	blob, err := store.Open(ctx, "kept")
+	require.NoError(t, err)
+	blob.Close()
+
+	_, err = store.Open(ctx, "old")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	blob, err = store.Open(ctx, "new")
+	require.NoError(t, err)
+	blob.Close()
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ClientIPResolver determines the real client IP of a request. Forwarding
+// headers are only honoured when the direct peer is a trusted proxy, and the
+// forwarding chain is walked from the right so clients cannot spoof their
+// address by prepending entries.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+var (
+	clientIPResolver      = &ClientIPResolver{}
+	clientIPResolverMutex sync.RWMutex
+)
+
+// NewClientIPResolver creates a resolver trusting the given proxies. Entries
+// may be CIDR ranges or single IP addresses.
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	resolver := &ClientIPResolver{}
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		resolver.trusted = append(resolver.trusted, network)
+	}
+	return resolver, nil
+}
+
+// IsTrusted reports whether ip belongs to a trusted proxy
+func (c *ClientIPResolver) IsTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client IP for a request. The Forwarded header
+// (RFC 7239) takes precedence over X-Forwarded-For, which takes precedence
+// over X-Real-IP.
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	remote := hostOnly(r.RemoteAddr)
+	if !c.IsTrusted(net.ParseIP(remote)) {
+		return remote
+	}
+
+	var chain []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		chain = parseForwardedFor(forwarded)
+	} else if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, value := range xff {
+			for _, hop := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(hop))
+			}
+		}
+	} else if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
+		return xri
+	}
+
+	// Walk from the nearest hop outwards, skipping our own proxies. The
+	// first untrusted address is the client; an unparsable hop means the
+	// chain cannot be trusted beyond the last proxy we know.
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hostOnly(chain[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !c.IsTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// parseForwardedFor extracts the for= parameters from Forwarded header
+// values, in order. Obfuscated or unknown identifiers are kept so that they
+// stop the walk.
+func parseForwardedFor(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, strings.Trim(strings.TrimSpace(val), `"`))
+			}
+		}
+	}
+	return chain
+}
+
+// hostOnly strips an optional port and IPv6 brackets from an address
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// SetClientIPResolver installs the resolver used by ClientIP
+func SetClientIPResolver(resolver *ClientIPResolver) {
+	clientIPResolverMutex.Lock()
+	defer clientIPResolverMutex.Unlock()
+	clientIPResolver = resolver
+}
+
+// ClientIP returns the client IP for a request using the installed resolver.
+// Until one is installed no proxies are trusted.
+func ClientIP(r *http.Request) string {
+	clientIPResolverMutex.RLock()
+	resolver := clientIPResolver
+	clientIPResolverMutex.RUnlock()
+	return resolver.ClientIP(r)
+}
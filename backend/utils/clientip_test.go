@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"direct client", "203.0.113.9:5000", nil, "203.0.113.9"},
+		{"untrusted peer cannot spoof", "203.0.113.9:5000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.9"},
+		{"trusted proxy", "10.0.0.5:443", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"spoofed leftmost entry ignored", "10.0.0.5:443", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.1.1.1"}, "198.51.100.7"},
+		{"single trusted IP", "192.168.1.1:443", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"garbage hop stops the walk", "10.0.0.5:443", map[string]string{"X-Forwarded-For": "198.51.100.7, not-an-ip"}, "10.0.0.5"},
+		{"all hops trusted", "10.0.0.5:443", map[string]string{"X-Forwarded-For": "10.2.2.2"}, "10.2.2.2"},
+		{"forwarded header", "10.0.0.5:443", map[string]string{
+			"Forwarded":       `for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=https`,
+			"X-Forwarded-For": "9.9.9.9",
+		}, "2001:db8:cafe::17"},
+		{"forwarded obfuscated", "10.0.0.5:443", map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.5"},
+		{"x-real-ip from trusted proxy", "10.0.0.5:443", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			assert.Equal(t, tt.expected, resolver.ClientIP(r))
+		})
+	}
+}
+
+func TestNewClientIPResolver_Invalid(t *testing.T) {
+	_, err := NewClientIPResolver([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = NewClientIPResolver([]string{"proxy.internal"})
+	assert.Error(t, err)
+}
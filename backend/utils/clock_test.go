@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	var fired []string
+	var firedAt []time.Time
+	record := func(name string) func() {
+		return func() {
+			fired = append(fired, name)
+			firedAt = append(firedAt, clock.Now())
+		}
+	}
+	clock.AfterFunc(3*time.Minute, record("third"))
+	clock.AfterFunc(time.Minute, func() {
+		record("first")()
+		// Timers scheduled while advancing run if they fall due in time
+		clock.AfterFunc(time.Minute, record("second"))
+	})
+	stopped := clock.AfterFunc(2*time.Minute, record("stopped"))
+	clock.AfterFunc(10*time.Minute, record("later"))
+
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	clock.Advance(5 * time.Minute)
+	assert.Equal(t, []string{"first", "second", "third"}, fired)
+	assert.Equal(t, []time.Time{start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)}, firedAt)
+	assert.Equal(t, start.Add(5*time.Minute), clock.Now())
+	assert.Equal(t, 1, clock.Pending())
+
+	// Timers already due run on the next advance, even by zero
+	clock.AfterFunc(-time.Second, record("overdue"))
+	clock.Advance(0)
+	assert.Equal(t, "overdue", fired[len(fired)-1])
+}
+
+func TestRealClock(t *testing.T) {
+	done := make(chan struct{})
+	RealClock.AfterFunc(time.Millisecond, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+	assert.WithinDuration(t, time.Now(), RealClock.Now(), time.Second)
+}
@@ -2,12 +2,17 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 	"voice-chat-app/models"
 )
 
@@ -20,6 +25,28 @@ type Config struct {
 	// Security configuration
 	JWTSecret      []byte
 	AllowedOrigins []string
+	AdminAPIKeys   []AdminAPIKeyConfig
+
+	// JWT key rotation: retired HS256 secrets, asymmetric keys and the
+	// ID of the key used for signing (defaults to the JWT_SECRET key)
+	JWTPreviousSecrets [][]byte
+	JWTKeys            []JWTKeyConfig
+	JWTActiveKeyID     string
+
+	// Revoked tokens are persisted here; empty keeps them in memory only
+	RevocationStorePath string
+
+	// Origin policy for native apps, which send no Origin or a custom one
+	AllowMissingOrigin bool
+	NativeAppOrigins   []string
+
+	// Reverse proxy configuration
+	TrustedProxies       []string
+	ProxyProtocolEnabled bool
+
+	// Audit log configuration
+	AuditLogPath    string
+	AuditLogMaxSize int64
 
 	// Timeout configuration
 	ReadTimeout       time.Duration
@@ -36,6 +63,98 @@ type Config struct {
 	WSRateLimitPerMinute   int
 	MaxWSConnPerIP         int
 
+	// Connect ticket configuration
+	RequireWSTicket bool
+
+	// Registered account configuration
+	AccountsEnabled  bool
+	AccountsPath     string
+	PasswordHashCost int
+
+	// Friend lists and direct calls between friends. Without a path the
+	// friendships are lost on restart.
+	FriendsEnabled bool
+	FriendsPath    string
+
+	// Friend presence; only active when friends are enabled
+	PresenceEnabled        bool
+	PresenceCoalesceWindow time.Duration
+
+	// Generated display names and the filter for names users choose. Empty
+	// word lists use the built-in ones; filter terms add to the reserved ones.
+	DisplayNameAdjectives []string
+	DisplayNameAnimals    []string
+	TextFilterTerms       []string
+
+	// Contact exchange between partners with mutual consent
+	ContactExchangeEnabled bool
+
+	// Scheduled calls between friends; only active when friends are enabled.
+	// Without a path the schedule is lost on restart.
+	ScheduledCallsEnabled  bool
+	ScheduledCallsPath     string
+	ScheduledCallReminders []time.Duration
+	MaxScheduledCalls      int
+
+	// Push notifications for calls to offline friends; each platform is
+	// enabled by its credentials. Without a path the registered tokens are
+	// lost on restart.
+	PushTokensPath         string
+	PushFCMProjectID       string
+	PushFCMCredentialsFile string
+	PushAPNsKeyFile        string
+	PushAPNsKeyID          string
+	PushAPNsTeamID         string
+	PushAPNsTopic          string
+	PushAPNsSandbox        bool
+
+	// Voice notes between friends; enabled by a storage directory
+	VoiceNotesDir         string
+	VoiceNoteMaxBytes     int64
+	VoiceNoteMaxDuration  time.Duration
+	VoiceNoteRetention    time.Duration
+	VoiceNoteURLTTL       time.Duration
+	VoiceNoteMaxPending   int
+	VoiceNoteMaxPerSender int // of a recipient's pending notes
+	// OpenID Connect single sign-on configuration; disabled without an issuer
+	OIDCIssuerURL      string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCRedirectURL    string
+	OIDCAppRedirectURL string // where the browser returns once a login completes
+	OIDCScopes         []string
+
+	// Account mail configuration; disabled without a mailer
+	Mailer          string
+	SMTPHost        string
+	SMTPPort        int
+	SMTPUsername    string
+	SMTPPassword    string
+	MailFrom        string
+	MailOutboxDir   string
+	MailLinkBaseURL string
+	MailRatePerHour int
+
+	// Admission control configuration
+	AdmissionMode       string
+	WaitlistSize        int
+	WaitlistTimeout     time.Duration
+	AdmissionRetryAfter time.Duration
+
+	// Proof-of-work challenge configuration
+	ChallengeEnabled       bool
+	ChallengeDifficulty    int
+	ChallengeMaxDifficulty int
+	ChallengeRateThreshold int
+	ChallengeTimeout       time.Duration
+
+	// Bot detection configuration
+	BotDetectionEnabled bool
+	BotChallengeScore   float64
+	BotThrottleScore    float64
+	BotShadowBanScore   float64
+	BotDisconnectScore  float64
+
 	// WebRTC configuration
 	STUNServers []string
 	TURNServers []TURNServerConfig
@@ -47,6 +166,22 @@ type TURNServerConfig struct {
 	Credential string
 }
 
+// JWTKeyConfig describes an asymmetric JWT key loaded from a PEM file. A
+// public key may only verify tokens; a private key may also sign them.
+type JWTKeyConfig struct {
+	ID        string
+	Algorithm string // EdDSA or ES256
+	Path      string
+}
+
+// AdminAPIKeyConfig describes an admin API key. Only the SHA-256 hash of the
+// key is kept in configuration.
+type AdminAPIKeyConfig struct {
+	ID     string
+	Hash   string // hex-encoded SHA-256 of the raw key
+	Scopes []string
+}
+
 func LoadConfig() *Config {
 	config := &Config{
 		// Server settings
@@ -57,6 +192,27 @@ func LoadConfig() *Config {
 		// Security settings
 		JWTSecret:      getJWTSecret(),
 		AllowedOrigins: getAllowedOrigins(),
+		AdminAPIKeys:   getAdminAPIKeys(),
+
+		// JWT key rotation settings
+		JWTPreviousSecrets: getJWTPreviousSecrets(),
+		JWTKeys:            getJWTKeys(),
+		JWTActiveKeyID:     getEnv("JWT_ACTIVE_KEY_ID", ""),
+
+		// Token revocation settings
+		RevocationStorePath: getEnv("REVOCATION_STORE_PATH", ""),
+
+		// Native app origin settings
+		AllowMissingOrigin: getBoolEnv("ALLOW_MISSING_ORIGIN", true),
+		NativeAppOrigins:   getListEnv("NATIVE_APP_ORIGINS"),
+
+		// Reverse proxy settings
+		TrustedProxies:       getListEnv("TRUSTED_PROXIES"),
+		ProxyProtocolEnabled: getBoolEnv("PROXY_PROTOCOL_ENABLED", false),
+
+		// Audit log settings
+		AuditLogPath:    getEnv("AUDIT_LOG_PATH", ""),
+		AuditLogMaxSize: int64(getIntEnv("AUDIT_LOG_MAX_SIZE_MB", 10)) * 1024 * 1024,
 
 		// Timeout settings
 		ReadTimeout:       getDurationEnv("READ_TIMEOUT", models.ReadTimeout),
@@ -73,6 +229,94 @@ func LoadConfig() *Config {
 		WSRateLimitPerMinute:   getIntEnv("WS_RATE_LIMIT_PER_MINUTE", models.DefaultWSRatePerMinute),
 		MaxWSConnPerIP:         getIntEnv("MAX_WS_CONN_PER_IP", models.DefaultMaxWSConnPerIP),
 
+		// Connect ticket settings; tickets are optional unless REQUIRE_WS_TICKET is set
+		RequireWSTicket: getBoolEnv("REQUIRE_WS_TICKET", false),
+
+		// Registered account settings
+		AccountsEnabled:  getBoolEnv("ACCOUNTS_ENABLED", true),
+		AccountsPath:     getEnv("ACCOUNTS_PATH", ""),
+		PasswordHashCost: getIntEnv("PASSWORD_HASH_COST", models.DefaultPasswordHashCost),
+
+		// Friend settings
+		FriendsEnabled: getBoolEnv("FRIENDS_ENABLED", true),
+		FriendsPath:    getEnv("FRIENDS_PATH", ""),
+
+		// Presence settings
+		PresenceEnabled:        getBoolEnv("PRESENCE_ENABLED", true),
+		PresenceCoalesceWindow: getDurationEnv("PRESENCE_COALESCE_WINDOW", models.DefaultPresenceCoalesceWindow),
+
+		// Display name settings
+		DisplayNameAdjectives: getListEnv("DISPLAY_NAME_ADJECTIVES"),
+		DisplayNameAnimals:    getListEnv("DISPLAY_NAME_ANIMALS"),
+		TextFilterTerms:       getListEnv("TEXT_FILTER_TERMS"),
+
+		// Contact exchange settings
+		ContactExchangeEnabled: getBoolEnv("CONTACT_EXCHANGE_ENABLED", true),
+
+		// Scheduled call settings
+		ScheduledCallsEnabled:  getBoolEnv("SCHEDULED_CALLS_ENABLED", true),
+		ScheduledCallsPath:     getEnv("SCHEDULED_CALLS_PATH", ""),
+		ScheduledCallReminders: getDurationListEnv("SCHEDULED_CALL_REMINDERS", models.DefaultScheduledCallReminders),
+		MaxScheduledCalls:      getIntEnv("MAX_SCHEDULED_CALLS", models.DefaultMaxScheduledCalls),
+
+		// Push settings
+		PushTokensPath:         getEnv("PUSH_TOKENS_PATH", ""),
+		PushFCMProjectID:       getEnv("PUSH_FCM_PROJECT_ID", ""),
+		PushFCMCredentialsFile: getEnv("PUSH_FCM_CREDENTIALS_FILE", ""),
+		PushAPNsKeyFile:        getEnv("PUSH_APNS_KEY_FILE", ""),
+		PushAPNsKeyID:          getEnv("PUSH_APNS_KEY_ID", ""),
+		PushAPNsTeamID:         getEnv("PUSH_APNS_TEAM_ID", ""),
+		PushAPNsTopic:          getEnv("PUSH_APNS_TOPIC", ""),
+		PushAPNsSandbox:        getBoolEnv("PUSH_APNS_SANDBOX", false),
+
+		// Voice note settings
+		VoiceNotesDir:         getEnv("VOICE_NOTES_DIR", ""),
+		VoiceNoteMaxBytes:     int64(getIntEnv("VOICE_NOTE_MAX_SIZE_KB", models.DefaultVoiceNoteMaxBytes/1024)) * 1024,
+		VoiceNoteMaxDuration:  getDurationEnv("VOICE_NOTE_MAX_DURATION", models.DefaultVoiceNoteMaxDuration),
+		VoiceNoteRetention:    getDurationEnv("VOICE_NOTE_RETENTION", models.DefaultVoiceNoteRetention),
+		VoiceNoteURLTTL:       getDurationEnv("VOICE_NOTE_URL_TTL", models.DefaultVoiceNoteURLTTL),
+		VoiceNoteMaxPending:   getIntEnv("VOICE_NOTE_MAX_PENDING", models.DefaultMaxPendingVoiceNotes),
+		VoiceNoteMaxPerSender: getIntEnv("VOICE_NOTE_MAX_PER_SENDER", models.DefaultVoiceNoteMaxPerSender),
+
+		// OpenID Connect settings
+		OIDCIssuerURL:      getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:       getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:   getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:    getEnv("OIDC_REDIRECT_URL", ""),
+		OIDCAppRedirectURL: getEnv("OIDC_APP_REDIRECT_URL", ""),
+		OIDCScopes:         getListEnv("OIDC_SCOPES"),
+
+		// Account mail settings
+		Mailer:          getEnv("MAILER", ""),
+		SMTPHost:        getEnv("SMTP_HOST", ""),
+		SMTPPort:        getIntEnv("SMTP_PORT", 587),
+		SMTPUsername:    getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:    getEnv("SMTP_PASSWORD", ""),
+		MailFrom:        getEnv("MAIL_FROM", "noreply@localhost"),
+		MailOutboxDir:   getEnv("MAIL_OUTBOX_DIR", ""),
+		MailLinkBaseURL: getEnv("MAIL_LINK_BASE_URL", ""),
+		MailRatePerHour: getIntEnv("MAIL_RATE_PER_HOUR", models.DefaultMailRatePerHour),
+
+		// Admission control settings
+		AdmissionMode:       getEnv("ADMISSION_MODE", models.AdmissionModeReject),
+		WaitlistSize:        getIntEnv("WAITLIST_SIZE", models.DefaultWaitlistSize),
+		WaitlistTimeout:     getDurationEnv("WAITLIST_TIMEOUT", models.DefaultWaitlistTimeout),
+		AdmissionRetryAfter: getDurationEnv("ADMISSION_RETRY_AFTER", models.DefaultAdmissionRetryAfter),
+
+		// Proof-of-work challenge settings
+		ChallengeEnabled:       getBoolEnv("POW_CHALLENGE_ENABLED", false),
+		ChallengeDifficulty:    getIntEnv("POW_DIFFICULTY", models.DefaultChallengeDifficulty),
+		ChallengeMaxDifficulty: getIntEnv("POW_MAX_DIFFICULTY", models.DefaultMaxChallengeDifficulty),
+		ChallengeRateThreshold: getIntEnv("POW_RATE_THRESHOLD", models.DefaultChallengeRateThreshold),
+		ChallengeTimeout:       getDurationEnv("POW_TIMEOUT", models.ChallengeTimeout),
+
+		// Bot detection settings
+		BotDetectionEnabled: getBoolEnv("BOT_DETECTION_ENABLED", false),
+		BotChallengeScore:   float64(getIntEnv("BOT_CHALLENGE_SCORE", models.DefaultBotChallengeScore)),
+		BotThrottleScore:    float64(getIntEnv("BOT_THROTTLE_SCORE", models.DefaultBotThrottleScore)),
+		BotShadowBanScore:   float64(getIntEnv("BOT_SHADOW_BAN_SCORE", models.DefaultBotShadowBanScore)),
+		BotDisconnectScore:  float64(getIntEnv("BOT_DISCONNECT_SCORE", models.DefaultBotDisconnectScore)),
+
 		// WebRTC settings
 		STUNServers: getSTUNServers(),
 		TURNServers: getTURNServers(),
@@ -111,27 +355,118 @@ func getJWTSecret() []byte {
 	return secret
 }
 
+// getJWTPreviousSecrets parses retired JWT secrets that may still verify
+// tokens issued before a rotation
+func getJWTPreviousSecrets() [][]byte {
+	var secrets [][]byte
+	for _, secret := range getListEnv("JWT_PREVIOUS_SECRETS") {
+		secrets = append(secrets, []byte(secret))
+	}
+	return secrets
+}
+
+// getJWTKeys parses asymmetric JWT keys from environment.
+// Format: "kid:alg:/path/to/key.pem;kid2:alg:/path/to/key2.pem"
+// Malformed entries are kept without an algorithm or path so that
+// validation rejects them instead of silently dropping a key.
+func getJWTKeys() []JWTKeyConfig {
+	keysEnv := getEnv("JWT_KEYS", "")
+	if keysEnv == "" {
+		return nil
+	}
+
+	var keys []JWTKeyConfig
+	for _, entry := range strings.Split(keysEnv, ";") {
+		// Paths may contain colons, so only split off the ID and algorithm
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			keys = append(keys, JWTKeyConfig{ID: strings.TrimSpace(parts[0])})
+			continue
+		}
+		keys = append(keys, JWTKeyConfig{
+			ID:        strings.TrimSpace(parts[0]),
+			Algorithm: strings.TrimSpace(parts[1]),
+			Path:      strings.TrimSpace(parts[2]),
+		})
+	}
+
+	return keys
+}
+
 // getAllowedOrigins parses the allowed origins from environment
 func getAllowedOrigins() []string {
 	originsEnv := getEnv(models.EnvAllowedOrigins, "")
 
 	// If no origins specified, use defaults based on environment
 	if originsEnv == "" {
-		env := getEnv(models.EnvEnvironment, models.EnvironmentDevelopment)
-		if env == models.EnvironmentProduction {
+		switch getEnv(models.EnvEnvironment, models.EnvironmentDevelopment) {
+		case models.EnvironmentProduction:
 			log.Fatal("ALLOWED_ORIGINS environment variable is required in production")
+		case models.EnvironmentStaging:
+			return models.DefaultAllowedOrigins
 		}
-		// return models.DefaultAllowedOrigins
-		return []string{}
+		return []string{"*"}
 	}
 
-	// Parse comma-separated origins
-	origins := strings.Split(originsEnv, ",")
-	for i, origin := range origins {
-		origins[i] = strings.TrimSpace(origin)
+	return getListEnv(models.EnvAllowedOrigins)
+}
+
+// DisplayNameFilterTerms returns the terms chosen display names may not
+// contain: the reserved ones plus any configured
+func (c *Config) DisplayNameFilterTerms() []string {
+	return append(append([]string(nil), models.ReservedNameTerms...), c.TextFilterTerms...)
+}
+
+// validNameWord reports whether a word can be used in generated display names
+func validNameWord(word string) bool {
+	if word == "" || utf8.RuneCountInString(word) > models.MaxNameWordLength {
+		return false
+	}
+	for _, r := range word {
+		if !unicode.IsLetter(r) {
+			return false
+		}
 	}
+	return true
+}
 
-	return origins
+// getListEnv parses a comma-separated list from environment, dropping empty entries
+func getListEnv(key string) []string {
+	value := getEnv(key, "")
+	if value == "" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getDurationListEnv parses a comma-separated list of durations. An entry
+// that is not a duration is kept as -1 so that validation rejects it.
+func getDurationListEnv(key, defaultValue string) []time.Duration {
+	items := getListEnv(key)
+	if items == nil {
+		items = strings.Split(defaultValue, ",")
+	}
+
+	durations := make([]time.Duration, 0, len(items))
+	for _, item := range items {
+		duration, err := time.ParseDuration(item)
+		if err != nil {
+			duration = -1
+		}
+		durations = append(durations, duration)
+	}
+	return durations
 }
 
 // getSTUNServers parses STUN servers from environment
@@ -176,6 +511,50 @@ func getTURNServers() []TURNServerConfig {
 	return servers
 }
 
+// getAdminAPIKeys parses admin API keys from environment.
+// Format: "id:sha256hex:scope1,scope2;id2:sha256hex:scope3". A malformed
+// entry is kept without a hash so that validation rejects it rather than
+// the key silently going missing.
+func getAdminAPIKeys() []AdminAPIKeyConfig {
+	keysEnv := getEnv(models.EnvAdminAPIKeys, "")
+	if keysEnv == "" {
+		return nil
+	}
+
+	var keys []AdminAPIKeyConfig
+	for _, entry := range strings.Split(keysEnv, ";") {
+		// Scopes contain colons themselves, so only split off the ID and hash
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			keys = append(keys, AdminAPIKeyConfig{ID: strings.TrimSpace(parts[0])})
+			continue
+		}
+
+		key := AdminAPIKeyConfig{
+			ID:   strings.TrimSpace(parts[0]),
+			Hash: strings.ToLower(strings.TrimSpace(parts[1])),
+		}
+		for _, scope := range strings.Split(parts[2], ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				key.Scopes = append(key.Scopes, scope)
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash used to configure an API key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
 // validateConfig validates the configuration
 func validateConfig(config *Config) error {
 	// Validate JWT secret length
@@ -183,6 +562,21 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("JWT secret must be at least %d characters long", models.MinJWTSecretLength)
 	}
 
+	// Validate JWT keys; the keyring loads every key so bad files fail at startup
+	for _, secret := range config.JWTPreviousSecrets {
+		if len(secret) < models.MinJWTSecretLength {
+			return fmt.Errorf("previous JWT secrets must be at least %d characters long", models.MinJWTSecretLength)
+		}
+	}
+	for _, key := range config.JWTKeys {
+		if key.ID == "" || key.Algorithm == "" || key.Path == "" {
+			return fmt.Errorf("JWT key %q must be formatted as kid:alg:path", key.ID)
+		}
+	}
+	if _, err := NewKeyringFromConfig(config); err != nil {
+		return fmt.Errorf("JWT keys: %w", err)
+	}
+
 	// Validate environment
 	validEnvironments := []string{
 		models.EnvironmentDevelopment,
@@ -219,6 +613,156 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("invalid log level: %s", config.LogLevel)
 	}
 
+	// Validate trusted proxies
+	if _, err := NewClientIPResolver(config.TrustedProxies); err != nil {
+		return err
+	}
+	if config.ProxyProtocolEnabled && len(config.TrustedProxies) == 0 {
+		return fmt.Errorf("PROXY_PROTOCOL_ENABLED requires TRUSTED_PROXIES")
+	}
+	if config.Environment == "production" && len(config.TrustedProxies) == 0 {
+		log.Printf("Warning: TRUSTED_PROXIES is empty; behind a load balancer every client shares its IP for per-IP limits and admission")
+	}
+
+	// Validate admission mode
+	switch config.AdmissionMode {
+	case "", models.AdmissionModeReject, models.AdmissionModeWaitlist:
+	default:
+		return fmt.Errorf("invalid admission mode: %s", config.AdmissionMode)
+	}
+
+	// Validate password hashing cost (bcrypt accepts 4-31)
+	if config.AccountsEnabled && (config.PasswordHashCost < 4 || config.PasswordHashCost > 31) {
+		return fmt.Errorf("PASSWORD_HASH_COST must be between 4 and 31")
+	}
+
+	// Validate presence settings
+	if config.PresenceEnabled && (config.PresenceCoalesceWindow <= 0 || config.PresenceCoalesceWindow > time.Minute) {
+		return fmt.Errorf("PRESENCE_COALESCE_WINDOW must be between 0 and 1m")
+	}
+
+	// Validate display name settings
+	filter := NewTextFilter(config.DisplayNameFilterTerms())
+	for key, words := range map[string][]string{
+		"DISPLAY_NAME_ADJECTIVES": config.DisplayNameAdjectives,
+		"DISPLAY_NAME_ANIMALS":    config.DisplayNameAnimals,
+	} {
+		for _, word := range words {
+			if !validNameWord(word) {
+				return fmt.Errorf("%s must be words of up to %d letters", key, models.MaxNameWordLength)
+			}
+			if !filter.Allows(word) {
+				return fmt.Errorf("%s contains %q, which the text filter blocks", key, word)
+			}
+		}
+	}
+
+	// Validate scheduled call settings
+	if config.ScheduledCallsEnabled {
+		for _, reminder := range config.ScheduledCallReminders {
+			if reminder <= 0 || reminder > 24*time.Hour {
+				return fmt.Errorf("SCHEDULED_CALL_REMINDERS must be durations between 0 and 24h")
+			}
+		}
+		if config.MaxScheduledCalls < 1 {
+			return fmt.Errorf("MAX_SCHEDULED_CALLS must be at least 1")
+		}
+	}
+
+	// Validate push settings
+	if (config.PushFCMCredentialsFile != "" || config.PushAPNsKeyFile != "") && !config.FriendsEnabled {
+		return fmt.Errorf("push notifications require FRIENDS_ENABLED")
+	}
+	if config.PushAPNsKeyFile != "" && (config.PushAPNsKeyID == "" || config.PushAPNsTeamID == "" || config.PushAPNsTopic == "") {
+		return fmt.Errorf("PUSH_APNS_KEY_FILE requires PUSH_APNS_KEY_ID, PUSH_APNS_TEAM_ID and PUSH_APNS_TOPIC")
+	}
+
+	// Validate voice note settings
+	if config.VoiceNotesDir != "" {
+		if !config.FriendsEnabled {
+			return fmt.Errorf("VOICE_NOTES_DIR requires FRIENDS_ENABLED")
+		}
+		if config.VoiceNoteMaxBytes <= 0 || config.VoiceNoteMaxBytes > 16*1024*1024 {
+			return fmt.Errorf("VOICE_NOTE_MAX_SIZE_KB must be between 1 and 16384")
+		}
+		if config.VoiceNoteMaxDuration <= 0 || config.VoiceNoteMaxDuration > 10*time.Minute {
+			return fmt.Errorf("VOICE_NOTE_MAX_DURATION must be between 0 and 10m")
+		}
+		if config.VoiceNoteRetention < time.Hour {
+			return fmt.Errorf("VOICE_NOTE_RETENTION must be at least 1h")
+		}
+		if config.VoiceNoteURLTTL <= 0 || config.VoiceNoteURLTTL > config.VoiceNoteRetention {
+			return fmt.Errorf("VOICE_NOTE_URL_TTL must be positive and no longer than VOICE_NOTE_RETENTION")
+		}
+		if config.VoiceNoteMaxPending < 1 {
+			return fmt.Errorf("VOICE_NOTE_MAX_PENDING must be at least 1")
+		}
+		if config.VoiceNoteMaxPerSender < 1 {
+			return fmt.Errorf("VOICE_NOTE_MAX_PER_SENDER must be at least 1")
+		}
+	}
+
+	// Validate OpenID Connect settings
+	if config.OIDCIssuerURL != "" {
+		if !config.AccountsEnabled {
+			return fmt.Errorf("OIDC_ISSUER_URL requires ACCOUNTS_ENABLED")
+		}
+		if config.OIDCClientID == "" || config.OIDCRedirectURL == "" || config.OIDCAppRedirectURL == "" {
+			return fmt.Errorf("OIDC_ISSUER_URL requires OIDC_CLIENT_ID, OIDC_REDIRECT_URL and OIDC_APP_REDIRECT_URL")
+		}
+		if appURL, err := url.Parse(config.OIDCAppRedirectURL); err != nil || appURL.Scheme == "" || appURL.Fragment != "" {
+			return fmt.Errorf("OIDC_APP_REDIRECT_URL must be an absolute URL without a fragment")
+		}
+		if config.Environment == models.EnvironmentProduction && !strings.HasPrefix(config.OIDCIssuerURL, "https://") {
+			return fmt.Errorf("OIDC_ISSUER_URL must use https in production")
+		}
+	}
+
+	// Validate account mail settings
+	switch config.Mailer {
+	case "":
+	case models.MailerSMTP, models.MailerOutbox:
+		if !config.AccountsEnabled {
+			return fmt.Errorf("MAILER requires ACCOUNTS_ENABLED")
+		}
+		if config.MailLinkBaseURL == "" {
+			return fmt.Errorf("MAILER requires MAIL_LINK_BASE_URL")
+		}
+		if config.MailRatePerHour <= 0 {
+			return fmt.Errorf("MAIL_RATE_PER_HOUR must be positive")
+		}
+		if config.Mailer == models.MailerSMTP && (config.SMTPHost == "" || config.SMTPPort <= 0 || config.SMTPPort > 65535) {
+			return fmt.Errorf("MAILER=smtp requires SMTP_HOST and a valid SMTP_PORT")
+		}
+	default:
+		return fmt.Errorf("invalid mailer: %s", config.Mailer)
+	}
+
+	// Validate challenge difficulty
+	if config.ChallengeEnabled {
+		if config.ChallengeDifficulty < 1 || config.ChallengeMaxDifficulty < config.ChallengeDifficulty || config.ChallengeMaxDifficulty > 32 {
+			return fmt.Errorf("challenge difficulty must satisfy 1 <= POW_DIFFICULTY <= POW_MAX_DIFFICULTY <= 32")
+		}
+	}
+
+	// Validate admin API keys
+	seenKeyIDs := make(map[string]bool)
+	for _, key := range config.AdminAPIKeys {
+		if key.ID == "" || seenKeyIDs[key.ID] {
+			return fmt.Errorf("admin API key IDs must be unique and non-empty")
+		}
+		seenKeyIDs[key.ID] = true
+
+		if decoded, err := hex.DecodeString(key.Hash); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("admin API key %s: hash must be a hex-encoded SHA-256", key.ID)
+		}
+		for _, scope := range key.Scopes {
+			if !isKnownAdminScope(scope) {
+				return fmt.Errorf("admin API key %s: unknown scope %s", key.ID, scope)
+			}
+		}
+	}
+
 	// Validate origins in production
 	if config.Environment == models.EnvironmentProduction {
 		for _, origin := range config.AllowedOrigins {
@@ -228,9 +772,35 @@ func validateConfig(config *Config) error {
 		}
 	}
 
+	// Custom schemes in ALLOWED_ORIGINS are never matched, so point them out
+	for _, origin := range config.AllowedOrigins {
+		lower := strings.ToLower(origin)
+		if origin != "*" && !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+			log.Printf("Warning: ALLOWED_ORIGINS entry %s is ignored; list custom schemes in NATIVE_APP_ORIGINS", origin)
+		}
+	}
+
+	// Native app origins must be custom schemes; web origins belong in ALLOWED_ORIGINS
+	for _, origin := range config.NativeAppOrigins {
+		lower := strings.ToLower(origin)
+		if !strings.Contains(origin, "://") || strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+			return fmt.Errorf("invalid native app origin %s: must use a custom scheme", origin)
+		}
+	}
+
 	return nil
 }
 
+// isKnownAdminScope reports whether scope is a valid admin API scope
+func isKnownAdminScope(scope string) bool {
+	for _, known := range models.AdminScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
 // IsProduction returns true if running in production environment
 func (c *Config) IsProduction() bool {
 	return c.Environment == models.EnvironmentProduction
@@ -257,6 +827,15 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoadConfig_Defaults(t *testing.T) {
@@ -293,6 +294,73 @@ func TestGetDurationEnv(t *testing.T) {
 	}
 }
 
+func TestGetJWTKeys(t *testing.T) {
+	original := os.Getenv("JWT_KEYS")
+	defer func() {
+		if original != "" {
+			os.Setenv("JWT_KEYS", original)
+		} else {
+			os.Unsetenv("JWT_KEYS")
+		}
+	}()
+
+	os.Setenv("JWT_KEYS", "ed-1:EdDSA:C:/keys/ed.pem; ;ed-2-EdDSA")
+
+	keys := getJWTKeys()
+	require.Len(t, keys, 2)
+	assert.Equal(t, JWTKeyConfig{ID: "ed-1", Algorithm: "EdDSA", Path: "C:/keys/ed.pem"}, keys[0])
+	assert.Equal(t, JWTKeyConfig{ID: "ed-2-EdDSA"}, keys[1])
+
+	config := &Config{
+		JWTSecret:   []byte("test-secret"),
+		Environment: "development",
+		LogLevel:    "info",
+		JWTKeys:     keys[1:],
+	}
+	assert.ErrorContains(t, validateConfig(config), "kid:alg:path", "a malformed entry fails validation")
+}
+
+func TestGetAdminAPIKeys(t *testing.T) {
+	original := os.Getenv("ADMIN_API_KEYS")
+	defer func() {
+		if original != "" {
+			os.Setenv("ADMIN_API_KEYS", original)
+		} else {
+			os.Unsetenv("ADMIN_API_KEYS")
+		}
+	}()
+
+	hash := HashAPIKey("oncall-secret")
+	os.Setenv("ADMIN_API_KEYS", "oncall:"+hash+":stats:read; ops:"+hash+":rooms:read,rooms:write;malformed")
+
+	keys := getAdminAPIKeys()
+	require.Len(t, keys, 3)
+	assert.Equal(t, "malformed", keys[2].ID)
+	assert.Empty(t, keys[2].Hash)
+	assert.Equal(t, "oncall", keys[0].ID)
+	assert.Equal(t, hash, keys[0].Hash)
+	assert.Equal(t, []string{"stats:read"}, keys[0].Scopes)
+	assert.Equal(t, "ops", keys[1].ID)
+	assert.Equal(t, []string{"rooms:read", "rooms:write"}, keys[1].Scopes)
+
+	config := &Config{
+		JWTSecret:    []byte("test-secret"),
+		Environment:  "development",
+		LogLevel:     "info",
+		AdminAPIKeys: keys,
+	}
+	assert.Error(t, validateConfig(config), "a malformed entry fails validation")
+
+	config.AdminAPIKeys = keys[:2]
+	assert.NoError(t, validateConfig(config))
+
+	config.AdminAPIKeys = []AdminAPIKeyConfig{{ID: "bad", Hash: hash, Scopes: []string{"everything"}}}
+	assert.Error(t, validateConfig(config))
+
+	config.AdminAPIKeys = []AdminAPIKeyConfig{{ID: "bad", Hash: "not-a-hash"}}
+	assert.Error(t, validateConfig(config))
+}
+
 // Test concurrent access to config loading
 func TestLoadConfig_Concurrent(t *testing.T) {
 	// This test ensures that concurrent calls to LoadConfig don't cause race conditions
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"time"
+	"voice-chat-app/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// deviceAudience marks device tokens so they are never mistaken for other
+// tokens signed by the same keyring
+const deviceAudience = "device"
+
+// DeviceClaims are the claims of a long-lived device token. The client keeps
+// the token and presents it on every connect, so moderation keyed to the
+// device ID outlives any one session.
+type DeviceClaims struct {
+	DeviceID string `json:"device_id"`
+	jwt.RegisteredClaims
+}
+
+// GenerateDeviceToken issues a device token and returns it with its device
+// ID. An empty deviceID registers a new device; otherwise the token renews
+// an existing one.
+func GenerateDeviceToken(deviceID string) (string, string, error) {
+	ensureJWTInit()
+
+	if deviceID == "" {
+		deviceID = GenerateUUID()
+	}
+	now := time.Now()
+	claims := &DeviceClaims{
+		DeviceID: deviceID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(models.DeviceTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "voice-chat-app",
+			Audience:  jwt.ClaimStrings{deviceAudience},
+			Subject:   deviceID,
+			ID:        GenerateUUID(),
+		},
+	}
+	token, err := getKeyring().Sign(claims)
+	return deviceID, token, err
+}
+
+// ValidateDeviceToken checks a device token and returns its claims
+func ValidateDeviceToken(tokenString string) (*DeviceClaims, error) {
+	ensureJWTInit()
+
+	claims := &DeviceClaims{}
+	token, err := getKeyring().Parse(tokenString, claims)
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrInvalidToken
+	}
+	if !token.Valid || claims.DeviceID == "" || claims.ExpiresAt == nil || !claims.VerifyAudience(deviceAudience, true) {
+		return nil, ErrInvalidToken
+	}
+	if getRevocationStore().IsRevoked(claims.ID) {
+		return nil, ErrTokenBlacklisted
+	}
+	return claims, nil
+}
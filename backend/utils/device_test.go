@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceToken(t *testing.T) {
+	deviceID, token, err := GenerateDeviceToken("")
+	require.NoError(t, err)
+	require.NotEmpty(t, deviceID)
+
+	claims, err := ValidateDeviceToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, deviceID, claims.DeviceID)
+	assert.True(t, time.Until(claims.ExpiresAt.Time) > 300*24*time.Hour)
+
+	// Renewing keeps the device ID
+	renewedID, renewed, err := GenerateDeviceToken(deviceID)
+	require.NoError(t, err)
+	assert.Equal(t, deviceID, renewedID)
+	assert.NotEqual(t, token, renewed)
+}
+
+func TestDeviceToken_NotInterchangeableWithSessions(t *testing.T) {
+	session, err := GenerateToken("user-1")
+	require.NoError(t, err)
+	_, err = ValidateDeviceToken(session)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	_, device, err := GenerateDeviceToken("")
+	require.NoError(t, err)
+	_, err = ValidateJWT(device)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	action, err := GenerateActionToken(TokenPurposeVerifyEmail, "account-1", "alice@example.com", time.Hour)
+	require.NoError(t, err)
+	_, err = ValidateDeviceToken(action)
+	assert.Equal(t, ErrInvalidToken, err)
+}
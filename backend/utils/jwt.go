@@ -1,9 +1,9 @@
 package utils
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
-	"fmt"
 	"sync"
 	"time"
 	"voice-chat-app/models"
@@ -13,15 +13,14 @@ import (
 )
 
 var (
-	jwtSecret      []byte
-	tokenBlacklist = make(map[string]time.Time)
-	blacklistMutex sync.RWMutex
-	configOnce     sync.Once
+	jwtSecret  []byte
+	configOnce sync.Once
 )
 
 type Claims struct {
 	UserID    string `json:"user_id"`
 	SessionID string `json:"session_id"`
+	AccountID string `json:"account_id,omitempty"` // set for registered accounts
 	jwt.RegisteredClaims
 }
 
@@ -31,8 +30,15 @@ var (
 	ErrTokenExpired     = errors.New("token has expired")
 	ErrInvalidToken     = errors.New("invalid token")
 	ErrWeakSecret       = errors.New("JWT secret is too weak")
+	ErrTokenNotDueYet   = errors.New("token does not need refresh yet")
 )
 
+func init() {
+	// Issue times are compared with when an account's sessions were revoked,
+	// which needs better than whole seconds
+	jwt.TimePrecision = time.Millisecond
+}
+
 // initJWTConfig initializes JWT configuration once
 func initJWTConfig() {
 	configOnce.Do(func() {
@@ -44,6 +50,17 @@ func initJWTConfig() {
 			panic(ErrWeakSecret)
 		}
 
+		// Build the signing keyring unless one was installed explicitly
+		keyring, err := NewKeyringFromConfig(config)
+		if err != nil {
+			panic(err)
+		}
+		keyringMutex.Lock()
+		if activeKeyring == nil {
+			activeKeyring = keyring
+		}
+		keyringMutex.Unlock()
+
 		// Start blacklist cleanup goroutine
 		go cleanupBlacklist()
 	})
@@ -67,7 +84,14 @@ func GenerateSecureSecret() ([]byte, error) {
 	return secret, err
 }
 
+// GenerateToken issues a token for an anonymous session
 func GenerateToken(userID string) (string, error) {
+	return GenerateAccountToken(userID, "")
+}
+
+// GenerateAccountToken issues a token for a session, bound to a registered
+// account when accountID is set
+func GenerateAccountToken(userID, accountID string) (string, error) {
 	ensureJWTInit()
 
 	sessionID := GenerateUUID()
@@ -75,8 +99,9 @@ func GenerateToken(userID string) (string, error) {
 	claims := &Claims{
 		UserID:    userID,
 		SessionID: sessionID,
+		AccountID: accountID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(models.TokenExpiryDuration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "voice-chat-app",
@@ -85,26 +110,14 @@ func GenerateToken(userID string) (string, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return getKeyring().Sign(claims)
 }
 
 func ValidateJWT(tokenString string) (*Claims, error) {
 	ensureJWTInit()
 
-	// Check if token is blacklisted
-	if isTokenBlacklisted(tokenString) {
-		return nil, ErrTokenBlacklisted
-	}
-
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	token, err := getKeyring().Parse(tokenString, claims)
 
 	if err != nil {
 		if ve, ok := err.(*jwt.ValidationError); ok {
@@ -124,92 +137,125 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 		return nil, ErrInvalidToken
 	}
 
+	// Check if the session has been revoked
+	if getRevocationStore().IsRevoked(claims.TokenID()) {
+		return nil, ErrTokenBlacklisted
+	}
+
+	// Check if every session of the account was revoked after it was issued
+	if claims.AccountID != "" && accountSessionsRevoked(claims) {
+		return nil, ErrTokenBlacklisted
+	}
+
 	return claims, nil
 }
 
-// RevokeToken adds a token to the blacklist
+// TokenID returns the ID used to revoke the token: its jti claim, or the
+// session ID for tokens issued without one
+func (c *Claims) TokenID() string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return c.SessionID
+}
+
+// RevokeToken revokes the session a token belongs to until the token expires
 func RevokeToken(tokenString string) error {
 	ensureJWTInit()
 
-	// Parse token to get expiration time
+	// Parse token to get its ID and expiration time
 	claims := &Claims{}
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-
+	_, err := getKeyring().Parse(tokenString, claims)
 	if err != nil {
 		return err
 	}
 
-	blacklistMutex.Lock()
-	defer blacklistMutex.Unlock()
-
-	// Add token to blacklist until its expiration
+	// Tokens without an expiry are revoked for the longest lifetime we issue
+	expiresAt := time.Now().Add(models.TokenExpiryDuration)
 	if claims.ExpiresAt != nil {
-		tokenBlacklist[tokenString] = claims.ExpiresAt.Time
+		expiresAt = claims.ExpiresAt.Time
 	}
+	return RevokeSession(claims.TokenID(), expiresAt)
+}
 
-	return nil
+// RevokeSession revokes a session by its token ID until expiresAt
+func RevokeSession(tokenID string, expiresAt time.Time) error {
+	if tokenID == "" {
+		return ErrInvalidToken
+	}
+	return getRevocationStore().Revoke(tokenID, expiresAt)
 }
 
-// isTokenBlacklisted checks if a token is in the blacklist
-func isTokenBlacklisted(tokenString string) bool {
-	blacklistMutex.RLock()
-	defer blacklistMutex.RUnlock()
+// RevokeAccountSessions revokes every token issued to an account before now,
+// such as after its password was reset. The revocation lasts until the last
+// of those tokens expires.
+func RevokeAccountSessions(accountID string) error {
+	if accountID == "" {
+		return ErrInvalidToken
+	}
+	// Issue times are kept in milliseconds, so a session issued right after
+	// the revocation stays valid
+	cutoff := time.Now().Truncate(jwt.TimePrecision)
+	return getRevocationStore().Revoke(accountRevocationID(accountID), cutoff.Add(models.TokenExpiryDuration))
+}
 
-	expiry, exists := tokenBlacklist[tokenString]
-	if !exists {
+// accountSessionsRevoked reports whether the token was issued before its
+// account's sessions were last revoked
+func accountSessionsRevoked(claims *Claims) bool {
+	revokedUntil, revoked := getRevocationStore().RevokedUntil(accountRevocationID(claims.AccountID))
+	if !revoked {
 		return false
 	}
+	if claims.IssuedAt == nil {
+		return true
+	}
+	// Decoded issue times can be one step of precision early
+	issuedAt := claims.IssuedAt.Time.Add(jwt.TimePrecision)
+	return issuedAt.Before(revokedUntil.Add(-models.TokenExpiryDuration))
+}
 
-	// Check if token is still within its expiration time
-	return time.Now().Before(expiry)
+// accountRevocationID is the revocation store ID covering an account's sessions
+func accountRevocationID(accountID string) string {
+	return "account:" + accountID
 }
 
-// cleanupBlacklist removes expired tokens from the blacklist
+// cleanupBlacklist removes revocations of expired tokens
 func cleanupBlacklist() {
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		blacklistMutex.Lock()
-		now := time.Now()
-
-		for token, expiry := range tokenBlacklist {
-			if now.After(expiry) {
-				delete(tokenBlacklist, token)
-			}
+		if _, err := getRevocationStore().Cleanup(time.Now()); err != nil {
+			Error(context.Background(), "Failed to clean up revoked tokens", err)
 		}
-
-		blacklistMutex.Unlock()
 	}
 }
 
-// RefreshToken generates a new token for a user if the current token is valid
+// RefreshToken generates a new token for a user if the current token is
+// valid. No new token is issued unless the old one was revoked.
 func RefreshToken(tokenString string) (string, error) {
 	claims, err := ValidateJWT(tokenString)
 	if err != nil {
 		return "", err
 	}
 
-	// Check if token is close to expiry (within 1 hour)
-	if claims.ExpiresAt != nil && time.Until(claims.ExpiresAt.Time) > time.Hour {
-		return "", errors.New("token does not need refresh yet")
+	// Check if token is close to expiry
+	if claims.ExpiresAt != nil && time.Until(claims.ExpiresAt.Time) > models.TokenRefreshWindow {
+		return "", ErrTokenNotDueYet
 	}
 
 	// Revoke old token
-	RevokeToken(tokenString)
+	if err := RevokeToken(tokenString); err != nil {
+		return "", err
+	}
 
-	// Generate new token
-	return GenerateToken(claims.UserID)
+	// Generate new token for the same user and account
+	return GenerateAccountToken(claims.UserID, claims.AccountID)
 }
 
-// GetBlacklistStats returns statistics about the token blacklist
+// GetBlacklistStats returns statistics about revoked tokens
 func GetBlacklistStats() map[string]interface{} {
-	blacklistMutex.RLock()
-	defer blacklistMutex.RUnlock()
-
 	return map[string]interface{}{
-		"blacklisted_tokens": len(tokenBlacklist),
+		"blacklisted_tokens": getRevocationStore().Len(),
 	}
 }
@@ -0,0 +1,405 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmES256 = "ES256"
+	AlgorithmRS256 = "RS256" // verification only, for identity provider keys
+)
+
+// Keyring errors
+var (
+	ErrUnknownKeyID       = errors.New("unknown signing key ID")
+	ErrUnsupportedKeyAlg  = errors.New("unsupported signing algorithm")
+	ErrVerificationOnly   = errors.New("signing key has no private key")
+	ErrAlgorithmMismatch  = errors.New("token algorithm does not match its key")
+	ErrNoVerificationKeys = errors.New("no key can verify a token without a key ID")
+)
+
+// SigningKey is a JWT key identified by its kid. Verification-only keys have
+// no private part; they keep tokens signed before a rotation valid.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// CanSign reports whether the key holds private key material
+func (k *SigningKey) CanSign() bool {
+	return k.signKey != nil
+}
+
+// NewHMACKey creates an HS256 key. An empty id is derived from the secret so
+// that rotated secrets get distinct key IDs.
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	if id == "" {
+		sum := sha256.Sum256(secret)
+		id = "hs-" + hex.EncodeToString(sum[:4])
+	}
+	return &SigningKey{
+		ID:        id,
+		Algorithm: AlgorithmHS256,
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// NewSigningKeyFromPEM loads an EdDSA or ES256 key from PEM. A private key
+// (PKCS#8 or SEC 1) gives a signing key; a public key (PKIX) gives a
+// verification-only key.
+func NewSigningKeyFromPEM(id, algorithm string, pemData []byte) (*SigningKey, error) {
+	key := &SigningKey{ID: id, Algorithm: algorithm}
+
+	switch algorithm {
+	case AlgorithmEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+		if private, err := jwt.ParseEdPrivateKeyFromPEM(pemData); err == nil {
+			key.signKey = private
+			key.verifyKey = private.(ed25519.PrivateKey).Public()
+			return key, nil
+		}
+		public, err := jwt.ParseEdPublicKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		key.verifyKey = public
+	case AlgorithmES256:
+		key.method = jwt.SigningMethodES256
+		if private, err := jwt.ParseECPrivateKeyFromPEM(pemData); err == nil {
+			if private.Curve != elliptic.P256() {
+				return nil, fmt.Errorf("key %s: ES256 requires a P-256 key", id)
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+			return key, nil
+		}
+		public, err := jwt.ParseECPublicKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if public.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: ES256 requires a P-256 key", id)
+		}
+		key.verifyKey = public
+	default:
+		return nil, fmt.Errorf("key %s: %w: %s", id, ErrUnsupportedKeyAlg, algorithm)
+	}
+
+	return key, nil
+}
+
+// GenerateSigningKey creates a fresh EdDSA or ES256 key
+func GenerateSigningKey(id, algorithm string) (*SigningKey, error) {
+	switch algorithm {
+	case AlgorithmEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{ID: id, Algorithm: algorithm, method: jwt.SigningMethodEdDSA, signKey: private, verifyKey: public}, nil
+	case AlgorithmES256:
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{ID: id, Algorithm: algorithm, method: jwt.SigningMethodES256, signKey: private, verifyKey: &private.PublicKey}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyAlg, algorithm)
+	}
+}
+
+// NewSigningKeyFromJWK imports a published public key as a verification-only
+// key. RSA keys are accepted so tokens from external identity providers can
+// be verified.
+func NewSigningKeyFromJWK(jwk JSONWebKey) (*SigningKey, error) {
+	key := &SigningKey{ID: jwk.KeyID, Algorithm: jwk.Algorithm}
+
+	switch {
+	case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("key %s: invalid Ed25519 key", jwk.KeyID)
+		}
+		key.Algorithm = AlgorithmEdDSA
+		key.method = jwt.SigningMethodEdDSA
+		key.verifyKey = ed25519.PublicKey(x)
+	case jwk.KeyType == "EC" && jwk.Curve == "P-256":
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("key %s: invalid P-256 key", jwk.KeyID)
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("key %s: point is not on P-256", jwk.KeyID)
+		}
+		key.Algorithm = AlgorithmES256
+		key.method = jwt.SigningMethodES256
+		key.verifyKey = public
+	case jwk.KeyType == "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %s: invalid or short RSA key", jwk.KeyID)
+		}
+		key.Algorithm = AlgorithmRS256
+		key.method = jwt.SigningMethodRS256
+		key.verifyKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	default:
+		return nil, fmt.Errorf("key %s: %w: %s %s", jwk.KeyID, ErrUnsupportedKeyAlg, jwk.KeyType, jwk.Curve)
+	}
+
+	if jwk.Algorithm != "" && jwk.Algorithm != key.Algorithm {
+		return nil, fmt.Errorf("key %s: %w", jwk.KeyID, ErrAlgorithmMismatch)
+	}
+	return key, nil
+}
+
+// Keyring holds the active signing key and every key that may still verify
+// tokens. Tokens carry the signing key's ID in their kid header.
+type Keyring struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewKeyring creates a keyring that signs with active and verifies with
+// active plus the given keys
+func NewKeyring(active *SigningKey, verification ...*SigningKey) (*Keyring, error) {
+	if active == nil || !active.CanSign() {
+		return nil, ErrVerificationOnly
+	}
+
+	k := &Keyring{
+		active: active,
+		keys:   map[string]*SigningKey{active.ID: active},
+	}
+	for _, key := range verification {
+		if existing, ok := k.keys[key.ID]; ok && existing != key {
+			return nil, fmt.Errorf("duplicate signing key ID %s", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+// NewVerifier creates a keyring that only verifies tokens, such as one
+// holding an identity provider's published keys
+func NewVerifier(keys ...*SigningKey) *Keyring {
+	k := &Keyring{keys: make(map[string]*SigningKey, len(keys))}
+	for _, key := range keys {
+		k.keys[key.ID] = key
+	}
+	return k
+}
+
+// NewKeyringFromConfig builds the keyring described by the configuration.
+// JWT_SECRET and JWT_PREVIOUS_SECRETS provide HS256 keys; JWT_KEYS adds
+// EdDSA and ES256 keys loaded from PEM files. JWT_ACTIVE_KEY_ID picks the
+// signing key, defaulting to the JWT_SECRET key.
+func NewKeyringFromConfig(config *Config) (*Keyring, error) {
+	primary := NewHMACKey("", config.JWTSecret)
+	keys := []*SigningKey{primary}
+
+	for _, secret := range config.JWTPreviousSecrets {
+		keys = append(keys, NewHMACKey("", secret))
+	}
+	for _, keyConfig := range config.JWTKeys {
+		pemData, err := os.ReadFile(keyConfig.Path)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", keyConfig.ID, err)
+		}
+		key, err := NewSigningKeyFromPEM(keyConfig.ID, keyConfig.Algorithm, pemData)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	active := primary
+	if config.JWTActiveKeyID != "" {
+		active = nil
+		for _, key := range keys {
+			if key.ID == config.JWTActiveKeyID {
+				active = key
+			}
+		}
+		if active == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, config.JWTActiveKeyID)
+		}
+	}
+
+	return NewKeyring(active, keys...)
+}
+
+// ActiveKeyID returns the ID of the signing key
+func (k *Keyring) ActiveKeyID() string {
+	if k.active == nil {
+		return ""
+	}
+	return k.active.ID
+}
+
+// HasKey reports whether the keyring holds a key with the given ID
+func (k *Keyring) HasKey(id string) bool {
+	_, exists := k.keys[id]
+	return exists
+}
+
+// Sign signs claims with the active key, setting the kid header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	if k.active == nil {
+		return "", ErrVerificationOnly
+	}
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.signKey)
+}
+
+// Parse verifies a token against the key named by its kid header. Tokens
+// without a kid, such as ours from before key IDs were introduced or those
+// of identity providers that omit it, are checked against every key of
+// their algorithm.
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(tokenString, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if kid, ok := unverified.Header["kid"].(string); ok {
+		key, exists := k.keys[kid]
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, kid)
+		}
+		return jwt.ParseWithClaims(tokenString, claims, k.keyfunc(key))
+	}
+
+	// Without a kid, try every key of the token's algorithm
+	lastErr := ErrNoVerificationKeys
+	for _, key := range k.keys {
+		if key.Algorithm != unverified.Method.Alg() {
+			continue
+		}
+		token, err := jwt.ParseWithClaims(tokenString, claims, k.keyfunc(key))
+		if err == nil {
+			return token, nil
+		}
+		lastErr = err
+		// Only a signature mismatch means another key might still match
+		if ve, ok := err.(*jwt.ValidationError); !ok || ve.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			return token, err
+		}
+	}
+	return nil, lastErr
+}
+
+// keyfunc returns the verification key, rejecting tokens whose alg header
+// does not match the key's algorithm
+func (k *Keyring) keyfunc(key *SigningKey) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != key.Algorithm {
+			return nil, ErrAlgorithmMismatch
+		}
+		return key.verifyKey, nil
+	}
+}
+
+// JSONWebKey is a public key in JWK format (RFC 7517)
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"` // RSA modulus
+	E         string `json:"e,omitempty"` // RSA exponent
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyring. HS256 keys are secret and
+// are never published.
+func (k *Keyring) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range k.keys {
+		switch public := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		case *ecdsa.PublicKey:
+			x := make([]byte, 32)
+			y := make([]byte, 32)
+			public.X.FillBytes(x)
+			public.Y.FillBytes(y)
+			set.Keys = append(set.Keys, JSONWebKey{
+				KeyType:   "EC",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				Curve:     "P-256",
+				X:         base64.RawURLEncoding.EncodeToString(x),
+				Y:         base64.RawURLEncoding.EncodeToString(y),
+			})
+		}
+	}
+	return set
+}
+
+var (
+	activeKeyring *Keyring
+	keyringMutex  sync.RWMutex
+)
+
+// SetKeyring installs the keyring used to sign and verify session tokens
+func SetKeyring(k *Keyring) {
+	keyringMutex.Lock()
+	defer keyringMutex.Unlock()
+	activeKeyring = k
+}
+
+// getKeyring returns the installed keyring, initializing it from the
+// environment on first use
+func getKeyring() *Keyring {
+	ensureJWTInit()
+
+	keyringMutex.RLock()
+	defer keyringMutex.RUnlock()
+	return activeKeyring
+}
+
+// JWKS returns the public keys of the installed keyring
+func JWKS() JSONWebKeySet {
+	return getKeyring().JWKS()
+}
@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClaims(userID string) *Claims {
+	return &Claims{
+		UserID:    userID,
+		SessionID: "session-" + userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+}
+
+// writeKeyPEM writes a key's private and public halves as PEM files
+func writeKeyPEM(t *testing.T, dir string, key *SigningKey) (privatePath, publicPath string) {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key.signKey)
+	require.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(key.verifyKey)
+	require.NoError(t, err)
+
+	privatePath = filepath.Join(dir, key.ID+".pem")
+	publicPath = filepath.Join(dir, key.ID+".pub.pem")
+	require.NoError(t, os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600))
+	require.NoError(t, os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644))
+	return privatePath, publicPath
+}
+
+func TestKeyring_SignAndParse(t *testing.T) {
+	for _, alg := range []string{AlgorithmEdDSA, AlgorithmES256} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateSigningKey("key-"+alg, alg)
+			require.NoError(t, err)
+			keyring, err := NewKeyring(key)
+			require.NoError(t, err)
+
+			tokenString, err := keyring.Sign(testClaims("user-1"))
+			require.NoError(t, err)
+
+			claims := &Claims{}
+			token, err := keyring.Parse(tokenString, claims)
+			require.NoError(t, err)
+			assert.True(t, token.Valid)
+			assert.Equal(t, alg, token.Method.Alg())
+			assert.Equal(t, key.ID, token.Header["kid"])
+			assert.Equal(t, "user-1", claims.UserID)
+		})
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey := NewHMACKey("", []byte("old-secret-that-is-at-least-32-characters"))
+	newKey, err := GenerateSigningKey("ed-2024", AlgorithmEdDSA)
+	require.NoError(t, err)
+
+	before, err := NewKeyring(oldKey)
+	require.NoError(t, err)
+	oldToken, err := before.Sign(testClaims("user-old"))
+	require.NoError(t, err)
+
+	// After rotation the old key only verifies
+	after, err := NewKeyring(newKey, oldKey)
+	require.NoError(t, err)
+	assert.Equal(t, "ed-2024", after.ActiveKeyID())
+
+	_, err = after.Parse(oldToken, &Claims{})
+	assert.NoError(t, err, "tokens signed before the rotation should stay valid")
+
+	newToken, err := after.Sign(testClaims("user-new"))
+	require.NoError(t, err)
+	_, err = after.Parse(newToken, &Claims{})
+	assert.NoError(t, err)
+
+	// Dropping the old key invalidates its tokens
+	retired, err := NewKeyring(newKey)
+	require.NoError(t, err)
+	_, err = retired.Parse(oldToken, &Claims{})
+	assert.ErrorIs(t, err, ErrUnknownKeyID)
+}
+
+func TestKeyring_LegacyTokensWithoutKeyID(t *testing.T) {
+	secret := []byte("legacy-secret-that-is-at-least-32-chars")
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("user-legacy")).SignedString(secret)
+	require.NoError(t, err)
+
+	active, err := GenerateSigningKey("ec-1", AlgorithmES256)
+	require.NoError(t, err)
+	keyring, err := NewKeyring(active, NewHMACKey("", []byte("another-secret-that-is-at-least-32-chars")), NewHMACKey("", secret))
+	require.NoError(t, err)
+
+	claims := &Claims{}
+	_, err = keyring.Parse(legacy, claims)
+	require.NoError(t, err)
+	assert.Equal(t, "user-legacy", claims.UserID)
+
+	// A legacy token signed with an unknown secret is rejected
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("user-forged")).SignedString([]byte("unknown-secret-that-is-at-least-32-chars"))
+	require.NoError(t, err)
+	_, err = keyring.Parse(forged, &Claims{})
+	assert.Error(t, err)
+}
+
+func TestKeyring_RejectsAlgorithmMismatch(t *testing.T) {
+	key, err := GenerateSigningKey("ed-1", AlgorithmEdDSA)
+	require.NoError(t, err)
+	keyring, err := NewKeyring(key)
+	require.NoError(t, err)
+
+	// An HS256 token claiming the EdDSA key's kid, keyed with its public key
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("user-1"))
+	token.Header["kid"] = "ed-1"
+	forged, err := token.SignedString([]byte(key.verifyKey.(ed25519.PublicKey)))
+	require.NoError(t, err)
+
+	_, err = keyring.Parse(forged, &Claims{})
+	assert.ErrorIs(t, err, ErrAlgorithmMismatch)
+}
+
+func TestNewKeyring_RequiresSigningKey(t *testing.T) {
+	key, err := GenerateSigningKey("ec-1", AlgorithmES256)
+	require.NoError(t, err)
+	publicOnly := &SigningKey{ID: key.ID, Algorithm: key.Algorithm, method: key.method, verifyKey: key.verifyKey}
+
+	_, err = NewKeyring(publicOnly)
+	assert.ErrorIs(t, err, ErrVerificationOnly)
+
+	other, err := GenerateSigningKey("ec-1", AlgorithmES256)
+	require.NoError(t, err)
+	_, err = NewKeyring(key, other)
+	assert.Error(t, err, "duplicate key IDs should be rejected")
+}
+
+func TestNewSigningKeyFromPEM(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, alg := range []string{AlgorithmEdDSA, AlgorithmES256} {
+		t.Run(alg, func(t *testing.T) {
+			generated, err := GenerateSigningKey("pem-"+alg, alg)
+			require.NoError(t, err)
+			privatePath, publicPath := writeKeyPEM(t, dir, generated)
+
+			privatePEM, err := os.ReadFile(privatePath)
+			require.NoError(t, err)
+			private, err := NewSigningKeyFromPEM(generated.ID, alg, privatePEM)
+			require.NoError(t, err)
+			assert.True(t, private.CanSign())
+
+			publicPEM, err := os.ReadFile(publicPath)
+			require.NoError(t, err)
+			public, err := NewSigningKeyFromPEM(generated.ID, alg, publicPEM)
+			require.NoError(t, err)
+			assert.False(t, public.CanSign())
+
+			// A token from the private key verifies with the public key alone
+			signer, err := NewKeyring(private)
+			require.NoError(t, err)
+			tokenString, err := signer.Sign(testClaims("user-1"))
+			require.NoError(t, err)
+
+			verifier := &Keyring{active: private, keys: map[string]*SigningKey{public.ID: public}}
+			_, err = verifier.Parse(tokenString, &Claims{})
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := NewSigningKeyFromPEM("bad", "RS256", []byte("irrelevant"))
+	assert.ErrorIs(t, err, ErrUnsupportedKeyAlg)
+
+	_, err = NewSigningKeyFromPEM("bad", AlgorithmEdDSA, []byte("not a pem"))
+	assert.Error(t, err)
+}
+
+func TestNewKeyringFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	edKey, err := GenerateSigningKey("ed-1", AlgorithmEdDSA)
+	require.NoError(t, err)
+	edPrivate, _ := writeKeyPEM(t, dir, edKey)
+	ecKey, err := GenerateSigningKey("ec-old", AlgorithmES256)
+	require.NoError(t, err)
+	_, ecPublic := writeKeyPEM(t, dir, ecKey)
+
+	config := &Config{
+		JWTSecret:          []byte("current-secret-that-is-at-least-32-chars"),
+		JWTPreviousSecrets: [][]byte{[]byte("previous-secret-that-is-at-least-32-chars")},
+		JWTKeys: []JWTKeyConfig{
+			{ID: "ed-1", Algorithm: AlgorithmEdDSA, Path: edPrivate},
+			{ID: "ec-old", Algorithm: AlgorithmES256, Path: ecPublic},
+		},
+	}
+
+	// Defaults to signing with the JWT_SECRET key
+	keyring, err := NewKeyringFromConfig(config)
+	require.NoError(t, err)
+	assert.Equal(t, NewHMACKey("", config.JWTSecret).ID, keyring.ActiveKeyID())
+	assert.Len(t, keyring.keys, 4)
+
+	config.JWTActiveKeyID = "ed-1"
+	keyring, err = NewKeyringFromConfig(config)
+	require.NoError(t, err)
+	assert.Equal(t, "ed-1", keyring.ActiveKeyID())
+
+	// A public key cannot be the active key
+	config.JWTActiveKeyID = "ec-old"
+	_, err = NewKeyringFromConfig(config)
+	assert.ErrorIs(t, err, ErrVerificationOnly)
+
+	config.JWTActiveKeyID = "missing"
+	_, err = NewKeyringFromConfig(config)
+	assert.ErrorIs(t, err, ErrUnknownKeyID)
+
+	config.JWTActiveKeyID = ""
+	config.JWTKeys = append(config.JWTKeys, JWTKeyConfig{ID: "gone", Algorithm: AlgorithmEdDSA, Path: filepath.Join(dir, "missing.pem")})
+	_, err = NewKeyringFromConfig(config)
+	assert.Error(t, err)
+}
+
+func TestKeyring_JWKS(t *testing.T) {
+	edKey, err := GenerateSigningKey("ed-1", AlgorithmEdDSA)
+	require.NoError(t, err)
+	ecKey, err := GenerateSigningKey("ec-1", AlgorithmES256)
+	require.NoError(t, err)
+	keyring, err := NewKeyring(edKey, ecKey, NewHMACKey("", []byte("secret-that-is-at-least-32-characters")))
+	require.NoError(t, err)
+
+	set := keyring.JWKS()
+	require.Len(t, set.Keys, 2, "HMAC keys must never be published")
+
+	byID := make(map[string]JSONWebKey)
+	for _, key := range set.Keys {
+		byID[key.KeyID] = key
+		assert.Equal(t, "sig", key.Use)
+	}
+
+	ed := byID["ed-1"]
+	assert.Equal(t, "OKP", ed.KeyType)
+	assert.Equal(t, "Ed25519", ed.Curve)
+	assert.Equal(t, AlgorithmEdDSA, ed.Algorithm)
+	assert.Len(t, ed.X, 43) // 32 bytes, unpadded base64url
+	assert.Empty(t, ed.Y)
+
+	ec := byID["ec-1"]
+	assert.Equal(t, "EC", ec.KeyType)
+	assert.Equal(t, "P-256", ec.Curve)
+	assert.Equal(t, AlgorithmES256, ec.Algorithm)
+	assert.Len(t, ec.X, 43)
+	assert.Len(t, ec.Y, 43)
+	_, ok := ecKey.verifyKey.(*ecdsa.PublicKey)
+	assert.True(t, ok)
+}
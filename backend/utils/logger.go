@@ -3,10 +3,8 @@ package utils
 import (
 	"context"
 	"io"
-	"net"
 	"net/http"
 	"os"
-	"strings"
 
 	"github.com/sirupsen/logrus"
 )
@@ -19,6 +17,7 @@ const (
 	UserIDKey        ContextKey = "user_id"
 	SessionIDKey     ContextKey = "session_id"
 	IPAddressKey     ContextKey = "ip_address"
+	AdminKeyIDKey    ContextKey = "admin_key_id"
 )
 
 var logger *logrus.Logger
@@ -102,6 +101,11 @@ func NewLoggerEntry(ctx context.Context) *logrus.Entry {
 		entry = entry.WithField("ip_address", ipAddress)
 	}
 
+	// Add admin API key ID if present
+	if keyID := GetAdminKeyID(ctx); keyID != "" {
+		entry = entry.WithField("admin_key_id", keyID)
+	}
+
 	return entry
 }
 
@@ -159,6 +163,19 @@ func GetIPAddress(ctx context.Context) string {
 	return ""
 }
 
+// WithAdminKeyID adds an admin API key ID to the context
+func WithAdminKeyID(ctx context.Context, keyID string) context.Context {
+	return context.WithValue(ctx, AdminKeyIDKey, keyID)
+}
+
+// GetAdminKeyID retrieves the admin API key ID from context
+func GetAdminKeyID(ctx context.Context) string {
+	if keyID, ok := ctx.Value(AdminKeyIDKey).(string); ok {
+		return keyID
+	}
+	return ""
+}
+
 // Convenience logging functions
 
 // Debug logs a debug message with context
@@ -220,7 +237,7 @@ func LoggerMiddleware(next http.Handler) http.Handler {
 
 		// Add to request context
 		ctx := WithCorrelationID(r.Context(), correlationID)
-		ctx = WithIPAddress(ctx, getClientIP(r))
+		ctx = WithIPAddress(ctx, ClientIP(r))
 
 		// Add correlation ID to response headers
 		w.Header().Set("X-Correlation-ID", correlationID)
@@ -240,34 +257,6 @@ func LoggerMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// getClientIP extracts client IP from request (reused from middleware)
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return parseFirstIP(xff)
-	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-
-	// Fall back to remote address
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return host
-}
-
-// parseFirstIP parses the first IP from comma-separated list
-func parseFirstIP(ips string) string {
-	if idx := strings.Index(ips, ","); idx != -1 {
-		return strings.TrimSpace(ips[:idx])
-	}
-	return strings.TrimSpace(ips)
-}
-
 // SetLogOutput sets the output for the logger (useful for testing)
 func SetLogOutput(output io.Writer) {
 	if logger != nil {
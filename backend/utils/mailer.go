@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidMail is returned for messages that cannot be sent safely
+var ErrInvalidMail = errors.New("invalid mail message")
+
+// Mail is a plain-text email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// SMTPConfig configures an SMTP relay
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer sends mail through an SMTP relay. STARTTLS is required unless
+// the relay is on a loopback address.
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTPMailer creates a mailer for an SMTP relay
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{config: config}
+}
+
+// Send delivers a message to the relay
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	message, err := formatMail(m.config.From, mail)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+			return err
+		}
+	} else if !isLoopbackHost(m.config.Host) {
+		return fmt.Errorf("SMTP relay %s does not support STARTTLS", addr)
+	}
+
+	if m.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.config.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(mail.To); err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// outboxMaxMessages is how many messages an outbox keeps before dropping
+// the oldest
+const outboxMaxMessages = 1000
+
+// OutboxMailer records mail instead of sending it, for development and
+// tests. With a directory set, each message is also written there as an
+// .eml file. Only the most recent messages are kept, in memory and on disk.
+type OutboxMailer struct {
+	dir   string
+	from  string
+	sent  []Mail
+	files []string // .eml file of each sent message, when dir is set
+	count int      // messages recorded over the outbox's lifetime
+	limit int
+	mutex sync.Mutex
+}
+
+// NewOutboxMailer creates an outbox, creating dir if it is set
+func NewOutboxMailer(dir, from string) (*OutboxMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &OutboxMailer{dir: dir, from: from, limit: outboxMaxMessages}, nil
+}
+
+// Send records a message
+func (m *OutboxMailer) Send(ctx context.Context, mail Mail) error {
+	message, err := formatMail(m.from, mail)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	file := ""
+	if m.dir != "" {
+		file = filepath.Join(m.dir, fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), m.count))
+		if err := os.WriteFile(file, message, 0o600); err != nil {
+			return err
+		}
+	}
+	m.count++
+	m.sent = append(m.sent, mail)
+	m.files = append(m.files, file)
+
+	// Drop the oldest messages beyond the limit
+	for len(m.sent) > m.limit {
+		if m.files[0] != "" {
+			os.Remove(m.files[0])
+		}
+		m.sent = m.sent[1:]
+		m.files = m.files[1:]
+	}
+	return nil
+}
+
+// Sent returns the messages the outbox still holds
+func (m *OutboxMailer) Sent() []Mail {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Mail(nil), m.sent...)
+}
+
+// LastTo returns the most recent message to an address
+func (m *OutboxMailer) LastTo(to string) (Mail, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if strings.EqualFold(m.sent[i].To, to) {
+			return m.sent[i], true
+		}
+	}
+	return Mail{}, false
+}
+
+// formatMail renders a message with its headers, refusing header values that
+// could inject further headers
+func formatMail(from string, mail Mail) ([]byte, error) {
+	if from == "" || mail.To == "" || strings.ContainsAny(from+mail.To+mail.Subject, "\r\n") {
+		return nil, ErrInvalidMail
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + mail.To + "\r\n")
+	b.WriteString("Subject: " + mail.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(mail.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// isLoopbackHost reports whether host names the local machine
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	outbox, err := NewOutboxMailer(dir, "noreply@example.com")
+	require.NoError(t, err)
+
+	require.NoError(t, outbox.Send(context.Background(), Mail{To: "alice@example.com", Subject: "First", Body: "one"}))
+	require.NoError(t, outbox.Send(context.Background(), Mail{To: "bob@example.com", Subject: "Second", Body: "two"}))
+	require.NoError(t, outbox.Send(context.Background(), Mail{To: "alice@example.com", Subject: "Third", Body: "line\nbreak"}))
+
+	assert.Len(t, outbox.Sent(), 3)
+	last, ok := outbox.LastTo("Alice@example.com")
+	require.True(t, ok)
+	assert.Equal(t, "Third", last.Subject)
+	_, ok = outbox.LastTo("carol@example.com")
+	assert.False(t, ok)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	raw, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "From: noreply@example.com\r\n")
+	assert.Contains(t, string(raw), "Subject: First\r\n")
+}
+
+func TestOutboxMailer_KeepsRecentMessages(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	outbox, err := NewOutboxMailer(dir, "noreply@example.com")
+	require.NoError(t, err)
+	outbox.limit = 2
+
+	for _, subject := range []string{"First", "Second", "Third"} {
+		require.NoError(t, outbox.Send(context.Background(), Mail{To: "alice@example.com", Subject: subject}))
+	}
+
+	sent := outbox.Sent()
+	require.Len(t, sent, 2)
+	assert.Equal(t, "Second", sent[0].Subject)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestMailer_RejectsHeaderInjection(t *testing.T) {
+	outbox, err := NewOutboxMailer("", "noreply@example.com")
+	require.NoError(t, err)
+
+	err = outbox.Send(context.Background(), Mail{To: "alice@example.com\r\nBcc: everyone@example.com", Subject: "Hi"})
+	assert.Equal(t, ErrInvalidMail, err)
+	err = outbox.Send(context.Background(), Mail{To: "alice@example.com", Subject: "Hi\nBcc: everyone@example.com"})
+	assert.Equal(t, ErrInvalidMail, err)
+	err = outbox.Send(context.Background(), Mail{Subject: "Hi"})
+	assert.Equal(t, ErrInvalidMail, err)
+	assert.Empty(t, outbox.Sent())
+}
+
+// TestSMTPMailer_Send talks to a minimal SMTP server on loopback, where
+// STARTTLS is not required
+func TestSMTPMailer_Send(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var commands []string
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if inData {
+				if line == "." {
+					inData = false
+					reply("250 queued")
+					continue
+				}
+				commands = append(commands, "DATA> "+line)
+				continue
+			}
+			commands = append(commands, line)
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- commands
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	mailer := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "noreply@example.com"})
+	err = mailer.Send(context.Background(), Mail{To: "alice@example.com", Subject: "Hello", Body: "Hi Alice"})
+	require.NoError(t, err)
+
+	commands := <-received
+	assert.Contains(t, commands, "MAIL FROM:<noreply@example.com>")
+	assert.Contains(t, commands, "RCPT TO:<alice@example.com>")
+	assert.Contains(t, commands, "DATA> Subject: Hello")
+	assert.Contains(t, commands, "DATA> Hi Alice")
+}
@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OIDC errors
+var (
+	ErrOIDCDiscovery    = errors.New("OIDC discovery failed")
+	ErrOIDCExchange     = errors.New("OIDC code exchange failed")
+	ErrOIDCInvalidToken = errors.New("invalid OIDC ID token")
+)
+
+// oidcKeyRefreshInterval limits how often an unknown kid triggers a JWKS refetch
+const oidcKeyRefreshInterval = time.Minute
+
+// OIDCConfig configures an OpenID Connect relying party
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// oidcDiscovery is the subset of the provider metadata we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCIdentity is the verified identity from an ID token
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// idTokenClaims are the ID token claims we read
+type idTokenClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	AuthorizedBy  string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// OIDCClient runs the authorization code flow with PKCE against one provider.
+// Provider metadata and keys are fetched on first use and cached. The lock
+// only guards the cache; fetches run without it, so a slow provider does not
+// stall every other login.
+type OIDCClient struct {
+	config      OIDCConfig
+	httpClient  *http.Client
+	discovery   *oidcDiscovery
+	keys        *Keyring
+	keysFetched time.Time
+	mutex       sync.Mutex
+}
+
+// NewOIDCClient creates a client for the configured provider. A nil
+// httpClient uses a client with a 10 second timeout.
+func NewOIDCClient(config OIDCConfig, httpClient *http.Client) *OIDCClient {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &OIDCClient{config: config, httpClient: httpClient}
+}
+
+// RedirectURL returns the callback URL registered with the provider
+func (c *OIDCClient) RedirectURL() string {
+	return c.config.RedirectURL
+}
+
+// NewPKCEVerifier returns a random code verifier and its S256 challenge
+func NewPKCEVerifier() (verifier, challenge string, err error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(random)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL returns the provider URL that starts a login
+func (c *OIDCClient) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.config.ClientID},
+		"redirect_uri":          {c.config.RedirectURL},
+		"scope":                 {strings.Join(c.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades an authorization code for the provider's tokens and
+// returns the verified identity from the ID token
+func (c *OIDCClient) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCIdentity, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"client_id":     {c.config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: token endpoint returned %d: %s", ErrOIDCExchange, resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: response has no id_token", ErrOIDCExchange)
+	}
+
+	return c.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, expiry and
+// nonce
+func (c *OIDCClient) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCIdentity, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := c.verificationKeys(ctx, discovery, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+	token, err := keys.Parse(rawIDToken, claims)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCInvalidToken, err)
+	}
+
+	switch {
+	case claims.Issuer != discovery.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrOIDCInvalidToken, claims.Issuer)
+	case !claims.VerifyAudience(c.config.ClientID, true):
+		return nil, fmt.Errorf("%w: not issued for this client", ErrOIDCInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != c.config.ClientID:
+		return nil, fmt.Errorf("%w: authorized party mismatch", ErrOIDCInvalidToken)
+	case claims.ExpiresAt == nil:
+		return nil, fmt.Errorf("%w: missing expiry", ErrOIDCInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrOIDCInvalidToken)
+	case nonce == "" || claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidToken)
+	}
+
+	return &OIDCIdentity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// discover fetches and caches the provider metadata
+func (c *OIDCClient) discover(ctx context.Context) (*oidcDiscovery, error) {
+	c.mutex.Lock()
+	cached := c.discovery
+	c.mutex.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	wellKnown := strings.TrimSuffix(c.config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(ctx, wellKnown, discovery); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCDiscovery, err)
+	}
+	if discovery.Issuer != c.config.IssuerURL {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrOIDCDiscovery, discovery.Issuer, c.config.IssuerURL)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete provider metadata", ErrOIDCDiscovery)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.discovery == nil {
+		c.discovery = discovery
+	}
+	return c.discovery, nil
+}
+
+// verificationKeys returns the provider's keys, refetching them when the
+// token names a key we have not seen, so provider key rotation is picked up.
+// A token without a kid is checked against every key of its algorithm.
+func (c *OIDCClient) verificationKeys(ctx context.Context, discovery *oidcDiscovery, rawIDToken string) (*Keyring, error) {
+	kid := ""
+	if unverified, _, err := jwt.NewParser().ParseUnverified(rawIDToken, &jwt.RegisteredClaims{}); err == nil {
+		kid, _ = unverified.Header["kid"].(string)
+	}
+
+	c.mutex.Lock()
+	cached := c.keys
+	fresh := time.Since(c.keysFetched) < oidcKeyRefreshInterval
+	c.mutex.Unlock()
+	if cached != nil && (kid == "" || cached.HasKey(kid) || fresh) {
+		return cached, nil
+	}
+
+	var set JSONWebKeySet
+	if err := c.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, fmt.Errorf("%w: fetching keys: %v", ErrOIDCInvalidToken, err)
+	}
+
+	var keys []*SigningKey
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys without a kid still need distinct IDs in the keyring
+		if jwk.KeyID == "" {
+			jwk.KeyID = fmt.Sprintf("#%d", i)
+		}
+		if key, err := NewSigningKeyFromJWK(jwk); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	verifier := NewVerifier(keys...)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.keys = verifier
+	c.keysFetched = time.Now()
+	return verifier, nil
+}
+
+// getJSON fetches and decodes a JSON document
+func (c *OIDCClient) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"voice-chat-app/oidctest"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOIDC(t *testing.T) (*oidctest.Provider, *OIDCClient) {
+	provider := oidctest.NewProvider("talk-app", "client-secret")
+	t.Cleanup(provider.Close)
+
+	client := NewOIDCClient(OIDCConfig{
+		IssuerURL:    provider.Issuer(),
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://localhost:8080/auth/oidc/callback",
+	}, nil)
+	return provider, client
+}
+
+// authorize runs the provider's authorization endpoint and returns the code
+func authorize(t *testing.T, client *OIDCClient, nonce, codeChallenge string) string {
+	authURL, err := client.AuthCodeURL(context.Background(), "state-1", nonce, codeChallenge)
+	require.NoError(t, err)
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", location.Query().Get("state"))
+	return location.Query().Get("code")
+}
+
+func TestOIDCClient_Exchange(t *testing.T) {
+	_, client := newTestOIDC(t)
+	verifier, challenge, err := NewPKCEVerifier()
+	require.NoError(t, err)
+
+	code := authorize(t, client, "nonce-1", challenge)
+	identity, err := client.Exchange(context.Background(), code, verifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", identity.Subject)
+	assert.Equal(t, "user@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+
+	// Codes are single-use
+	_, err = client.Exchange(context.Background(), code, verifier, "nonce-1")
+	assert.True(t, errors.Is(err, ErrOIDCExchange))
+}
+
+func TestOIDCClient_ExchangeRejectsWrongVerifier(t *testing.T) {
+	_, client := newTestOIDC(t)
+	_, challenge, err := NewPKCEVerifier()
+	require.NoError(t, err)
+	otherVerifier, _, err := NewPKCEVerifier()
+	require.NoError(t, err)
+
+	code := authorize(t, client, "nonce-1", challenge)
+	_, err = client.Exchange(context.Background(), code, otherVerifier, "nonce-1")
+	assert.True(t, errors.Is(err, ErrOIDCExchange))
+}
+
+func TestOIDCClient_VerifyIDToken(t *testing.T) {
+	provider, client := newTestOIDC(t)
+	user := oidctest.User{Subject: "user-2", Email: "two@example.com"}
+
+	tests := []struct {
+		name   string
+		mutate func(jwt.MapClaims)
+		nonce  string
+		valid  bool
+	}{
+		{"valid", func(jwt.MapClaims) {}, "nonce-1", true},
+		{"wrong nonce", func(jwt.MapClaims) {}, "nonce-2", false},
+		{"no nonce expected", func(jwt.MapClaims) {}, "", false},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, "nonce-1", false},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "nonce-1", false},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, "nonce-1", false},
+		{"missing expiry", func(c jwt.MapClaims) { delete(c, "exp") }, "nonce-1", false},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }, "nonce-1", false},
+		{"multiple audiences without azp", func(c jwt.MapClaims) { c["aud"] = []string{"talk-app", "other"} }, "nonce-1", false},
+		{"multiple audiences with azp", func(c jwt.MapClaims) {
+			c["aud"] = []string{"talk-app", "other"}
+			c["azp"] = "talk-app"
+		}, "nonce-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := provider.IDTokenClaims(user, "nonce-1")
+			tt.mutate(claims)
+
+			identity, err := client.VerifyIDToken(context.Background(), provider.SignIDToken(claims), tt.nonce)
+			if tt.valid {
+				require.NoError(t, err)
+				assert.Equal(t, "user-2", identity.Subject)
+			} else {
+				assert.True(t, errors.Is(err, ErrOIDCInvalidToken), "got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCClient_RejectsForgedSignature(t *testing.T) {
+	provider, client := newTestOIDC(t)
+	claims := provider.IDTokenClaims(oidctest.User{Subject: "user-1"}, "nonce-1")
+
+	// An HMAC token keyed with the client ID must not verify
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged, err := token.SignedString([]byte(provider.ClientID))
+	require.NoError(t, err)
+
+	_, err = client.VerifyIDToken(context.Background(), forged, "nonce-1")
+	assert.True(t, errors.Is(err, ErrOIDCInvalidToken))
+}
+
+func TestOIDCClient_PicksUpKeyRotation(t *testing.T) {
+	provider, client := newTestOIDC(t)
+	user := oidctest.User{Subject: "user-1"}
+
+	_, err := client.VerifyIDToken(context.Background(), provider.SignIDToken(provider.IDTokenClaims(user, "n")), "n")
+	require.NoError(t, err)
+
+	// A token under a new kid refetches the keys once the refresh interval
+	// has passed
+	provider.RotateKey()
+	client.keysFetched = time.Now().Add(-oidcKeyRefreshInterval)
+	_, err = client.VerifyIDToken(context.Background(), provider.SignIDToken(provider.IDTokenClaims(user, "n")), "n")
+	assert.NoError(t, err)
+}
+
+func TestOIDCClient_TokensWithoutKeyID(t *testing.T) {
+	provider, client := newTestOIDC(t)
+	provider.OmitKeyID(true)
+	user := oidctest.User{Subject: "user-1"}
+
+	identity, err := client.VerifyIDToken(context.Background(), provider.SignIDToken(provider.IDTokenClaims(user, "n")), "n")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", identity.Subject)
+
+	// The fallback only tries keys of the token's algorithm
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, provider.IDTokenClaims(user, "n"))
+	forged, err := token.SignedString([]byte(provider.ClientID))
+	require.NoError(t, err)
+	_, err = client.VerifyIDToken(context.Background(), forged, "n")
+	assert.True(t, errors.Is(err, ErrOIDCInvalidToken))
+}
+
+func TestOIDCClient_DiscoveryIssuerMismatch(t *testing.T) {
+	provider, _ := newTestOIDC(t)
+	client := NewOIDCClient(OIDCConfig{
+		IssuerURL:   provider.Issuer() + "/",
+		ClientID:    provider.ClientID,
+		RedirectURL: "http://localhost:8080/auth/oidc/callback",
+	}, nil)
+
+	_, err := client.AuthCodeURL(context.Background(), "state", "nonce", "challenge")
+	assert.True(t, errors.Is(err, ErrOIDCDiscovery))
+}
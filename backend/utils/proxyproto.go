@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY protocol errors
+var (
+	ErrProxyHeaderMissing = errors.New("proxy protocol header missing")
+	ErrProxyHeaderInvalid = errors.New("proxy protocol header invalid")
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1HeaderLength is the longest valid v1 header, including CRLF
+const maxProxyV1HeaderLength = 107
+
+// ProxyProtocolListener accepts HAProxy PROXY protocol v1 and v2 headers from
+// trusted proxies and reports the original client address as the
+// connection's RemoteAddr. Connections from trusted proxies must send a
+// header; other connections are passed through untouched.
+type ProxyProtocolListener struct {
+	net.Listener
+	resolver      *ClientIPResolver
+	headerTimeout time.Duration
+}
+
+// NewProxyProtocolListener wraps a listener with PROXY protocol support
+func NewProxyProtocolListener(listener net.Listener, resolver *ClientIPResolver, headerTimeout time.Duration) *ProxyProtocolListener {
+	if headerTimeout <= 0 {
+		headerTimeout = 5 * time.Second
+	}
+	return &ProxyProtocolListener{
+		Listener:      listener,
+		resolver:      resolver,
+		headerTimeout: headerTimeout,
+	}
+}
+
+// Accept waits for the next connection. The header is read lazily on first
+// use so a slow proxy cannot stall the accept loop.
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{
+		Conn:     conn,
+		reader:   bufio.NewReader(conn),
+		listener: l,
+	}, nil
+}
+
+// proxyConn is a connection whose remote address may come from a PROXY header
+type proxyConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	listener *ProxyProtocolListener
+	remote   net.Addr
+	err      error
+	once     sync.Once
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remote
+}
+
+// readHeader consumes the PROXY header once, if the peer is a trusted proxy
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+
+		peer := net.ParseIP(hostOnly(c.remote.String()))
+		if !c.listener.resolver.IsTrusted(peer) {
+			return
+		}
+
+		c.Conn.SetReadDeadline(time.Now().Add(c.listener.headerTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		addr, err := ReadProxyHeader(c.reader)
+		if err != nil {
+			c.err = err
+			c.Conn.Close()
+			return
+		}
+		if addr != nil {
+			c.remote = addr
+		}
+	})
+}
+
+// ReadProxyHeader reads a v1 or v2 PROXY header and returns the source
+// address it carries. A nil address means the header was valid but carried
+// no address (v1 UNKNOWN, v2 LOCAL or a non-IP family).
+func ReadProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	if prefix, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(prefix, proxyV2Signature) {
+		return readProxyV2Header(r)
+	}
+	if prefix, err := r.Peek(6); err == nil && string(prefix) == "PROXY " {
+		return readProxyV1Header(r)
+	}
+	return nil, ErrProxyHeaderMissing
+}
+
+// readProxyV1Header parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxProxyV1HeaderLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyHeaderInvalid
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrProxyHeaderInvalid
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, ErrProxyHeaderInvalid
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2Header parses the binary v2 header
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	versionCommand := header[12]
+	if versionCommand>>4 != 2 {
+		return nil, ErrProxyHeaderInvalid
+	}
+	family := header[13] >> 4
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch versionCommand & 0x0F {
+	case 0x0: // LOCAL: health checks from the proxy itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, ErrProxyHeaderInvalid
+	}
+
+	switch family {
+	case 0x1: // AF_INET
+		if length < 12 {
+			return nil, ErrProxyHeaderInvalid
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x2: // AF_INET6
+		if length < 36 {
+			return nil, ErrProxyHeaderInvalid
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadProxyHeader_V1(t *testing.T) {
+	r := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 198.51.100.7 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+
+	addr, err := ReadProxyHeader(r)
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.7:56324", addr.String())
+
+	rest, _ := io.ReadAll(r)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+
+	addr, err = ReadProxyHeader(bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN\r\n")))
+	require.NoError(t, err)
+	assert.Nil(t, addr)
+
+	_, err = ReadProxyHeader(bufio.NewReader(bytes.NewBufferString("PROXY TCP4 bogus\r\n")))
+	assert.ErrorIs(t, err, ErrProxyHeaderInvalid)
+
+	_, err = ReadProxyHeader(bufio.NewReader(bytes.NewBufferString("GET / HTTP/1.1\r\n")))
+	assert.ErrorIs(t, err, ErrProxyHeaderMissing)
+}
+
+func TestReadProxyHeader_V2(t *testing.T) {
+	body := make([]byte, 12)
+	copy(body[0:4], net.ParseIP("198.51.100.7").To4())
+	copy(body[4:8], net.ParseIP("10.0.0.1").To4())
+	binary.BigEndian.PutUint16(body[8:10], 56324)
+	binary.BigEndian.PutUint16(body[10:12], 443)
+
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, byte(len(body)))
+	header = append(header, body...)
+
+	addr, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(append(header, "payload"...))))
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.7:56324", addr.String())
+
+	// LOCAL command carries no address
+	local := append([]byte{}, proxyV2Signature...)
+	local = append(local, 0x20, 0x00, 0, 0)
+	addr, err = ReadProxyHeader(bufio.NewReader(bytes.NewReader(local)))
+	require.NoError(t, err)
+	assert.Nil(t, addr)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	resolver, err := NewClientIPResolver([]string{"127.0.0.1"})
+	require.NoError(t, err)
+	listener := NewProxyProtocolListener(inner, resolver, 0)
+	defer listener.Close()
+
+	go func() {
+		client, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		client.Write([]byte("PROXY TCP4 198.51.100.7 127.0.0.1 56324 80\r\nhello"))
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "198.51.100.7:56324", conn.RemoteAddr().String())
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Push errors
+var (
+	ErrPushTokenInvalid = errors.New("push token is no longer valid")
+	ErrPushFailed       = errors.New("push delivery failed")
+)
+
+// Push is a notification for one device
+type Push struct {
+	Token      string            // device push token from FCM or APNs
+	Title      string            // shown by the system when set
+	Body       string            // shown by the system when set
+	Data       map[string]string // delivered to the app
+	TTL        time.Duration     // dropped by the push service if undelivered after this long
+	CollapseID string            // replaces an undelivered push with the same ID
+}
+
+// PushProvider delivers pushes through a push service. ErrPushTokenInvalid
+// means the token should be forgotten.
+type PushProvider interface {
+	Send(ctx context.Context, push Push) error
+}
+
+// FakePushProvider records pushes instead of sending them
+type FakePushProvider struct {
+	Err   error // returned from Send when set
+	sent  []Push
+	mutex sync.Mutex
+}
+
+// NewFakePushProvider creates a provider that records pushes
+func NewFakePushProvider() *FakePushProvider {
+	return &FakePushProvider{}
+}
+
+// Send records a push, or returns Err if set
+func (p *FakePushProvider) Send(ctx context.Context, push Push) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.Err != nil {
+		return p.Err
+	}
+	p.sent = append(p.sent, push)
+	return nil
+}
+
+// Sent returns the pushes recorded so far
+func (p *FakePushProvider) Sent() []Push {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]Push(nil), p.sent...)
+}
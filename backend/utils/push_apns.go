@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// APNs endpoints
+const (
+	APNsProductionEndpoint = "https://api.push.apple.com"
+	APNsSandboxEndpoint    = "https://api.sandbox.push.apple.com"
+)
+
+// apnsTokenLifetime is how long a provider token is reused. Apple rejects
+// tokens older than an hour and refreshes more often than every 20 minutes.
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsConfig configures the Apple Push Notification service
+type APNsConfig struct {
+	KeyFile  string // .p8 signing key from the Apple developer account
+	KeyID    string
+	TeamID   string
+	Topic    string // the app's bundle ID
+	Endpoint string // defaults to APNsProductionEndpoint
+}
+
+// APNsProvider sends pushes through APNs over HTTP/2, authenticating with a
+// provider token signed by the team's key
+type APNsProvider struct {
+	config     APNsConfig
+	key        *ecdsa.PrivateKey
+	httpClient *http.Client
+	jwt        string
+	issuedAt   time.Time
+	mutex      sync.Mutex
+}
+
+// NewAPNsProvider loads the signing key and creates a provider. A nil
+// httpClient uses a client with a 10 second timeout.
+func NewAPNsProvider(config APNsConfig, httpClient *http.Client) (*APNsProvider, error) {
+	data, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading APNs key: %w", err)
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing APNs key: %w", err)
+	}
+	if config.KeyID == "" || config.TeamID == "" || config.Topic == "" {
+		return nil, fmt.Errorf("APNs key ID, team ID and topic are required")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = APNsProductionEndpoint
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &APNsProvider{config: config, key: key, httpClient: httpClient}, nil
+}
+
+// Send delivers a high-priority alert push to an iOS device. Data entries are
+// added to the payload next to aps.
+func (p *APNsProvider) Send(ctx context.Context, push Push) error {
+	providerToken, err := p.token()
+	if err != nil {
+		return err
+	}
+
+	payload := make(map[string]interface{}, len(push.Data)+1)
+	for key, value := range push.Data {
+		payload[key] = value
+	}
+	payload["aps"] = map[string]interface{}{
+		"alert": map[string]string{"title": push.Title, "body": push.Body},
+		"sound": "default",
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Endpoint+"/3/device/"+push.Token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", p.config.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	if push.TTL > 0 {
+		req.Header.Set("apns-expiration", strconv.FormatInt(time.Now().Add(push.TTL).Unix(), 10))
+	}
+	if push.CollapseID != "" {
+		req.Header.Set("apns-collapse-id", push.CollapseID)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPushFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	json.Unmarshal(respBody, &failure)
+
+	switch failure.Reason {
+	case "BadDeviceToken", "DeviceTokenNotForTopic", "Unregistered":
+		return ErrPushTokenInvalid
+	case "ExpiredProviderToken", "InvalidProviderToken":
+		p.mutex.Lock()
+		p.jwt = ""
+		p.mutex.Unlock()
+	}
+	if resp.StatusCode == http.StatusGone {
+		return ErrPushTokenInvalid
+	}
+	return fmt.Errorf("%w: APNs returned %d: %s", ErrPushFailed, resp.StatusCode, failure.Reason)
+}
+
+// token returns the cached provider token, signing a new one when it is
+// due for renewal
+func (p *APNsProvider) token() (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.jwt != "" && time.Since(p.issuedAt) < apnsTokenLifetime {
+		return p.jwt, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": p.config.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = p.config.KeyID
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+
+	p.jwt, p.issuedAt = signed, now
+	return p.jwt, nil
+}
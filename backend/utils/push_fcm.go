@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// FCMEndpoint is the Firebase Cloud Messaging HTTP v1 API
+const FCMEndpoint = "https://fcm.googleapis.com"
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMConfig configures Firebase Cloud Messaging
+type FCMConfig struct {
+	ProjectID       string // defaults to the service account's project
+	CredentialsFile string // service account key JSON
+	Endpoint        string // defaults to FCMEndpoint
+}
+
+// fcmServiceAccount is the part of a service account key file FCM needs
+type fcmServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMProvider sends pushes through the FCM HTTP v1 API. It authenticates as
+// a service account, caching the OAuth access token until shortly before it
+// expires.
+type FCMProvider struct {
+	config      FCMConfig
+	account     fcmServiceAccount
+	key         *rsa.PrivateKey
+	httpClient  *http.Client
+	accessToken string
+	expiresAt   time.Time
+	mutex       sync.Mutex
+}
+
+// NewFCMProvider loads the service account key and creates a provider. A nil
+// httpClient uses a client with a 10 second timeout.
+func NewFCMProvider(config FCMConfig, httpClient *http.Client) (*FCMProvider, error) {
+	data, err := os.ReadFile(config.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading FCM credentials: %w", err)
+	}
+
+	var account fcmServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("parsing FCM credentials: %w", err)
+	}
+	if account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, fmt.Errorf("FCM credentials are not a service account key")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("parsing FCM private key: %w", err)
+	}
+
+	if config.ProjectID == "" {
+		config.ProjectID = account.ProjectID
+	}
+	if config.ProjectID == "" {
+		return nil, fmt.Errorf("FCM project ID is required")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = FCMEndpoint
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &FCMProvider{config: config, account: account, key: key, httpClient: httpClient}, nil
+}
+
+// Send delivers a high-priority push to an Android or web device
+func (p *FCMProvider) Send(ctx context.Context, push Push) error {
+	accessToken, err := p.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	message := map[string]interface{}{
+		"token": push.Token,
+		"data":  push.Data,
+	}
+	android := map[string]interface{}{"priority": "HIGH"}
+	if push.TTL > 0 {
+		android["ttl"] = strconv.Itoa(int(push.TTL.Seconds())) + "s"
+	}
+	if push.CollapseID != "" {
+		android["collapse_key"] = push.CollapseID
+	}
+	message["android"] = android
+	if push.Title != "" || push.Body != "" {
+		message["notification"] = map[string]string{"title": push.Title, "body": push.Body}
+	}
+	body, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return err
+	}
+
+	target := p.config.Endpoint + "/v1/projects/" + url.PathEscape(p.config.ProjectID) + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPushFailed, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || strings.Contains(string(respBody), "UNREGISTERED"):
+		return ErrPushTokenInvalid
+	case resp.StatusCode == http.StatusUnauthorized:
+		// The access token may have been revoked; fetch a new one next time
+		p.mutex.Lock()
+		p.accessToken = ""
+		p.mutex.Unlock()
+	}
+	return fmt.Errorf("%w: FCM returned %d: %s", ErrPushFailed, resp.StatusCode, respBody)
+}
+
+// token returns a cached OAuth access token, exchanging a signed service
+// account assertion for a new one when it is about to expire
+func (p *FCMProvider) token(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.accessToken != "" && time.Until(p.expiresAt) > time.Minute {
+		return p.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   p.account.ClientEmail,
+		"scope": fcmScope,
+		"aud":   p.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: fetching access token: %v", ErrPushFailed, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token endpoint returned %d: %s", ErrPushFailed, resp.StatusCode, body)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("%w: token endpoint returned no access token", ErrPushFailed)
+	}
+
+	p.accessToken = token.AccessToken
+	p.expiresAt = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return p.accessToken, nil
+}
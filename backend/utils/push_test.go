@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakePushProvider(t *testing.T) {
+	fake := NewFakePushProvider()
+	require.NoError(t, fake.Send(context.Background(), Push{Token: "one"}))
+
+	fake.Err = ErrPushTokenInvalid
+	assert.ErrorIs(t, fake.Send(context.Background(), Push{Token: "two"}), ErrPushTokenInvalid)
+
+	sent := fake.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "one", sent[0].Token)
+}
+
+// writePKCS8Key writes a private key as PKCS#8 PEM and returns its path
+func writePKCS8Key(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	return path
+}
+
+func TestFCMProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var tokenRequests, sends atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokenRequests.Add(1)
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(r.PostForm.Get("assertion"), claims, func(*jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "push@example.iam.gserviceaccount.com", claims["iss"])
+			assert.Equal(t, fcmScope, claims["scope"])
+			assert.Equal(t, server.URL+"/token", claims["aud"])
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access-1", "expires_in": 3600})
+		case "/v1/projects/talk-app/messages:send":
+			sends.Add(1)
+			assert.Equal(t, "Bearer access-1", r.Header.Get("Authorization"))
+			var body struct {
+				Message struct {
+					Token   string            `json:"token"`
+					Data    map[string]string `json:"data"`
+					Android map[string]string `json:"android"`
+				} `json:"message"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			if body.Message.Token == "stale" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":{"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`))
+				return
+			}
+			assert.Equal(t, "device-token", body.Message.Token)
+			assert.Equal(t, "friend-1", body.Message.Data["friend_id"])
+			assert.Equal(t, "HIGH", body.Message.Android["priority"])
+			assert.Equal(t, "30s", body.Message.Android["ttl"])
+			w.Write([]byte(`{"name":"projects/talk-app/messages/1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	keyPEM, err := os.ReadFile(writePKCS8Key(t, key))
+	require.NoError(t, err)
+	credentials, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "talk-app",
+		"client_email": "push@example.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    server.URL + "/token",
+	})
+	require.NoError(t, err)
+	credentialsFile := filepath.Join(t.TempDir(), "service-account.json")
+	require.NoError(t, os.WriteFile(credentialsFile, credentials, 0600))
+
+	provider, err := NewFCMProvider(FCMConfig{CredentialsFile: credentialsFile, Endpoint: server.URL}, nil)
+	require.NoError(t, err)
+
+	push := Push{Token: "device-token", Data: map[string]string{"friend_id": "friend-1"}, TTL: 30 * time.Second}
+	require.NoError(t, provider.Send(context.Background(), push))
+	require.NoError(t, provider.Send(context.Background(), push))
+	assert.Equal(t, int32(2), sends.Load())
+	assert.Equal(t, int32(1), tokenRequests.Load(), "the access token is cached")
+
+	push.Token = "stale"
+	assert.ErrorIs(t, provider.Send(context.Background(), push), ErrPushTokenInvalid)
+
+	_, err = NewFCMProvider(FCMConfig{CredentialsFile: filepath.Join(t.TempDir(), "missing.json")}, nil)
+	assert.Error(t, err)
+}
+
+func TestAPNsProvider(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		token, err := jwt.Parse(authorization[len("bearer "):], func(token *jwt.Token) (interface{}, error) {
+			assert.Equal(t, "KEY123", token.Header["kid"])
+			return &key.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"}))
+		require.NoError(t, err)
+		assert.Equal(t, "TEAM123", token.Claims.(jwt.MapClaims)["iss"])
+		assert.Equal(t, "com.example.talk", r.Header.Get("apns-topic"))
+		assert.Equal(t, "10", r.Header.Get("apns-priority"))
+		assert.NotEmpty(t, r.Header.Get("apns-expiration"))
+
+		switch r.URL.Path {
+		case "/3/device/abcd":
+			var payload map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			assert.Equal(t, "friend-1", payload["friend_id"])
+			assert.Contains(t, payload, "aps")
+		case "/3/device/0bad":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"reason":"BadDeviceToken"}`))
+		case "/3/device/0ff0":
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"reason":"Unregistered"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"reason":"InternalServerError"}`))
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewAPNsProvider(APNsConfig{
+		KeyFile:  writePKCS8Key(t, key),
+		KeyID:    "KEY123",
+		TeamID:   "TEAM123",
+		Topic:    "com.example.talk",
+		Endpoint: server.URL,
+	}, nil)
+	require.NoError(t, err)
+
+	push := Push{Title: "Incoming call", Data: map[string]string{"friend_id": "friend-1"}, TTL: 30 * time.Second}
+	for _, tc := range []struct {
+		token string
+		err   error
+	}{
+		{"abcd", nil},
+		{"0bad", ErrPushTokenInvalid},
+		{"0ff0", ErrPushTokenInvalid},
+		{"ffff", ErrPushFailed},
+	} {
+		push.Token = tc.token
+		err := provider.Send(context.Background(), push)
+		if tc.err == nil {
+			assert.NoError(t, err, tc.token)
+		} else {
+			assert.True(t, errors.Is(err, tc.err), "%s: %v", tc.token, err)
+		}
+	}
+
+	_, err = NewAPNsProvider(APNsConfig{KeyFile: writePKCS8Key(t, key)}, nil)
+	assert.Error(t, err, "key ID, team ID and topic are required")
+}
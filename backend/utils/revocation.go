@@ -0,0 +1,333 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// compactMinRecords is the file size, in records, below which a file-backed
+// revocation store is never compacted
+const compactMinRecords = 1024
+
+// ErrRevocationMalformed is returned when a revocation file cannot be parsed
+var ErrRevocationMalformed = errors.New("revocation file entry is malformed")
+
+// RevocationStore records revoked token IDs (the jti claim) until the tokens
+// they belong to expire. Once a token has expired it is rejected anyway, so
+// its revocation no longer needs to be kept.
+type RevocationStore interface {
+	// Revoke marks id as revoked until expiresAt
+	Revoke(id string, expiresAt time.Time) error
+	// IsRevoked reports whether id is revoked and not yet expired
+	IsRevoked(id string) bool
+	// RevokedUntil returns when the revocation of id expires, if it is revoked
+	RevokedUntil(id string) (time.Time, bool)
+	// Cleanup forgets revocations that expired before now, returning how many were removed
+	Cleanup(now time.Time) (int, error)
+	// Len returns the number of revocations held
+	Len() int
+	Close() error
+}
+
+// MemoryRevocationStore keeps revocations in memory. They are lost on restart.
+type MemoryRevocationStore struct {
+	revoked map[string]time.Time
+	mutex   sync.RWMutex
+}
+
+// NewMemoryRevocationStore creates an empty in-memory store
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{revoked: make(map[string]time.Time)}
+}
+
+// Revoke marks id as revoked until expiresAt
+func (s *MemoryRevocationStore) Revoke(id string, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existing, ok := s.revoked[id]; !ok || expiresAt.After(existing) {
+		s.revoked[id] = expiresAt
+	}
+	return nil
+}
+
+// IsRevoked reports whether id is revoked and not yet expired
+func (s *MemoryRevocationStore) IsRevoked(id string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	expiresAt, exists := s.revoked[id]
+	return exists && time.Now().Before(expiresAt)
+}
+
+// RevokedUntil returns when the revocation of id expires, if it is revoked
+func (s *MemoryRevocationStore) RevokedUntil(id string) (time.Time, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	expiresAt, exists := s.revoked[id]
+	if !exists || !time.Now().Before(expiresAt) {
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// Cleanup forgets revocations that expired before now
+func (s *MemoryRevocationStore) Cleanup(now time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cleanupLocked(now), nil
+}
+
+// cleanupLocked removes expired entries. Caller must hold the lock.
+func (s *MemoryRevocationStore) cleanupLocked(now time.Time) int {
+	removed := 0
+	for id, expiresAt := range s.revoked {
+		if now.After(expiresAt) {
+			delete(s.revoked, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of revocations held
+func (s *MemoryRevocationStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.revoked)
+}
+
+// Close is a no-op for the in-memory store
+func (s *MemoryRevocationStore) Close() error {
+	return nil
+}
+
+// revocationRecord is one line of a revocation file
+type revocationRecord struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// FileRevocationStore persists revocations to an append-only JSON Lines file
+// so they survive restarts. The file is replayed on open and rewritten
+// without expired records once they make up most of it.
+type FileRevocationStore struct {
+	MemoryRevocationStore
+	path    string
+	file    *os.File
+	records int // records in the file, including expired ones
+}
+
+// OpenFileRevocationStore opens (or creates) the revocation file at path and
+// loads the revocations that have not yet expired
+func OpenFileRevocationStore(path string) (*FileRevocationStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &FileRevocationStore{
+		MemoryRevocationStore: MemoryRevocationStore{revoked: make(map[string]time.Time)},
+		path:                  path,
+	}
+	end, err := s.load()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// Drop a torn final line, so the next record does not land on it
+	if err := truncateTail(path, end); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	return s, nil
+}
+
+// load replays the revocation file, returning the length of its complete
+// lines. A final line without a newline is the remains of an interrupted
+// write and is ignored.
+func (s *FileRevocationStore) load() (int64, error) {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	now := time.Now()
+	var end int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		end += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record revocationRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.ID == "" {
+			return 0, ErrRevocationMalformed
+		}
+		s.records++
+		if now.Before(record.ExpiresAt) {
+			s.MemoryRevocationStore.Revoke(record.ID, record.ExpiresAt)
+		}
+	}
+}
+
+// truncateTail cuts the file at path back to end if it is longer, as it is
+// when its last write was interrupted
+func truncateTail(path string, end int64) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() <= end {
+		return nil
+	}
+	return os.Truncate(path, end)
+}
+
+// Revoke appends a revocation to the file before it takes effect
+func (s *FileRevocationStore) Revoke(id string, expiresAt time.Time) error {
+	line, err := json.Marshal(revocationRecord{ID: id, ExpiresAt: expiresAt.UTC()})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.records++
+
+	if existing, ok := s.revoked[id]; !ok || expiresAt.After(existing) {
+		s.revoked[id] = expiresAt
+	}
+	return nil
+}
+
+// Cleanup forgets expired revocations and compacts the file once expired and
+// duplicate records outnumber live ones
+func (s *FileRevocationStore) Cleanup(now time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := s.cleanupLocked(now)
+	if s.records >= compactMinRecords && s.records > 2*len(s.revoked) {
+		return removed, s.compactLocked()
+	}
+	return removed, nil
+}
+
+// Compact rewrites the file with only the live revocations
+func (s *FileRevocationStore) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cleanupLocked(time.Now())
+	return s.compactLocked()
+}
+
+// compactLocked writes live revocations to a temporary file and renames it
+// over the current one, so a crash leaves either the old or the new file.
+// Caller must hold the lock.
+func (s *FileRevocationStore) compactLocked() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for id, expiresAt := range s.revoked {
+		line, err := json.Marshal(revocationRecord{ID: id, ExpiresAt: expiresAt.UTC()})
+		if err == nil {
+			writer.Write(append(line, '\n'))
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	s.file.Close()
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.records = len(s.revoked)
+	return nil
+}
+
+// Close closes the underlying file
+func (s *FileRevocationStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+var (
+	revocationStore      RevocationStore = NewMemoryRevocationStore()
+	revocationStoreMutex sync.RWMutex
+)
+
+// SetRevocationStore installs the store used to revoke session tokens
+func SetRevocationStore(store RevocationStore) {
+	revocationStoreMutex.Lock()
+	defer revocationStoreMutex.Unlock()
+	revocationStore = store
+}
+
+// getRevocationStore returns the installed revocation store
+func getRevocationStore() RevocationStore {
+	revocationStoreMutex.RLock()
+	defer revocationStoreMutex.RUnlock()
+	return revocationStore
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countLines(t *testing.T, path string) int {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestMemoryRevocationStore(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	now := time.Now()
+
+	require.NoError(t, store.Revoke("live", now.Add(time.Hour)))
+	require.NoError(t, store.Revoke("expired", now.Add(-time.Minute)))
+
+	assert.True(t, store.IsRevoked("live"))
+	assert.False(t, store.IsRevoked("expired"), "expired tokens fail validation on their own")
+	assert.False(t, store.IsRevoked("unknown"))
+	assert.Equal(t, 2, store.Len())
+
+	removed, err := store.Cleanup(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, 1, store.Len())
+
+	// A later revocation never shortens an existing one
+	require.NoError(t, store.Revoke("live", now.Add(time.Minute)))
+	removed, err = store.Cleanup(now.Add(30 * time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestFileRevocationStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.jsonl")
+
+	store, err := OpenFileRevocationStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Revoke("session-1", time.Now().Add(time.Hour)))
+	require.NoError(t, store.Revoke("session-old", time.Now().Add(-time.Hour)))
+	require.NoError(t, store.Close())
+
+	reopened, err := OpenFileRevocationStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.True(t, reopened.IsRevoked("session-1"))
+	assert.False(t, reopened.IsRevoked("session-old"))
+	assert.Equal(t, 1, reopened.Len(), "expired records are not loaded")
+}
+
+func TestFileRevocationStore_TruncatedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.jsonl")
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	content := fmt.Sprintf("{\"id\":\"session-1\",\"expires_at\":%q}\n{\"id\":\"session-2\",\"exp", expires)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o640))
+
+	store, err := OpenFileRevocationStore(path)
+	require.NoError(t, err)
+	assert.True(t, store.IsRevoked("session-1"))
+	assert.Equal(t, 1, store.Len())
+
+	// The torn line is dropped, so later records and restarts are unaffected
+	require.NoError(t, store.Revoke("session-3", time.Now().Add(time.Hour)))
+	require.NoError(t, store.Close())
+	reopened, err := OpenFileRevocationStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.True(t, reopened.IsRevoked("session-1"))
+	assert.True(t, reopened.IsRevoked("session-3"))
+	assert.Equal(t, 2, reopened.Len())
+}
+
+func TestFileRevocationStore_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("not json\n"), 0o640))
+
+	_, err := OpenFileRevocationStore(path)
+	assert.ErrorIs(t, err, ErrRevocationMalformed)
+}
+
+func TestFileRevocationStore_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.jsonl")
+	store, err := OpenFileRevocationStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	now := time.Now()
+	for i := 0; i < compactMinRecords; i++ {
+		require.NoError(t, store.Revoke(fmt.Sprintf("expiring-%d", i), now.Add(time.Minute)))
+	}
+	require.NoError(t, store.Revoke("long-lived", now.Add(time.Hour)))
+	assert.Equal(t, compactMinRecords+1, countLines(t, path))
+
+	// Once the short-lived revocations expire the file is rewritten
+	removed, err := store.Cleanup(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, compactMinRecords, removed)
+	assert.Equal(t, 1, countLines(t, path))
+
+	// Appends continue on the compacted file
+	require.NoError(t, store.Revoke("after-compaction", now.Add(time.Hour)))
+	assert.Equal(t, 2, countLines(t, path))
+
+	reopened, err := OpenFileRevocationStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.True(t, reopened.IsRevoked("long-lived"))
+	assert.True(t, reopened.IsRevoked("after-compaction"))
+	assert.False(t, reopened.IsRevoked("expiring-0"))
+}
+
+func TestRefreshToken_RevokeFailure(t *testing.T) {
+	store, err := OpenFileRevocationStore(filepath.Join(t.TempDir(), "revoked.jsonl"))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	previous := getRevocationStore()
+	SetRevocationStore(store)
+	defer SetRevocationStore(previous)
+
+	ensureJWTInit()
+	token, err := getKeyring().Sign(&Claims{
+		UserID:    "user-1",
+		SessionID: "session-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			ID:        "session-1",
+		},
+	})
+	require.NoError(t, err)
+
+	refreshed, err := RefreshToken(token)
+	assert.Error(t, err, "a token that could not be revoked is not replaced")
+	assert.Empty(t, refreshed)
+}
+
+func TestRevokeToken_BySession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.jsonl")
+	store, err := OpenFileRevocationStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	previous := getRevocationStore()
+	SetRevocationStore(store)
+	defer SetRevocationStore(previous)
+
+	revoked, err := GenerateToken("user-1")
+	require.NoError(t, err)
+	other, err := GenerateToken("user-1")
+	require.NoError(t, err)
+
+	claims, err := ValidateJWT(revoked)
+	require.NoError(t, err)
+	require.NoError(t, RevokeToken(revoked))
+
+	_, err = ValidateJWT(revoked)
+	assert.Equal(t, ErrTokenBlacklisted, err)
+	assert.True(t, store.IsRevoked(claims.SessionID), "revocation is keyed by the token ID, not the token string")
+
+	// Other sessions of the same user are unaffected
+	_, err = ValidateJWT(other)
+	assert.NoError(t, err)
+}
+
+func TestRevokeAccountSessions(t *testing.T) {
+	previous := getRevocationStore()
+	SetRevocationStore(NewMemoryRevocationStore())
+	defer SetRevocationStore(previous)
+
+	ensureJWTInit()
+	old, err := getKeyring().Sign(&Claims{
+		UserID:    "user-1",
+		SessionID: "session-old",
+		AccountID: "account-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			ID:        "session-old",
+		},
+	})
+	require.NoError(t, err)
+	otherAccount, err := GenerateAccountToken("user-2", "account-2")
+	require.NoError(t, err)
+
+	require.NoError(t, RevokeAccountSessions("account-1"))
+
+	_, err = ValidateJWT(old)
+	assert.Equal(t, ErrTokenBlacklisted, err)
+	_, err = ValidateJWT(otherAccount)
+	assert.NoError(t, err)
+
+	// Sessions issued after the revocation are valid
+	fresh, err := GenerateAccountToken("user-1", "account-1")
+	require.NoError(t, err)
+	_, err = ValidateJWT(fresh)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLSigner(t *testing.T) {
+	secret := []byte("a-secret-that-is-long-enough-for-tests")
+	signer := NewURLSigner(secret, "voice-notes")
+
+	signed := signer.Sign("/voice-notes/abc", time.Now().Add(time.Minute))
+	parsed, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "/voice-notes/abc", parsed.Path)
+	assert.NoError(t, signer.Verify("/voice-notes/abc", parsed.Query()))
+
+	// The signature covers the path and the expiry
+	assert.ErrorIs(t, signer.Verify("/voice-notes/abd", parsed.Query()), ErrURLSignature)
+	tampered := parsed.Query()
+	tampered.Set("expires", tampered.Get("expires")+"0")
+	assert.ErrorIs(t, signer.Verify("/voice-notes/abc", tampered), ErrURLSignature)
+	assert.ErrorIs(t, signer.Verify("/voice-notes/abc", url.Values{}), ErrURLSignature)
+
+	// Signers for other purposes do not accept the URL
+	other := NewURLSigner(secret, "avatars")
+	assert.ErrorIs(t, other.Verify("/voice-notes/abc", parsed.Query()), ErrURLSignature)
+
+	expired := signer.Sign("/voice-notes/abc", time.Now().Add(-time.Second))
+	parsed, err = url.Parse(expired)
+	require.NoError(t, err)
+	assert.ErrorIs(t, signer.Verify("/voice-notes/abc", parsed.Query()), ErrURLExpired)
+	assert.True(t, strings.HasPrefix(expired, "/voice-notes/abc?"))
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"voice-chat-app/models"
+)
+
+// lookalikes maps characters commonly swapped in for letters to get past a
+// filter
+var lookalikes = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's',
+}
+
+// TextFilter rejects user-chosen text, such as display names, that contains
+// a blocked term as a word or run of whole words. Text and terms are
+// compared after folding case and lookalike characters, and words are split
+// at anything else that is not a letter, so "Ad.m1n" is caught by "admin"
+// while "Sysadministrator" is not.
+type TextFilter struct {
+	terms []string
+}
+
+// NewTextFilter creates a filter for the given terms
+func NewTextFilter(terms []string) *TextFilter {
+	filter := &TextFilter{}
+	for _, term := range terms {
+		if folded := strings.Join(foldWords(term), ""); folded != "" {
+			filter.terms = append(filter.terms, folded)
+		}
+	}
+	return filter
+}
+
+// Allows reports whether no word, or run of consecutive words, of text
+// spells one of the filter's terms
+func (f *TextFilter) Allows(text string) bool {
+	words := foldWords(text)
+	for start := range words {
+		run := ""
+		for _, word := range words[start:] {
+			run += word
+			for _, term := range f.terms {
+				if run == term {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// foldWords folds case and lookalike characters and splits text into words
+// at every character that is not then a letter
+func foldWords(text string) []string {
+	var words []string
+	var b strings.Builder
+	for _, r := range strings.ToLower(models.FoldConfusables(text)) {
+		if replacement, ok := lookalikes[r]; ok {
+			r = replacement
+		}
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if b.Len() > 0 {
+			words = append(words, b.String())
+			b.Reset()
+		}
+	}
+	if b.Len() > 0 {
+		words = append(words, b.String())
+	}
+	return words
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextFilter(t *testing.T) {
+	filter := NewTextFilter([]string{"admin", "Bad Word", "  "})
+
+	assert.True(t, filter.Allows("Brave Otter"))
+	assert.False(t, filter.Allows("The Admin"))
+	assert.False(t, filter.Allows("Ad.m1n"), "punctuation and lookalikes are folded")
+	assert.False(t, filter.Allows("b4d_w0rd 2"))
+	assert.False(t, filter.Allows("Bad Word"), "a term can span words")
+	assert.False(t, filter.Allows("Аdmіn"), "Cyrillic lookalikes are folded")
+
+	// Terms only match whole words
+	assert.True(t, filter.Allows("Sysadministrator"))
+	assert.True(t, filter.Allows("Badwords Fan"))
+	assert.True(t, NewTextFilter([]string{"bat"}).Allows("Batman"))
+	assert.True(t, NewTextFilter(nil).Allows("anything"))
+}